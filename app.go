@@ -10,6 +10,7 @@ import (
 	"live-server/pkg/utils"
 	"live-server/pkg/utils/network"
 	"os"
+	"path/filepath"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
@@ -21,12 +22,12 @@ type App struct {
 	isHidden bool // 是否已隐藏窗口
 	isOnTop  bool // 是否已置顶窗口
 
-	rtspTunnels map[string]struct {
-		RtspTunnel *pullrtsp2pushrtsp.RtspTunnel `json:"rtsp_tunnel"`
-		Infos      TunnelInfos                   `json:"infos"`
-	}
+	tunnelSupervisor *pullrtsp2pushrtsp.Supervisor
 }
 
+// tunnelStoreFilename rtsp转发任务的持久化文件
+const tunnelStoreFilename = "configs/tunnels.json"
+
 // NewApp creates a new App application struct
 func NewApp() *App {
 	return &App{}
@@ -42,12 +43,14 @@ func (a *App) startup(ctx context.Context) {
 	}
 	a.ctx = ctx
 	runtime.WindowSetDarkTheme(a.ctx)
-	a.rtspTunnels = make(map[string]struct {
-		RtspTunnel *pullrtsp2pushrtsp.RtspTunnel `json:"rtsp_tunnel"`
-		Infos      TunnelInfos                   `json:"infos"`
-	})
 
-	go lalserver.Init()
+	lalserver.SetOnStatusChange(a.onLalServerStatusChange)
+	lalserver.SetOnNotify(a.onLalServerNotify)
+	if err := lalserver.Init(); err != nil {
+		nazalog.Errorf("start lalserver failed. err=%+v", err)
+	}
+
+	a.initTunnelSupervisor()
 }
 
 // beforeClose 退出前生命周期
@@ -55,28 +58,186 @@ func (a *App) beforeClose(ctx context.Context) bool {
 	if a.hide {
 		a.hideWindow()
 	} else {
-		// TODO: 退出前的操作
-		fmt.Println("退出前的操作", a)
+		a.dispose()
 	}
 	// 返回 true 将阻止程序关闭
 	return a.hide
 }
 
-/* *****************http-api***************** */
-func (a *App) HttpApiStatGroup(stream_name string) base.ApiStatGroupResp {
-	return lalserver.HttpApiStatGroup(stream_name)
+/* *****************lalserver设置***************** */
+
+// LalServerGetSettings 获取lalserver的配置
+func (a *App) LalServerGetSettings() (lalserver.Settings, error) {
+	return lalserver.GetSettings()
+}
+
+// LalServerUpdateSettings 修改lalserver的配置，校验端口后保存并重启lalserver
+func (a *App) LalServerUpdateSettings(settings lalserver.Settings) error {
+	if err := lalserver.UpdateSettings(settings); err != nil {
+		nazalog.Errorf("update lalserver settings failed. err=%+v", err)
+		return err
+	}
+	return nil
+}
+
+// LalServerRestart 重新读取配置文件（包括手动编辑过的）并重启lalserver，没有运行时直接启动
+func (a *App) LalServerRestart() error {
+	if err := lalserver.Restart(); err != nil {
+		nazalog.Errorf("restart lalserver failed. err=%+v", err)
+		return err
+	}
+	return nil
+}
+
+// LalServerStart 启动lalserver，端口被占用等启动失败的原因通过返回值或 lalserver_status_changed 事件获取
+func (a *App) LalServerStart() error {
+	if err := lalserver.Start(); err != nil {
+		nazalog.Errorf("start lalserver failed. err=%+v", err)
+		return err
+	}
+	return nil
+}
+
+// LalServerStop 停止lalserver
+func (a *App) LalServerStop() error {
+	return lalserver.Stop()
+}
+
+// LalServerStatus 获取lalserver的运行状态(running, stopped, failed)
+func (a *App) LalServerStatus() lalserver.ServerStatus {
+	return lalserver.Status()
+}
+
+// onLalServerStatusChange 通知前端lalserver的状态变化
+func (a *App) onLalServerStatusChange(status lalserver.ServerStatus) {
+	if status.State == lalserver.ServerStateFailed {
+		nazalog.Errorf("lalserver failed. err=%s", status.LastError)
+	}
+	runtime.EventsEmit(a.ctx, "lalserver_status_changed", status)
+}
+
+// onLalServerNotify 将lalserver的事件转发给前端，事件名见 lalserver.NotifyEvent*
+func (a *App) onLalServerNotify(event lalserver.NotifyEvent) {
+	runtime.EventsEmit(a.ctx, event.Name, event.Info)
 }
 
-func (a *App) HttpApiStatAllGroup() base.ApiStatAllGroupResp {
-	return lalserver.HttpApiStatAllGroup()
+// LalServerNotifyHistory 查询最近的lalserver事件，name为空时返回所有类型的事件，limit小于等于0时不限制条数
+func (a *App) LalServerNotifyHistory(name string, limit int) []lalserver.NotifyEvent {
+	return lalserver.NotifyHistory(name, limit)
 }
 
-func (a *App) HttpApiCtrlStartRelayPull(url, stream_name string) base.ApiCtrlStartRelayPullResp {
-	return lalserver.HttpApiCtrlStartRelayPull(url, stream_name)
+/* *****************lalserver接口***************** */
+
+// LalServerStatLalInfo 获取lalserver的基本信息
+func (a *App) LalServerStatLalInfo() (base.LalInfo, error) {
+	return lalserver.StatLalInfo()
 }
 
-func (a *App) HttpApiCtrlStopRelayPull(stream_name string) base.ApiCtrlStopRelayPullResp {
-	return lalserver.HttpApiCtrlStopRelayPull(stream_name)
+// LalServerStatGroup 获取指定流的信息
+func (a *App) LalServerStatGroup(streamName string) (base.StatGroup, error) {
+	return lalserver.StatGroup(streamName)
+}
+
+// LalServerStatAllGroup 获取所有流的信息
+func (a *App) LalServerStatAllGroup() ([]base.StatGroup, error) {
+	return lalserver.StatAllGroup()
+}
+
+// LalServerStatNotify 获取事件通知的分发统计
+func (a *App) LalServerStatNotify() (base.StatNotify, error) {
+	return lalserver.StatNotify()
+}
+
+// LalServerStatAcl 获取acl策略以及被拒绝的session数量
+func (a *App) LalServerStatAcl() (base.StatAcl, error) {
+	return lalserver.StatAcl()
+}
+
+// LalServerCtrlStartRelayPull 从远端拉流到lalserver，streamName为空时使用url中的最后一级路径
+func (a *App) LalServerCtrlStartRelayPull(url string, streamName string) (base.ApiCtrlStartRelayPullResp, error) {
+	return lalserver.CtrlStartRelayPull(lalserver.NewApiCtrlStartRelayPullReq(url, streamName))
+}
+
+// LalServerCtrlStopRelayPull 停止从远端拉流
+func (a *App) LalServerCtrlStopRelayPull(streamName string) (base.ApiCtrlStopRelayPullResp, error) {
+	return lalserver.CtrlStopRelayPull(streamName)
+}
+
+// LalServerStatRelayPush 获取流的所有转推目标及其状态
+func (a *App) LalServerStatRelayPush(streamName string) ([]base.StatRelayPush, error) {
+	return lalserver.StatRelayPush(streamName)
+}
+
+// LalServerCtrlStartRelayPush 添加一个转推目标，支持rtmp://和rtsp://
+func (a *App) LalServerCtrlStartRelayPush(streamName string, url string) (base.ApiCtrlStartRelayPushResp, error) {
+	return lalserver.CtrlStartRelayPush(lalserver.NewApiCtrlStartRelayPushReq(streamName, url))
+}
+
+// LalServerCtrlStopRelayPush 删除一个转推目标
+func (a *App) LalServerCtrlStopRelayPush(streamName string, url string) (base.ApiCtrlStopRelayPushResp, error) {
+	return lalserver.CtrlStopRelayPush(streamName, url)
+}
+
+// LalServerStatRecord 获取流正在进行的录制
+func (a *App) LalServerStatRecord(streamName string) ([]base.StatRecord, error) {
+	return lalserver.StatRecord(streamName)
+}
+
+// LalServerCtrlStartRecord 开始录制，format为flv、mpegts或mp4，maxDurationSec为0表示不限制录制时长
+func (a *App) LalServerCtrlStartRecord(streamName string, format string, maxDurationSec int) (base.ApiCtrlStartRecordResp, error) {
+	return lalserver.CtrlStartRecord(base.ApiCtrlStartRecordReq{
+		StreamName:     streamName,
+		Format:         format,
+		MaxDurationSec: maxDurationSec,
+	})
+}
+
+// LalServerCtrlStopRecord 停止录制，format为空时停止该流所有的录制
+func (a *App) LalServerCtrlStopRecord(streamName string, format string) (base.ApiCtrlStopRecordResp, error) {
+	return lalserver.CtrlStopRecord(streamName, format)
+}
+
+// LalServerCtrlStartFilePub 将flv或ts文件作为直播流推入，path支持glob，loop为true时循环推送
+func (a *App) LalServerCtrlStartFilePub(streamName string, path string, loop bool) (base.ApiCtrlStartFilePubResp, error) {
+	return lalserver.CtrlStartFilePub(streamName, path, loop)
+}
+
+// LalServerCtrlStopFilePub 停止文件推流
+func (a *App) LalServerCtrlStopFilePub(streamName string) (base.ApiCtrlStopFilePubResp, error) {
+	return lalserver.CtrlStopFilePub(streamName)
+}
+
+// LalServerCtrlStartUdpTsPub 接收udp单播或组播的ts流，比如组播设备输出的流，停止时使用 LalServerCtrlKickSession
+func (a *App) LalServerCtrlStartUdpTsPub(info base.ApiCtrlStartUdpTsPubReq) (base.ApiCtrlStartUdpTsPubResp, error) {
+	return lalserver.CtrlStartUdpTsPub(info)
+}
+
+// LalServerStatSnapshot 获取流最近的一个视频关键帧，format为mp4时可以直接作为<video>的poster，data字段为base64编码
+func (a *App) LalServerStatSnapshot(streamName string, format string) (base.Snapshot, error) {
+	return lalserver.StatSnapshot(streamName, format)
+}
+
+// LalServerStatRecordRetention 获取录制文件保留策略的状态，包括磁盘使用率以及录制是否因为磁盘空间不足被停止
+func (a *App) LalServerStatRecordRetention() (base.StatRecordRetention, error) {
+	return lalserver.StatRecordRetention()
+}
+
+// LalServerCtrlKickSession 踢掉指定的session
+func (a *App) LalServerCtrlKickSession(streamName string, sessionId string) (base.ApiCtrlKickSessionResp, error) {
+	return lalserver.CtrlKickSession(base.ApiCtrlKickSessionReq{
+		StreamName: streamName,
+		SessionId:  sessionId,
+	})
+}
+
+// LalServerCtrlStartRtpPub 打开gb28181的rtp接收端口
+func (a *App) LalServerCtrlStartRtpPub(info base.ApiCtrlStartRtpPubReq) (base.ApiCtrlStartRtpPubResp, error) {
+	return lalserver.CtrlStartRtpPub(info)
+}
+
+// LalServerCtrlReloadAcl 替换运行中的acl策略，重启lalserver后恢复为配置文件中的策略
+func (a *App) LalServerCtrlReloadAcl(config base.AclConfig) (base.ApiCtrlReloadAclResp, error) {
+	return lalserver.CtrlReloadAcl(config)
 }
 
 /* *****************pullrtsp2pushrtsp***************** */
@@ -88,98 +249,142 @@ type TunnelInfos struct {
 }
 
 func (a *App) PullRtsp2PushRtspStart(inUrl string, name string, pullOverTcp int, pushOverTcp int) (TunnelInfos, error) {
-	// 判断是否已经存在同name
-	if _, ok := a.rtspTunnels[name]; ok {
-		nazalog.Errorf("tunnel already exists. name=%s", name)
-		return TunnelInfos{}, fmt.Errorf("tunnel already exists. name=%s", name)
+	task := pullrtsp2pushrtsp.TunnelTask{
+		Name:        name,
+		OriginUrl:   inUrl,
+		PullOverTcp: pullOverTcp == 1,
+		PushOverTcp: pushOverTcp == 1,
 	}
-	outUrl := lalserver.RtspDefaultUrl(name)
-
-	rtspTunnel := pullrtsp2pushrtsp.NewRtspTunnel(inUrl, outUrl, pullOverTcp == 1, pushOverTcp == 1)
-	infos := TunnelInfos{
-		Name:      name,
-		OriginUrl: inUrl,
-		TargetUrl: outUrl,
-		HlsUrl:    lalserver.HlsDefaultUrl(name),
+	if err := a.tunnelSupervisor.Add(task); err != nil {
+		nazalog.Errorf("start tunnel failed. err=%+v", err)
+		return TunnelInfos{}, fmt.Errorf("start tunnel failed. err=%+v", err)
 	}
-	if a.rtspTunnels == nil {
-		a.rtspTunnels = make(map[string]struct {
-			RtspTunnel *pullrtsp2pushrtsp.RtspTunnel `json:"rtsp_tunnel"`
-			Infos      TunnelInfos                   `json:"infos"`
-		})
+	return newTunnelInfos(task), nil
+}
+
+// TunnelStart 开启支持协议转换的转发任务，根据url的scheme选择协议组合，比如rtsp->rtmp，httpflv->rtmp，rtsp->rtsp
+//
+// 和 PullRtsp2PushRtspStart 共用任务列表，停止、查询等使用 PullRtsp2PushRtsp* 系列方法
+func (a *App) TunnelStart(inUrl string, outUrl string, name string, pullOverTcp int, pushOverTcp int) (TunnelInfos, error) {
+	if _, _, err := pullrtsp2pushrtsp.ParseProtocolPair(inUrl, outUrl); err != nil {
+		return TunnelInfos{}, err
 	}
-	a.rtspTunnels[name] = struct {
-		RtspTunnel *pullrtsp2pushrtsp.RtspTunnel `json:"rtsp_tunnel"`
-		Infos      TunnelInfos                   `json:"infos"`
-	}{
-		RtspTunnel: rtspTunnel,
-		Infos:      infos,
+	task := pullrtsp2pushrtsp.TunnelTask{
+		Name:        name,
+		OriginUrl:   inUrl,
+		TargetUrl:   outUrl,
+		PullOverTcp: pullOverTcp == 1,
+		PushOverTcp: pushOverTcp == 1,
 	}
-
-	err := rtspTunnel.Start()
-	if err != nil {
+	if err := a.tunnelSupervisor.Add(task); err != nil {
 		nazalog.Errorf("start tunnel failed. err=%+v", err)
-		delete(a.rtspTunnels, name)
 		return TunnelInfos{}, fmt.Errorf("start tunnel failed. err=%+v", err)
 	}
-
-	//go func() {
-	//	time.Sleep(5 * time.Second)
-	//	_ = rtspTunnel.Dispose()
-	//}()
-
-	go func() {
-		err = <-rtspTunnel.WaitChan()
-		nazalog.Errorf("tunnel stopped. err=%+v", err)
-		delete(a.rtspTunnels, name)
-		// 通知前端
-		runtime.EventsEmit(a.ctx, "stream_disconnected", name)
-	}()
-
-	return infos, nil
+	return newTunnelInfos(task), nil
 }
 
 // PullRtsp2PushRtspStop 停止rtsp转发任务
 func (a *App) PullRtsp2PushRtspStop(name string) {
-	if _, ok := a.rtspTunnels[name]; !ok {
-		nazalog.Errorf("tunnel not exists. name=%s", name)
-		return
-	}
-	err := a.rtspTunnels[name].RtspTunnel.Dispose()
-	if err != nil {
-		nazalog.Errorf("dispose tunnel failed. err=%+v", err)
-		return
+	if err := a.tunnelSupervisor.Remove(name); err != nil {
+		nazalog.Errorf("stop tunnel failed. err=%+v", err)
 	}
-	delete(a.rtspTunnels, name)
 }
 
 // PullRtsp2PushRtspStopAll 停止所有rtsp转发任务
 func (a *App) PullRtsp2PushRtspStopAll() {
-	for k := range a.rtspTunnels {
-		err := a.rtspTunnels[k].RtspTunnel.Dispose()
-		if err != nil {
-			nazalog.Errorf("dispose tunnel failed. err=%+v", err)
-			return
-		}
-		delete(a.rtspTunnels, k)
+	if err := a.tunnelSupervisor.RemoveAll(); err != nil {
+		nazalog.Errorf("stop all tunnel failed. err=%+v", err)
 	}
 }
 
 // PullRtsp2PushRtspList 获取rtsp转发任务列表
 func (a *App) PullRtsp2PushRtspList() []TunnelInfos {
 	var infos []TunnelInfos
-	for _, v := range a.rtspTunnels {
-		infos = append(infos, v.Infos)
+	for _, status := range a.tunnelSupervisor.List() {
+		infos = append(infos, newTunnelInfos(status.TunnelTask))
+	}
+	return infos
+}
+
+// PullRtsp2PushRtspStatus 获取rtsp转发任务的运行状态(connecting, running, backoff, failed)
+func (a *App) PullRtsp2PushRtspStatus() []pullrtsp2pushrtsp.TunnelStatus {
+	return a.tunnelSupervisor.List()
+}
+
+// PullRtsp2PushRtspGetBackoff 获取断线重连的退避配置
+func (a *App) PullRtsp2PushRtspGetBackoff() pullrtsp2pushrtsp.BackoffConfig {
+	return a.tunnelSupervisor.Backoff()
+}
+
+// PullRtsp2PushRtspSetBackoff 修改断线重连的退避配置
+func (a *App) PullRtsp2PushRtspSetBackoff(backoff pullrtsp2pushrtsp.BackoffConfig) error {
+	return a.tunnelSupervisor.SetBackoff(backoff)
+}
+
+// initTunnelSupervisor 初始化rtsp转发任务守护，并恢复上次保存的任务
+func (a *App) initTunnelSupervisor() {
+	utils.MkDir(filepath.Dir(tunnelStoreFilename))
+	a.tunnelSupervisor = pullrtsp2pushrtsp.NewSupervisor(func(option *pullrtsp2pushrtsp.SupervisorOption) {
+		option.StoreFilename = tunnelStoreFilename
+		option.NewTunnel = func(task pullrtsp2pushrtsp.TunnelTask) (pullrtsp2pushrtsp.ITunnel, error) {
+			return pullrtsp2pushrtsp.NewTunnelByUrl(task.OriginUrl, tunnelTargetUrl(task), task.PullOverTcp, task.PushOverTcp)
+		}
+		option.OnStateChange = a.onTunnelStateChange
+	})
+	if err := a.tunnelSupervisor.Restore(); err != nil {
+		nazalog.Errorf("restore tunnel tasks failed. err=%+v", err)
+	}
+}
+
+// onTunnelStateChange 通知前端转发任务的状态变化
+func (a *App) onTunnelStateChange(status pullrtsp2pushrtsp.TunnelStatus) {
+	runtime.EventsEmit(a.ctx, "tunnel_state_changed", status)
+	if status.State == pullrtsp2pushrtsp.TunnelStateBackoff || status.State == pullrtsp2pushrtsp.TunnelStateFailed {
+		runtime.EventsEmit(a.ctx, "stream_disconnected", status.Name)
+	}
+}
+
+func newTunnelInfos(task pullrtsp2pushrtsp.TunnelTask) TunnelInfos {
+	infos := TunnelInfos{
+		Name:      task.Name,
+		OriginUrl: task.OriginUrl,
+		TargetUrl: tunnelTargetUrl(task),
+	}
+	// 推送到外部地址时，内置lalserver没有这路流，也就没有hls地址
+	if task.TargetUrl == "" {
+		infos.HlsUrl = lalserver.HlsDefaultUrl(task.Name)
 	}
 	return infos
 }
 
+// tunnelTargetUrl 任务没有指定推流地址时，推送到内置lalserver
+func tunnelTargetUrl(task pullrtsp2pushrtsp.TunnelTask) string {
+	if task.TargetUrl != "" {
+		return task.TargetUrl
+	}
+	return lalserver.RtspDefaultUrl(task.Name)
+}
+
 /* *****************network***************** */
 func (a *App) CheckUrl(url string) (string, error) {
 	return network.CheckUrl(url)
 }
 
 /* *****************私有方法***************** */
+
+// dispose 退出程序前释放资源
+//
+// 注意，先停止转发任务，再停止lalserver，避免转发任务因为推流断开而触发重连
+func (a *App) dispose() {
+	if a.tunnelSupervisor != nil {
+		a.tunnelSupervisor.Dispose()
+	}
+	if err := lalserver.Stop(); err != nil {
+		nazalog.Errorf("stop lalserver failed. err=%+v", err)
+	}
+	nazalog.Sync()
+}
+
 // showWindow 显示窗口
 func (a *App) showWindow() {
 	runtime.WindowShow(a.ctx)
@@ -2,87 +2,347 @@ package lalserver
 
 import (
 	"fmt"
-	"live-server/library/LAL/pkg/base"
-	"live-server/pkg/utils/encoding"
 
-	"github.com/imroc/req/v3"
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/logic"
 )
 
-var (
-	httpApiEnable     = true
-	httpApiPort       = "8083"
-	httpApiRequestUrl = fmt.Sprintf("http://%s:%s", baseUrl, httpApiPort)
-	// /api/stat/group
-	httpApiStatGroupUrl = fmt.Sprintf("%s/api/stat/group", httpApiRequestUrl)
-	// /api/stat/all_group
-	httpApiStatAllGroupUrl = fmt.Sprintf("%s/api/stat/all_group", httpApiRequestUrl)
-
-	// /api/ctrl/start_relay_pull
-	httpApiCtrlStartRelayPullUrl = fmt.Sprintf("%s/api/ctrl/start_relay_pull", httpApiRequestUrl)
-	// /api/ctrl/stop_relay_pull
-	httpApiCtrlStopRelayPullUrl = fmt.Sprintf("%s/api/ctrl/stop_relay_pull", httpApiRequestUrl)
-)
+// api.go
+//
+// 直接调用进程内的 logic.ILalServer ，不再通过回环地址访问http api，所以不依赖 http_api.enable 配置。
+// lalserver没有运行时返回 ErrServerNotRunning ，接口执行失败时返回 *ApiError 。
 
 /*
-接口规则
-
-1. 所有接口的返回结果中，必含的一级参数：
-
-	{
-		"error_code": 0,
-		"desp": "succ",
-		"data": ...
-	}
-
-2. error_code列表：
+error_code列表（与http api保持一致）：
 
 error_code	desp	说明
 0	succ	调用成功
 1001	group not found	group不存在
 1002	param missing	必填参数缺失
 1003	session not found	session不存在
+1004	rejected by acl	session被acl策略拒绝
+1005	record not found	没有正在进行的按需录制
 2001	多种值，表示失败的具体原因	start_relay_pull失败
 2002	打开gb28181端口失败	start_rtp_pub
+2003	多种值，表示失败的具体原因	reload_acl失败
+2004	多种值，表示失败的具体原因	start_relay_push失败
+2005	多种值，表示失败的具体原因	start_record失败
+2006	多种值，表示失败的具体原因	snapshot失败
+2007	多种值，表示失败的具体原因	start_file_pub失败
+2008	多种值，表示失败的具体原因	start_udp_ts_pub失败
 */
 
-// /api/stat/group: curl http://127.0.0.1:8083/api/stat/group?stream_name=test110
-func HttpApiStatGroup(stream_name string) base.ApiStatGroupResp {
-	res := req.C().R().SetQueryParams(map[string]string{
-		"stream_name": stream_name,
-	}).MustGet(httpApiStatGroupUrl)
+// ApiError lalserver接口返回的错误
+type ApiError struct {
+	ErrorCode int    `json:"error_code"`
+	Desp      string `json:"desp"`
+}
+
+func (e *ApiError) Error() string {
+	return fmt.Sprintf("lalserver: api failed. error_code=%d, desp=%s", e.ErrorCode, e.Desp)
+}
+
+// StatLalInfo 对应 /api/stat/lal_info
+func StatLalInfo() (base.LalInfo, error) {
+	server, err := Server()
+	if err != nil {
+		return base.LalInfo{}, err
+	}
+	return server.StatLalInfo(), nil
+}
 
-	var ret base.ApiStatGroupResp
-	encoding.JSONDecode(res.String(), &ret)
-	return ret
+// StatAllGroup 对应 /api/stat/all_group
+func StatAllGroup() ([]base.StatGroup, error) {
+	server, err := Server()
+	if err != nil {
+		return nil, err
+	}
+	return server.StatAllGroup(), nil
 }
 
-// /api/stat/all_group: curl http://127.0.0.1:8083/api/stat/all_group
-func HttpApiStatAllGroup() base.ApiStatAllGroupResp {
-	res := req.C().R().MustGet(httpApiStatAllGroupUrl)
+// StatGroup 对应 /api/stat/group
+func StatGroup(streamName string) (base.StatGroup, error) {
+	if streamName == "" {
+		return base.StatGroup{}, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	server, err := Server()
+	if err != nil {
+		return base.StatGroup{}, err
+	}
+	group := server.StatGroup(streamName)
+	if group == nil {
+		return base.StatGroup{}, newApiError(base.ErrorCodeGroupNotFound, base.DespGroupNotFound)
+	}
+	return *group, nil
+}
 
-	var ret base.ApiStatAllGroupResp
-	encoding.JSONDecode(res.String(), &ret)
-	return ret
+// StatNotify 对应 /api/stat/notify
+func StatNotify() (base.StatNotify, error) {
+	server, err := Server()
+	if err != nil {
+		return base.StatNotify{}, err
+	}
+	return server.StatNotify(), nil
 }
 
-// /api/ctrl/start_relay_pull: curl -H "Content-Type:application/json" -X POST -d '{"url": "rtmp://127.0.0.1/live/test110?token=aaa&p2=bbb", "pull_retry_num": 0}' http://127.0.0.1:8083/api/ctrl/start_relay_pull
-func HttpApiCtrlStartRelayPull(url string, stream_name string) base.ApiCtrlStartRelayPullResp {
-	res := req.C().R().SetBody(map[string]interface{}{
-		"url":         url,
-		"stream_name": stream_name,
-	}).MustPost(httpApiCtrlStartRelayPullUrl)
+// StatAcl 对应 /api/stat/acl
+func StatAcl() (base.StatAcl, error) {
+	server, err := Server()
+	if err != nil {
+		return base.StatAcl{}, err
+	}
+	return server.StatAcl(), nil
+}
 
-	var ret base.ApiCtrlStartRelayPullResp
-	encoding.JSONDecode(res.String(), &ret)
-	return ret
+// NewApiCtrlStartRelayPullReq 生成 CtrlStartRelayPull 的请求参数，除url和streamName外，其他参数使用http api中的默认值
+func NewApiCtrlStartRelayPullReq(url string, streamName string) base.ApiCtrlStartRelayPullReq {
+	return base.ApiCtrlStartRelayPullReq{
+		Url:                      url,
+		StreamName:               streamName,
+		PullTimeoutMs:            logic.DefaultApiCtrlStartRelayPullReqPullTimeoutMs,
+		PullRetryNum:             base.PullRetryNumNever,
+		AutoStopPullAfterNoOutMs: base.AutoStopPullAfterNoOutMsNever,
+		RtspMode:                 base.RtspModeTcp,
+	}
 }
 
-// /api/ctrl/stop_relay_pull: curl http://127.0.0.1:8083/api/ctrl/stop_relay_pull?stream_name=test110
-func HttpApiCtrlStopRelayPull(stream_name string) base.ApiCtrlStopRelayPullResp {
-	res := req.C().R().SetQueryParams(map[string]string{
-		"stream_name": stream_name,
-	}).MustGet(httpApiCtrlStopRelayPullUrl)
-	var ret base.ApiCtrlStopRelayPullResp
-	encoding.JSONDecode(res.String(), &ret)
-	return ret
+// CtrlStartRelayPull 对应 /api/ctrl/start_relay_pull
+func CtrlStartRelayPull(info base.ApiCtrlStartRelayPullReq) (base.ApiCtrlStartRelayPullResp, error) {
+	if info.Url == "" {
+		return base.ApiCtrlStartRelayPullResp{}, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	server, err := Server()
+	if err != nil {
+		return base.ApiCtrlStartRelayPullResp{}, err
+	}
+	resp := server.CtrlStartRelayPull(info)
+	return resp, checkResp(resp.ApiRespBasic)
+}
+
+// CtrlStopRelayPull 对应 /api/ctrl/stop_relay_pull
+func CtrlStopRelayPull(streamName string) (base.ApiCtrlStopRelayPullResp, error) {
+	if streamName == "" {
+		return base.ApiCtrlStopRelayPullResp{}, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	server, err := Server()
+	if err != nil {
+		return base.ApiCtrlStopRelayPullResp{}, err
+	}
+	resp := server.CtrlStopRelayPull(streamName)
+	return resp, checkResp(resp.ApiRespBasic)
+}
+
+// StatRelayPush 对应 /api/stat/relay_push
+func StatRelayPush(streamName string) ([]base.StatRelayPush, error) {
+	if streamName == "" {
+		return nil, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	server, err := Server()
+	if err != nil {
+		return nil, err
+	}
+	resp := server.StatRelayPush(streamName)
+	return resp.Data, checkResp(resp.ApiRespBasic)
+}
+
+// NewApiCtrlStartRelayPushReq 生成 CtrlStartRelayPush 的请求参数，永远重试，rtsp使用tcp方式转推
+func NewApiCtrlStartRelayPushReq(streamName string, url string) base.ApiCtrlStartRelayPushReq {
+	return base.ApiCtrlStartRelayPushReq{
+		StreamName: streamName,
+		Url:        url,
+		RetryNum:   base.PullRetryNumForever,
+		RtspMode:   base.RtspModeTcp,
+	}
+}
+
+// CtrlStartRelayPush 对应 /api/ctrl/start_relay_push
+//
+// @param info: RetryIntervalMs 、 RetryMaxIntervalMs 为0时使用配置文件中的值
+func CtrlStartRelayPush(info base.ApiCtrlStartRelayPushReq) (base.ApiCtrlStartRelayPushResp, error) {
+	if info.StreamName == "" || info.Url == "" {
+		return base.ApiCtrlStartRelayPushResp{}, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	server, err := Server()
+	if err != nil {
+		return base.ApiCtrlStartRelayPushResp{}, err
+	}
+	resp := server.CtrlStartRelayPush(info)
+	return resp, checkResp(resp.ApiRespBasic)
+}
+
+// CtrlStopRelayPush 对应 /api/ctrl/stop_relay_push
+func CtrlStopRelayPush(streamName string, url string) (base.ApiCtrlStopRelayPushResp, error) {
+	if streamName == "" || url == "" {
+		return base.ApiCtrlStopRelayPushResp{}, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	server, err := Server()
+	if err != nil {
+		return base.ApiCtrlStopRelayPushResp{}, err
+	}
+	resp := server.CtrlStopRelayPush(base.ApiCtrlStopRelayPushReq{StreamName: streamName, Url: url})
+	return resp, checkResp(resp.ApiRespBasic)
+}
+
+// StatRecord 流正在进行的录制，也即 /api/stat/group 中的records字段
+func StatRecord(streamName string) ([]base.StatRecord, error) {
+	group, err := StatGroup(streamName)
+	if err != nil {
+		return nil, err
+	}
+	return group.StatRecords, nil
+}
+
+// CtrlStartRecord 对应 /api/ctrl/start_record
+func CtrlStartRecord(info base.ApiCtrlStartRecordReq) (base.ApiCtrlStartRecordResp, error) {
+	if info.StreamName == "" || info.Format == "" {
+		return base.ApiCtrlStartRecordResp{}, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	server, err := Server()
+	if err != nil {
+		return base.ApiCtrlStartRecordResp{}, err
+	}
+	resp := server.CtrlStartRecord(info)
+	return resp, checkResp(resp.ApiRespBasic)
+}
+
+// CtrlStopRecord 对应 /api/ctrl/stop_record
+//
+// @param format: 为空时停止所有按需开启的录制
+func CtrlStopRecord(streamName string, format string) (base.ApiCtrlStopRecordResp, error) {
+	if streamName == "" {
+		return base.ApiCtrlStopRecordResp{}, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	server, err := Server()
+	if err != nil {
+		return base.ApiCtrlStopRecordResp{}, err
+	}
+	resp := server.CtrlStopRecord(base.ApiCtrlStopRecordReq{StreamName: streamName, Format: format})
+	return resp, checkResp(resp.ApiRespBasic)
+}
+
+// CtrlStartFilePub 对应 /api/ctrl/start_file_pub
+//
+// @param path: 文件路径，支持glob，比如 *.flv ，必须在配置 file_pub.root_dir 下
+func CtrlStartFilePub(streamName string, path string, loop bool) (base.ApiCtrlStartFilePubResp, error) {
+	if streamName == "" || path == "" {
+		return base.ApiCtrlStartFilePubResp{}, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	server, err := Server()
+	if err != nil {
+		return base.ApiCtrlStartFilePubResp{}, err
+	}
+	resp := server.CtrlStartFilePub(base.ApiCtrlStartFilePubReq{StreamName: streamName, Path: path, Loop: loop})
+	return resp, checkResp(resp.ApiRespBasic)
+}
+
+// CtrlStopFilePub 对应 /api/ctrl/stop_file_pub
+func CtrlStopFilePub(streamName string) (base.ApiCtrlStopFilePubResp, error) {
+	if streamName == "" {
+		return base.ApiCtrlStopFilePubResp{}, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	server, err := Server()
+	if err != nil {
+		return base.ApiCtrlStopFilePubResp{}, err
+	}
+	resp := server.CtrlStopFilePub(base.ApiCtrlStopFilePubReq{StreamName: streamName})
+	return resp, checkResp(resp.ApiRespBasic)
+}
+
+// CtrlStartUdpTsPub 对应 /api/ctrl/start_udp_ts_pub ，停止时使用 CtrlKickSession
+//
+// @param info: TimeoutMs 为0时使用http api中的默认值
+func CtrlStartUdpTsPub(info base.ApiCtrlStartUdpTsPubReq) (base.ApiCtrlStartUdpTsPubResp, error) {
+	if info.StreamName == "" || info.Addr == "" {
+		return base.ApiCtrlStartUdpTsPubResp{}, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	if info.TimeoutMs == 0 {
+		info.TimeoutMs = logic.DefaultApiCtrlStartUdpTsPubReqTimeoutMs
+	}
+	server, err := Server()
+	if err != nil {
+		return base.ApiCtrlStartUdpTsPubResp{}, err
+	}
+	resp := server.CtrlStartUdpTsPub(info)
+	return resp, checkResp(resp.ApiRespBasic)
+}
+
+// StatSnapshot 对应 /api/stat/snapshot ，format见 base.SnapshotFormatAnnexb 等，为空时使用annexb
+func StatSnapshot(streamName string, format string) (base.Snapshot, error) {
+	if streamName == "" {
+		return base.Snapshot{}, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	server, err := Server()
+	if err != nil {
+		return base.Snapshot{}, err
+	}
+	resp := server.StatSnapshot(streamName, format)
+	if err = checkResp(resp.ApiRespBasic); err != nil {
+		return base.Snapshot{}, err
+	}
+	return *resp.Data, nil
+}
+
+// StatRecordRetention 对应 /api/stat/record_retention
+func StatRecordRetention() (base.StatRecordRetention, error) {
+	server, err := Server()
+	if err != nil {
+		return base.StatRecordRetention{}, err
+	}
+	return server.StatRecordRetention(), nil
+}
+
+// CtrlKickSession 对应 /api/ctrl/kick_session
+func CtrlKickSession(info base.ApiCtrlKickSessionReq) (base.ApiCtrlKickSessionResp, error) {
+	if info.StreamName == "" || info.SessionId == "" {
+		return base.ApiCtrlKickSessionResp{}, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	server, err := Server()
+	if err != nil {
+		return base.ApiCtrlKickSessionResp{}, err
+	}
+	resp := server.CtrlKickSession(info)
+	return resp, checkResp(resp.ApiRespBasic)
+}
+
+// CtrlStartRtpPub 对应 /api/ctrl/start_rtp_pub
+//
+// @param info: TimeoutMs 为0时使用http api中的默认值
+func CtrlStartRtpPub(info base.ApiCtrlStartRtpPubReq) (base.ApiCtrlStartRtpPubResp, error) {
+	if info.StreamName == "" {
+		return base.ApiCtrlStartRtpPubResp{}, newApiError(base.ErrorCodeParamMissing, base.DespParamMissing)
+	}
+	if info.TimeoutMs == 0 {
+		info.TimeoutMs = logic.DefaultApiCtrlStartRtpPubReqTimeoutMs
+	}
+	server, err := Server()
+	if err != nil {
+		return base.ApiCtrlStartRtpPubResp{}, err
+	}
+	resp := server.CtrlStartRtpPub(info)
+	return resp, checkResp(resp.ApiRespBasic)
+}
+
+// CtrlReloadAcl 对应 /api/ctrl/reload_acl
+//
+// 注意，只修改运行中的策略，不写入配置文件，重启lalserver后恢复为配置文件中的策略
+func CtrlReloadAcl(config base.AclConfig) (base.ApiCtrlReloadAclResp, error) {
+	server, err := Server()
+	if err != nil {
+		return base.ApiCtrlReloadAclResp{}, err
+	}
+	resp := server.CtrlReloadAcl(base.ApiCtrlReloadAclReq{AclConfig: config})
+	return resp, checkResp(resp.ApiRespBasic)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newApiError(errorCode int, desp string) *ApiError {
+	return &ApiError{ErrorCode: errorCode, Desp: desp}
+}
+
+func checkResp(resp base.ApiRespBasic) error {
+	if resp.ErrorCode == base.ErrorCodeSucc {
+		return nil
+	}
+	return newApiError(resp.ErrorCode, resp.Desp)
 }
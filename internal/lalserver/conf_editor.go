@@ -0,0 +1,245 @@
+package lalserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// conf_editor.go
+//
+// 直接在配置文件的原始文本上修改字段，保持key的顺序、缩进、以及没有修改的部分不变，
+// 避免反序列化再序列化后把用户的配置文件重新排序、格式化。
+//
+
+// confEditor 第一次出错后，后续的修改都不再执行，通过 result 返回错误
+type confEditor struct {
+	raw []byte
+	err error
+}
+
+func newConfEditor(raw []byte) *confEditor {
+	return &confEditor{raw: raw}
+}
+
+func (e *confEditor) result() ([]byte, error) {
+	return e.raw, e.err
+}
+
+// set 修改path（用`.`分隔的多级key）对应的值，key不存在时添加到所在对象的末尾，中间的对象不存在时一并创建
+func (e *confEditor) set(path string, v interface{}) {
+	if e.err != nil {
+		return
+	}
+	value, err := json.Marshal(v)
+	if err != nil {
+		e.err = err
+		return
+	}
+
+	keys := strings.Split(path, ".")
+	objStart, err := skipWhitespace(e.raw, 0)
+	if err != nil {
+		e.err = err
+		return
+	}
+	for i, k := range keys {
+		members, objEnd, err := scanObject(e.raw, objStart)
+		if err != nil {
+			e.err = fmt.Errorf("lalserver: edit conf failed. path=%s, err=%w", path, err)
+			return
+		}
+		m, ok := findMember(members, k)
+		if !ok {
+			// 把剩余的key组装成嵌套的对象
+			for j := len(keys) - 1; j > i; j-- {
+				value = []byte(fmt.Sprintf("{%s: %s}", quoteKey(keys[j]), value))
+			}
+			e.raw = insertMember(e.raw, members, objStart, objEnd, k, value)
+			return
+		}
+		if i == len(keys)-1 {
+			e.raw = replaceBytes(e.raw, m.valueStart, m.valueEnd, value)
+			return
+		}
+		if e.raw[m.valueStart] != '{' {
+			e.err = fmt.Errorf("lalserver: edit conf failed, not an object. path=%s, key=%s", path, k)
+			return
+		}
+		objStart = m.valueStart
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type jsonMember struct {
+	index      int
+	key        string
+	keyStart   int
+	valueStart int
+	valueEnd   int // 不包含
+}
+
+// scanObject 解析从start（`{`的位置）开始的对象的所有成员
+//
+// @return end: `}`的位置
+func scanObject(raw []byte, start int) (members []jsonMember, end int, err error) {
+	if start >= len(raw) || raw[start] != '{' {
+		return nil, 0, fmt.Errorf("expect object at %d", start)
+	}
+	pos, err := skipWhitespace(raw, start+1)
+	if err != nil {
+		return nil, 0, err
+	}
+	if raw[pos] == '}' {
+		return nil, pos, nil
+	}
+	for {
+		var m jsonMember
+		m.index = len(members)
+		m.keyStart = pos
+		keyEnd, err := skipString(raw, pos)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err = json.Unmarshal(raw[pos:keyEnd], &m.key); err != nil {
+			return nil, 0, err
+		}
+		if pos, err = expectByte(raw, keyEnd, ':'); err != nil {
+			return nil, 0, err
+		}
+		if m.valueStart, err = skipWhitespace(raw, pos); err != nil {
+			return nil, 0, err
+		}
+		if m.valueEnd, err = skipValue(raw, m.valueStart); err != nil {
+			return nil, 0, err
+		}
+		members = append(members, m)
+
+		if pos, err = skipWhitespace(raw, m.valueEnd); err != nil {
+			return nil, 0, err
+		}
+		switch raw[pos] {
+		case '}':
+			return members, pos, nil
+		case ',':
+			if pos, err = skipWhitespace(raw, pos+1); err != nil {
+				return nil, 0, err
+			}
+		default:
+			return nil, 0, fmt.Errorf("unexpected %q at %d", raw[pos], pos)
+		}
+	}
+}
+
+func findMember(members []jsonMember, key string) (jsonMember, bool) {
+	for _, m := range members {
+		if m.key == key {
+			return m, true
+		}
+	}
+	return jsonMember{}, false
+}
+
+// insertMember 添加到对象的末尾，缩进与对象中最后一个成员保持一致
+func insertMember(raw []byte, members []jsonMember, objStart int, objEnd int, key string, value []byte) []byte {
+	member := quoteKey(key) + ": " + string(value)
+	if len(members) == 0 {
+		return replaceBytes(raw, objStart+1, objEnd, []byte(member))
+	}
+	last := members[len(members)-1]
+	indent := " "
+	if i := bytes.LastIndexByte(raw[:last.keyStart], '\n'); i != -1 {
+		indent = string(raw[i:last.keyStart])
+	}
+	return replaceBytes(raw, last.valueEnd, last.valueEnd, []byte(","+indent+member))
+}
+
+func replaceBytes(raw []byte, start int, end int, b []byte) []byte {
+	ret := make([]byte, 0, len(raw)-(end-start)+len(b))
+	ret = append(ret, raw[:start]...)
+	ret = append(ret, b...)
+	return append(ret, raw[end:]...)
+}
+
+func quoteKey(key string) string {
+	b, _ := json.Marshal(key)
+	return string(b)
+}
+
+func skipWhitespace(raw []byte, pos int) (int, error) {
+	for ; pos < len(raw); pos++ {
+		switch raw[pos] {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return pos, nil
+		}
+	}
+	return 0, fmt.Errorf("unexpected end of json")
+}
+
+func expectByte(raw []byte, pos int, c byte) (int, error) {
+	pos, err := skipWhitespace(raw, pos)
+	if err != nil {
+		return 0, err
+	}
+	if raw[pos] != c {
+		return 0, fmt.Errorf("expect %q at %d", c, pos)
+	}
+	return pos + 1, nil
+}
+
+// skipString 返回字符串结尾的`"`之后的位置
+func skipString(raw []byte, pos int) (int, error) {
+	if raw[pos] != '"' {
+		return 0, fmt.Errorf("expect string at %d", pos)
+	}
+	for i := pos + 1; i < len(raw); i++ {
+		switch raw[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unexpected end of json")
+}
+
+// skipValue 返回值结尾之后的位置，只检查括号是否匹配，值的合法性由 json.Unmarshal 检查
+func skipValue(raw []byte, pos int) (int, error) {
+	switch raw[pos] {
+	case '"':
+		return skipString(raw, pos)
+	case '{', '[':
+		depth := 0
+		for i := pos; i < len(raw); i++ {
+			switch raw[i] {
+			case '"':
+				end, err := skipString(raw, i)
+				if err != nil {
+					return 0, err
+				}
+				i = end - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1, nil
+				}
+			}
+		}
+		return 0, fmt.Errorf("unexpected end of json")
+	default:
+		// 数字、true、false、null
+		i := pos
+		for ; i < len(raw); i++ {
+			switch raw[i] {
+			case ',', '}', ']', ' ', '\t', '\r', '\n':
+				return i, nil
+			}
+		}
+		return i, nil
+	}
+}
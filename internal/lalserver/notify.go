@@ -0,0 +1,160 @@
+package lalserver
+
+import (
+	"sync"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+)
+
+// notify.go
+//
+// lalserver的事件通知：
+// - 实现 logic.INotifyHandler ，每个事件通过 SetOnNotify 设置的回调转发出去（app层转发为Wails事件）
+// - 在内存中保留最近的事件，供前端查询
+//
+
+// 事件名，同时也是app层发送给前端的Wails事件名
+const (
+	NotifyEventServerStart    = "lalserver_on_server_start"
+	NotifyEventUpdate         = "lalserver_on_update"
+	NotifyEventPubStart       = "lalserver_on_pub_start"
+	NotifyEventPubStop        = "lalserver_on_pub_stop"
+	NotifyEventSubStart       = "lalserver_on_sub_start"
+	NotifyEventSubStop        = "lalserver_on_sub_stop"
+	NotifyEventRelayPullStart = "lalserver_on_relay_pull_start"
+	NotifyEventRelayPullStop  = "lalserver_on_relay_pull_stop"
+	NotifyEventRtmpConnect    = "lalserver_on_rtmp_connect"
+	NotifyEventHlsMakeTs      = "lalserver_on_hls_make_ts"
+	NotifyEventRecordSegment  = "lalserver_on_record_segment"
+	NotifyEventRecordError    = "lalserver_on_record_error"
+)
+
+// maxNotifyHistoryNum 内存中最多保留的事件数量
+const maxNotifyHistoryNum = 1000
+
+// NotifyEvent 一条事件记录
+type NotifyEvent struct {
+	Name string      `json:"name"`
+	Time int64       `json:"time"` // unix毫秒
+	Info interface{} `json:"info"` // base.PubStartInfo 等，和事件名一一对应
+}
+
+var (
+	notifyMutex   sync.Mutex
+	notifyHistory []NotifyEvent // 环形缓冲
+	notifyNext    int           // 下一条事件在环形缓冲中的位置
+	onNotifyEvent func(event NotifyEvent)
+)
+
+// SetOnNotify 设置事件回调，回调在lalserver的通知协程中执行，不要阻塞
+func SetOnNotify(fn func(event NotifyEvent)) {
+	notifyMutex.Lock()
+	defer notifyMutex.Unlock()
+	onNotifyEvent = fn
+}
+
+// NotifyHistory 查询最近的事件，按时间从早到晚排序
+//
+// @param name:  事件名，为空时返回所有类型的事件
+// @param limit: 最多返回的条数，小于等于0时不限制
+func NotifyHistory(name string, limit int) []NotifyEvent {
+	notifyMutex.Lock()
+	defer notifyMutex.Unlock()
+
+	n := len(notifyHistory)
+	ret := make([]NotifyEvent, 0)
+	// 从最新的事件往前找
+	for i := 0; i < n; i++ {
+		if limit > 0 && len(ret) >= limit {
+			break
+		}
+		event := notifyHistory[(notifyNext-1-i+n)%n]
+		if name == "" || event.Name == name {
+			ret = append(ret, event)
+		}
+	}
+	for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+		ret[i], ret[j] = ret[j], ret[i]
+	}
+	return ret
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// notifyHandler 注册到lalserver中的事件监听
+//
+// 注意，http notify由lalserver内部的 logic.NotifyDispatcher 同时分发，这里不需要再处理
+type notifyHandler struct{}
+
+func (h *notifyHandler) OnServerStart(info base.LalInfo) {
+	emitNotify(NotifyEventServerStart, info)
+}
+
+func (h *notifyHandler) OnUpdate(info base.UpdateInfo) {
+	emitNotify(NotifyEventUpdate, info)
+}
+
+func (h *notifyHandler) OnPubStart(info base.PubStartInfo) {
+	emitNotify(NotifyEventPubStart, info)
+}
+
+func (h *notifyHandler) OnPubStop(info base.PubStopInfo) {
+	emitNotify(NotifyEventPubStop, info)
+}
+
+func (h *notifyHandler) OnSubStart(info base.SubStartInfo) {
+	emitNotify(NotifyEventSubStart, info)
+}
+
+func (h *notifyHandler) OnSubStop(info base.SubStopInfo) {
+	emitNotify(NotifyEventSubStop, info)
+}
+
+func (h *notifyHandler) OnRelayPullStart(info base.PullStartInfo) {
+	emitNotify(NotifyEventRelayPullStart, info)
+}
+
+func (h *notifyHandler) OnRelayPullStop(info base.PullStopInfo) {
+	emitNotify(NotifyEventRelayPullStop, info)
+}
+
+func (h *notifyHandler) OnRtmpConnect(info base.RtmpConnectInfo) {
+	emitNotify(NotifyEventRtmpConnect, info)
+}
+
+func (h *notifyHandler) OnHlsMakeTs(info base.HlsMakeTsInfo) {
+	emitNotify(NotifyEventHlsMakeTs, info)
+}
+
+func (h *notifyHandler) OnRecordSegment(info base.RecordSegmentInfo) {
+	emitNotify(NotifyEventRecordSegment, info)
+}
+
+func (h *notifyHandler) OnRecordError(info base.RecordErrorInfo) {
+	emitNotify(NotifyEventRecordError, info)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func emitNotify(name string, info interface{}) {
+	event := NotifyEvent{
+		Name: name,
+		Time: time.Now().UnixMilli(),
+		Info: info,
+	}
+
+	notifyMutex.Lock()
+	if len(notifyHistory) < maxNotifyHistoryNum {
+		notifyHistory = append(notifyHistory, event)
+	} else {
+		notifyHistory[notifyNext] = event
+	}
+	notifyNext = (notifyNext + 1) % maxNotifyHistoryNum
+	fn := onNotifyEvent
+	notifyMutex.Unlock()
+
+	if fn != nil {
+		fn(event)
+	}
+}
@@ -0,0 +1,220 @@
+package lalserver
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/logic"
+	"live-server/library/naza/pkg/nazalog"
+)
+
+// server.go
+//
+// 内置lalserver的生命周期管理：
+// - 按需启动、停止、重启
+// - 启动前解析配置并检查端口，失败时返回错误，而不是让lal内部退出程序
+// - 运行过程中异常退出（比如端口在检查之后被占用）时，释放已经启动的服务，并通过回调上报状态
+//
+
+type ServerState string
+
+const (
+	ServerStateStopped ServerState = "stopped"
+	ServerStateRunning ServerState = "running"
+	ServerStateFailed  ServerState = "failed" // 启动失败或者运行过程中异常退出
+)
+
+var ErrServerNotRunning = errors.New("lalserver: server not running")
+
+type ServerStatus struct {
+	State     ServerState `json:"state"`
+	LastError string      `json:"last_error"`
+	StartTime string      `json:"start_time"` // 只在 ServerStateRunning 时有效
+}
+
+var (
+	mutex          sync.Mutex
+	lals           logic.ILalServer
+	loopDone       chan struct{}
+	runningConfig  *logic.Config // 正在运行的配置
+	status         = ServerStatus{State: ServerStateStopped}
+	onStatusChange func(status ServerStatus)
+
+	signalHandlerOnce sync.Once
+)
+
+// SetOnStatusChange 设置状态变化的回调，回调可能在任意协程中执行
+func SetOnStatusChange(fn func(status ServerStatus)) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	onStatusChange = fn
+}
+
+// Status 获取lalserver的运行状态
+func Status() ServerStatus {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return status
+}
+
+// Server 获取正在运行的lalserver，没有运行时返回 ErrServerNotRunning
+func Server() (logic.ILalServer, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if lals == nil {
+		return nil, ErrServerNotRunning
+	}
+	return lals, nil
+}
+
+// Start 按配置文件启动lalserver，已经在运行时直接返回
+func Start() error {
+	mutex.Lock()
+	if lals != nil {
+		mutex.Unlock()
+		return nil
+	}
+	err := startWithConfFile(nil)
+	fn, st := onStatusChange, status
+	mutex.Unlock()
+
+	notifyStatus(fn, st)
+	return err
+}
+
+// Stop 停止lalserver，释放所有的group（包括其中的session、hls、录制等）以及监听的端口
+func Stop() error {
+	mutex.Lock()
+	if lals == nil {
+		mutex.Unlock()
+		return nil
+	}
+	stop()
+	setStatus(ServerStateStopped, nil)
+	fn, st := onStatusChange, status
+	mutex.Unlock()
+
+	notifyStatus(fn, st)
+	return nil
+}
+
+// Restart 重新读取配置文件并重启lalserver，没有运行时直接启动，用于用户手动修改了配置文件的场景
+func Restart() error {
+	mutex.Lock()
+	err := startWithConfFile(runningConfig)
+	fn, st := onStatusChange, status
+	mutex.Unlock()
+
+	notifyStatus(fn, st)
+	return err
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// startWithConfFile 注意，调用方需要持有锁
+//
+// @param running: 正在运行的配置，见 validateConf
+func startWithConfFile(running *logic.Config) error {
+	rawContent, config, err := loadConf()
+	if err == nil {
+		err = validateConf(config, running)
+	}
+	if err != nil {
+		// 校验失败时，正在运行的lalserver保持不变
+		if lals == nil {
+			setStatus(ServerStateFailed, err)
+		}
+		return err
+	}
+	restart(rawContent, config)
+	return nil
+}
+
+// restart 注意，调用方需要持有锁，并且已经校验过配置
+func restart(rawContent []byte, config *logic.Config) {
+	stop()
+	start(rawContent, config)
+}
+
+func start(rawContent []byte, config *logic.Config) {
+	// 信号只在进程级别监听一次，收到信号时停止当前正在运行的lalserver
+	signalHandlerOnce.Do(func() {
+		go base.RunSignalHandler(func() {
+			_ = Stop()
+		})
+	})
+
+	server := logic.NewLalServer(func(option *logic.Option) {
+		option.ConfRawContent = rawContent
+		option.NotifyHandler = &notifyHandler{}
+		option.DisableSignalHandler = true
+	})
+	done := make(chan struct{})
+	lals = server
+	loopDone = done
+	runningConfig = config
+	setStatus(ServerStateRunning, nil)
+
+	go func() {
+		err := server.RunLoop()
+		nazalog.Infof("lal server loop done. err=%+v", err)
+		nazalog.Sync()
+		close(done)
+		onLoopDone(server, err)
+	}()
+}
+
+// onLoopDone 没有经过 stop 而退出了RunLoop，比如监听端口失败，或者收到了退出信号
+func onLoopDone(server logic.ILalServer, err error) {
+	mutex.Lock()
+	if lals != server {
+		// 通过 stop 主动关闭
+		mutex.Unlock()
+		return
+	}
+	if err != nil {
+		// 释放已经启动的其他服务，避免下次启动时端口被自己占用
+		server.Dispose()
+		setStatus(ServerStateFailed, err)
+	} else {
+		setStatus(ServerStateStopped, nil)
+	}
+	lals = nil
+	runningConfig = nil
+	fn, st := onStatusChange, status
+	mutex.Unlock()
+
+	notifyStatus(fn, st)
+}
+
+func stop() {
+	if lals == nil {
+		return
+	}
+	server, done := lals, loopDone
+	lals = nil
+	runningConfig = nil
+	server.Dispose()
+	<-done
+}
+
+func setStatus(state ServerState, err error) {
+	status.State = state
+	status.LastError = ""
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	if state == ServerStateRunning {
+		status.StartTime = time.Now().Format("2006-01-02 15:04:05")
+	} else {
+		status.StartTime = ""
+	}
+}
+
+func notifyStatus(fn func(status ServerStatus), st ServerStatus) {
+	if fn != nil {
+		fn(st)
+	}
+}
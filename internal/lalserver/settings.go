@@ -0,0 +1,287 @@
+package lalserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"live-server/library/LAL/pkg/logic"
+)
+
+// settings.go
+//
+// lalserver的可视化配置：
+// - 配置文件 ConfFilename 由用户维护，首次启动时从内置的默认配置释放，之后不再覆盖
+// - Settings 是配置文件中设置页面关心的那部分字段，其余字段仍然可以直接编辑配置文件
+// - 修改配置时检查端口冲突以及端口是否可用，写入配置文件后重启 logic.ServerManager
+//
+
+var (
+	ErrInvalidPort     = errors.New("lalserver: invalid port")
+	ErrPortConflict    = errors.New("lalserver: port conflict")
+	ErrPortUnavailable = errors.New("lalserver: port unavailable")
+)
+
+// Settings 设置页面使用的lalserver配置
+//
+// 注意，HttpPort 和 HttpsPort 对应 default_http ，由没有单独配置监听地址的httpflv、httpts、hls共用
+type Settings struct {
+	RtmpEnable  bool `json:"rtmp_enable"`
+	RtmpPort    int  `json:"rtmp_port"`
+	RtmpsEnable bool `json:"rtmps_enable"`
+	RtmpsPort   int  `json:"rtmps_port"`
+
+	RtspEnable  bool `json:"rtsp_enable"`
+	RtspPort    int  `json:"rtsp_port"`
+	RtspsEnable bool `json:"rtsps_enable"`
+	RtspsPort   int  `json:"rtsps_port"`
+
+	HttpPort      int  `json:"http_port"`
+	HttpsPort     int  `json:"https_port"`
+	HttpsEnable   bool `json:"https_enable"`
+	HttpflvEnable bool `json:"httpflv_enable"`
+	HttptsEnable  bool `json:"httpts_enable"`
+	HlsEnable     bool `json:"hls_enable"`
+
+	HttpApiEnable bool `json:"http_api_enable"`
+	HttpApiPort   int  `json:"http_api_port"`
+	PprofEnable   bool `json:"pprof_enable"`
+	PprofPort     int  `json:"pprof_port"`
+
+	RecordFlvEnable    bool `json:"record_flv_enable"`
+	RecordMpegtsEnable bool `json:"record_mpegts_enable"`
+}
+
+// GetSettings 从配置文件中读取当前的配置
+func GetSettings() (Settings, error) {
+	_, config, err := loadConf()
+	if err != nil {
+		return Settings{}, err
+	}
+	return newSettings(config), nil
+}
+
+// UpdateSettings 校验并保存配置，lalserver正在运行时重启使配置生效
+//
+// 校验失败时不修改配置文件，也不重启
+func UpdateSettings(settings Settings) error {
+	rawContent, err := os.ReadFile(ConfFilename)
+	if err != nil {
+		return err
+	}
+	oldConfig, err := parseConf(rawContent)
+	if err != nil {
+		return err
+	}
+	// 在原始文本上修改，保持用户配置文件的格式
+	editor := newConfEditor(rawContent)
+	settings.applyTo(editor, newSettings(oldConfig))
+	newContent, err := editor.result()
+	if err != nil {
+		return err
+	}
+	config, err := parseConf(newContent)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	if err = validateConf(config, runningConfig); err != nil {
+		mutex.Unlock()
+		return err
+	}
+	if err = os.WriteFile(ConfFilename, newContent, 0666); err != nil {
+		mutex.Unlock()
+		return err
+	}
+	// 用户主动停止了lalserver时只保存配置，下次启动时生效
+	if lals == nil {
+		mutex.Unlock()
+		return nil
+	}
+	restart(newContent, config)
+	fn, st := onStatusChange, status
+	mutex.Unlock()
+
+	notifyStatus(fn, st)
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newSettings(config *logic.Config) Settings {
+	return Settings{
+		RtmpEnable:         config.RtmpConfig.Enable,
+		RtmpPort:           addrPort(config.RtmpConfig.Addr),
+		RtmpsEnable:        config.RtmpConfig.RtmpsEnable,
+		RtmpsPort:          addrPort(config.RtmpConfig.RtmpsAddr),
+		RtspEnable:         config.RtspConfig.Enable,
+		RtspPort:           addrPort(config.RtspConfig.Addr),
+		RtspsEnable:        config.RtspConfig.RtspsEnable,
+		RtspsPort:          addrPort(config.RtspConfig.RtspsAddr),
+		HttpPort:           addrPort(config.DefaultHttpConfig.HttpListenAddr),
+		HttpsPort:          addrPort(config.DefaultHttpConfig.HttpsListenAddr),
+		HttpsEnable:        config.HttpflvConfig.EnableHttps || config.HttptsConfig.EnableHttps || config.HlsConfig.EnableHttps,
+		HttpflvEnable:      config.HttpflvConfig.Enable,
+		HttptsEnable:       config.HttptsConfig.Enable,
+		HlsEnable:          config.HlsConfig.Enable,
+		HttpApiEnable:      config.HttpApiConfig.Enable,
+		HttpApiPort:        addrPort(config.HttpApiConfig.Addr),
+		PprofEnable:        config.PprofConfig.Enable,
+		PprofPort:          addrPort(config.PprofConfig.Addr),
+		RecordFlvEnable:    config.RecordConfig.EnableFlv,
+		RecordMpegtsEnable: config.RecordConfig.EnableMpegts,
+	}
+}
+
+// applyTo 将配置写入配置文件，只修改和old相比发生变化的字段，其余字段保持不变
+//
+// 注意，HttpPort 和 HttpsPort 只修改 default_http ，httpflv、httpts、hls单独配置的监听地址保持不变。
+// HttpsEnable 发生变化时，同时修改httpflv、httpts、hls的 enable_https
+//
+// @param old: 修改前配置文件中的配置
+func (s Settings) applyTo(e *confEditor, old Settings) {
+	setBool := func(path string, v bool, oldV bool) {
+		if v != oldV {
+			e.set(path, v)
+		}
+	}
+	setAddr := func(path string, port int, oldPort int) {
+		if port != oldPort {
+			setConfAddr(e, path, port)
+		}
+	}
+
+	setBool("rtmp.enable", s.RtmpEnable, old.RtmpEnable)
+	setAddr("rtmp.addr", s.RtmpPort, old.RtmpPort)
+	setBool("rtmp.rtmps_enable", s.RtmpsEnable, old.RtmpsEnable)
+	setAddr("rtmp.rtmps_addr", s.RtmpsPort, old.RtmpsPort)
+	setBool("rtsp.enable", s.RtspEnable, old.RtspEnable)
+	setAddr("rtsp.addr", s.RtspPort, old.RtspPort)
+	setBool("rtsp.rtsps_enable", s.RtspsEnable, old.RtspsEnable)
+	setAddr("rtsp.rtsps_addr", s.RtspsPort, old.RtspsPort)
+
+	setAddr("default_http.http_listen_addr", s.HttpPort, old.HttpPort)
+	setAddr("default_http.https_listen_addr", s.HttpsPort, old.HttpsPort)
+	if s.HttpsEnable != old.HttpsEnable {
+		for _, app := range []string{"httpflv", "httpts", "hls"} {
+			e.set(app+".enable_https", s.HttpsEnable)
+		}
+	}
+	setBool("httpflv.enable", s.HttpflvEnable, old.HttpflvEnable)
+	setBool("httpts.enable", s.HttptsEnable, old.HttptsEnable)
+	setBool("hls.enable", s.HlsEnable, old.HlsEnable)
+
+	setBool("http_api.enable", s.HttpApiEnable, old.HttpApiEnable)
+	setAddr("http_api.addr", s.HttpApiPort, old.HttpApiPort)
+	setBool("pprof.enable", s.PprofEnable, old.PprofEnable)
+	setAddr("pprof.addr", s.PprofPort, old.PprofPort)
+
+	setBool("record.enable_flv", s.RecordFlvEnable, old.RecordFlvEnable)
+	setBool("record.enable_mpegts", s.RecordMpegtsEnable, old.RecordMpegtsEnable)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type listenItem struct {
+	name  string
+	group string // 同一个group的服务共用监听端口，比如httpflv和hls
+	addr  string
+}
+
+// listenItems 配置中所有开启了的服务的监听地址
+func listenItems(config *logic.Config) []listenItem {
+	var items []listenItem
+	add := func(enable bool, name string, group string, addr string) {
+		if enable {
+			items = append(items, listenItem{name: name, group: group, addr: addr})
+		}
+	}
+	add(config.RtmpConfig.Enable, "rtmp", "rtmp", config.RtmpConfig.Addr)
+	add(config.RtmpConfig.RtmpsEnable, "rtmps", "rtmps", config.RtmpConfig.RtmpsAddr)
+	add(config.RtspConfig.Enable, "rtsp", "rtsp", config.RtspConfig.Addr)
+	add(config.RtspConfig.RtspsEnable, "rtsps", "rtsps", config.RtspConfig.RtspsAddr)
+	for _, c := range []struct {
+		name   string
+		config logic.CommonHttpServerConfig
+	}{
+		{"httpflv", config.HttpflvConfig.CommonHttpServerConfig},
+		{"httpts", config.HttptsConfig.CommonHttpServerConfig},
+		{"hls", config.HlsConfig.CommonHttpServerConfig},
+	} {
+		add(c.config.Enable, c.name, "http", httpListenAddr(c.config.HttpListenAddr, config.DefaultHttpConfig.HttpListenAddr))
+		add(c.config.EnableHttps, c.name+"(https)", "https", httpListenAddr(c.config.HttpsListenAddr, config.DefaultHttpConfig.HttpsListenAddr))
+	}
+	add(config.HttpApiConfig.Enable, "http_api", "http_api", config.HttpApiConfig.Addr)
+	add(config.PprofConfig.Enable, "pprof", "pprof", config.PprofConfig.Addr)
+	return items
+}
+
+// validateConf 检查端口是否合法、是否冲突、是否被其他程序占用
+//
+// @param running: 正在运行的配置，可以为nil。正在运行的lalserver占用的端口在重启时会释放，所以不检查是否可用
+func validateConf(config *logic.Config, running *logic.Config) error {
+	items := listenItems(config)
+
+	port2Item := make(map[int]listenItem)
+	for _, item := range items {
+		port := addrPort(item.addr)
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("%w. name=%s, addr=%s", ErrInvalidPort, item.name, item.addr)
+		}
+		if exist, ok := port2Item[port]; ok && exist.group != item.group {
+			return fmt.Errorf("%w. port=%d, %s and %s", ErrPortConflict, port, exist.name, item.name)
+		}
+		port2Item[port] = item
+	}
+
+	runningPorts := make(map[int]bool)
+	if running != nil {
+		for _, item := range listenItems(running) {
+			runningPorts[addrPort(item.addr)] = true
+		}
+	}
+	for port, item := range port2Item {
+		if runningPorts[port] {
+			continue
+		}
+		ln, err := net.Listen("tcp", item.addr)
+		if err != nil {
+			return fmt.Errorf("%w. name=%s, port=%d, err=%+v", ErrPortUnavailable, item.name, port, err)
+		}
+		_ = ln.Close()
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func httpListenAddr(addr string, defaultAddr string) string {
+	if addr == "" {
+		return defaultAddr
+	}
+	return addr
+}
+
+// addrPort 从`:1935`或`0.0.0.0:1935`格式的地址中解析出端口，解析失败时返回0
+func addrPort(addr string) int {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return 0
+	}
+	return p
+}
+
+func setConfAddr(e *confEditor, path string, port int) {
+	// 端口为0时保留原有的地址，比如服务本来就没有配置
+	if port == 0 {
+		return
+	}
+	e.set(path, fmt.Sprintf(":%d", port))
+}
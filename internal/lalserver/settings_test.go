@@ -0,0 +1,140 @@
+package lalserver
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSettings_ApplyTo(t *testing.T) {
+	rawContent, err := conf.ReadFile("configs/lalserver.conf.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := parseConf(rawContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 配置不变时，配置文件不变
+	settings := newSettings(config)
+	old := settings
+	e := newConfEditor(rawContent)
+	settings.applyTo(e, old)
+	newContent, err := e.result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(newContent) != string(rawContent) {
+		t.Fatalf("conf changed.\n%s", newContent)
+	}
+
+	// 只有修改的行发生变化，key的顺序以及其他字段保持不变
+	settings.RtmpPort = 1936
+	settings.HlsEnable = false
+	e = newConfEditor(rawContent)
+	settings.applyTo(e, old)
+	if newContent, err = e.result(); err != nil {
+		t.Fatal(err)
+	}
+	oldLines := strings.Split(string(rawContent), "\n")
+	newLines := strings.Split(string(newContent), "\n")
+	if len(oldLines) != len(newLines) {
+		t.Fatalf("line num changed.\n%s", newContent)
+	}
+	var diff []string
+	for i := range oldLines {
+		if oldLines[i] != newLines[i] {
+			diff = append(diff, strings.TrimSpace(newLines[i]))
+		}
+	}
+	if strings.Join(diff, " ") != `"addr": ":1936", "enable": false,` {
+		t.Fatalf("invalid diff. %v", diff)
+	}
+	newConfig, err := parseConf(newContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newSettings(newConfig) != settings {
+		t.Fatalf("settings mismatch. %+v", newSettings(newConfig))
+	}
+}
+
+// 用户手动修改的单独的监听地址以及https配置，在没有修改对应的配置时保持不变
+func TestSettings_ApplyToKeepPerApp(t *testing.T) {
+	rawContent := []byte(`{
+  "default_http": {"http_listen_addr": ":8080", "https_listen_addr": ":4433"},
+  "httpflv": {"enable": true, "enable_https": true, "http_listen_addr": ":8081"},
+  "httpts": {"enable": true, "enable_https": false},
+  "hls": {"enable": true, "enable_https": false, "https_listen_addr": ":4434"}
+}`)
+	config, err := parseConf(rawContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := newSettings(config)
+	settings := old
+	settings.HttpPort = 8082
+	settings.HlsEnable = false
+	e := newConfEditor(rawContent)
+	settings.applyTo(e, old)
+	newContent, err := e.result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConfig, err := parseConf(newContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newConfig.DefaultHttpConfig.HttpListenAddr != ":8082" || newConfig.HlsConfig.Enable ||
+		newConfig.HttpflvConfig.HttpListenAddr != ":8081" || newConfig.HlsConfig.HttpsListenAddr != ":4434" ||
+		!newConfig.HttpflvConfig.EnableHttps || newConfig.HttptsConfig.EnableHttps || newConfig.HlsConfig.EnableHttps {
+		t.Fatalf("invalid conf.\n%s", newContent)
+	}
+
+	// 修改了https开关时，统一修改
+	settings.HttpsEnable = false
+	e = newConfEditor(rawContent)
+	settings.applyTo(e, old)
+	if newContent, err = e.result(); err != nil {
+		t.Fatal(err)
+	}
+	if newConfig, err = parseConf(newContent); err != nil {
+		t.Fatal(err)
+	}
+	if newConfig.HttpflvConfig.EnableHttps || newConfig.HttpflvConfig.HttpListenAddr != ":8081" {
+		t.Fatalf("invalid conf.\n%s", newContent)
+	}
+}
+
+func TestConfEditor(t *testing.T) {
+	golden := []struct {
+		in       string
+		path     string
+		value    interface{}
+		expected string
+	}{
+		{`{"a": 1, "b": {"c": "x\"}"}}`, "b.c", "y", `{"a": 1, "b": {"c": "y"}}`},
+		{"{\n  \"a\": 1\n}", "b", true, "{\n  \"a\": 1,\n  \"b\": true\n}"},
+		{`{"a": 1}`, "b.c.d", 2, `{"a": 1, "b": {"c": {"d": 2}}}`},
+		{`{"a": {}}`, "a.b", 2, `{"a": {"b": 2}}`},
+		{`{"a": 1, "b": [1, {"c": 2}], "d": 3}`, "d", 4, `{"a": 1, "b": [1, {"c": 2}], "d": 4}`},
+	}
+	for _, item := range golden {
+		e := newConfEditor([]byte(item.in))
+		e.set(item.path, item.value)
+		out, err := e.result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != item.expected || !json.Valid(out) {
+			t.Fatalf("in=%s, path=%s, expected=%s, actual=%s", item.in, item.path, item.expected, out)
+		}
+	}
+
+	e := newConfEditor([]byte(`{"a": 1}`))
+	e.set("a.b", 2)
+	if _, err := e.result(); err == nil {
+		t.Fatal("set into non object should fail")
+	}
+}
@@ -67,6 +67,8 @@ func (r *RtspTunnel) Start() error {
 	})
 	if err := r.pushSession.Push(r.pushUrl, sdpCtx); err != nil {
 		nazalog.Errorf("[%s] start push failed. err=%+v, url=%s", r.uniqueKey, err, r.pushUrl)
+		// 注意，push失败时pull已经成功，需要关闭，否则重试时会泄漏pull连接
+		_ = r.pullSession.Dispose()
 		return err
 	}
 	nazalog.Debugf("[%s] start push succ.", r.uniqueKey)
@@ -0,0 +1,448 @@
+package pullrtsp2pushrtsp
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"live-server/library/naza/pkg/nazalog"
+	"live-server/pkg/utils/file"
+)
+
+// supervisor.go
+//
+// 转发任务守护：
+// - 任务定义（name, origin url, tcp/udp模式）持久化到磁盘，程序启动时恢复
+// - tunnel断开后按指数退避自动重连
+// - 通过 OnStateChange 回调上报每个任务的状态
+//
+
+type TunnelState string
+
+const (
+	TunnelStateConnecting TunnelState = "connecting" // 正在建立pull和push
+	TunnelStateRunning    TunnelState = "running"    // 正在转发
+	TunnelStateBackoff    TunnelState = "backoff"    // 断开后等待重连
+	TunnelStateFailed     TunnelState = "failed"     // 达到最大重试次数，不再重连
+	TunnelStateStopped    TunnelState = "stopped"    // 被主动停止
+)
+
+var (
+	ErrTunnelExist    = errors.New("pullrtsp2pushrtsp: tunnel already exists")
+	ErrTunnelNotExist = errors.New("pullrtsp2pushrtsp: tunnel not exists")
+)
+
+// TunnelTask 持久化的任务定义
+type TunnelTask struct {
+	Name        string `json:"name"`
+	OriginUrl   string `json:"origin_url"`
+	TargetUrl   string `json:"target_url,omitempty"` // 为空时推送到内置lalserver的rtsp地址
+	PullOverTcp bool   `json:"pull_over_tcp"`
+	PushOverTcp bool   `json:"push_over_tcp"`
+}
+
+// TunnelStatus 任务的实时状态
+type TunnelStatus struct {
+	TunnelTask
+
+	State       TunnelState `json:"state"`
+	LastError   string      `json:"last_error"`
+	RetryCount  int         `json:"retry_count"`
+	NextRetryAt int64       `json:"next_retry_at"` // unix毫秒，只在 TunnelStateBackoff 时有效
+}
+
+// BackoffConfig 重连的指数退避配置
+type BackoffConfig struct {
+	InitialIntervalMs int     `json:"initial_interval_ms"`
+	MaxIntervalMs     int     `json:"max_interval_ms"`
+	Multiplier        float64 `json:"multiplier"`
+	MaxRetryNum       int     `json:"max_retry_num"` // 连续失败的最大重试次数，-1表示永远重试
+}
+
+var DefaultBackoffConfig = BackoffConfig{
+	InitialIntervalMs: 1000,
+	MaxIntervalMs:     60000,
+	Multiplier:        2,
+	MaxRetryNum:       -1,
+}
+
+// Interval 第retryCount次（从1开始）重试前需要等待的时间
+func (b BackoffConfig) Interval(retryCount int) time.Duration {
+	ms := float64(b.InitialIntervalMs)
+	for i := 1; i < retryCount; i++ {
+		ms *= b.Multiplier
+		if ms >= float64(b.MaxIntervalMs) {
+			break
+		}
+	}
+	if b.MaxIntervalMs > 0 && ms > float64(b.MaxIntervalMs) {
+		ms = float64(b.MaxIntervalMs)
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// ITunnel Supervisor 所管理的tunnel需要实现的接口，RtspTunnel 和 AvTunnel 实现了该接口
+type ITunnel interface {
+	Start() error
+	Dispose() error
+	WaitChan() chan error
+}
+
+type SupervisorOption struct {
+	// StoreFilename 任务定义持久化的文件
+	StoreFilename string
+
+	// NewTunnel 根据任务定义创建tunnel，任务定义不合法（比如不支持的协议组合）时返回错误
+	NewTunnel func(task TunnelTask) (ITunnel, error)
+
+	// OnStateChange 任务状态发生变化时回调，注意，回调在任务自身的协程中执行
+	OnStateChange func(status TunnelStatus)
+}
+
+type ModSupervisorOption func(option *SupervisorOption)
+
+type Supervisor struct {
+	option SupervisorOption
+
+	mutex   sync.Mutex
+	backoff BackoffConfig
+	tasks   map[string]*supervisedTunnel
+
+	loopWg sync.WaitGroup // 所有任务的守护协程
+}
+
+type supervisedTunnel struct {
+	status   TunnelStatus
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+type supervisorStore struct {
+	Backoff BackoffConfig `json:"backoff"`
+	Tasks   []TunnelTask  `json:"tasks"`
+}
+
+func NewSupervisor(modOption ...ModSupervisorOption) *Supervisor {
+	s := &Supervisor{
+		backoff: DefaultBackoffConfig,
+		tasks:   make(map[string]*supervisedTunnel),
+	}
+	for _, fn := range modOption {
+		fn(&s.option)
+	}
+	return s
+}
+
+// Restore 从持久化文件中恢复任务定义以及退避配置，并在后台启动所有任务
+//
+// 注意，文件不存在时不认为是错误
+func (s *Supervisor) Restore() error {
+	if s.option.StoreFilename == "" || !file.IsFile(s.option.StoreFilename) {
+		return nil
+	}
+
+	var store supervisorStore
+	if err := file.ReadJSON(s.option.StoreFilename, &store); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	if store.Backoff.InitialIntervalMs > 0 {
+		s.backoff = store.Backoff
+	}
+	var added []*supervisedTunnel
+	for _, task := range store.Tasks {
+		if _, ok := s.tasks[task.Name]; ok {
+			continue
+		}
+		t := newSupervisedTunnel(task)
+		s.tasks[task.Name] = t
+		added = append(added, t)
+	}
+	s.mutex.Unlock()
+
+	nazalog.Infof("restore tunnel tasks. num=%d, file=%s", len(added), s.option.StoreFilename)
+	for _, t := range added {
+		s.loopWg.Add(1)
+		go s.runLoop(t, nil)
+	}
+	return nil
+}
+
+// Add 添加任务并持久化
+//
+// 阻塞直到第一次启动成功或失败。第一次启动失败时返回错误，任务不会被保存。
+// 第一次启动成功后，后续断开时自动重连。
+func (s *Supervisor) Add(task TunnelTask) error {
+	s.mutex.Lock()
+	if _, ok := s.tasks[task.Name]; ok {
+		s.mutex.Unlock()
+		return fmt.Errorf("%w. name=%s", ErrTunnelExist, task.Name)
+	}
+	t := newSupervisedTunnel(task)
+	s.tasks[task.Name] = t
+	s.mutex.Unlock()
+
+	s.setState(t, TunnelStateConnecting, nil)
+	tunnel, err := s.option.NewTunnel(task)
+	if err == nil {
+		err = tunnel.Start()
+	}
+	if err != nil {
+		s.mutex.Lock()
+		delete(s.tasks, task.Name)
+		s.mutex.Unlock()
+		s.setState(t, TunnelStateStopped, err)
+		return err
+	}
+
+	if err := s.save(); err != nil {
+		nazalog.Errorf("save tunnel tasks failed. err=%+v", err)
+	}
+
+	s.loopWg.Add(1)
+	go s.runLoop(t, tunnel)
+	return nil
+}
+
+// Remove 停止任务并从持久化文件中删除
+func (s *Supervisor) Remove(name string) error {
+	s.mutex.Lock()
+	t, ok := s.tasks[name]
+	if ok {
+		delete(s.tasks, name)
+	}
+	s.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w. name=%s", ErrTunnelNotExist, name)
+	}
+	t.stop()
+	return s.save()
+}
+
+// RemoveAll 停止所有任务并清空持久化文件
+func (s *Supervisor) RemoveAll() error {
+	s.mutex.Lock()
+	tasks := s.tasks
+	s.tasks = make(map[string]*supervisedTunnel)
+	s.mutex.Unlock()
+
+	for _, t := range tasks {
+		t.stop()
+	}
+	return s.save()
+}
+
+// Dispose 停止所有任务，但是保留持久化的任务定义，用于程序退出时调用
+//
+// 阻塞直到所有tunnel都关闭
+func (s *Supervisor) Dispose() {
+	s.mutex.Lock()
+	for _, t := range s.tasks {
+		t.stop()
+	}
+	s.mutex.Unlock()
+
+	s.loopWg.Wait()
+}
+
+// List 获取所有任务的状态，按name排序
+func (s *Supervisor) List() []TunnelStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := make([]TunnelStatus, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		ret = append(ret, t.status)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+func (s *Supervisor) Get(name string) (TunnelStatus, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t, ok := s.tasks[name]
+	if !ok {
+		return TunnelStatus{}, false
+	}
+	return t.status, true
+}
+
+func (s *Supervisor) Backoff() BackoffConfig {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.backoff
+}
+
+// SetBackoff 修改退避配置并持久化，对之后发生的重连生效
+func (s *Supervisor) SetBackoff(backoff BackoffConfig) error {
+	if backoff.InitialIntervalMs <= 0 || backoff.Multiplier < 1 || backoff.MaxIntervalMs < backoff.InitialIntervalMs {
+		return fmt.Errorf("pullrtsp2pushrtsp: invalid backoff config. %+v", backoff)
+	}
+	s.mutex.Lock()
+	s.backoff = backoff
+	s.mutex.Unlock()
+	return s.save()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// runLoop 任务的守护协程
+//
+// @param tunnel: 如果不为nil，表示已经启动成功的tunnel
+func (s *Supervisor) runLoop(t *supervisedTunnel, tunnel ITunnel) {
+	defer s.loopWg.Done()
+
+	for {
+		if tunnel == nil {
+			s.setState(t, TunnelStateConnecting, nil)
+			var err error
+			tunnel, err = s.option.NewTunnel(t.status.TunnelTask)
+			if err == nil {
+				err = tunnel.Start()
+			}
+			if err != nil {
+				tunnel = nil
+				if !s.waitRetry(t, err) {
+					return
+				}
+				continue
+			}
+		}
+
+		// 启动过程中被停止
+		if t.isStopped() {
+			_ = tunnel.Dispose()
+			s.setState(t, TunnelStateStopped, nil)
+			return
+		}
+
+		s.mutex.Lock()
+		t.status.RetryCount = 0
+		s.mutex.Unlock()
+		s.setState(t, TunnelStateRunning, nil)
+
+		select {
+		case err := <-tunnel.WaitChan():
+			nazalog.Warnf("tunnel stopped. name=%s, err=%+v", t.status.Name, err)
+			if err == nil {
+				err = errors.New("tunnel closed")
+			}
+			tunnel = nil
+			if !s.waitRetry(t, err) {
+				return
+			}
+		case <-t.stopChan:
+			_ = tunnel.Dispose()
+			s.setState(t, TunnelStateStopped, nil)
+			return
+		}
+	}
+}
+
+// waitRetry 退避等待
+//
+// @return 返回false表示不再重试
+func (s *Supervisor) waitRetry(t *supervisedTunnel, err error) bool {
+	s.mutex.Lock()
+	t.status.RetryCount++
+	retryCount := t.status.RetryCount
+	backoff := s.backoff
+	s.mutex.Unlock()
+
+	if t.isStopped() {
+		s.setState(t, TunnelStateStopped, err)
+		return false
+	}
+	if backoff.MaxRetryNum >= 0 && retryCount > backoff.MaxRetryNum {
+		s.setState(t, TunnelStateFailed, err)
+		return false
+	}
+
+	interval := backoff.Interval(retryCount)
+	s.mutex.Lock()
+	t.status.NextRetryAt = time.Now().Add(interval).UnixNano() / 1e6
+	s.mutex.Unlock()
+	s.setState(t, TunnelStateBackoff, err)
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-t.stopChan:
+		s.setState(t, TunnelStateStopped, err)
+		return false
+	}
+}
+
+func (s *Supervisor) setState(t *supervisedTunnel, state TunnelState, err error) {
+	s.mutex.Lock()
+	t.status.State = state
+	if err != nil {
+		t.status.LastError = err.Error()
+	}
+	if state != TunnelStateBackoff {
+		t.status.NextRetryAt = 0
+	}
+	status := t.status
+	s.mutex.Unlock()
+
+	nazalog.Debugf("tunnel state change. name=%s, state=%s, err=%+v", status.Name, state, err)
+	if s.option.OnStateChange != nil {
+		s.option.OnStateChange(status)
+	}
+}
+
+func (s *Supervisor) save() error {
+	if s.option.StoreFilename == "" {
+		return nil
+	}
+
+	s.mutex.Lock()
+	store := supervisorStore{
+		Backoff: s.backoff,
+		Tasks:   make([]TunnelTask, 0, len(s.tasks)),
+	}
+	for _, t := range s.tasks {
+		store.Tasks = append(store.Tasks, t.status.TunnelTask)
+	}
+	s.mutex.Unlock()
+
+	sort.Slice(store.Tasks, func(i, j int) bool {
+		return store.Tasks[i].Name < store.Tasks[j].Name
+	})
+	return file.WriteJSON(s.option.StoreFilename, store)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newSupervisedTunnel(task TunnelTask) *supervisedTunnel {
+	return &supervisedTunnel{
+		status: TunnelStatus{
+			TunnelTask: task,
+			State:      TunnelStateConnecting,
+		},
+		stopChan: make(chan struct{}),
+	}
+}
+
+func (t *supervisedTunnel) stop() {
+	t.stopOnce.Do(func() {
+		close(t.stopChan)
+	})
+}
+
+func (t *supervisedTunnel) isStopped() bool {
+	select {
+	case <-t.stopChan:
+		return true
+	default:
+		return false
+	}
+}
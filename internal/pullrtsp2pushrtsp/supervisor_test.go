@@ -0,0 +1,330 @@
+package pullrtsp2pushrtsp
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"live-server/pkg/utils/file"
+)
+
+var errTestStartFail = errors.New("test start fail")
+
+type testTunnel struct {
+	waitChan    chan error
+	disposeChan chan struct{}
+	disposeOnce sync.Once
+}
+
+func (t *testTunnel) Start() error {
+	return nil
+}
+
+func (t *testTunnel) Dispose() error {
+	t.disposeOnce.Do(func() {
+		close(t.disposeChan)
+	})
+	return nil
+}
+
+func (t *testTunnel) WaitChan() chan error {
+	return t.waitChan
+}
+
+type testFailTunnel struct {
+	*testTunnel
+}
+
+func (t *testFailTunnel) Start() error {
+	return errTestStartFail
+}
+
+// testTunnelFactory 记录所有创建的tunnel，前failNum次创建的tunnel启动失败
+type testTunnelFactory struct {
+	mutex   sync.Mutex
+	failNum int
+	tunnels map[string][]*testTunnel
+}
+
+func newTestTunnelFactory(failNum int) *testTunnelFactory {
+	return &testTunnelFactory{
+		failNum: failNum,
+		tunnels: make(map[string][]*testTunnel),
+	}
+}
+
+func (f *testTunnelFactory) NewTunnel(task TunnelTask) (ITunnel, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	t := &testTunnel{
+		waitChan:    make(chan error, 1),
+		disposeChan: make(chan struct{}),
+	}
+	if f.failNum > 0 {
+		f.failNum--
+		return &testFailTunnel{testTunnel: t}, nil
+	}
+	f.tunnels[task.Name] = append(f.tunnels[task.Name], t)
+	return t, nil
+}
+
+func (f *testTunnelFactory) last(name string) *testTunnel {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	tunnels := f.tunnels[name]
+	if len(tunnels) == 0 {
+		return nil
+	}
+	return tunnels[len(tunnels)-1]
+}
+
+// testStateRecorder 记录 OnStateChange 回调的状态，并且可以等待某个状态
+type testStateRecorder struct {
+	mutex  sync.Mutex
+	states map[string][]TunnelStatus
+}
+
+func newTestStateRecorder() *testStateRecorder {
+	return &testStateRecorder{states: make(map[string][]TunnelStatus)}
+}
+
+func (r *testStateRecorder) OnStateChange(status TunnelStatus) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.states[status.Name] = append(r.states[status.Name], status)
+}
+
+func (r *testStateRecorder) list(name string) []TunnelState {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var ret []TunnelState
+	for _, s := range r.states[name] {
+		ret = append(ret, s.State)
+	}
+	return ret
+}
+
+func (r *testStateRecorder) wait(t *testing.T, name string, num int) []TunnelState {
+	for i := 0; i < 500; i++ {
+		if states := r.list(name); len(states) >= num {
+			return states
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	t.Fatalf("wait state timeout. name=%s, num=%d, states=%v", name, num, r.list(name))
+	return nil
+}
+
+// waitLast 等待最后一个状态为state
+func (r *testStateRecorder) waitLast(t *testing.T, name string, state TunnelState) {
+	for i := 0; i < 500; i++ {
+		if states := r.list(name); len(states) > 0 && states[len(states)-1] == state {
+			return
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	t.Fatalf("wait state timeout. name=%s, state=%s, states=%v", name, state, r.list(name))
+}
+
+func assertStates(t *testing.T, expected []TunnelState, actual []TunnelState) {
+	t.Helper()
+	if len(expected) != len(actual) {
+		t.Fatalf("states mismatch. expected=%v, actual=%v", expected, actual)
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Fatalf("states mismatch. expected=%v, actual=%v", expected, actual)
+		}
+	}
+}
+
+func TestBackoffConfig_Interval(t *testing.T) {
+	b := BackoffConfig{InitialIntervalMs: 1000, MaxIntervalMs: 5000, Multiplier: 2}
+	for i, ms := range []int{1000, 2000, 4000, 5000, 5000} {
+		if b.Interval(i+1) != time.Duration(ms)*time.Millisecond {
+			t.Fatalf("retry=%d, expected=%dms, actual=%v", i+1, ms, b.Interval(i+1))
+		}
+	}
+}
+
+func TestSupervisor_Restore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tunnels.json")
+	backoff := BackoffConfig{InitialIntervalMs: 10, MaxIntervalMs: 20, Multiplier: 2, MaxRetryNum: 3}
+	err := file.WriteJSON(filename, supervisorStore{
+		Backoff: backoff,
+		Tasks: []TunnelTask{
+			{Name: "a", OriginUrl: "rtsp://127.0.0.1/a"},
+			{Name: "b", OriginUrl: "rtsp://127.0.0.1/b", PullOverTcp: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	factory := newTestTunnelFactory(0)
+	recorder := newTestStateRecorder()
+	s := NewSupervisor(func(option *SupervisorOption) {
+		option.StoreFilename = filename
+		option.NewTunnel = factory.NewTunnel
+		option.OnStateChange = recorder.OnStateChange
+	})
+	if err = s.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		assertStates(t, []TunnelState{TunnelStateConnecting, TunnelStateRunning}, recorder.wait(t, name, 2))
+	}
+	if s.Backoff() != backoff {
+		t.Fatalf("backoff not restored. %+v", s.Backoff())
+	}
+	list := s.List()
+	if len(list) != 2 || list[0].Name != "a" || list[1].Name != "b" || !list[1].PullOverTcp {
+		t.Fatalf("invalid list. %+v", list)
+	}
+
+	// Dispose 关闭所有tunnel，但是保留持久化的任务
+	s.Dispose()
+	for _, name := range []string{"a", "b"} {
+		select {
+		case <-factory.last(name).disposeChan:
+		default:
+			t.Fatalf("tunnel not disposed. name=%s", name)
+		}
+	}
+	var store supervisorStore
+	if err = file.ReadJSON(filename, &store); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.Tasks) != 2 {
+		t.Fatalf("tasks should be kept. %+v", store)
+	}
+
+	// 文件不存在时不是错误
+	s = NewSupervisor(func(option *SupervisorOption) {
+		option.StoreFilename = filepath.Join(t.TempDir(), "notexist.json")
+	})
+	if err = s.Restore(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSupervisor_Backoff(t *testing.T) {
+	factory := newTestTunnelFactory(1)
+	s := NewSupervisor(func(option *SupervisorOption) {
+		option.NewTunnel = factory.NewTunnel
+	})
+	if err := s.SetBackoff(BackoffConfig{InitialIntervalMs: 10, MaxIntervalMs: 5, Multiplier: 2}); err == nil {
+		t.Fatal("invalid backoff should be rejected")
+	}
+	// 第一次启动失败时，Add 返回错误，任务不保存
+	if err := s.Add(TunnelTask{Name: "a"}); !errors.Is(err, errTestStartFail) {
+		t.Fatalf("add should fail. err=%+v", err)
+	}
+	if _, ok := s.Get("a"); ok {
+		t.Fatal("task should not be added")
+	}
+
+	// 恢复的任务启动失败时按退避重试，成功后重试计数清零
+	filename := filepath.Join(t.TempDir(), "tunnels.json")
+	err := file.WriteJSON(filename, supervisorStore{
+		Backoff: BackoffConfig{InitialIntervalMs: 10, MaxIntervalMs: 20, Multiplier: 2, MaxRetryNum: 2},
+		Tasks:   []TunnelTask{{Name: "a"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory = newTestTunnelFactory(2)
+	recorder := newTestStateRecorder()
+	s = NewSupervisor(func(option *SupervisorOption) {
+		option.StoreFilename = filename
+		option.NewTunnel = factory.NewTunnel
+		option.OnStateChange = recorder.OnStateChange
+	})
+	if err = s.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	assertStates(t, []TunnelState{
+		TunnelStateConnecting, TunnelStateBackoff,
+		TunnelStateConnecting, TunnelStateBackoff,
+		TunnelStateConnecting, TunnelStateRunning,
+	}, recorder.wait(t, "a", 6))
+	status, _ := s.Get("a")
+	if status.RetryCount != 0 || status.LastError != errTestStartFail.Error() {
+		t.Fatalf("invalid status. %+v", status)
+	}
+
+	// tunnel断开后重连
+	factory.last("a").waitChan <- errors.New("disconnect")
+	states := recorder.wait(t, "a", 9)
+	assertStates(t, []TunnelState{TunnelStateBackoff, TunnelStateConnecting, TunnelStateRunning}, states[6:])
+
+	// 连续失败超过最大重试次数
+	factory.mutex.Lock()
+	factory.failNum = 3
+	factory.mutex.Unlock()
+	factory.last("a").waitChan <- errors.New("disconnect")
+	states = recorder.wait(t, "a", 14)
+	assertStates(t, []TunnelState{
+		TunnelStateBackoff, TunnelStateConnecting,
+		TunnelStateBackoff, TunnelStateConnecting,
+		TunnelStateFailed,
+	}, states[9:])
+	s.Dispose()
+}
+
+func TestSupervisor_Remove(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tunnels.json")
+	factory := newTestTunnelFactory(0)
+	recorder := newTestStateRecorder()
+	s := NewSupervisor(func(option *SupervisorOption) {
+		option.StoreFilename = filename
+		option.NewTunnel = factory.NewTunnel
+		option.OnStateChange = recorder.OnStateChange
+	})
+	for _, name := range []string{"a", "b"} {
+		if err := s.Add(TunnelTask{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Add(TunnelTask{Name: "a"}); !errors.Is(err, ErrTunnelExist) {
+		t.Fatalf("add duplicated task should fail. err=%+v", err)
+	}
+
+	if err := s.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-factory.last("a").disposeChan:
+	case <-time.After(time.Second):
+		t.Fatal("tunnel not disposed")
+	}
+	recorder.waitLast(t, "a", TunnelStateStopped)
+	if err := s.Remove("a"); !errors.Is(err, ErrTunnelNotExist) {
+		t.Fatalf("remove not exist task should fail. err=%+v", err)
+	}
+
+	var store supervisorStore
+	if err := file.ReadJSON(filename, &store); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.Tasks) != 1 || store.Tasks[0].Name != "b" {
+		t.Fatalf("invalid store. %+v", store)
+	}
+
+	if err := s.RemoveAll(); err != nil {
+		t.Fatal(err)
+	}
+	s.Dispose()
+	if len(s.List()) != 0 {
+		t.Fatalf("invalid list. %+v", s.List())
+	}
+	store = supervisorStore{}
+	if err := file.ReadJSON(filename, &store); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.Tasks) != 0 {
+		t.Fatalf("invalid store. %+v", store)
+	}
+}
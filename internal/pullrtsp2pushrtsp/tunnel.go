@@ -0,0 +1,302 @@
+package pullrtsp2pushrtsp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/httpflv"
+	"live-server/library/LAL/pkg/remux"
+	"live-server/library/LAL/pkg/rtmp"
+	"live-server/library/LAL/pkg/rtprtcp"
+	"live-server/library/LAL/pkg/rtsp"
+	"live-server/library/LAL/pkg/sdp"
+
+	"live-server/library/naza/pkg/nazaerrors"
+	"live-server/library/naza/pkg/nazalog"
+	"live-server/library/naza/pkg/unique"
+)
+
+// tunnel.go
+//
+// 支持协议转换的tunnel，根据pull和push的url scheme选择协议组合：
+//
+// | pull               | push    | 实现                                             |
+// | ------------------ | ------- | ------------------------------------------------ |
+// | rtsp               | rtsp    | RtspTunnel，直接转发rtp包                        |
+// | rtsp               | rtmp(s) | AvTunnel，OnAvPacket -> AvPacket2RtmpRemuxer     |
+// | http(s)-flv        | rtmp(s) | AvTunnel，flv tag -> rtmp message                |
+//
+
+var ErrUnsupportedProtocol = errors.New("pullrtsp2pushrtsp: unsupported protocol pair")
+
+type PullProtocol string
+type PushProtocol string
+
+const (
+	PullProtocolRtsp    PullProtocol = "rtsp"
+	PullProtocolHttpflv PullProtocol = "httpflv"
+
+	PushProtocolRtsp PushProtocol = "rtsp"
+	PushProtocolRtmp PushProtocol = "rtmp"
+)
+
+// ParseProtocolPair 根据url的scheme判断pull和push所使用的协议
+func ParseProtocolPair(pullUrl string, pushUrl string) (PullProtocol, PushProtocol, error) {
+	pullScheme, pullPath, err := parseSchemeAndPath(pullUrl)
+	if err != nil {
+		return "", "", err
+	}
+	pushScheme, _, err := parseSchemeAndPath(pushUrl)
+	if err != nil {
+		return "", "", err
+	}
+
+	var pullProtocol PullProtocol
+	switch pullScheme {
+	case "rtsp":
+		pullProtocol = PullProtocolRtsp
+	case "http", "https":
+		if !strings.HasSuffix(pullPath, ".flv") {
+			return "", "", fmt.Errorf("%w. only httpflv is supported over http. url=%s", ErrUnsupportedProtocol, pullUrl)
+		}
+		pullProtocol = PullProtocolHttpflv
+	default:
+		return "", "", fmt.Errorf("%w. pull url=%s", ErrUnsupportedProtocol, pullUrl)
+	}
+
+	var pushProtocol PushProtocol
+	switch pushScheme {
+	case "rtsp":
+		pushProtocol = PushProtocolRtsp
+	case "rtmp", "rtmps":
+		pushProtocol = PushProtocolRtmp
+	default:
+		return "", "", fmt.Errorf("%w. push url=%s", ErrUnsupportedProtocol, pushUrl)
+	}
+
+	// httpflv中的数据是rtmp格式的，转换为rtsp需要重新打包rtp，暂不支持
+	if pullProtocol == PullProtocolHttpflv && pushProtocol == PushProtocolRtsp {
+		return "", "", fmt.Errorf("%w. pull=%s, push=%s", ErrUnsupportedProtocol, pullProtocol, pushProtocol)
+	}
+	return pullProtocol, pushProtocol, nil
+}
+
+// NewTunnelByUrl 根据pull和push的url scheme创建对应的tunnel
+//
+// @param pullOverTcp: 只对rtsp pull有效
+// @param pushOverTcp: 只对rtsp push有效
+func NewTunnelByUrl(pullUrl string, pushUrl string, pullOverTcp bool, pushOverTcp bool) (ITunnel, error) {
+	pullProtocol, pushProtocol, err := ParseProtocolPair(pullUrl, pushUrl)
+	if err != nil {
+		return nil, err
+	}
+	if pullProtocol == PullProtocolRtsp && pushProtocol == PushProtocolRtsp {
+		return NewRtspTunnel(pullUrl, pushUrl, pullOverTcp, pushOverTcp), nil
+	}
+	return NewAvTunnel(pullUrl, pushUrl, pullProtocol, pullOverTcp), nil
+}
+
+func parseSchemeAndPath(rawUrl string) (string, string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Host == "" {
+		return "", "", fmt.Errorf("%w. invalid url=%s", ErrUnsupportedProtocol, rawUrl)
+	}
+	return strings.ToLower(u.Scheme), u.Path, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// AvTunnel 拉取rtsp或httpflv流，转换为rtmp后推送
+type AvTunnel struct {
+	pullUrl      string
+	pushUrl      string
+	pullProtocol PullProtocol
+	pullOverTcp  bool
+
+	uniqueKey string
+
+	remuxer    *remux.AvPacket2RtmpRemuxer
+	rtmpMsgCh  chan base.RtmpMsg
+	pullWaitCh <-chan error
+
+	// closeChan 转发协程不再消费 rtmpMsgCh 时关闭，使得pull的回调不会阻塞
+	closeChan chan struct{}
+	closeOnce sync.Once
+
+	disposeOnce sync.Once
+	waitChan    chan error
+
+	rtspPullSession    *rtsp.PullSession
+	httpflvPullSession *httpflv.PullSession
+	pushSession        *rtmp.PushSession
+}
+
+func NewAvTunnel(pullUrl string, pushUrl string, pullProtocol PullProtocol, pullOverTcp bool) *AvTunnel {
+	uniqueKey := unique.GenUniqueKey("AVTUNNEL")
+	nazalog.Debugf("[%s] lifecycle new AvTunnel. pullUrl=%s, pushUrl=%s, pullProtocol=%s", uniqueKey, pullUrl, pushUrl, pullProtocol)
+	r := &AvTunnel{
+		pullUrl:      pullUrl,
+		pushUrl:      pushUrl,
+		pullProtocol: pullProtocol,
+		pullOverTcp:  pullOverTcp,
+		uniqueKey:    uniqueKey,
+		rtmpMsgCh:    make(chan base.RtmpMsg, 1024),
+		closeChan:    make(chan struct{}),
+		waitChan:     make(chan error, 1),
+	}
+	r.remuxer = remux.NewAvPacket2RtmpRemuxer().WithOnRtmpMsg(r.onRtmpMsg)
+	return r
+}
+
+// Start 开启任务，阻塞直到任务开启成功或失败。
+//
+// @return: 如果为nil，表示任务启动成功，此时数据已经在后台转发
+func (r *AvTunnel) Start() error {
+	// 注意，先pull再push，pull成功前收到的sequence header等数据缓存在rtmpMsgCh中，push成功后再发送
+	if err := r.startPull(); err != nil {
+		nazalog.Errorf("[%s] start pull failed. err=%+v, url=%s", r.uniqueKey, err, r.pullUrl)
+		return err
+	}
+	nazalog.Debugf("[%s] start pull succ.", r.uniqueKey)
+
+	r.pushSession = rtmp.NewPushSession(func(option *rtmp.PushSessionOption) {
+		option.PushTimeoutMs = 10000
+	})
+	if err := r.pushSession.Push(r.pushUrl); err != nil {
+		nazalog.Errorf("[%s] start push failed. err=%+v, url=%s", r.uniqueKey, err, r.pushUrl)
+		r.close()
+		_ = r.pullSession().Dispose()
+		return err
+	}
+	nazalog.Debugf("[%s] start push succ.", r.uniqueKey)
+
+	go func() {
+		t := time.NewTicker(1 * time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case msg := <-r.rtmpMsgCh:
+				_ = r.pushSession.WriteMsg(msg)
+			case err := <-r.pullWaitCh:
+				nazalog.Debugf("[%s] < pullSession.Wait(). err=%+v", r.uniqueKey, err)
+				_ = r.dispose(err)
+				return
+			case err := <-r.pushSession.WaitChan():
+				nazalog.Debugf("[%s] < pushSession.Wait(). err=%+v", r.uniqueKey, err)
+				_ = r.dispose(err)
+				return
+			case <-t.C:
+				pull := r.pullSession()
+				pull.UpdateStat(1)
+				pullStat := pull.GetStat()
+				r.pushSession.UpdateStat(1)
+				pushStat := r.pushSession.GetStat()
+				nazalog.Debugf("[%s] stat. pull=%+v, push=%+v", r.uniqueKey, pullStat, pushStat)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Dispose 主动关闭tunnel时调用
+//
+// 注意，只有 Start 成功后的tunnel才能调用，否则行为未定义
+func (r *AvTunnel) Dispose() error {
+	return r.dispose(nil)
+}
+
+// WaitChan Start 成功后，可使用这个channel来接收tunnel结束的消息
+func (r *AvTunnel) WaitChan() chan error {
+	return r.waitChan
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// OnRtpPacket OnSdp OnAvPacket
+//
+// 实现 rtsp.IPullSessionObserver ，rtsp pull的数据转交给remuxer
+func (r *AvTunnel) OnRtpPacket(pkt rtprtcp.RtpPacket) {
+	// noop
+}
+
+func (r *AvTunnel) OnSdp(sdpCtx sdp.LogicContext) {
+	r.remuxer.OnSdp(sdpCtx)
+}
+
+func (r *AvTunnel) OnAvPacket(pkt base.AvPacket) {
+	r.remuxer.OnAvPacket(pkt)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (r *AvTunnel) startPull() error {
+	switch r.pullProtocol {
+	case PullProtocolRtsp:
+		r.rtspPullSession = rtsp.NewPullSession(r, func(option *rtsp.PullSessionOption) {
+			option.PullTimeoutMs = 10000
+			option.OverTcp = r.pullOverTcp
+		})
+		if err := r.rtspPullSession.Pull(r.pullUrl); err != nil {
+			return err
+		}
+		r.pullWaitCh = r.rtspPullSession.WaitChan()
+	case PullProtocolHttpflv:
+		r.httpflvPullSession = httpflv.NewPullSession(func(option *httpflv.PullSessionOption) {
+			option.PullTimeoutMs = 10000
+		})
+		err := r.httpflvPullSession.Pull(r.pullUrl, func(tag httpflv.Tag) {
+			// 回调结束后tag的内存块不再被PullSession使用，所以不需要拷贝
+			r.onRtmpMsg(remux.FlvTag2RtmpMsg(tag))
+		})
+		if err != nil {
+			return err
+		}
+		r.pullWaitCh = r.httpflvPullSession.WaitChan()
+	default:
+		return fmt.Errorf("%w. pull=%s", ErrUnsupportedProtocol, r.pullProtocol)
+	}
+	return nil
+}
+
+func (r *AvTunnel) pullSession() base.IClientSession {
+	if r.rtspPullSession != nil {
+		return r.rtspPullSession
+	}
+	return r.httpflvPullSession
+}
+
+func (r *AvTunnel) onRtmpMsg(msg base.RtmpMsg) {
+	select {
+	case r.rtmpMsgCh <- msg:
+	case <-r.closeChan:
+	}
+}
+
+func (r *AvTunnel) close() {
+	r.closeOnce.Do(func() {
+		close(r.closeChan)
+	})
+}
+
+func (r *AvTunnel) dispose(err error) error {
+	var retErr error
+	r.disposeOnce.Do(func() {
+		nazalog.Infof("[%s] lifecycle dispose AvTunnel.", r.uniqueKey)
+		// 先唤醒可能阻塞在 onRtmpMsg 中的pull回调，使得pull的协程可以退出
+		r.close()
+		e1 := r.pullSession().Dispose()
+		e2 := r.pushSession.Dispose()
+		retErr = nazaerrors.CombineErrors(e1, e2)
+		r.waitChan <- err
+	})
+	return retErr
+}
@@ -0,0 +1,139 @@
+package pullrtsp2pushrtsp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/httpflv"
+	"live-server/library/LAL/pkg/remux"
+	"live-server/library/LAL/pkg/rtmp"
+)
+
+type testRtmpServerObserver struct {
+	pubChan chan *rtmp.ServerSession
+}
+
+func (o *testRtmpServerObserver) OnRtmpConnect(session *rtmp.ServerSession, opa rtmp.ObjectPairArray) {
+}
+
+func (o *testRtmpServerObserver) OnNewRtmpPubSession(session *rtmp.ServerSession) error {
+	o.pubChan <- session
+	return nil
+}
+
+func (o *testRtmpServerObserver) OnDelRtmpPubSession(session *rtmp.ServerSession) {
+}
+
+func (o *testRtmpServerObserver) OnNewRtmpSubSession(session *rtmp.ServerSession) error {
+	return nil
+}
+
+func (o *testRtmpServerObserver) OnDelRtmpSubSession(session *rtmp.ServerSession) {
+}
+
+// TestAvTunnel_PushFail push失败后，pull的连接和协程都退出
+//
+// 分别测试 Start 中push失败（此时pull收到的数据已经填满了缓存，pull的回调阻塞），以及转发过程中push断开
+func TestAvTunnel_PushFail(t *testing.T) {
+	for _, failOnStart := range []bool{true, false} {
+		testAvTunnelPushFail(t, failOnStart)
+	}
+}
+
+func testAvTunnelPushFail(t *testing.T, failOnStart bool) {
+	// httpflv源站，不停地发送音频tag，直到连接被关闭
+	sourceDone := make(chan struct{})
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(sourceDone)
+		if _, err := w.Write(httpflv.FlvHeader); err != nil {
+			return
+		}
+		for i := 0; ; i++ {
+			msg := base.RtmpMsg{
+				Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdAudio, TimestampAbs: uint32(i)},
+				Payload: []byte{0xaf, 0x01, 0x01, 0x02},
+			}
+			msg.Header.MsgLen = uint32(len(msg.Payload))
+			if _, err := w.Write(remux.RtmpMsg2FlvTag(msg).Raw); err != nil {
+				return
+			}
+		}
+	}))
+	defer source.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	var observer *testRtmpServerObserver
+	if failOnStart {
+		// 接受连接，一段时间后不做rtmp握手直接关闭，使得push失败
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			time.Sleep(200 * time.Millisecond)
+			_ = conn.Close()
+		}()
+		defer ln.Close()
+	} else {
+		_ = ln.Close()
+		observer = &testRtmpServerObserver{pubChan: make(chan *rtmp.ServerSession, 1)}
+		server := rtmp.NewServer(addr, observer)
+		if err = server.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		go server.RunLoop()
+		defer server.Dispose()
+	}
+
+	tunnel, err := NewTunnelByUrl(source.URL+"/live/test110.flv", "rtmp://"+addr+"/live/test110", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tunnel.Start()
+	if failOnStart {
+		if err == nil {
+			t.Fatal("push should fail")
+		}
+	} else {
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 服务端关闭推流连接，模拟转发过程中push断开
+		pubSession := <-observer.pubChan
+		_ = pubSession.Dispose()
+
+		select {
+		case <-tunnel.WaitChan():
+		case <-time.After(5 * time.Second):
+			t.Fatal("wait tunnel stop timeout")
+		}
+	}
+
+	select {
+	case <-sourceDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pull connection not closed")
+	}
+
+	// pull的回调不再阻塞在向转发协程发送数据上
+	var stack string
+	for i := 0; i < 100; i++ {
+		buf := make([]byte, 1<<20)
+		stack = string(buf[:runtime.Stack(buf, true)])
+		if !strings.Contains(stack, "(*AvTunnel).onRtmpMsg") {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("pull goroutine leaked. failOnStart=%v, stack=%s", failOnStart, stack)
+}
@@ -78,6 +78,22 @@ func NewBasicSessionStat(sessionType SessionType, remoteAddr string) BasicSessio
 		s.stat.SessionId = GenUkFlvSubSession()
 		s.stat.BaseType = SessionBaseTypeSubStr
 		s.stat.Protocol = SessionProtocolFlvStr
+	case SessionTypeFlvPull:
+		s.stat.SessionId = GenUkFlvPullSession()
+		s.stat.BaseType = SessionBaseTypePullStr
+		s.stat.Protocol = SessionProtocolFlvStr
+	case SessionTypeTsPull:
+		s.stat.SessionId = GenUkTsPullSession()
+		s.stat.BaseType = SessionBaseTypePullStr
+		s.stat.Protocol = SessionProtocolTsStr
+	case SessionTypeTsPub:
+		s.stat.SessionId = GenUkTsPubSession()
+		s.stat.BaseType = SessionBaseTypePubStr
+		s.stat.Protocol = SessionProtocolTsStr
+	case SessionTypeHlsPull:
+		s.stat.SessionId = GenUkHlsPullSession()
+		s.stat.BaseType = SessionBaseTypePullStr
+		s.stat.Protocol = SessionProtocolHlsStr
 	case SessionTypePsPub:
 		s.stat.SessionId = GenUkPsPubSession()
 		s.stat.BaseType = SessionBaseTypePubStr
@@ -108,7 +124,14 @@ func (s *BasicSessionStat) UpdateStat(intervalSec uint32) {
 	s.updateStat(s.currConnStat.ReadBytesSum.Load(), s.currConnStat.WroteBytesSum.Load(), s.stat.BaseType, intervalSec)
 }
 
+// UpdateStatWitchConn
+//
+// @param conn: 可以为nil，比如client类型的session还没有建立连接或者连接失败时，此时读写字节数都为0
 func (s *BasicSessionStat) UpdateStatWitchConn(conn IStatable, intervalSec uint32) {
+	if conn == nil {
+		s.updateStat(0, 0, s.stat.BaseType, intervalSec)
+		return
+	}
 	currStat := conn.GetStat()
 	s.updateStat(currStat.ReadBytesSum, currStat.WroteBytesSum, s.stat.BaseType, intervalSec)
 }
@@ -119,7 +142,13 @@ func (s *BasicSessionStat) GetStat() StatSession {
 	return s.stat
 }
 
+// GetStatWithConn
+//
+// @param conn: 可以为nil，见 UpdateStatWitchConn
 func (s *BasicSessionStat) GetStatWithConn(conn IStatable) StatSession {
+	if conn == nil {
+		return s.stat
+	}
 	connStat := conn.GetStat()
 	s.stat.ReadBytesSum = connStat.ReadBytesSum
 	s.stat.WroteBytesSum = connStat.WroteBytesSum
@@ -130,7 +159,13 @@ func (s *BasicSessionStat) IsAlive() (readAlive, writeAlive bool) {
 	return s.isAlive(s.currConnStat.ReadBytesSum.Load(), s.currConnStat.WroteBytesSum.Load())
 }
 
+// IsAliveWitchConn
+//
+// @param conn: 可以为nil，见 UpdateStatWitchConn
 func (s *BasicSessionStat) IsAliveWitchConn(conn IStatable) (readAlive, writeAlive bool) {
+	if conn == nil {
+		return s.isAlive(0, 0)
+	}
 	currStat := conn.GetStat()
 	return s.isAlive(currStat.ReadBytesSum, currStat.WroteBytesSum)
 }
@@ -0,0 +1,15 @@
+package base
+
+// DiskUsage 磁盘的容量信息，单位字节，见 GetDiskUsage
+type DiskUsage struct {
+	Total uint64
+	Free  uint64
+}
+
+// UsedPercent 已使用容量的百分比
+func (du DiskUsage) UsedPercent() float64 {
+	if du.Total == 0 {
+		return 0
+	}
+	return float64(du.Total-du.Free) * 100 / float64(du.Total)
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package base
+
+import "syscall"
+
+// GetDiskUsage 获取path所在磁盘的总容量和剩余可用容量，单位字节
+func GetDiskUsage(path string) (du DiskUsage, err error) {
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(path, &st); err != nil {
+		return
+	}
+	du.Total = uint64(st.Blocks) * uint64(st.Bsize)
+	du.Free = uint64(st.Bavail) * uint64(st.Bsize)
+	return
+}
@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package base
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// GetDiskUsage 获取path所在磁盘的总容量和剩余可用容量，单位字节
+func GetDiskUsage(path string) (du DiskUsage, err error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return
+	}
+	var free, total, totalFree uint64
+	r, _, e := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&free)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&totalFree)))
+	if r == 0 {
+		return du, e
+	}
+	du.Total = total
+	du.Free = free
+	return
+}
@@ -37,6 +37,15 @@ var (
 	ErrSessionNotStarted = errors.New("lal.base: session has not been started yet")
 
 	ErrInvalidUrl = errors.New("lal.base: invalid url")
+
+	ErrWebSocketPayloadTooLarge = errors.New("lal.base: websocket payload too large")
+)
+
+// ----- pkg/fmp4 ------------------------------------------------------------------------------------------------------
+
+var (
+	ErrFmp4NoTrack          = errors.New("lal.fmp4: no audio or video track")
+	ErrFmp4UnsupportedCodec = errors.New("lal.fmp4: unsupported codec")
 )
 
 // ----- pkg/hevc ------------------------------------------------------------------------------------------------------
@@ -47,6 +56,20 @@ var ErrHevc = errors.New("lal.hevc: fxxk")
 
 var ErrHls = errors.New("lal.hls: fxxk")
 var ErrHlsSessionNotFound = errors.New("lal.hls: hls session not found")
+var ErrHlsBlockingReloadTimeout = errors.New("lal.hls: blocking playlist reload timeout")
+var ErrHlsBlockingReloadInvalidMsn = errors.New("lal.hls: blocking playlist reload msn too far in the future")
+var ErrHlsNoFragment = errors.New("lal.hls: no fragment in the requested time range")
+var ErrHlsInvalidDvrQuery = errors.New("lal.hls: invalid dvr time shift query")
+var ErrHlsInvalidPlaylist = errors.New("lal.hls: invalid m3u8 playlist")
+var ErrHlsPullHttpStatus = errors.New("lal.hls: unexpected http status code")
+var ErrHlsPullFmp4NotSupported = errors.New("lal.hls: pull only supports mpegts segments, fmp4 not supported")
+
+// ----- pkg/remux -----------------------------------------------------------------------------------------------------
+
+var (
+	ErrRemuxSnapshotUnsupportedFormat = errors.New("lal.remux: snapshot format should be annexb, avcc, mp4 or ts")
+	ErrRemuxSnapshotNoParamSet        = errors.New("lal.remux: snapshot without sps or pps")
+)
 
 // ----- pkg/rtmp ------------------------------------------------------------------------------------------------------
 
@@ -77,6 +100,11 @@ var (
 	ErrRtsp                     = errors.New("lal.rtsp: fxxk")
 	ErrRtspClosedByObserver     = errors.New("lal.rtsp: close by observer")
 	ErrRtspUnsupportedTransport = errors.New("lal.rtsp: unsupported Transport")
+	ErrRtspInvalidRange         = errors.New("lal.rtsp: invalid Range")
+	ErrRtspInvalidScale         = errors.New("lal.rtsp: invalid Scale")
+
+	ErrRtspInvalidMulticastConfig = errors.New("lal.rtsp: invalid multicast config")
+	ErrRtspMulticastAddrExhausted = errors.New("lal.rtsp: multicast address pool exhausted")
 )
 
 // ----- pkg/sdp -------------------------------------------------------------------------------------------------------
@@ -91,6 +119,32 @@ var (
 
 	ErrSimpleAuthParamNotFound = errors.New("lal.logic: simple auth failed since url param lal_secret not found")
 	ErrSimpleAuthFailed        = errors.New("lal.logic: simple auth failed since url param lal_secret invalid")
+
+	ErrAuthParamNotFound = errors.New("lal.logic: auth failed since url param not found")
+	ErrAuthExpired       = errors.New("lal.logic: auth failed since expired")
+	ErrAuthFailed        = errors.New("lal.logic: auth failed")
+
+	ErrAclRejected = errors.New("lal.logic: rejected by acl")
+
+	ErrRelayPushExist          = errors.New("lal.logic: relay push url already exist")
+	ErrRelayPushNotFound       = errors.New("lal.logic: relay push url not found")
+	ErrRelayPushUnsupportedUrl = errors.New("lal.logic: relay push url scheme should be rtmp or rtsp")
+	ErrRelayPullUnsupportedUrl = errors.New("lal.logic: relay pull url should be rtmp, rtsp, http-flv(.flv), http-ts(.ts) or hls(.m3u8)")
+
+	ErrRecordExist             = errors.New("lal.logic: record of this format already exist")
+	ErrRecordNotFound          = errors.New("lal.logic: record not found")
+	ErrRecordUnsupportedFormat = errors.New("lal.logic: record format should be flv, mpegts or mp4")
+	ErrRecordNoInSession       = errors.New("lal.logic: stream has no pub or pull session")
+	ErrRecordSuspended         = errors.New("lal.logic: record suspended since disk usage exceeds the watermark")
+	ErrRecordPathNotAllowed    = errors.New("lal.logic: record path is outside of out path")
+
+	ErrSnapshotNoKeyFrame = errors.New("lal.logic: no video key frame received yet")
+
+	ErrFilePubNoFile         = errors.New("lal.logic: no flv or ts file matches the path")
+	ErrFilePubNotFound       = errors.New("lal.logic: file publisher not found")
+	ErrFilePubPathNotAllowed = errors.New("lal.logic: file pub path is outside of file_pub.root_dir")
+	ErrFilePubNoAvData       = errors.New("lal.logic: no audio or video data in files")
+	ErrFilePubSeekOutOfRange = errors.New("lal.logic: file pub seek position out of range")
 )
 
 // ----- pkg/gb28181 ---------------------------------------------------------------------------------------------------
@@ -99,4 +153,10 @@ var (
 	ErrGb28181 = errors.New("lal.gb28181: fxxk")
 )
 
+// ----- udp multicast -------------------------------------------------------------------------------------------------
+
+var (
+	ErrMulticastNoIpv4Addr = errors.New("lal.base: interface has no ipv4 addr")
+)
+
 // ---------------------------------------------------------------------------------------------------------------------
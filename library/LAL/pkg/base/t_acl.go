@@ -0,0 +1,32 @@
+package base
+
+// t_acl.go
+//
+// acl策略的配置，配置文件和http api共用
+//
+
+type AclConfig struct {
+	Enable    bool      `json:"enable"`
+	MaxPubNum int       `json:"max_pub_num"` // 整个server的最大pub数量，0表示不限制
+	MaxSubNum int       `json:"max_sub_num"` // 整个server的最大sub数量，0表示不限制
+	Rules     []AclRule `json:"rules"`       // 按顺序匹配，只使用第一条匹配上的规则
+}
+
+type AclRule struct {
+	// Pattern 匹配 appName/streamName ，通配符规则见 path.Match ，比如 "live/*"、"*/test*"
+	//
+	// 注意，rtsp、hls等没有appName的流，appName为空，"*/test110" 可以匹配
+	Pattern string `json:"pattern"`
+
+	Allow     []string `json:"allow"`       // 允许的ip或cidr，为空表示不限制
+	Deny      []string `json:"deny"`        // 拒绝的ip或cidr，优先于allow
+	MaxSubNum int      `json:"max_sub_num"` // 单路流的最大sub数量，0表示不限制
+}
+
+type StatAcl struct {
+	Config         AclConfig `json:"config"`
+	PubNum         int       `json:"pub_num"`
+	SubNum         int       `json:"sub_num"`
+	RejectedPubNum uint64    `json:"rejected_pub_num"`
+	RejectedSubNum uint64    `json:"rejected_sub_num"`
+}
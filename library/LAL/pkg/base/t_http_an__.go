@@ -35,15 +35,17 @@ type LalInfo struct {
 }
 
 type StatGroup struct {
-	StreamName  string    `json:"stream_name"`
-	AppName     string    `json:"app_name"`
-	AudioCodec  string    `json:"audio_codec"`
-	VideoCodec  string    `json:"video_codec"`
-	VideoWidth  int       `json:"video_width"`
-	VideoHeight int       `json:"video_height"`
-	StatPub     StatPub   `json:"pub"`
-	StatSubs    []StatSub `json:"subs"` // TODO(chef): [opt] 增加数量字段，因为这里不一定全部放入
-	StatPull    StatPull  `json:"pull"`
+	StreamName  string          `json:"stream_name"`
+	AppName     string          `json:"app_name"`
+	AudioCodec  string          `json:"audio_codec"`
+	VideoCodec  string          `json:"video_codec"`
+	VideoWidth  int             `json:"video_width"`
+	VideoHeight int             `json:"video_height"`
+	StatPub     StatPub         `json:"pub"`
+	StatSubs    []StatSub       `json:"subs"` // TODO(chef): [opt] 增加数量字段，因为这里不一定全部放入
+	StatPull    StatPull        `json:"pull"`
+	StatPushes  []StatRelayPush `json:"pushes"`
+	StatRecords []StatRecord    `json:"records"`
 
 	Fps []RecordPerSec `json:"in_frame_per_sec"`
 }
@@ -67,6 +69,11 @@ type StatSession struct {
 	ReadBitrateKbits  int    `json:"read_bitrate_kbits"`
 	WriteBitrateKbits int    `json:"write_bitrate_kbits"`
 
+	// 以下字段来自对端回复的rtcp rr，目前只有rtsp的sub和push类型的session有值，音视频两路流时取较差的那路
+	RtcpFractionLost float64 `json:"rtcp_fraction_lost"` // 丢包率，取值范围[0, 1]
+	RtcpJitterMs     int     `json:"rtcp_jitter_ms"`
+	RtcpRttMs        int     `json:"rtcp_rtt_ms"` // 还没有计算出往返时延时为0
+
 	typ SessionType
 }
 
@@ -82,6 +89,100 @@ type StatPull struct {
 	StatSession
 }
 
+const (
+	RelayPushStatusIdle       = "idle"       // 等待pub，或者rtsp转推在等待sdp
+	RelayPushStatusConnecting = "connecting" // 正在建立连接
+	RelayPushStatusPushing    = "pushing"    // 转推中
+	RelayPushStatusRetryWait  = "retry_wait" // 失败后等待重试
+	RelayPushStatusFailed     = "failed"     // 重试次数用完，直到下一次pub时再转推
+)
+
+// StatRelayPush relay push的一个转推目标
+type StatRelayPush struct {
+	Url           string      `json:"url"`
+	Protocol      string      `json:"protocol"`
+	Status        string      `json:"status"`
+	RetryNum      int         `json:"retry_num"`
+	FailedCount   int         `json:"failed_count"`    // 连续失败的次数，转推成功后清零
+	NextRetryTime string      `json:"next_retry_time"` // Status为 RelayPushStatusRetryWait 时有效
+	LastError     string      `json:"last_error"`
+	Session       StatSession `json:"session"` // Status为 RelayPushStatusPushing 时有效
+}
+
+const (
+	RecordFormatFlv    = "flv"
+	RecordFormatMpegts = "mpegts"
+	RecordFormatMp4    = "mp4"
+)
+
+// StatRecord 正在进行的录制
+type StatRecord struct {
+	Format         string `json:"format"`
+	Filename       string `json:"filename"`  // 正在写入的文件，mp4分段录制时为当前分段的文件
+	OnDemand       bool   `json:"on_demand"` // 是否是通过 /api/ctrl/start_record 开启的录制，否则为配置文件中开启的录制
+	StartTime      string `json:"start_time"`
+	MaxDurationSec int    `json:"max_duration_sec"` // 按需开启的录制的最长时间，0表示不限制
+}
+
+const (
+	SnapshotFormatAnnexb = "annexb" // 带start code的裸流，vps（h265）sps pps在关键帧前面
+	SnapshotFormatAvcc   = "avcc"   // 4字节长度前缀的裸流，vps（h265）sps pps在关键帧前面
+	SnapshotFormatMp4    = "mp4"    // 只有一帧的fmp4文件，可以直接作为<video>的poster
+	SnapshotFormatTs     = "ts"     // 只有一帧的mpegts文件
+)
+
+// Snapshot 直播流最近的一个视频关键帧
+type Snapshot struct {
+	StreamName  string `json:"stream_name"`
+	Format      string `json:"format"`
+	MimeType    string `json:"mime_type"`
+	VideoCodec  string `json:"video_codec"`
+	VideoWidth  int    `json:"video_width"`
+	VideoHeight int    `json:"video_height"`
+	Timestamp   uint32 `json:"timestamp"`    // 关键帧的时间戳，单位毫秒
+	CaptureTime string `json:"capture_time"` // 收到关键帧的时间
+	Data        []byte `json:"data"`         // 序列化成json时为base64编码
+}
+
+// StatNotify 事件通知的分发统计
+type StatNotify struct {
+	DisabledEvents []string         `json:"disabled_events"`
+	FilteredNum    uint64           `json:"filtered_num"` // 因为事件被关闭而没有分发的事件数量
+	Sinks          []StatNotifySink `json:"sinks"`
+}
+
+type StatNotifySink struct {
+	Name          string `json:"name"`
+	DispatchedNum uint64 `json:"dispatched_num"` // 分发给该sink的事件数量
+	DeliveredNum  uint64 `json:"delivered_num"`  // 投递成功的事件数量
+	FailedNum     uint64 `json:"failed_num"`     // 投递失败的次数，包含重试时的失败
+	RetriedNum    uint64 `json:"retried_num"`    // 重试的次数
+	DroppedNum    uint64 `json:"dropped_num"`    // 因为队列满或者重试次数用完而丢弃的事件数量
+	PendingNum    int    `json:"pending_num"`    // 等待投递的事件数量
+	LastError     string `json:"last_error"`
+	LastErrorTime string `json:"last_error_time"`
+}
+
+// StatRecordRetention 录制文件保留策略的状态，见 logic.RecordRetention
+type StatRecordRetention struct {
+	Enable           bool                        `json:"enable"`
+	LastCheckTime    string                      `json:"last_check_time"`
+	FileNum          int                         `json:"file_num"`           // 最近一次检查时，录制文件的数量，hls录制的每路流按一个计算
+	TotalSize        int64                       `json:"total_size"`         // 最近一次检查时，录制文件的总大小，单位字节
+	DiskUsagePercent float64                     `json:"disk_usage_percent"` // 录制目录所在磁盘的使用率，多个磁盘时取最大值
+	Suspended        bool                        `json:"suspended"`          // 是否因为磁盘使用率超过水位线而停止了录制
+	SuspendReason    string                      `json:"suspend_reason"`
+	DeletedFileNum   uint64                      `json:"deleted_file_num"` // 累计删除的文件数量
+	DeletedSize      uint64                      `json:"deleted_size"`     // 累计删除的文件大小，单位字节
+	Streams          []StatRecordRetentionStream `json:"streams"`
+}
+
+type StatRecordRetentionStream struct {
+	StreamName string `json:"stream_name"`
+	FileNum    int    `json:"file_num"`
+	Size       int64  `json:"size"`
+}
+
 type PeriodRecord struct {
 	ringBuf []RecordPerSec
 	nRecord int
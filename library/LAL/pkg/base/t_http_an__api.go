@@ -23,6 +23,10 @@ const (
 	RtspModeUdp = 1
 )
 
+// ApiCtrlStartRelayPullReq
+//
+// Url 支持rtmp://、rtsp://，以及http(s)://的http-flv(.flv)、http-ts(.ts)、hls(.m3u8)
+// StreamName 不填时使用url中最后一级路径，http类型的url去掉扩展名
 type ApiCtrlStartRelayPullReq struct {
 	Url                      string `json:"url"`
 	StreamName               string `json:"stream_name"`
@@ -33,6 +37,65 @@ type ApiCtrlStartRelayPullReq struct {
 	DebugDumpPacket          string `json:"debug_dump_packet"`
 }
 
+// ApiCtrlStartRelayPushReq
+//
+// Url 支持rtmp://和rtsp://，RetryNum、RetryIntervalMs、RetryMaxIntervalMs、RtspMode 不填时使用配置文件 relay_push 中的值
+type ApiCtrlStartRelayPushReq struct {
+	StreamName         string `json:"stream_name"`
+	Url                string `json:"url"`
+	RetryNum           int    `json:"retry_num"`
+	RetryIntervalMs    int    `json:"retry_interval_ms"`
+	RetryMaxIntervalMs int    `json:"retry_max_interval_ms"`
+	RtspMode           int    `json:"rtsp_mode"`
+}
+
+type ApiCtrlStopRelayPushReq struct {
+	StreamName string `json:"stream_name"`
+	Url        string `json:"url"`
+}
+
+// ApiCtrlStartRecordReq
+//
+// Format 见 RecordFormatFlv 等，MaxDurationSec 为0表示一直录制到调用stop_record或者流结束
+type ApiCtrlStartRecordReq struct {
+	StreamName     string `json:"stream_name"`
+	Format         string `json:"format"`
+	MaxDurationSec int    `json:"max_duration_sec"`
+}
+
+// ApiCtrlStopRecordReq Format 为空时停止该流所有按需开启的录制
+type ApiCtrlStopRecordReq struct {
+	StreamName string `json:"stream_name"`
+	Format     string `json:"format"`
+}
+
+// ApiCtrlStartFilePubReq
+//
+// Path 文件路径，支持glob，匹配到多个文件时按文件名顺序依次推送，支持flv、ts、m2ts文件。
+// 只能访问配置 file_pub.root_dir 下的文件，相对路径相对于该目录，不允许包含".."
+// Loop 为true时循环推送，直到调用stop_file_pub
+type ApiCtrlStartFilePubReq struct {
+	StreamName string `json:"stream_name"`
+	Path       string `json:"path"`
+	Loop       bool   `json:"loop"`
+}
+
+type ApiCtrlStopFilePubReq struct {
+	StreamName string `json:"stream_name"`
+}
+
+// ApiCtrlStartUdpTsPubReq
+//
+// Addr 监听地址，比如 ":1234" 接收单播，"239.1.1.1:1234" 接收组播。
+// Interface 加入组播组时使用的网卡名，为空时由系统选择。
+// 停止时使用kick_session，session_id见返回值
+type ApiCtrlStartUdpTsPubReq struct {
+	StreamName string `json:"stream_name"`
+	Addr       string `json:"addr"`
+	Interface  string `json:"interface"`
+	TimeoutMs  int    `json:"timeout_ms"`
+}
+
 type ApiCtrlKickSessionReq struct {
 	StreamName string `json:"stream_name"`
 	SessionId  string `json:"session_id"`
@@ -46,6 +109,11 @@ type ApiCtrlStartRtpPubReq struct {
 	DebugDumpPacket string `json:"debug_dump_packet"`
 }
 
+// ApiCtrlReloadAclReq 整体替换当前的acl策略
+type ApiCtrlReloadAclReq struct {
+	AclConfig
+}
+
 // ----- response ------------------------------------------------------------------------------------------------------
 
 const (
@@ -61,9 +129,19 @@ const (
 	DespParamMissing         = "param missing"
 	ErrorCodeSessionNotFound = 1003
 	DespSessionNotFound      = "session not found"
+	ErrorCodeAclRejected     = 1004 // session被acl策略拒绝，拉流者为http协议时作为http响应返回
+	DespAclRejected          = "rejected by acl"
+	ErrorCodeRecordNotFound  = 1005
+	DespRecordNotFound       = "record not found"
 
 	ErrorCodeStartRelayPullFail = 2001
 	ErrorCodeListenUdpPortFail  = 2002
+	ErrorCodeReloadAclFail      = 2003
+	ErrorCodeStartRelayPushFail = 2004
+	ErrorCodeStartRecordFail    = 2005
+	ErrorCodeSnapshotFail       = 2006
+	ErrorCodeStartFilePubFail   = 2007
+	ErrorCodeStartUdpTsPubFail  = 2008
 )
 
 type ApiRespBasic struct {
@@ -95,6 +173,26 @@ type ApiStatGroupResp struct {
 	Data *StatGroup `json:"data"`
 }
 
+type ApiStatNotifyResp struct {
+	ApiRespBasic
+	Data StatNotify `json:"data"`
+}
+
+type ApiStatAclResp struct {
+	ApiRespBasic
+	Data StatAcl `json:"data"`
+}
+
+type ApiStatRecordRetentionResp struct {
+	ApiRespBasic
+	Data StatRecordRetention `json:"data"`
+}
+
+type ApiStatSnapshotResp struct {
+	ApiRespBasic
+	Data *Snapshot `json:"data"`
+}
+
 type ApiCtrlStartRelayPullResp struct {
 	ApiRespBasic
 	Data struct {
@@ -110,6 +208,61 @@ type ApiCtrlStopRelayPullResp struct {
 	} `json:"data"`
 }
 
+type ApiStatRelayPushResp struct {
+	ApiRespBasic
+	Data []StatRelayPush `json:"data"`
+}
+
+type ApiCtrlStartRelayPushResp struct {
+	ApiRespBasic
+	Data struct {
+		StreamName string `json:"stream_name"`
+		Url        string `json:"url"`
+	} `json:"data"`
+}
+
+type ApiCtrlStopRelayPushResp struct {
+	ApiRespBasic
+	Data struct {
+		SessionId string `json:"session_id"` // 转推中时被关闭的session，没有在转推时为空
+	} `json:"data"`
+}
+
+type ApiCtrlStartRecordResp struct {
+	ApiRespBasic
+	Data StatRecord `json:"data"`
+}
+
+type ApiCtrlStopRecordResp struct {
+	ApiRespBasic
+	Data []StatRecord `json:"data"` // 被停止的录制
+}
+
+type ApiCtrlStartFilePubResp struct {
+	ApiRespBasic
+	Data struct {
+		StreamName string   `json:"stream_name"`
+		SessionId  string   `json:"session_id"`
+		Files      []string `json:"files"` // 匹配到的文件，按推送的顺序
+	} `json:"data"`
+}
+
+type ApiCtrlStopFilePubResp struct {
+	ApiRespBasic
+	Data struct {
+		SessionId string `json:"session_id"`
+	} `json:"data"`
+}
+
+type ApiCtrlStartUdpTsPubResp struct {
+	ApiRespBasic
+	Data struct {
+		StreamName string `json:"stream_name"`
+		SessionId  string `json:"session_id"`
+		LocalAddr  string `json:"local_addr"` // 实际监听的地址
+	} `json:"data"`
+}
+
 type ApiCtrlKickSessionResp struct {
 	ApiRespBasic
 }
@@ -122,3 +275,7 @@ type ApiCtrlStartRtpPubResp struct {
 		Port       int    `json:"port"`
 	} `json:"data"`
 }
+
+type ApiCtrlReloadAclResp struct {
+	ApiRespBasic
+}
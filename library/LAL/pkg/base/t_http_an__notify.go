@@ -90,6 +90,30 @@ type HlsMakeTsInfo struct {
 	Duration       float64 `json:"duration"`
 }
 
+// RecordSegmentInfo 录制文件完成一个分段
+type RecordSegmentInfo struct {
+	EventCommonInfo
+
+	AppName    string `json:"app_name"`
+	StreamName string `json:"stream_name"`
+	Format     string `json:"format"`     // 录制格式，比如"mp4"
+	Filename   string `json:"filename"`   // 带路径的文件名
+	StartTime  string `json:"start_time"` // 分段第一帧对应的时间
+	DurationMs int64  `json:"duration_ms"`
+	Size       int64  `json:"size"` // 文件大小，单位字节
+}
+
+// RecordErrorInfo 录制因为错误而停止，比如磁盘使用率超过水位线
+type RecordErrorInfo struct {
+	EventCommonInfo
+
+	AppName    string `json:"app_name"`
+	StreamName string `json:"stream_name"`
+	Format     string `json:"format"`   // 录制格式，比如"flv"
+	Filename   string `json:"filename"` // 正在录制的文件，带路径
+	Reason     string `json:"reason"`
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func Session2PubStartInfo(session ISession) PubStartInfo {
@@ -10,11 +10,11 @@ package base
 
 // ----- 所有session -----
 //
-// server.pub:  rtmp(ServerSession), rtsp(PubSession), customize(CustomizePubSessionContext), ps(gb28181.PubSession)
+// server.pub:  rtmp(ServerSession), rtsp(PubSession), customize(CustomizePubSessionContext), ps(gb28181.PubSession), ts(udpts.PubSession)
 // server.sub:  rtmp(ServerSession), rtsp(SubSession), flv(SubSession), ts(SubSession), 还有一个比较特殊的hls
 //
 // client.push: rtmp(PushSession), rtsp(PushSession)
-// client.pull: rtmp(PullSession), rtsp(PullSession), flv(PullSession)
+// client.pull: rtmp(PullSession), rtsp(PullSession), flv(PullSession), ts(PullSession), hls(PullSession)
 //
 // other:       rtmp.ClientSession, (rtmp.ServerSession)
 //              rtsp.BaseInSession, rtsp.BaseOutSession, rtsp.ClientCommandSession, rtsp.ServerCommandSession
@@ -38,8 +38,11 @@ const (
 	SessionTypeFlvSub            SessionType = SessionProtocolFlv<<8 | SessionBaseTypeSub
 	SessionTypeFlvPull           SessionType = SessionProtocolFlv<<8 | SessionBaseTypePull
 	SessionTypeTsSub             SessionType = SessionProtocolTs<<8 | SessionBaseTypeSub
+	SessionTypeTsPull            SessionType = SessionProtocolTs<<8 | SessionBaseTypePull
+	SessionTypeTsPub             SessionType = SessionProtocolTs<<8 | SessionBaseTypePub
 	SessionTypePsPub             SessionType = SessionProtocolPs<<8 | SessionBaseTypePub
 	SessionTypeHlsSub            SessionType = SessionProtocolHls<<8 | SessionBaseTypeSub
+	SessionTypeHlsPull           SessionType = SessionProtocolHls<<8 | SessionBaseTypePull
 
 	SessionProtocolCustomize = 1
 	SessionProtocolRtmp      = 2
@@ -22,8 +22,11 @@ const (
 	UkPreFlvSubSession              = SessionProtocolFlvStr + SessionBaseTypePubSubStr    // "FLVSUB"
 	UkPreFlvPullSession             = SessionProtocolFlvStr + SessionBaseTypePullStr      // "FLVPULL"
 	UkPreTsSubSession               = SessionProtocolTsStr + SessionBaseTypePubSubStr     // "TSSUB"
+	UkPreTsPullSession              = SessionProtocolTsStr + SessionBaseTypePullStr       // "TSPULL"
+	UkPreTsPubSession               = SessionProtocolTsStr + SessionBaseTypePubStr        // "TSPUB"
 	UkPrePsPubSession               = SessionProtocolPsStr + SessionBaseTypePubStr        // "PSPUB"
 	UkPreHlsSubSession              = SessionProtocolHlsStr + SessionBaseTypeSubStr       // "HLSSUB"
+	UkPreHlsPullSession             = SessionProtocolHlsStr + SessionBaseTypePullStr      // "HLSPULL"
 
 	UkPreRtspServerCommandSession = "RTSPSRVCMD" // 这个不暴露给上层
 
@@ -80,6 +83,14 @@ func GenUkTsSubSession() string {
 	return siUkTsSubSession.GenUniqueKey()
 }
 
+func GenUkTsPullSession() string {
+	return siUkTsPullSession.GenUniqueKey()
+}
+
+func GenUkTsPubSession() string {
+	return siUkTsPubSession.GenUniqueKey()
+}
+
 func GenUkFlvPullSession() string {
 	return siUkFlvPullSession.GenUniqueKey()
 }
@@ -88,6 +99,10 @@ func GenUkHlsSubSession() string {
 	return siUkHlsSubSession.GenUniqueKey()
 }
 
+func GenUkHlsPullSession() string {
+	return siUkHlsPullSession.GenUniqueKey()
+}
+
 func GenUkPsPubSession() string {
 	return siUkPsPubSession.GenUniqueKey()
 }
@@ -116,9 +131,12 @@ var (
 	siUkRtspPullSession          *unique.SingleGenerator
 	siUkFlvSubSession            *unique.SingleGenerator
 	siUkTsSubSession             *unique.SingleGenerator
+	siUkTsPullSession            *unique.SingleGenerator
+	siUkTsPubSession             *unique.SingleGenerator
 	siUkFlvPullSession           *unique.SingleGenerator
 	siUkPsPubSession             *unique.SingleGenerator
 	siUkHlsSubSession            *unique.SingleGenerator
+	siUkHlsPullSession           *unique.SingleGenerator
 
 	siUkGroup              *unique.SingleGenerator
 	siUkHlsMuxer           *unique.SingleGenerator
@@ -137,9 +155,12 @@ func init() {
 	siUkRtspPullSession = unique.NewSingleGenerator(UkPreRtspPullSession)
 	siUkFlvSubSession = unique.NewSingleGenerator(UkPreFlvSubSession)
 	siUkTsSubSession = unique.NewSingleGenerator(UkPreTsSubSession)
+	siUkTsPullSession = unique.NewSingleGenerator(UkPreTsPullSession)
+	siUkTsPubSession = unique.NewSingleGenerator(UkPreTsPubSession)
 	siUkFlvPullSession = unique.NewSingleGenerator(UkPreFlvPullSession)
 	siUkPsPubSession = unique.NewSingleGenerator(UkPrePsPubSession)
 	siUkHlsSubSession = unique.NewSingleGenerator(UkPreHlsSubSession)
+	siUkHlsPullSession = unique.NewSingleGenerator(UkPreHlsPullSession)
 
 	siUkGroup = unique.NewSingleGenerator(UkPreGroup)
 	siUkHlsMuxer = unique.NewSingleGenerator(UkPreHlsMuxer)
@@ -64,6 +64,12 @@ var (
 	// LalHttpflvPullSessionUa e.g. lal/0.12.3
 	LalHttpflvPullSessionUa string
 
+	// LalHttptsPullSessionUa e.g. lal/0.12.3
+	LalHttptsPullSessionUa string
+
+	// LalHlsPullSessionUa e.g. lal/0.12.3
+	LalHlsPullSessionUa string
+
 	// LalHttpflvSubSessionServer e.g. lal0.12.3
 	LalHttpflvSubSessionServer string
 
@@ -116,9 +122,14 @@ var (
 // - rtsp client(pull)
 //     - Options User-Agent
 //
+// - httpts pull
+// 	   - User-Agent
 // - httpts sub
 //     - `server:`
 //
+// - hls pull
+// 	   - User-Agent
+//
 // - http api
 //     - `server:`
 
@@ -138,6 +149,8 @@ func init() {
 	LalHttpApiServer = LalLibraryName + LalVersionDot
 
 	LalHttpflvPullSessionUa = LalLibraryName + "/" + LalVersionDot
+	LalHttptsPullSessionUa = LalLibraryName + "/" + LalVersionDot
+	LalHlsPullSessionUa = LalLibraryName + "/" + LalVersionDot
 	LalRtspPullSessionUa = LalLibraryName + "/" + LalVersionDot
 
 	LalRtmpHandshakeWaterMark = LalFullInfo
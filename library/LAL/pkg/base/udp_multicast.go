@@ -0,0 +1,66 @@
+package base
+
+import "net"
+
+// udp_multicast.go
+//
+// udp组播相关的辅助函数，发送端见 SetMulticastSendOption ，接收端使用标准库的 net.ListenMulticastUDP 加入组播组
+//
+
+// GetMulticastInterface 根据网卡名获取网卡，name为空时返回nil，表示由系统选择默认网卡
+func GetMulticastInterface(name string) (*net.Interface, error) {
+	if name == "" {
+		return nil, nil
+	}
+	return net.InterfaceByName(name)
+}
+
+// SetMulticastSendOption 设置发送组播数据时使用的网卡以及TTL
+//
+// @param ifi: 为nil时不设置网卡，由系统根据路由选择
+//
+// @param ttl: 小于等于0时不设置，使用系统默认值（通常为1，即不跨路由器）
+func SetMulticastSendOption(conn *net.UDPConn, ifi *net.Interface, ttl int) error {
+	var ip4 [4]byte
+	if ifi != nil {
+		ip, err := interfaceIpv4(ifi)
+		if err != nil {
+			return err
+		}
+		copy(ip4[:], ip)
+	}
+
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err = rc.Control(func(fd uintptr) {
+		if ttl > 0 {
+			if sockErr = setMulticastTtl(fd, ttl); sockErr != nil {
+				return
+			}
+		}
+		if ifi != nil {
+			sockErr = setMulticastIf(fd, ip4)
+		}
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+func interfaceIpv4(ifi *net.Interface) (net.IP, error) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if ip := ipNet.IP.To4(); ip != nil {
+				return ip, nil
+			}
+		}
+	}
+	return nil, ErrMulticastNoIpv4Addr
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package base
+
+import "syscall"
+
+func setMulticastTtl(fd uintptr, ttl int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl)
+}
+
+func setMulticastIf(fd uintptr, ip4 [4]byte) error {
+	return syscall.SetsockoptInet4Addr(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, ip4)
+}
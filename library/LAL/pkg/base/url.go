@@ -217,6 +217,14 @@ func ParseHttpflvUrl(rawUrl string) (ctx UrlContext, err error) {
 	return parseHttpUrl(rawUrl, ".flv")
 }
 
+func ParseHttptsUrl(rawUrl string) (ctx UrlContext, err error) {
+	return parseHttpUrl(rawUrl, ".ts")
+}
+
+func ParseHlsUrl(rawUrl string) (ctx UrlContext, err error) {
+	return parseHttpUrl(rawUrl, ".m3u8")
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 // ParseHttpRequest
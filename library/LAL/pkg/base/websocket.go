@@ -9,8 +9,10 @@
 package base
 
 import (
+	"crypto/rand"
 	"crypto/sha1"
 	"encoding/base64"
+	"io"
 	"math"
 
 	"live-server/library/naza/pkg/bele"
@@ -140,14 +142,101 @@ func MakeWsFrameHeader(wsHeader WsHeader) (buf []byte) {
 	}
 	return buf
 }
+
+// WsMaxPayloadLength 读取单个帧时允许的最大payload长度，避免对端发送异常长度导致分配过大的内存
+var WsMaxPayloadLength uint64 = 16 * 1024 * 1024
+
+// ReadWsFrame 读取一个websocket帧
+//
+// @return payload: 如果帧带掩码，返回的是已经解码后的数据
+func ReadWsFrame(r io.Reader) (wsHeader WsHeader, payload []byte, err error) {
+	var b [8]byte
+	if _, err = io.ReadFull(r, b[:2]); err != nil {
+		return
+	}
+	wsHeader.Fin = b[0]&0x80 != 0
+	wsHeader.Rsv1 = b[0]&0x40 != 0
+	wsHeader.Rsv2 = b[0]&0x20 != 0
+	wsHeader.Rsv3 = b[0]&0x10 != 0
+	wsHeader.Opcode = b[0] & 0x0F
+	wsHeader.Masked = b[1]&0x80 != 0
+
+	wsHeader.PayloadLength = uint64(b[1] & 0x7F)
+	switch wsHeader.PayloadLength {
+	case 126:
+		if _, err = io.ReadFull(r, b[:2]); err != nil {
+			return
+		}
+		wsHeader.PayloadLength = uint64(bele.BeUint16(b[:2]))
+	case 127:
+		if _, err = io.ReadFull(r, b[:8]); err != nil {
+			return
+		}
+		wsHeader.PayloadLength = bele.BeUint64(b[:8])
+	}
+	if wsHeader.PayloadLength > WsMaxPayloadLength {
+		err = ErrWebSocketPayloadTooLarge
+		return
+	}
+
+	if wsHeader.Masked {
+		if _, err = io.ReadFull(r, b[:4]); err != nil {
+			return
+		}
+		wsHeader.MaskKey = bele.LeUint32(b[:4])
+	}
+
+	payload = make([]byte, wsHeader.PayloadLength)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if wsHeader.Masked {
+		MaskWsPayload(payload, wsHeader.MaskKey)
+	}
+	return
+}
+
+// MaskWsPayload 使用掩码对payload进行原地编码或解码（两者是相同的操作）
+//
+// 注意，maskKey的字节序和 MakeWsFrameHeader 中写入的保持一致
+func MaskWsPayload(payload []byte, maskKey uint32) {
+	var key [4]byte
+	bele.LePutUint32(key[:], maskKey)
+	for i := range payload {
+		payload[i] ^= key[i%4]
+	}
+}
+
+// MakeWebSocketKey 生成客户端握手时使用的Sec-WebSocket-Key
+func MakeWebSocketKey() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// CalcWebSocketAccept 根据Sec-WebSocket-Key计算Sec-WebSocket-Accept
+func CalcWebSocketAccept(secWebSocketKey string) string {
+	sha1Sum := sha1.Sum([]byte(secWebSocketKey + WsMagicStr))
+	return base64.StdEncoding.EncodeToString(sha1Sum[:])
+}
+
 func UpdateWebSocketHeader(secWebSocketKey string) []byte {
+	return UpdateWebSocketHeaderWithProtocol(secWebSocketKey, "")
+}
+
+// UpdateWebSocketHeaderWithProtocol
+//
+// @param protocol: 回复给客户端的Sec-WebSocket-Protocol，为空则不回复该字段
+func UpdateWebSocketHeaderWithProtocol(secWebSocketKey string, protocol string) []byte {
 	firstLine := "HTTP/1.1 101 Switching Protocol\r\n"
-	sha1Sum := sha1.Sum([]byte(secWebSocketKey + WsMagicStr))
-	secWebSocketAccept := base64.StdEncoding.EncodeToString(sha1Sum[:])
+	secWebSocketAccept := CalcWebSocketAccept(secWebSocketKey)
 	webSocketResponseHeaderStr := firstLine +
 		"Server: " + LalHttpflvSubSessionServer + "\r\n" +
-		"Sec-WebSocket-Accept:" + secWebSocketAccept + "\r\n" +
-		"Keep-Alive: timeout=15, max=100\r\n" +
+		"Sec-WebSocket-Accept:" + secWebSocketAccept + "\r\n"
+	if protocol != "" {
+		webSocketResponseHeaderStr += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	webSocketResponseHeaderStr += "Keep-Alive: timeout=15, max=100\r\n" +
 		"Connection: Upgrade\r\n" +
 		"Upgrade: websocket\r\n" +
 		CorsHeaders +
@@ -0,0 +1,45 @@
+package base_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"live-server/library/LAL/pkg/base"
+
+	"live-server/library/naza/pkg/assert"
+)
+
+func TestReadWsFrame(t *testing.T) {
+	for _, n := range []int{0, 125, 126, 65535, 65536} {
+		payload := make([]byte, n)
+		for i := range payload {
+			payload[i] = byte(i)
+		}
+		masked := append([]byte(nil), payload...)
+		h := base.WsHeader{
+			Fin:           true,
+			Opcode:        base.Wso_Binary,
+			PayloadLength: uint64(n),
+			Masked:        true,
+			MaskKey:       0x11223344,
+		}
+		base.MaskWsPayload(masked, h.MaskKey)
+
+		b := append(base.MakeWsFrameHeader(h), masked...)
+		rh, rp, err := base.ReadWsFrame(bytes.NewReader(b))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, h, rh)
+		assert.Equal(t, payload, rp)
+	}
+
+	h := base.WsHeader{Fin: true, Opcode: base.Wso_Binary, PayloadLength: base.WsMaxPayloadLength + 1}
+	_, _, err := base.ReadWsFrame(bytes.NewReader(base.MakeWsFrameHeader(h)))
+	assert.Equal(t, true, errors.Is(err, base.ErrWebSocketPayloadTooLarge))
+}
+
+func TestCalcWebSocketAccept(t *testing.T) {
+	// rfc6455 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", base.CalcWebSocketAccept("dGhlIHNhbXBsZSBub25jZQ=="))
+	assert.Equal(t, 24, len(base.MakeWebSocketKey()))
+}
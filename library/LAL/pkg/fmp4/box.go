@@ -0,0 +1,91 @@
+package fmp4
+
+import (
+	"live-server/library/naza/pkg/bele"
+)
+
+// boxWriter 顺序写入box，box的size字段在box结束时回填
+//
+// 使用方式：
+//
+//	pos := w.startBox("moov")
+//	...写入box的内容，包括子box
+//	w.endBox(pos)
+type boxWriter struct {
+	b []byte
+}
+
+// startBox
+//
+// @return box在buffer中的起始位置，调用 endBox 时使用
+func (w *boxWriter) startBox(typ string) int {
+	pos := len(w.b)
+	w.u32(0)
+	w.b = append(w.b, typ...)
+	return pos
+}
+
+// startFullBox 写入FullBox的头，包含version和flags
+func (w *boxWriter) startFullBox(typ string, version uint8, flags uint32) int {
+	pos := w.startBox(typ)
+	w.u8(version)
+	w.u24(flags)
+	return pos
+}
+
+func (w *boxWriter) endBox(pos int) {
+	bele.BePutUint32(w.b[pos:], uint32(len(w.b)-pos))
+}
+
+func (w *boxWriter) u8(v uint8) {
+	w.b = append(w.b, v)
+}
+
+func (w *boxWriter) u16(v uint16) {
+	w.b = append(w.b, uint8(v>>8), uint8(v))
+}
+
+func (w *boxWriter) u24(v uint32) {
+	w.b = append(w.b, uint8(v>>16), uint8(v>>8), uint8(v))
+}
+
+func (w *boxWriter) u32(v uint32) {
+	w.b = append(w.b, uint8(v>>24), uint8(v>>16), uint8(v>>8), uint8(v))
+}
+
+func (w *boxWriter) u64(v uint64) {
+	w.u32(uint32(v >> 32))
+	w.u32(uint32(v))
+}
+
+func (w *boxWriter) zero(n int) {
+	for i := 0; i < n; i++ {
+		w.b = append(w.b, 0)
+	}
+}
+
+func (w *boxWriter) bytes(b []byte) {
+	w.b = append(w.b, b...)
+}
+
+func (w *boxWriter) str(s string) {
+	w.b = append(w.b, s...)
+}
+
+// matrix 单位矩阵，mvhd和tkhd使用
+func (w *boxWriter) matrix() {
+	w.u32(0x00010000)
+	w.zero(12)
+	w.u32(0x00010000)
+	w.zero(12)
+	w.u32(0x40000000)
+}
+
+// descriptor 写入esds中的descriptor，长度使用4字节的扩展格式
+func (w *boxWriter) descriptor(tag uint8, size int) {
+	w.u8(tag)
+	w.u8(0x80 | uint8(size>>21&0x7f))
+	w.u8(0x80 | uint8(size>>14&0x7f))
+	w.u8(0x80 | uint8(size>>7&0x7f))
+	w.u8(uint8(size & 0x7f))
+}
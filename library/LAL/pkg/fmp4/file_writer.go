@@ -0,0 +1,88 @@
+package fmp4
+
+import (
+	"os"
+	"path/filepath"
+
+	"live-server/library/LAL/pkg/base"
+)
+
+// FileWriter 将音视频数据写入fragmented mp4文件
+//
+// 文件开头是init segment，之后每次 Flush 追加一个moof+mdat。
+// 由于不依赖文件结尾的moov，进程异常退出时，已经 Flush 的数据依然可以播放。
+type FileWriter struct {
+	fp    *os.File
+	muxer *Muxer
+	size  int64
+}
+
+// Create 创建文件并写入init segment，文件所在的目录不存在时自动创建
+func (fw *FileWriter) Create(filename string, video *VideoTrack, audio *AudioTrack) (err error) {
+	muxer := NewMuxer(video, audio)
+	init, err := muxer.InitSegment()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return err
+	}
+	if fw.fp, err = os.Create(filename); err != nil {
+		return err
+	}
+	fw.muxer = muxer
+	fw.size = 0
+	return fw.write(init)
+}
+
+// WriteAvPacket 缓存采样，调用 Flush 后才真正写入文件
+func (fw *FileWriter) WriteAvPacket(pkt base.AvPacket) error {
+	if fw.fp == nil {
+		return base.ErrFileNotExist
+	}
+	fw.muxer.FeedAvPacket(pkt)
+	return nil
+}
+
+// Flush 将缓存的采样作为一个fragment写入文件
+func (fw *FileWriter) Flush() error {
+	if fw.fp == nil {
+		return base.ErrFileNotExist
+	}
+	b := fw.muxer.Flush()
+	if b == nil {
+		return nil
+	}
+	return fw.write(b)
+}
+
+// Size 已经写入文件的字节数
+func (fw *FileWriter) Size() int64 {
+	return fw.size
+}
+
+// Dispose 写入缓存的采样，并关闭文件
+func (fw *FileWriter) Dispose() error {
+	if fw.fp == nil {
+		return base.ErrFileNotExist
+	}
+	err := fw.Flush()
+	if cerr := fw.fp.Close(); err == nil {
+		err = cerr
+	}
+	fw.fp = nil
+	return err
+}
+
+func (fw *FileWriter) Name() string {
+	if fw.fp == nil {
+		return ""
+	}
+	return fw.fp.Name()
+}
+
+func (fw *FileWriter) write(b []byte) error {
+	n, err := fw.fp.Write(b)
+	fw.size += int64(n)
+	return err
+}
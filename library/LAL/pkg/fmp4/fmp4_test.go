@@ -0,0 +1,201 @@
+package fmp4
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/naza/pkg/assert"
+	"live-server/library/naza/pkg/bele"
+)
+
+var (
+	goldenSps = []byte{
+		0x67, 0x64, 0x00, 0x20, 0xAC, 0xD9, 0x40, 0xC0, 0x29, 0xB0, 0x11, 0x00, 0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x03, 0x00, 0x32, 0x0F, 0x18, 0x31, 0x96,
+	}
+	goldenPps = []byte{
+		0x68, 0xEB, 0xEC, 0xB2, 0x2C,
+	}
+	goldenAsc = []byte{0x12, 0x10} // AAC-LC 44100 2ch
+)
+
+type box struct {
+	typ     string
+	payload []byte
+}
+
+func splitBoxes(t *testing.T, b []byte) []box {
+	var ret []box
+	for len(b) != 0 {
+		assert.Equal(t, true, len(b) >= 8)
+		size := int(bele.BeUint32(b))
+		assert.Equal(t, true, size >= 8 && size <= len(b))
+		ret = append(ret, box{typ: string(b[4:8]), payload: b[8:size]})
+		b = b[size:]
+	}
+	return ret
+}
+
+func findBox(t *testing.T, b []byte, path ...string) []byte {
+	for _, typ := range path {
+		found := false
+		for _, item := range splitBoxes(t, b) {
+			if item.typ == typ {
+				b = item.payload
+				found = true
+				break
+			}
+		}
+		assert.Equal(t, true, found, typ)
+	}
+	return b
+}
+
+func TestMakeInitSegment(t *testing.T) {
+	_, err := MakeInitSegment(nil, nil)
+	assert.Equal(t, base.ErrFmp4NoTrack, err)
+
+	video, err := NewAvcVideoTrack(goldenSps, goldenPps)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 768, video.Width)
+	assert.Equal(t, 320, video.Height)
+	audio, err := NewAudioTrack(goldenAsc)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 44100, audio.SampleRate)
+	assert.Equal(t, 2, audio.ChannelCount)
+
+	b, err := MakeInitSegment(video, audio)
+	assert.Equal(t, nil, err)
+	boxes := splitBoxes(t, b)
+	assert.Equal(t, 2, len(boxes))
+	assert.Equal(t, "ftyp", boxes[0].typ)
+	assert.Equal(t, "moov", boxes[1].typ)
+
+	var traks []box
+	for _, item := range splitBoxes(t, boxes[1].payload) {
+		if item.typ == "trak" {
+			traks = append(traks, item)
+		}
+	}
+	assert.Equal(t, 2, len(traks))
+
+	stsd := findBox(t, traks[0].payload, "mdia", "minf", "stbl", "stsd")
+	// stsd: version+flags(4) entry_count(4)，avc1的头78字节之后是avcC
+	avc1 := findBox(t, stsd[8:], "avc1")
+	avcc := findBox(t, avc1[78:], "avcC")
+	assert.Equal(t, video.Record, avcc)
+
+	stsd = findBox(t, traks[1].payload, "mdia", "minf", "stbl", "stsd")
+	mp4a := findBox(t, stsd[8:], "mp4a")
+	esds := findBox(t, mp4a[28:], "esds")
+	// asc之后是SLConfigDescriptor，5字节的头加1字节的内容
+	assert.Equal(t, goldenAsc, esds[len(esds)-6-len(goldenAsc):len(esds)-6])
+
+	mvex := findBox(t, boxes[1].payload, "mvex")
+	assert.Equal(t, 2, len(splitBoxes(t, mvex)))
+}
+
+func TestMuxer(t *testing.T) {
+	video, _ := NewAvcVideoTrack(goldenSps, goldenPps)
+	audio, _ := NewAudioTrack(goldenAsc)
+	m := NewMuxer(video, audio)
+
+	assert.Equal(t, nil, m.Flush())
+
+	idr := []byte{0, 0, 0, 3, 0x65, 0xaa, 0xbb}
+	p := []byte{0, 0, 0, 2, 0x41, 0xcc}
+	aacFrame := []byte{0x21, 0x22, 0x23}
+	m.FeedAvPacket(base.AvPacket{PayloadType: base.AvPacketPtAvc, Timestamp: 1000, Pts: 1040, Payload: idr})
+	m.FeedAvPacket(base.AvPacket{PayloadType: base.AvPacketPtAac, Timestamp: 1000, Pts: 1000, Payload: aacFrame})
+	m.FeedAvPacket(base.AvPacket{PayloadType: base.AvPacketPtAvc, Timestamp: 1040, Pts: 1040, Payload: p})
+	// 不支持的类型被忽略
+	m.FeedAvPacket(base.AvPacket{PayloadType: base.AvPacketPtHevc, Timestamp: 1040, Pts: 1040, Payload: p})
+	assert.Equal(t, true, m.HasSamples())
+
+	b := m.Flush()
+	assert.Equal(t, false, m.HasSamples())
+	boxes := splitBoxes(t, b)
+	assert.Equal(t, 2, len(boxes))
+	assert.Equal(t, "moof", boxes[0].typ)
+	assert.Equal(t, "mdat", boxes[1].typ)
+
+	var mdat []byte
+	mdat = append(mdat, idr...)
+	mdat = append(mdat, p...)
+	mdat = append(mdat, aacFrame...)
+	assert.Equal(t, mdat, boxes[1].payload)
+
+	mfhd := findBox(t, boxes[0].payload, "mfhd")
+	assert.Equal(t, uint32(1), bele.BeUint32(mfhd[4:]))
+
+	var trafs []box
+	for _, item := range splitBoxes(t, boxes[0].payload) {
+		if item.typ == "traf" {
+			trafs = append(trafs, item)
+		}
+	}
+	assert.Equal(t, 2, len(trafs))
+
+	// 视频
+	tfdt := findBox(t, trafs[0].payload, "tfdt")
+	assert.Equal(t, uint64(90000), bele.BeUint64(tfdt[4:]))
+	trun := findBox(t, trafs[0].payload, "trun")
+	assert.Equal(t, uint32(2), bele.BeUint32(trun[4:]))
+	moofSize := len(boxes[0].payload) + 8
+	assert.Equal(t, uint32(moofSize+8), bele.BeUint32(trun[8:]))
+	// 第一个采样：duration size flags cto
+	assert.Equal(t, uint32(3600), bele.BeUint32(trun[12:]))
+	assert.Equal(t, uint32(len(idr)), bele.BeUint32(trun[16:]))
+	assert.Equal(t, uint32(sampleFlagsKey), bele.BeUint32(trun[20:]))
+	assert.Equal(t, uint32(3600), bele.BeUint32(trun[24:]))
+	// 第二个采样的时长使用上一个采样的时长
+	assert.Equal(t, uint32(3600), bele.BeUint32(trun[28:]))
+	assert.Equal(t, uint32(sampleFlagsNonKey), bele.BeUint32(trun[36:]))
+
+	// 音频
+	tfdt = findBox(t, trafs[1].payload, "tfdt")
+	assert.Equal(t, uint64(44100), bele.BeUint64(tfdt[4:]))
+	trun = findBox(t, trafs[1].payload, "trun")
+	assert.Equal(t, uint32(moofSize+8+len(idr)+len(p)), bele.BeUint32(trun[8:]))
+	assert.Equal(t, uint32(aacSamplesPerFrame), bele.BeUint32(trun[12:]))
+
+	m.FeedAvPacket(base.AvPacket{PayloadType: base.AvPacketPtAac, Timestamp: 1023, Pts: 1023, Payload: aacFrame})
+	b = m.Flush()
+	mfhd = findBox(t, findBox(t, b, "moof"), "mfhd")
+	assert.Equal(t, uint32(2), bele.BeUint32(mfhd[4:]))
+	assert.Equal(t, 1, len(splitBoxes(t, findBox(t, b, "moof")))-1)
+}
+
+func TestFileWriter(t *testing.T) {
+	video, _ := NewAvcVideoTrack(goldenSps, goldenPps)
+	audio, _ := NewAudioTrack(goldenAsc)
+
+	var fw FileWriter
+	assert.Equal(t, base.ErrFileNotExist, fw.Flush())
+
+	filename := filepath.Join(t.TempDir(), "a", "b", "test.mp4")
+	err := fw.Create(filename, video, audio)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, filename, fw.Name())
+	initSize := fw.Size()
+
+	idr := []byte{0, 0, 0, 3, 0x65, 0xaa, 0xbb}
+	_ = fw.WriteAvPacket(base.AvPacket{PayloadType: base.AvPacketPtAvc, Timestamp: 0, Pts: 0, Payload: idr})
+	_ = fw.WriteAvPacket(base.AvPacket{PayloadType: base.AvPacketPtAac, Timestamp: 0, Pts: 0, Payload: []byte{0x21}})
+	// Flush 之前数据不会写入文件
+	assert.Equal(t, initSize, fw.Size())
+	assert.Equal(t, nil, fw.Flush())
+	_ = fw.WriteAvPacket(base.AvPacket{PayloadType: base.AvPacketPtAvc, Timestamp: 40, Pts: 40, Payload: idr})
+	assert.Equal(t, nil, fw.Dispose())
+	assert.Equal(t, "", fw.Name())
+
+	b, err := os.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(len(b)), fw.Size())
+	boxes := splitBoxes(t, b)
+	assert.Equal(t, 6, len(boxes))
+	for i, typ := range []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat"} {
+		assert.Equal(t, typ, boxes[i].typ)
+	}
+}
@@ -0,0 +1,239 @@
+package fmp4
+
+import (
+	"live-server/library/LAL/pkg/avc"
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/hevc"
+	"live-server/library/naza/pkg/bele"
+)
+
+// fragment.go
+//
+// 每次 Muxer.Flush 生成一个fragment，结构：
+//
+//	moof
+//	  mfhd
+//	  traf（每个有采样的track一个，视频在前）
+//	    tfhd
+//	    tfdt
+//	    trun
+//	mdat
+//
+
+const (
+	sampleFlagsKey    = 0x02000000 // sample_depends_on=2，不依赖其他帧
+	sampleFlagsNonKey = 0x01010000 // sample_depends_on=1，sample_is_non_sync_sample=1
+
+	tfhdFlagsDefaultBaseIsMoof = 0x020000
+
+	trunFlagsDataOffset = 0x000001
+	trunFlagsDuration   = 0x000100
+	trunFlagsSize       = 0x000200
+	trunFlagsFlags      = 0x000400
+	trunFlagsCto        = 0x000800
+)
+
+type sample struct {
+	dts      int64 // 单位为所在track的timescale
+	cto      int64
+	duration int64 // 小于0表示还不知道，需要等下一个采样
+	isKey    bool
+	payload  []byte
+}
+
+// Muxer 将 base.AvPacket 封装成fmp4的fragment
+//
+// 输入的 base.AvPacket 的要求：
+//   - Timestamp 为dts，Pts 为pts，单位都是毫秒
+//   - 视频的 Payload 为AVCC格式，也即每个nalu前面是4字节的长度
+//   - 音频的 Payload 为不包含adts头的aac raw数据
+type Muxer struct {
+	video *VideoTrack
+	audio *AudioTrack
+
+	videoTrackId int
+	audioTrackId int
+
+	seq uint32
+
+	videoSamples      []sample
+	audioSamples      []sample
+	lastVideoDuration int64
+}
+
+// NewMuxer
+//
+// @param video: 为nil时表示没有视频
+// @param audio: 为nil时表示没有音频
+func NewMuxer(video *VideoTrack, audio *AudioTrack) *Muxer {
+	m := &Muxer{
+		video: video,
+		audio: audio,
+	}
+	m.videoTrackId, m.audioTrackId = trackIds(video != nil, audio != nil)
+	return m
+}
+
+func (m *Muxer) InitSegment() ([]byte, error) {
+	return MakeInitSegment(m.video, m.audio)
+}
+
+// FeedAvPacket 缓存采样，直到调用 Flush
+//
+// 与init segment中不存在的track对应的数据，以及不支持的编码类型的数据，会被忽略
+func (m *Muxer) FeedAvPacket(pkt base.AvPacket) {
+	switch {
+	case pkt.IsVideo():
+		if m.video == nil || pkt.PayloadType != m.video.PayloadType {
+			return
+		}
+		dts := pkt.Timestamp * VideoTimescale / 1000
+		if n := len(m.videoSamples); n != 0 && m.videoSamples[n-1].duration < 0 {
+			d := dts - m.videoSamples[n-1].dts
+			if d < 0 {
+				d = 0
+			}
+			m.videoSamples[n-1].duration = d
+			m.lastVideoDuration = d
+		}
+		m.videoSamples = append(m.videoSamples, sample{
+			dts:      dts,
+			cto:      (pkt.Pts - pkt.Timestamp) * VideoTimescale / 1000,
+			duration: -1,
+			isKey:    isKeyFrame(pkt.PayloadType, pkt.Payload),
+			payload:  pkt.Payload,
+		})
+	case pkt.PayloadType == base.AvPacketPtAac:
+		if m.audio == nil {
+			return
+		}
+		m.audioSamples = append(m.audioSamples, sample{
+			dts:      pkt.Timestamp * int64(m.audio.SampleRate) / 1000,
+			duration: aacSamplesPerFrame,
+			isKey:    true,
+			payload:  pkt.Payload,
+		})
+	}
+}
+
+// SetLastVideoDuration 设置无法推算时长的视频采样使用的时长，比如只有一个视频采样时，单位毫秒
+//
+// 后续输入的视频采样会覆盖该值
+func (m *Muxer) SetLastVideoDuration(durationMs int64) {
+	m.lastVideoDuration = durationMs * VideoTimescale / 1000
+}
+
+// HasSamples 是否有还没有 Flush 的采样
+func (m *Muxer) HasSamples() bool {
+	return len(m.videoSamples) != 0 || len(m.audioSamples) != 0
+}
+
+// Flush 将缓存的采样生成一个moof+mdat，并清空缓存
+//
+// 最后一个视频采样的时长还不知道，使用上一个采样的时长代替
+//
+// @return 没有缓存的采样时返回nil
+func (m *Muxer) Flush() []byte {
+	if !m.HasSamples() {
+		return nil
+	}
+	if n := len(m.videoSamples); n != 0 && m.videoSamples[n-1].duration < 0 {
+		m.videoSamples[n-1].duration = m.lastVideoDuration
+	}
+
+	m.seq++
+
+	var w boxWriter
+	moof := w.startBox("moof")
+	mfhd := w.startFullBox("mfhd", 0, 0)
+	w.u32(m.seq)
+	w.endBox(mfhd)
+
+	// trun中的data_offset是相对moof起始位置的偏移，需要等moof写完后回填
+	var dataOffsetPosList []int
+	if len(m.videoSamples) != 0 {
+		dataOffsetPosList = append(dataOffsetPosList, writeTraf(&w, uint32(m.videoTrackId), m.videoSamples))
+	}
+	if len(m.audioSamples) != 0 {
+		dataOffsetPosList = append(dataOffsetPosList, writeTraf(&w, uint32(m.audioTrackId), m.audioSamples))
+	}
+	w.endBox(moof)
+
+	offset := len(w.b) + 8 // mdat的头
+	i := 0
+	for _, samples := range [][]sample{m.videoSamples, m.audioSamples} {
+		if len(samples) == 0 {
+			continue
+		}
+		bele.BePutUint32(w.b[dataOffsetPosList[i]:], uint32(offset))
+		for _, s := range samples {
+			offset += len(s.payload)
+		}
+		i++
+	}
+
+	mdat := w.startBox("mdat")
+	for _, s := range m.videoSamples {
+		w.bytes(s.payload)
+	}
+	for _, s := range m.audioSamples {
+		w.bytes(s.payload)
+	}
+	w.endBox(mdat)
+
+	m.videoSamples = nil
+	m.audioSamples = nil
+	return w.b
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// writeTraf
+//
+// @return trun中data_offset字段的位置
+func writeTraf(w *boxWriter, trackId uint32, samples []sample) int {
+	traf := w.startBox("traf")
+
+	tfhd := w.startFullBox("tfhd", 0, tfhdFlagsDefaultBaseIsMoof)
+	w.u32(trackId)
+	w.endBox(tfhd)
+
+	tfdt := w.startFullBox("tfdt", 1, 0)
+	w.u64(uint64(samples[0].dts))
+	w.endBox(tfdt)
+
+	// version 1，cto可以为负数
+	trun := w.startFullBox("trun", 1, trunFlagsDataOffset|trunFlagsDuration|trunFlagsSize|trunFlagsFlags|trunFlagsCto)
+	w.u32(uint32(len(samples)))
+	dataOffsetPos := len(w.b)
+	w.u32(0)
+	for _, s := range samples {
+		w.u32(uint32(s.duration))
+		w.u32(uint32(len(s.payload)))
+		if s.isKey {
+			w.u32(sampleFlagsKey)
+		} else {
+			w.u32(sampleFlagsNonKey)
+		}
+		w.u32(uint32(int32(s.cto)))
+	}
+	w.endBox(trun)
+
+	w.endBox(traf)
+	return dataOffsetPos
+}
+
+func isKeyFrame(pt base.AvPacketPt, payload []byte) bool {
+	isKey := false
+	_ = avc.IterateNaluAvcc(payload, func(nal []byte) {
+		if len(nal) == 0 {
+			return
+		}
+		if pt == base.AvPacketPtAvc {
+			isKey = isKey || avc.ParseNaluType(nal[0]) == avc.NaluTypeIdrSlice
+		} else {
+			isKey = isKey || hevc.IsIrapNalu(hevc.ParseNaluType(nal[0]))
+		}
+	})
+	return isKey
+}
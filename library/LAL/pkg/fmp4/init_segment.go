@@ -0,0 +1,318 @@
+package fmp4
+
+import (
+	"live-server/library/LAL/pkg/base"
+)
+
+// init_segment.go
+//
+// init segment的结构：
+//
+//	ftyp
+//	moov
+//	  mvhd
+//	  trak（视频、音频各一个，视频在前）
+//	    tkhd
+//	    mdia
+//	      mdhd
+//	      hdlr
+//	      minf
+//	        vmhd / smhd
+//	        dinf
+//	          dref
+//	            url
+//	        stbl
+//	          stsd
+//	            avc1 / hvc1 / mp4a
+//	              avcC / hvcC / esds
+//	          stts stsc stsz stco（都是空的，采样信息在moof中）
+//	  mvex
+//	    trex（每个trak一个）
+//
+
+// MakeInitSegment 生成ftyp+moov
+//
+// @param video: 为nil时表示没有视频
+// @param audio: 为nil时表示没有音频
+func MakeInitSegment(video *VideoTrack, audio *AudioTrack) ([]byte, error) {
+	if video == nil && audio == nil {
+		return nil, base.ErrFmp4NoTrack
+	}
+	if video != nil && video.PayloadType != base.AvPacketPtAvc && video.PayloadType != base.AvPacketPtHevc {
+		return nil, base.ErrFmp4UnsupportedCodec
+	}
+
+	var w boxWriter
+
+	ftyp := w.startBox("ftyp")
+	w.str("iso5")
+	w.u32(512)
+	w.str("iso5")
+	w.str("iso6")
+	w.str("mp41")
+	w.endBox(ftyp)
+
+	videoTrackId, audioTrackId := trackIds(video != nil, audio != nil)
+
+	moov := w.startBox("moov")
+	writeMvhd(&w, uint32(maxInt(videoTrackId, audioTrackId)+1))
+	if video != nil {
+		writeVideoTrak(&w, video, uint32(videoTrackId))
+	}
+	if audio != nil {
+		writeAudioTrak(&w, audio, uint32(audioTrackId))
+	}
+	mvex := w.startBox("mvex")
+	if video != nil {
+		writeTrex(&w, uint32(videoTrackId))
+	}
+	if audio != nil {
+		writeTrex(&w, uint32(audioTrackId))
+	}
+	w.endBox(mvex)
+	w.endBox(moov)
+
+	return w.b, nil
+}
+
+// trackIds 视频的track id固定为1，音频为视频之后的下一个
+//
+// @return 对应的track不存在时为0
+func trackIds(hasVideo, hasAudio bool) (videoTrackId, audioTrackId int) {
+	id := 1
+	if hasVideo {
+		videoTrackId = id
+		id++
+	}
+	if hasAudio {
+		audioTrackId = id
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func writeMvhd(w *boxWriter, nextTrackId uint32) {
+	mvhd := w.startFullBox("mvhd", 0, 0)
+	w.u32(0)          // creation_time
+	w.u32(0)          // modification_time
+	w.u32(1000)       // timescale
+	w.u32(0)          // duration
+	w.u32(0x00010000) // rate 1.0
+	w.u16(0x0100)     // volume 1.0
+	w.zero(10)        // reserved
+	w.matrix()
+	w.zero(24) // pre_defined
+	w.u32(nextTrackId)
+	w.endBox(mvhd)
+}
+
+func writeTkhd(w *boxWriter, trackId uint32, isAudio bool, width, height int) {
+	// flags: track_enabled | track_in_movie
+	tkhd := w.startFullBox("tkhd", 0, 3)
+	w.u32(0) // creation_time
+	w.u32(0) // modification_time
+	w.u32(trackId)
+	w.u32(0) // reserved
+	w.u32(0) // duration
+	w.zero(8)
+	w.u16(0) // layer
+	w.u16(0) // alternate_group
+	if isAudio {
+		w.u16(0x0100)
+	} else {
+		w.u16(0)
+	}
+	w.u16(0) // reserved
+	w.matrix()
+	w.u32(uint32(width) << 16)
+	w.u32(uint32(height) << 16)
+	w.endBox(tkhd)
+}
+
+func writeMdhd(w *boxWriter, timescale uint32) {
+	mdhd := w.startFullBox("mdhd", 0, 0)
+	w.u32(0) // creation_time
+	w.u32(0) // modification_time
+	w.u32(timescale)
+	w.u32(0)      // duration
+	w.u16(0x55c4) // language: und
+	w.u16(0)      // pre_defined
+	w.endBox(mdhd)
+}
+
+func writeHdlr(w *boxWriter, handlerType string, name string) {
+	hdlr := w.startFullBox("hdlr", 0, 0)
+	w.u32(0) // pre_defined
+	w.str(handlerType)
+	w.zero(12) // reserved
+	w.str(name)
+	w.u8(0)
+	w.endBox(hdlr)
+}
+
+func writeDinf(w *boxWriter) {
+	dinf := w.startBox("dinf")
+	dref := w.startFullBox("dref", 0, 0)
+	w.u32(1)
+	url := w.startFullBox("url ", 0, 1) // flags 1 表示数据就在本文件中
+	w.endBox(url)
+	w.endBox(dref)
+	w.endBox(dinf)
+}
+
+// writeEmptySampleTables stts stsc stsz stco，fmp4的采样信息都在moof中，所以这里都是空的
+func writeEmptySampleTables(w *boxWriter) {
+	stts := w.startFullBox("stts", 0, 0)
+	w.u32(0)
+	w.endBox(stts)
+	stsc := w.startFullBox("stsc", 0, 0)
+	w.u32(0)
+	w.endBox(stsc)
+	stsz := w.startFullBox("stsz", 0, 0)
+	w.u32(0) // sample_size
+	w.u32(0) // sample_count
+	w.endBox(stsz)
+	stco := w.startFullBox("stco", 0, 0)
+	w.u32(0)
+	w.endBox(stco)
+}
+
+func writeVideoTrak(w *boxWriter, video *VideoTrack, trackId uint32) {
+	trak := w.startBox("trak")
+	writeTkhd(w, trackId, false, video.Width, video.Height)
+
+	mdia := w.startBox("mdia")
+	writeMdhd(w, VideoTimescale)
+	writeHdlr(w, "vide", "VideoHandler")
+
+	minf := w.startBox("minf")
+	vmhd := w.startFullBox("vmhd", 0, 1)
+	w.u16(0)  // graphicsmode
+	w.zero(6) // opcolor
+	w.endBox(vmhd)
+	writeDinf(w)
+
+	stbl := w.startBox("stbl")
+	stsd := w.startFullBox("stsd", 0, 0)
+	w.u32(1) // entry_count
+
+	sampleEntryType, recordType := "avc1", "avcC"
+	if video.PayloadType == base.AvPacketPtHevc {
+		// 注意，使用hvc1而不是hev1，Safari只支持hvc1
+		sampleEntryType, recordType = "hvc1", "hvcC"
+	}
+	entry := w.startBox(sampleEntryType)
+	w.zero(6)  // reserved
+	w.u16(1)   // data_reference_index
+	w.u16(0)   // pre_defined
+	w.u16(0)   // reserved
+	w.zero(12) // pre_defined
+	w.u16(uint16(video.Width))
+	w.u16(uint16(video.Height))
+	w.u32(0x00480000) // horizresolution 72 dpi
+	w.u32(0x00480000) // vertresolution 72 dpi
+	w.u32(0)          // reserved
+	w.u16(1)          // frame_count
+	w.zero(32)        // compressorname
+	w.u16(0x0018)     // depth
+	w.u16(0xffff)     // pre_defined -1
+	record := w.startBox(recordType)
+	w.bytes(video.Record)
+	w.endBox(record)
+	w.endBox(entry)
+
+	w.endBox(stsd)
+	writeEmptySampleTables(w)
+	w.endBox(stbl)
+
+	w.endBox(minf)
+	w.endBox(mdia)
+	w.endBox(trak)
+}
+
+func writeAudioTrak(w *boxWriter, audio *AudioTrack, trackId uint32) {
+	trak := w.startBox("trak")
+	writeTkhd(w, trackId, true, 0, 0)
+
+	mdia := w.startBox("mdia")
+	writeMdhd(w, uint32(audio.SampleRate))
+	writeHdlr(w, "soun", "SoundHandler")
+
+	minf := w.startBox("minf")
+	smhd := w.startFullBox("smhd", 0, 0)
+	w.u16(0) // balance
+	w.u16(0) // reserved
+	w.endBox(smhd)
+	writeDinf(w)
+
+	stbl := w.startBox("stbl")
+	stsd := w.startFullBox("stsd", 0, 0)
+	w.u32(1) // entry_count
+
+	mp4a := w.startBox("mp4a")
+	w.zero(6) // reserved
+	w.u16(1)  // data_reference_index
+	w.zero(8) // reserved
+	w.u16(uint16(audio.ChannelCount))
+	w.u16(16) // samplesize
+	w.u16(0)  // pre_defined
+	w.u16(0)  // reserved
+	w.u32(uint32(audio.SampleRate) << 16)
+	writeEsds(w, audio, trackId)
+	w.endBox(mp4a)
+
+	w.endBox(stsd)
+	writeEmptySampleTables(w)
+	w.endBox(stbl)
+
+	w.endBox(minf)
+	w.endBox(mdia)
+	w.endBox(trak)
+}
+
+// writeEsds ISO/IEC 14496-1 ES_Descriptor
+func writeEsds(w *boxWriter, audio *AudioTrack, trackId uint32) {
+	const descriptorHeaderSize = 5
+
+	decSpecificInfoSize := len(audio.Asc)
+	decConfigSize := 13 + descriptorHeaderSize + decSpecificInfoSize
+	slConfigSize := 1
+	esSize := 3 + descriptorHeaderSize + decConfigSize + descriptorHeaderSize + slConfigSize
+
+	esds := w.startFullBox("esds", 0, 0)
+	w.descriptor(0x03, esSize) // ES_DescrTag
+	w.u16(uint16(trackId))     // ES_ID
+	w.u8(0)                    // flags
+
+	w.descriptor(0x04, decConfigSize) // DecoderConfigDescrTag
+	w.u8(0x40)                        // objectTypeIndication: Audio ISO/IEC 14496-3
+	w.u8(0x15)                        // streamType: AudioStream(5) << 2 | upStream(0) << 1 | reserved(1)
+	w.u24(0)                          // bufferSizeDB
+	w.u32(0)                          // maxBitrate
+	w.u32(0)                          // avgBitrate
+
+	w.descriptor(0x05, decSpecificInfoSize) // DecSpecificInfoTag
+	w.bytes(audio.Asc)
+
+	w.descriptor(0x06, slConfigSize) // SLConfigDescrTag
+	w.u8(0x02)                       // predefined: reserved for use in MP4 files
+	w.endBox(esds)
+}
+
+func writeTrex(w *boxWriter, trackId uint32) {
+	trex := w.startFullBox("trex", 0, 0)
+	w.u32(trackId)
+	w.u32(1) // default_sample_description_index
+	w.u32(0) // default_sample_duration
+	w.u32(0) // default_sample_size
+	w.u32(0) // default_sample_flags
+	w.endBox(trex)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
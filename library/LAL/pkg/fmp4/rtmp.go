@@ -0,0 +1,106 @@
+package fmp4
+
+import (
+	"live-server/library/LAL/pkg/avc"
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/hevc"
+	"live-server/library/naza/pkg/bele"
+)
+
+// rtmp.go
+//
+// rtmp消息转换为fmp4的track信息和 base.AvPacket，方便直接使用rtmp流生成fmp4
+//
+
+// NewVideoTrackFromRtmpSeqHeader
+//
+// @param msg: 视频的seq header，支持H264和H265（包括enhanced rtmp）
+func NewVideoTrackFromRtmpSeqHeader(msg base.RtmpMsg) (*VideoTrack, error) {
+	if msg.VideoCodecId() == base.RtmpCodecIdAvc {
+		sps, pps, err := avc.ParseSpsPpsFromSeqHeader(msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+		return NewAvcVideoTrack(sps, pps)
+	}
+
+	var (
+		vps, sps, pps []byte
+		err           error
+	)
+	if msg.IsEnhanced() {
+		vps, sps, pps, err = hevc.ParseVpsSpsPpsFromEnhancedSeqHeader(msg.Payload)
+	} else {
+		vps, sps, pps, err = hevc.ParseVpsSpsPpsFromSeqHeader(msg.Payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewHevcVideoTrack(vps, sps, pps)
+}
+
+// NewAudioTrackFromRtmpSeqHeader
+//
+// @param msg: aac的seq header
+func NewAudioTrackFromRtmpSeqHeader(msg base.RtmpMsg) (*AudioTrack, error) {
+	if len(msg.Payload) <= 2 {
+		return nil, base.ErrShortBuffer
+	}
+	return NewAudioTrack(msg.Payload[2:])
+}
+
+// RtmpMsg2AvPacket 将rtmp的音视频帧（非seq header）转换为 Muxer 所需的 base.AvPacket
+//
+// 返回的 base.AvPacket 的 Payload 是新申请的内存
+//
+// @return ok: 不是视频帧或aac音频帧时返回false
+func RtmpMsg2AvPacket(msg base.RtmpMsg) (pkt base.AvPacket, ok bool) {
+	switch msg.Header.MsgTypeId {
+	case base.RtmpTypeIdVideo:
+		if len(msg.Payload) <= 5 {
+			return
+		}
+		pkt.Timestamp = int64(msg.Dts())
+		if msg.IsEnhanced() {
+			if !msg.IsEnchanedHevcNalu() {
+				return
+			}
+			index := msg.GetEnchanedHevcNaluIndex()
+			if len(msg.Payload) <= index {
+				return
+			}
+			pkt.PayloadType = base.AvPacketPtHevc
+			pkt.Pts = pkt.Timestamp
+			if msg.Payload[0]&0x0f == base.RtmpExPacketTypeCodedFrames {
+				pkt.Pts += int64(bele.BeUint24(msg.Payload[5:]))
+			}
+			pkt.Payload = append([]byte(nil), msg.Payload[index:]...)
+			return pkt, true
+		}
+
+		if msg.Payload[1] != base.RtmpAvcPacketTypeNalu {
+			return
+		}
+		switch msg.VideoCodecId() {
+		case base.RtmpCodecIdAvc:
+			pkt.PayloadType = base.AvPacketPtAvc
+		case base.RtmpCodecIdHevc:
+			pkt.PayloadType = base.AvPacketPtHevc
+		default:
+			return
+		}
+		pkt.Pts = int64(msg.Pts())
+		pkt.Payload = append([]byte(nil), msg.Payload[5:]...)
+		return pkt, true
+	case base.RtmpTypeIdAudio:
+		if len(msg.Payload) <= 2 || msg.AudioCodecId() != base.RtmpSoundFormatAac || msg.IsAacSeqHeader() {
+			return
+		}
+		pkt.PayloadType = base.AvPacketPtAac
+		pkt.Timestamp = int64(msg.Dts())
+		pkt.Pts = pkt.Timestamp
+		pkt.Payload = append([]byte(nil), msg.Payload[2:]...)
+		return pkt, true
+	}
+	return
+}
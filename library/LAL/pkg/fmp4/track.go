@@ -0,0 +1,86 @@
+package fmp4
+
+import (
+	"live-server/library/LAL/pkg/aac"
+	"live-server/library/LAL/pkg/avc"
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/hevc"
+)
+
+const (
+	VideoTimescale = 90000
+
+	// aacSamplesPerFrame 每个aac帧包含的采样数，也即aac帧在mp4中的时长
+	aacSamplesPerFrame = 1024
+)
+
+// VideoTrack 视频轨道的初始化信息，用于生成init segment
+type VideoTrack struct {
+	PayloadType base.AvPacketPt // base.AvPacketPtAvc 或 base.AvPacketPtHevc
+	Width       int
+	Height      int
+
+	// Record avcC或hvcC box的内容，也即 AVCDecoderConfigurationRecord 或 HEVCDecoderConfigurationRecord
+	Record []byte
+}
+
+// AudioTrack 音频轨道的初始化信息，目前只支持aac
+type AudioTrack struct {
+	Asc          []byte
+	SampleRate   int // 同时也是音频轨道的timescale
+	ChannelCount int
+}
+
+func NewAvcVideoTrack(sps, pps []byte) (*VideoTrack, error) {
+	sh, err := avc.BuildSeqHeaderFromSpsPps(sps, pps)
+	if err != nil {
+		return nil, err
+	}
+	var ctx avc.Context
+	if err = avc.ParseSps(sps, &ctx); err != nil {
+		return nil, err
+	}
+	return &VideoTrack{
+		PayloadType: base.AvPacketPtAvc,
+		Width:       int(ctx.Width),
+		Height:      int(ctx.Height),
+		Record:      sh[5:], // 去掉rtmp的5字节头
+	}, nil
+}
+
+func NewHevcVideoTrack(vps, sps, pps []byte) (*VideoTrack, error) {
+	sh, err := hevc.BuildSeqHeaderFromVpsSpsPps(vps, sps, pps)
+	if err != nil {
+		return nil, err
+	}
+	var ctx hevc.Context
+	if err = hevc.ParseSps(sps, &ctx); err != nil {
+		return nil, err
+	}
+	return &VideoTrack{
+		PayloadType: base.AvPacketPtHevc,
+		Width:       int(ctx.PicWidthInLumaSamples),
+		Height:      int(ctx.PicHeightInLumaSamples),
+		Record:      sh[5:],
+	}, nil
+}
+
+func NewAudioTrack(asc []byte) (*AudioTrack, error) {
+	ascCtx, err := aac.NewAscContext(asc)
+	if err != nil {
+		return nil, err
+	}
+	sampleRate, err := ascCtx.GetSamplingFrequency()
+	if err != nil {
+		return nil, err
+	}
+	channelCount := int(ascCtx.ChannelConfiguration)
+	if channelCount == 0 {
+		channelCount = 2
+	}
+	return &AudioTrack{
+		Asc:          append([]byte(nil), asc...),
+		SampleRate:   sampleRate,
+		ChannelCount: channelCount,
+	}, nil
+}
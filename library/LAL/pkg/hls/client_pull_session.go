@@ -0,0 +1,528 @@
+package hls
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/remux"
+	"live-server/library/LAL/pkg/rtmp"
+
+	"live-server/library/naza/pkg/nazaatomic"
+)
+
+// client_pull_session.go
+//
+// hls拉流：
+// - 周期性拉取m3u8，下载新出现的ts，使用 remux.Mpegts2RtmpRemuxer 解析后以rtmp消息的形式回调给上层
+// - 如果是master playlist，使用其中的第一个子m3u8
+// - 开始拉流时，从倒数第 PullSessionOption.StartSegmentNum 个ts开始，如果m3u8中有`#EXT-X-ENDLIST`，则从第一个ts开始
+// - ts是整个下载的，为了避免瞬间回调大量数据，按照时间戳的间隔匀速回调
+// - `#EXT-X-DISCONTINUITY`时重新创建remuxer，并且保持回调的时间戳连续
+// - 只支持mpegts切片，不支持fmp4切片
+// - m3u8中有`#EXT-X-ENDLIST`时，所有ts回调完后结束拉流，此时 WaitChan 返回 io.EOF
+//
+
+type PullSessionOption struct {
+	// 从调用Pull函数，到获取到第一个可用的m3u8（如果是master playlist，则是子m3u8）的超时时间
+	// 如果为0，则没有超时时间
+	PullTimeoutMs int
+
+	ReadTimeoutMs int // 单个m3u8或ts的http请求的超时时间，单位毫秒，如果为0，则不设置超时
+
+	StartSegmentNum int // 开始拉流时，从倒数第几个ts开始。太小容易卡顿，太大延迟高
+}
+
+var defaultPullSessionOption = PullSessionOption{
+	PullTimeoutMs:   10000,
+	ReadTimeoutMs:   10000,
+	StartSegmentNum: 3,
+}
+
+const (
+	pullMaxPlaylistFailCount = 3  // 连续拉取m3u8失败的次数达到该值时，结束拉流
+	pullDiscontinuityGapMs   = 40 // `#EXT-X-DISCONTINUITY`前后两段的时间戳间隔
+)
+
+// PullSession 使用http拉取hls流，解析后以rtmp消息的形式回调给上层
+type PullSession struct {
+	option PullSessionOption // const after ctor
+
+	sessionStat base.BasicSessionStat
+	urlCtx      base.UrlContext
+	client      *http.Client
+
+	onPullSucc      func()
+	onReadRtmpAvMsg rtmp.OnReadRtmpAvMsg
+
+	ctx         context.Context
+	cancel      context.CancelFunc
+	waitChan    chan error
+	disposeOnce sync.Once
+
+	// 见 IsAlive
+	lastActiveUnixMs nazaatomic.Int64 // 最后一次读取到数据或者回调数据的时间
+	activeTimeoutMs  nazaatomic.Int64
+
+	// 以下只在拉流协程中访问
+	playlistUrl string // 实际拉取的m3u8地址，如果是master playlist，则为选中的子m3u8
+	hasSeq      bool
+	lastSeq     int64 // 最后一个处理过的ts的序号
+	remuxer     *remux.Mpegts2RtmpRemuxer
+	pendingMsgs []base.RtmpMsg // ts解析出来，还没有回调的消息
+
+	// 时间戳重新计算以及匀速回调
+	hasFirstTs bool
+	firstTs    int64 // 当前段（两个`#EXT-X-DISCONTINUITY`之间）第一个音视频消息的原始时间戳
+	baseTs     int64 // 当前段的起始时间戳
+	hasSent    bool
+	prevTs     int64 // 上一个回调的消息的时间戳
+	startTs    int64 // 第一个回调的消息的时间戳
+	startTime  time.Time
+}
+
+type ModPullSessionOption func(option *PullSessionOption)
+
+func NewPullSession(modOptions ...ModPullSessionOption) *PullSession {
+	option := defaultPullSessionOption
+	for _, fn := range modOptions {
+		fn(&option)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: true,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &PullSession{
+		option:      option,
+		sessionStat: base.NewBasicSessionStat(base.SessionTypeHlsPull, ""),
+		client:      &http.Client{Transport: transport},
+		ctx:         ctx,
+		cancel:      cancel,
+		waitChan:    make(chan error, 1),
+	}
+	Log.Infof("[%s] lifecycle new hls PullSession. session=%p", s.UniqueKey(), s)
+	return s
+}
+
+// WithOnPullSucc Pull成功
+//
+// 如果你想保证绝对时序，在回调音视频数据前，做一些操作，那么使用这个回调替代 Pull 返回成功
+func (session *PullSession) WithOnPullSucc(onPullSucc func()) *PullSession {
+	session.onPullSucc = onPullSucc
+	return session
+}
+
+// Pull 阻塞直到获取到第一个可用的m3u8，或者发生错误。
+//
+// @param rawUrl 支持如下两种格式（当然，关键点是对端支持）：
+//  1. `http://{domain}/{app_name}/{stream_name}.m3u8`
+//  2. `http://{ip}/{domain}/{app_name}/{stream_name}.m3u8`
+//
+// @param onReadRtmpAvMsg 解析ts得到的rtmp消息，包含metadata、音视频seq header以及音视频数据。
+//
+//	回调结束后，PullSession 不会再使用这块 <msg> 数据。
+func (session *PullSession) Pull(rawUrl string, onReadRtmpAvMsg rtmp.OnReadRtmpAvMsg) error {
+	Log.Debugf("[%s] pull. url=%s", session.UniqueKey(), rawUrl)
+
+	var err error
+	session.urlCtx, err = base.ParseHlsUrl(rawUrl)
+	if err != nil {
+		_ = session.dispose(err)
+		return err
+	}
+	session.sessionStat.SetRemoteAddr(session.urlCtx.HostWithPort)
+	session.onReadRtmpAvMsg = onReadRtmpAvMsg
+	session.remuxer = session.newRemuxer()
+
+	ctx := session.ctx
+	if session.option.PullTimeoutMs != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(session.ctx, time.Duration(session.option.PullTimeoutMs)*time.Millisecond)
+		defer cancel()
+	}
+	playlist, err := session.fetchFirstPlaylist(ctx)
+	if err != nil {
+		_ = session.dispose(err)
+		return err
+	}
+
+	if session.onPullSucc != nil {
+		session.onPullSucc()
+	}
+
+	go session.runLoop(playlist)
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+// IClientSessionLifecycle interface
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Dispose 文档请参考： IClientSessionLifecycle interface
+func (session *PullSession) Dispose() error {
+	return session.dispose(nil)
+}
+
+// WaitChan 文档请参考： IClientSessionLifecycle interface
+func (session *PullSession) WaitChan() <-chan error {
+	return session.waitChan
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Url 文档请参考： interface ISessionUrlContext
+func (session *PullSession) Url() string {
+	return session.urlCtx.Url
+}
+
+// AppName 文档请参考： interface ISessionUrlContext
+func (session *PullSession) AppName() string {
+	return session.urlCtx.PathWithoutLastItem
+}
+
+// StreamName 文档请参考： interface ISessionUrlContext
+func (session *PullSession) StreamName() string {
+	return strings.TrimSuffix(session.urlCtx.LastItemOfPath, ".m3u8")
+}
+
+// RawQuery 文档请参考： interface ISessionUrlContext
+func (session *PullSession) RawQuery() string {
+	return session.urlCtx.RawQuery
+}
+
+// UniqueKey 文档请参考： interface IObject
+func (session *PullSession) UniqueKey() string {
+	return session.sessionStat.UniqueKey()
+}
+
+// ----- ISessionStat --------------------------------------------------------------------------------------------------
+
+// UpdateStat 文档请参考： interface ISessionStat
+func (session *PullSession) UpdateStat(intervalSec uint32) {
+	session.sessionStat.UpdateStat(intervalSec)
+}
+
+// GetStat 文档请参考： interface ISessionStat
+func (session *PullSession) GetStat() base.StatSession {
+	return session.sessionStat.GetStat()
+}
+
+// IsAlive 文档请参考： interface ISessionStat
+//
+// 注意，ts下载完后是按时间戳匀速回调的，没有新ts时也会等待一段时间再拉取m3u8，这些时间内都不会读取数据，
+// 所以最后一次读取或回调数据后的 2*target duration 内都认为是活跃的，避免ts时长大于检查间隔时被误判为不活跃
+func (session *PullSession) IsAlive() (readAlive, writeAlive bool) {
+	readAlive, writeAlive = session.sessionStat.IsAlive()
+	if !readAlive {
+		inactiveMs := time.Now().UnixNano()/1e6 - session.lastActiveUnixMs.Load()
+		readAlive = inactiveMs < session.activeTimeoutMs.Load()
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// fetchFirstPlaylist 获取第一个m3u8，如果是master playlist，则继续获取第一个子m3u8
+func (session *PullSession) fetchFirstPlaylist(ctx context.Context) (playlist M3u8Playlist, err error) {
+	session.playlistUrl = session.urlCtx.Url
+	if playlist, err = session.fetchPlaylist(ctx); err != nil {
+		return
+	}
+
+	if playlist.IsMaster() {
+		if session.playlistUrl, err = resolveUri(session.playlistUrl, playlist.Variants[0]); err != nil {
+			return
+		}
+		Log.Infof("[%s] master playlist, use the first variant. variants=%v", session.UniqueKey(), playlist.Variants)
+		if playlist, err = session.fetchPlaylist(ctx); err != nil {
+			return
+		}
+		if playlist.IsMaster() {
+			return playlist, fmt.Errorf("%w. nested master playlist. url=%s", base.ErrHlsInvalidPlaylist, session.playlistUrl)
+		}
+	}
+
+	if playlist.HasMap {
+		return playlist, fmt.Errorf("%w. url=%s", base.ErrHlsPullFmp4NotSupported, session.playlistUrl)
+	}
+	return
+}
+
+func (session *PullSession) fetchPlaylist(ctx context.Context) (M3u8Playlist, error) {
+	content, err := session.httpGet(ctx, session.playlistUrl)
+	if err != nil {
+		return M3u8Playlist{}, err
+	}
+	playlist, err := ParseM3u8Playlist(content)
+	if err == nil {
+		session.activeTimeoutMs.Store(int64(playlist.TargetDurationSec * 2 * 1000))
+	}
+	return playlist, err
+}
+
+func (session *PullSession) runLoop(playlist M3u8Playlist) {
+	var err error
+	defer func() {
+		_ = session.dispose(err)
+	}()
+
+	failCount := 0
+	for {
+		var n int
+		if n, err = session.pumpSegments(playlist); err != nil {
+			return
+		}
+
+		if playlist.EndList {
+			session.remuxer.Flush()
+			if err = session.feedPendingMsgs(); err == nil {
+				err = io.EOF
+			}
+			return
+		}
+
+		// 没有新的ts时，等待半个target duration再重新拉取m3u8
+		if n == 0 {
+			if err = session.sleep(reloadInterval(playlist)); err != nil {
+				return
+			}
+		}
+
+		newPlaylist, fetchErr := session.fetchPlaylist(session.ctx)
+		if fetchErr != nil {
+			if err = session.ctx.Err(); err != nil {
+				return
+			}
+			failCount++
+			Log.Warnf("[%s] fetch playlist failed. count=%d, err=%+v", session.UniqueKey(), failCount, fetchErr)
+			if failCount >= pullMaxPlaylistFailCount {
+				err = fetchErr
+				return
+			}
+			// 继续使用上一次的m3u8，因为没有新的ts，会等待一段时间后再重试
+			continue
+		}
+		failCount = 0
+		playlist = newPlaylist
+	}
+}
+
+// pumpSegments 下载并回调m3u8中还没有处理过的ts
+//
+// @return n: 处理的ts的数量
+func (session *PullSession) pumpSegments(playlist M3u8Playlist) (n int, err error) {
+	segments := playlist.Segments
+	if session.hasSeq && len(segments) != 0 && segments[len(segments)-1].Sequence < session.lastSeq {
+		// 序号回退，比如源站重启了，重新开始
+		Log.Warnf("[%s] media sequence rollback. last=%d, curr=%d", session.UniqueKey(), session.lastSeq, segments[len(segments)-1].Sequence)
+		session.hasSeq = false
+	}
+
+	if !session.hasSeq {
+		if !playlist.EndList && len(segments) > session.option.StartSegmentNum {
+			segments = segments[len(segments)-session.option.StartSegmentNum:]
+		}
+	} else {
+		for len(segments) != 0 && segments[0].Sequence <= session.lastSeq {
+			segments = segments[1:]
+		}
+	}
+
+	for _, segment := range segments {
+		if err = session.pumpSegment(segment); err != nil {
+			return
+		}
+		session.hasSeq = true
+		session.lastSeq = segment.Sequence
+		n++
+	}
+	return
+}
+
+func (session *PullSession) pumpSegment(segment M3u8Segment) error {
+	segmentUrl, err := resolveUri(session.playlistUrl, segment.Uri)
+	if err != nil {
+		return err
+	}
+
+	content, err := session.httpGet(session.ctx, segmentUrl)
+	if err != nil {
+		if ctxErr := session.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		// 单个ts下载失败，跳过
+		Log.Warnf("[%s] fetch segment failed, skip it. url=%s, err=%+v", session.UniqueKey(), segmentUrl, err)
+		return nil
+	}
+
+	if segment.Discontinuity && session.hasSent {
+		// 编码参数可能发生了变化，时间戳也可能不连续，使用新的remuxer
+		session.remuxer.Flush()
+		if err = session.feedPendingMsgs(); err != nil {
+			return err
+		}
+		session.remuxer = session.newRemuxer()
+		session.hasFirstTs = false
+		session.baseTs = session.prevTs + pullDiscontinuityGapMs
+	}
+
+	session.remuxer.Feed(content)
+	return session.feedPendingMsgs()
+}
+
+func (session *PullSession) newRemuxer() *remux.Mpegts2RtmpRemuxer {
+	return remux.NewMpegts2RtmpRemuxer(func(msg base.RtmpMsg) {
+		session.pendingMsgs = append(session.pendingMsgs, msg)
+	})
+}
+
+func (session *PullSession) feedPendingMsgs() error {
+	defer func() {
+		session.pendingMsgs = session.pendingMsgs[:0]
+	}()
+	for _, msg := range session.pendingMsgs {
+		if err := session.feed(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// feed 重新计算时间戳，并且按照时间戳的间隔回调
+func (session *PullSession) feed(msg base.RtmpMsg) error {
+	if msg.Header.MsgTypeId == base.RtmpTypeIdMetadata {
+		msg.Header.TimestampAbs = uint32(session.prevTs)
+		session.onReadRtmpAvMsg(msg)
+		return nil
+	}
+
+	if !session.hasFirstTs {
+		session.firstTs = int64(msg.Header.TimestampAbs)
+		session.hasFirstTs = true
+	}
+	ts := session.baseTs + int64(msg.Header.TimestampAbs) - session.firstTs
+	// 时间戳回退，使用上一个消息的时间戳，不等待直接回调
+	if session.hasSent && ts < session.prevTs {
+		ts = session.prevTs
+	}
+
+	if !session.hasSent {
+		session.startTs = ts
+		session.startTime = time.Now()
+	} else {
+		wait := time.Duration(ts-session.startTs)*time.Millisecond - time.Since(session.startTime)
+		if err := session.sleep(wait); err != nil {
+			return err
+		}
+	}
+
+	msg.Header.TimestampAbs = uint32(ts)
+	session.hasSent = true
+	session.prevTs = ts
+	session.markActive()
+	session.onReadRtmpAvMsg(msg)
+	return nil
+}
+
+func (session *PullSession) httpGet(ctx context.Context, rawUrl string) ([]byte, error) {
+	if session.option.ReadTimeoutMs != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(session.option.ReadTimeoutMs)*time.Millisecond)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", base.LalHlsPullSessionUa)
+
+	resp, err := session.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w. code=%d, url=%s", base.ErrHlsPullHttpStatus, resp.StatusCode, rawUrl)
+	}
+
+	// 边读边统计，使得下载比较大的ts的过程中也是活跃的
+	return io.ReadAll(&statReader{r: resp.Body, session: session})
+}
+
+func (session *PullSession) markActive() {
+	session.lastActiveUnixMs.Store(time.Now().UnixNano() / 1e6)
+}
+
+func (session *PullSession) sleep(d time.Duration) error {
+	if d <= 0 {
+		return session.ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-session.ctx.Done():
+		return session.ctx.Err()
+	}
+}
+
+func (session *PullSession) dispose(err error) error {
+	session.disposeOnce.Do(func() {
+		Log.Infof("[%s] lifecycle dispose hls PullSession. err=%+v", session.UniqueKey(), err)
+		session.cancel()
+		session.waitChan <- err
+	})
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// reloadInterval 没有新的ts时，重新拉取m3u8的间隔
+func reloadInterval(playlist M3u8Playlist) time.Duration {
+	d := time.Duration(playlist.TargetDurationSec * float64(time.Second) / 2)
+	if d < 100*time.Millisecond {
+		d = 100 * time.Millisecond
+	}
+	return d
+}
+
+// statReader 统计读取的字节数
+type statReader struct {
+	r       io.Reader
+	session *PullSession
+}
+
+func (sr *statReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	if n > 0 {
+		sr.session.sessionStat.AddReadBytes(n)
+		sr.session.markActive()
+	}
+	return n, err
+}
+
+// resolveUri 将m3u8中的相对地址转换为绝对地址
+func resolveUri(playlistUrl string, uri string) (string, error) {
+	u, err := url.Parse(playlistUrl)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	return u.ResolveReference(ref).String(), nil
+}
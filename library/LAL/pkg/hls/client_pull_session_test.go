@@ -0,0 +1,195 @@
+package hls_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/hls"
+	"live-server/library/LAL/pkg/mpegts"
+
+	"live-server/library/naza/pkg/assert"
+)
+
+// makeSegmentTs 生成包含n个视频帧的mpegts切片，帧间隔40毫秒，第一帧为关键帧，第一帧的序号为start
+func makeSegmentTs(start int, n int) []byte {
+	sps, _ := hex.DecodeString("67640032ad84010c20086100430802184010c200843b5014005ad370101014000003000400000300ca100002")
+	pps, _ := hex.DecodeString("68ee3cb0")
+
+	var ts []byte
+	ts = append(ts, mpegts.FixedFragmentHeader...)
+	var cc uint8
+	for i := 0; i < n; i++ {
+		var raw []byte
+		if i == 0 {
+			raw = append(raw, 0, 0, 0, 1)
+			raw = append(raw, sps...)
+			raw = append(raw, 0, 0, 0, 1)
+			raw = append(raw, pps...)
+			raw = append(raw, 0, 0, 0, 1, 0x65)
+		} else {
+			raw = append(raw, 0, 0, 0, 1, 0x41)
+		}
+		raw = append(raw, bytes.Repeat([]byte{0xaa}, 300)...)
+		frame := mpegts.Frame{
+			Pts: uint64(start+i) * 3600,
+			Dts: uint64(start+i) * 3600,
+			Cc:  cc,
+			Pid: mpegts.PidVideo,
+			Sid: mpegts.StreamIdVideo,
+			Key: i == 0,
+			Raw: raw,
+		}
+		ts = append(ts, frame.Pack()...)
+		cc = frame.Cc
+	}
+	return ts
+}
+
+// newHlsServer 提供master playlist `/live/test110.m3u8`，子m3u8 `/live/sub/test110.m3u8`，以及segmentNum个ts，每个ts包含2帧
+func newHlsServer(segmentNum int, endList bool) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live/test110.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\nsub/test110.m3u8\n"))
+	})
+	mux.HandleFunc("/live/sub/test110.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		buf.WriteString("#EXTM3U\r\n#EXT-X-VERSION:3\r\n#EXT-X-TARGETDURATION:1\r\n#EXT-X-MEDIA-SEQUENCE:10\r\n")
+		for i := 0; i < segmentNum; i++ {
+			buf.WriteString(fmt.Sprintf("#EXTINF:0.080,\r\ntest110-%d.ts\r\n", i))
+		}
+		if endList {
+			buf.WriteString("#EXT-X-ENDLIST\r\n")
+		}
+		_, _ = w.Write(buf.Bytes())
+	})
+	for i := 0; i < segmentNum; i++ {
+		ts := makeSegmentTs(i*2, 2)
+		mux.HandleFunc(fmt.Sprintf("/live/sub/test110-%d.ts", i), func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(ts)
+		})
+	}
+	return httptest.NewServer(mux)
+}
+
+func TestPullSession(t *testing.T) {
+	// 有`#EXT-X-ENDLIST`，从第一个ts开始，全部回调完后结束
+	server := newHlsServer(3, true)
+	defer server.Close()
+
+	var mutex sync.Mutex
+	var videoTs []uint32
+	var hasSeqHeader bool
+	onMsg := func(msg base.RtmpMsg) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if msg.IsAvcKeySeqHeader() {
+			hasSeqHeader = true
+		} else if msg.Header.MsgTypeId == base.RtmpTypeIdVideo {
+			videoTs = append(videoTs, msg.Header.TimestampAbs)
+		}
+	}
+
+	var pullSucc bool
+	session := hls.NewPullSession().WithOnPullSucc(func() {
+		pullSucc = true
+	})
+	b := time.Now()
+	err := session.Pull(server.URL+"/live/test110.m3u8", onMsg)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, pullSucc)
+	assert.Equal(t, "live", session.AppName())
+	assert.Equal(t, "test110", session.StreamName())
+	err = <-session.WaitChan()
+	assert.Equal(t, io.EOF, err)
+	elapsed := time.Since(b)
+
+	mutex.Lock()
+	assert.Equal(t, true, hasSeqHeader)
+	assert.Equal(t, 6, len(videoTs))
+	for i := 1; i < len(videoTs); i++ {
+		assert.Equal(t, uint32(40), videoTs[i]-videoTs[i-1])
+	}
+	mutex.Unlock()
+	// 按时间戳匀速回调
+	assert.Equal(t, true, elapsed >= 180*time.Millisecond, elapsed.String())
+
+	stat := session.GetStat()
+	assert.Equal(t, base.SessionProtocolHlsStr, stat.Protocol)
+	assert.Equal(t, base.SessionBaseTypePullStr, stat.BaseType)
+	assert.Equal(t, true, strings.HasPrefix(stat.SessionId, base.UkPreHlsPullSession))
+	assert.Equal(t, true, stat.ReadBytesSum > 0)
+}
+
+func TestPullSession_Live(t *testing.T) {
+	// 没有`#EXT-X-ENDLIST`，从倒数第2个ts开始
+	server := newHlsServer(5, false)
+	defer server.Close()
+
+	var mutex sync.Mutex
+	var videoTs []uint32
+	session := hls.NewPullSession(func(option *hls.PullSessionOption) {
+		option.StartSegmentNum = 2
+	})
+	err := session.Pull(server.URL+"/live/sub/test110.m3u8", func(msg base.RtmpMsg) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if msg.Header.MsgTypeId == base.RtmpTypeIdVideo && !msg.IsAvcKeySeqHeader() {
+			videoTs = append(videoTs, msg.Header.TimestampAbs)
+		}
+	})
+	assert.Equal(t, nil, err)
+
+	for i := 0; i < 100; i++ {
+		mutex.Lock()
+		num := len(videoTs)
+		mutex.Unlock()
+		if num >= 4 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 两次检查之间即使没有读取数据，刚回调过数据也认为是活跃的
+	session.IsAlive()
+	readAlive, _ := session.IsAlive()
+	assert.Equal(t, true, readAlive)
+
+	// 等待一次m3u8的重新拉取，不应该重复回调
+	time.Sleep(600 * time.Millisecond)
+	_ = session.Dispose()
+	err = <-session.WaitChan()
+	assert.Equal(t, nil, err)
+
+	mutex.Lock()
+	assert.Equal(t, 4, len(videoTs))
+	mutex.Unlock()
+}
+
+func TestPullSession_Fail(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live/fmp4.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:1.000,\n0.m4s\n"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	onMsg := func(msg base.RtmpMsg) {}
+
+	err := hls.NewPullSession().Pull(server.URL+"/live/fmp4.m3u8", onMsg)
+	assert.Equal(t, true, errors.Is(err, base.ErrHlsPullFmp4NotSupported))
+
+	err = hls.NewPullSession().Pull(server.URL+"/live/notfound.m3u8", onMsg)
+	assert.Equal(t, true, errors.Is(err, base.ErrHlsPullHttpStatus))
+
+	err = hls.NewPullSession().Pull(server.URL+"/live/test110.flv", onMsg)
+	assert.Equal(t, true, errors.Is(err, base.ErrInvalidUrl))
+}
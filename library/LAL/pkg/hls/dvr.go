@@ -0,0 +1,289 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+)
+
+// dvr.go
+//
+// 时移回看（DVR）
+//
+// 录制的m3u8（record.m3u8）中，每个切片都通过`#EXT-X-PROGRAM-DATE-TIME`记录了切片开始时的墙上时间，
+// 播放端请求直播的m3u8时，如果携带了以下参数，则根据时间从录制的m3u8中截取对应的切片，生成新的m3u8：
+//
+//	?start=<unix>&end=<unix>  [start, end)范围内的切片，点播类型，带`#EXT-X-ENDLIST`
+//	?start=<unix>             从start开始的所有切片，event类型，随着录制不断增长
+//	?offset=-300              相对当前时间回退300秒的直播列表，滑动窗口
+//
+// 注意，只有录制m3u8的模式（CleanupModeNever 或 CleanupModeInTheEnd）才支持时移回看
+//
+
+const programDateTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// dvrLiveFragmentNum 使用offset参数时，返回的滑动窗口中切片的数量
+const dvrLiveFragmentNum = 6
+
+type recordFragment struct {
+	discont         bool
+	initFilename    string    // fmp4模式时，该切片使用的init segment
+	programDateTime time.Time // 切片开始时的墙上时间，为零值时表示没有该字段（比如旧版本生成的录制m3u8）
+	duration        float64
+	filename        string
+}
+
+func (f *recordFragment) endTime() time.Time {
+	return f.programDateTime.Add(time.Duration(f.duration * float64(time.Second)))
+}
+
+// recordPlaylist 录制的m3u8
+type recordPlaylist struct {
+	version  int
+	mediaSeq int
+	frags    []recordFragment
+}
+
+func parseRecordPlaylist(content []byte) (recordPlaylist, error) {
+	var (
+		p               recordPlaylist
+		discont         bool
+		initFilename    string
+		programDateTime time.Time
+		duration        float64
+		hasExtinf       bool
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var err error
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#EXT-X-VERSION:"):
+			p.version, err = strconv.Atoi(line[len("#EXT-X-VERSION:"):])
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			p.mediaSeq, err = strconv.Atoi(line[len("#EXT-X-MEDIA-SEQUENCE:"):])
+		case line == "#EXT-X-DISCONTINUITY":
+			discont = true
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			initFilename = parseMapUri(line)
+		case strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
+			programDateTime, err = time.Parse(programDateTimeLayout, line[len("#EXT-X-PROGRAM-DATE-TIME:"):])
+		case strings.HasPrefix(line, "#EXTINF:"):
+			v := line[len("#EXTINF:"):]
+			if i := strings.IndexByte(v, ','); i != -1 {
+				v = v[:i]
+			}
+			duration, err = strconv.ParseFloat(v, 64)
+			hasExtinf = true
+		case strings.HasPrefix(line, "#"):
+			// 其他的标签不关心
+		default:
+			if !hasExtinf {
+				return p, fmt.Errorf("%w: uri without #EXTINF. line=%s", base.ErrHls, line)
+			}
+			p.frags = append(p.frags, recordFragment{
+				discont:         discont,
+				initFilename:    initFilename,
+				programDateTime: programDateTime,
+				duration:        duration,
+				filename:        line,
+			})
+			discont = false
+			programDateTime = time.Time{}
+			hasExtinf = false
+		}
+		if err != nil {
+			return p, fmt.Errorf("%w: invalid line. line=%s, err=%+v", base.ErrHls, line, err)
+		}
+	}
+	return p, scanner.Err()
+}
+
+// parseMapUri 解析`#EXT-X-MAP:URI="xxx"`中的URI
+func parseMapUri(line string) string {
+	l := strings.Index(line, "URI=\"")
+	if l == -1 {
+		return ""
+	}
+	l += len("URI=\"")
+	r := strings.IndexByte(line[l:], '"')
+	if r == -1 {
+		return ""
+	}
+	return line[l : l+r]
+}
+
+func (p *recordPlaylist) targetDuration() int {
+	var maxFrag float64
+	for i := range p.frags {
+		if p.frags[i].duration > maxFrag {
+			maxFrag = p.frags[i].duration
+		}
+	}
+	return int(maxFrag + 0.5)
+}
+
+// marshal 生成录制的m3u8文件内容
+func (p *recordPlaylist) marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	buf.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", p.version))
+	buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", p.targetDuration()))
+	buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n\n", p.mediaSeq))
+	writeRecordFragments(&buf, p.frags)
+	buf.WriteString("#EXT-X-ENDLIST\n")
+	return buf.Bytes()
+}
+
+func writeRecordFragments(buf *bytes.Buffer, frags []recordFragment) {
+	var lastInitFilename string
+	for i := range frags {
+		frag := &frags[i]
+		if frag.discont {
+			buf.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if frag.initFilename != "" && frag.initFilename != lastInitFilename {
+			buf.WriteString(fmt.Sprintf("#EXT-X-MAP:URI=\"%s\"\n", frag.initFilename))
+			lastInitFilename = frag.initFilename
+		}
+		writeProgramDateTime(buf, frag.programDateTime)
+		buf.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", frag.duration, frag.filename))
+	}
+}
+
+func writeProgramDateTime(buf *bytes.Buffer, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	buf.WriteString(fmt.Sprintf("#EXT-X-PROGRAM-DATE-TIME:%s\n", t.Format(programDateTimeLayout)))
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// dvrQuery 时移回看的请求参数
+type dvrQuery struct {
+	start  time.Time
+	end    time.Time     // 为零值时表示没有结束时间
+	offset time.Duration // 小于0，为0时表示使用start和end
+}
+
+// parseDvrQuery
+//
+// @return ok: 请求中没有时移回看的参数时返回false
+func parseDvrQuery(query url.Values) (q dvrQuery, ok bool, err error) {
+	startStr := query.Get("start")
+	endStr := query.Get("end")
+	offsetStr := query.Get("offset")
+	if startStr == "" && endStr == "" && offsetStr == "" {
+		return q, false, nil
+	}
+
+	if offsetStr != "" {
+		if startStr != "" || endStr != "" {
+			return q, true, fmt.Errorf("%w: offset can not be used with start or end", base.ErrHlsInvalidDvrQuery)
+		}
+		offset, err := strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil || offset >= 0 {
+			return q, true, fmt.Errorf("%w: invalid offset. offset=%s", base.ErrHlsInvalidDvrQuery, offsetStr)
+		}
+		q.offset = time.Duration(offset) * time.Second
+		return q, true, nil
+	}
+
+	if startStr == "" {
+		return q, true, fmt.Errorf("%w: end without start", base.ErrHlsInvalidDvrQuery)
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start <= 0 {
+		return q, true, fmt.Errorf("%w: invalid start. start=%s", base.ErrHlsInvalidDvrQuery, startStr)
+	}
+	q.start = time.Unix(start, 0)
+	if endStr != "" {
+		end, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end <= start {
+			return q, true, fmt.Errorf("%w: invalid end. start=%s, end=%s", base.ErrHlsInvalidDvrQuery, startStr, endStr)
+		}
+		q.end = time.Unix(end, 0)
+	}
+	return q, true, nil
+}
+
+// makeDvrPlaylist 根据时移回看的请求参数，从录制的m3u8中截取切片生成新的m3u8
+//
+// @return 没有符合条件的切片时返回 base.ErrHlsNoFragment
+func makeDvrPlaylist(p recordPlaylist, q dvrQuery, now time.Time) ([]byte, error) {
+	var first, last int // 截取的切片的范围，[first, last)
+	if q.offset != 0 {
+		// 滑动窗口，取结束时间不晚于目标时间点的最后几个切片
+		target := now.Add(q.offset)
+		last = 0
+		for i := range p.frags {
+			f := &p.frags[i]
+			if f.programDateTime.IsZero() {
+				continue
+			}
+			if f.endTime().After(target) {
+				break
+			}
+			last = i + 1
+		}
+		first = last - dvrLiveFragmentNum
+		if first < 0 {
+			first = 0
+		}
+	} else {
+		first = len(p.frags)
+		for i := range p.frags {
+			if f := &p.frags[i]; !f.programDateTime.IsZero() && f.endTime().After(q.start) {
+				first = i
+				break
+			}
+		}
+		last = len(p.frags)
+		if !q.end.IsZero() {
+			for i := first; i < len(p.frags); i++ {
+				if !p.frags[i].programDateTime.Before(q.end) {
+					last = i
+					break
+				}
+			}
+		}
+	}
+	if first >= last {
+		return nil, base.ErrHlsNoFragment
+	}
+
+	frags := p.frags[first:last]
+	sub := recordPlaylist{frags: frags}
+
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	buf.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", p.version))
+	buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", sub.targetDuration()))
+	buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", p.mediaSeq+first))
+	switch {
+	case q.offset != 0:
+		// noop
+	case q.end.IsZero():
+		buf.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	default:
+		buf.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	}
+	buf.WriteString("\n")
+
+	// 截取后的第一个切片不一定带有`#EXT-X-DISCONTINUITY`，也无所谓，但`#EXT-X-MAP`必须有，writeRecordFragments 会保证这一点
+	writeRecordFragments(&buf, frags)
+	if !q.end.IsZero() {
+		buf.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,109 @@
+package hls_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/hls"
+	"live-server/library/naza/pkg/assert"
+	"live-server/library/naza/pkg/mock"
+)
+
+func TestDvr(t *testing.T) {
+	startTime := time.Date(2022, 1, 16, 23, 24, 25, 0, time.UTC)
+	clock := hls.Clock
+	hls.Clock = mock.NewFakeClock()
+	hls.Clock.Set(startTime)
+	defer func() {
+		hls.Clock = clock
+	}()
+
+	config := hls.MuxerConfig{
+		OutPath:            t.TempDir(),
+		FragmentDurationMs: 1000,
+		FragmentNum:        3,
+		DeleteThreshold:    3,
+		CleanupMode:        hls.CleanupModeNever,
+		SegmentType:        hls.SegmentTypeFmp4,
+		DvrRetentionSec:    10,
+	}
+	m := hls.NewMuxer("dvrtest", &config, nil)
+	m.Start()
+
+	// 20个1秒的切片，每秒一个关键帧，墙上时间和时间戳同步增长
+	m.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdVideo, 0, goldenAvcSeqHeader))
+	for i := uint32(0); i <= 500; i++ {
+		ts := i * 40
+		if i%25 == 0 {
+			m.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdVideo, ts, []byte{0x17, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88}))
+		} else {
+			m.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdVideo, ts, []byte{0x27, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9a}))
+		}
+		hls.Clock.Add(40 * time.Millisecond)
+	}
+
+	sh := hls.NewServerHandler(config.OutPath, "/hls/", "", 0, nil)
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		sh.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://127.0.0.1:8080/hls/dvrtest/playlist.m3u8"+query, nil))
+		return w
+	}
+	unix := func(sec int) int64 {
+		return startTime.Unix() + int64(sec)
+	}
+
+	// 直播的m3u8带有墙上时间
+	w := get("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 3, strings.Count(w.Body.String(), "#EXT-X-PROGRAM-DATE-TIME:"))
+
+	// 超出保存时长的切片被清理
+	record, err := hls.ReadFile(filepath.Join(m.OutPath(), "record.m3u8"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(record), "#EXT-X-MEDIA-SEQUENCE:10\n"))
+	assert.Equal(t, true, strings.Contains(string(record), "#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:35.000Z\n"))
+	assert.Equal(t, 10, strings.Count(string(record), "#EXTINF:"))
+	assert.Equal(t, 1, strings.Count(string(record), "#EXT-X-MAP:"))
+
+	// 点播
+	w = get(fmt.Sprintf("?start=%d&end=%d", unix(12), unix(15)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Equal(t, true, strings.Contains(body, "#EXT-X-MEDIA-SEQUENCE:12\n"))
+	assert.Equal(t, true, strings.Contains(body, "#EXT-X-PLAYLIST-TYPE:VOD\n"))
+	assert.Equal(t, true, strings.HasSuffix(body, "#EXT-X-ENDLIST\n"))
+	assert.Equal(t, 1, strings.Count(body, "#EXT-X-MAP:"))
+	assert.Equal(t, 3, strings.Count(body, "#EXTINF:"))
+
+	// event
+	w = get(fmt.Sprintf("?start=%d", unix(16)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body = w.Body.String()
+	assert.Equal(t, true, strings.Contains(body, "#EXT-X-PLAYLIST-TYPE:EVENT\n"))
+	assert.Equal(t, false, strings.Contains(body, "#EXT-X-ENDLIST"))
+	assert.Equal(t, 4, strings.Count(body, "#EXTINF:"))
+
+	// 相对当前时间回退
+	w = get("?offset=-5")
+	assert.Equal(t, http.StatusOK, w.Code)
+	body = w.Body.String()
+	assert.Equal(t, true, strings.Contains(body, "#EXT-X-MEDIA-SEQUENCE:10\n"))
+	assert.Equal(t, false, strings.Contains(body, "#EXT-X-ENDLIST"))
+	assert.Equal(t, 5, strings.Count(body, "#EXTINF:"))
+
+	// 参数错误，或者没有对应的切片
+	assert.Equal(t, http.StatusBadRequest, get("?offset=5").Code)
+	assert.Equal(t, http.StatusBadRequest, get(fmt.Sprintf("?start=%d&offset=-5", unix(12))).Code)
+	assert.Equal(t, http.StatusBadRequest, get(fmt.Sprintf("?start=%d&end=%d", unix(12), unix(10))).Code)
+	assert.Equal(t, http.StatusBadRequest, get(fmt.Sprintf("?end=%d", unix(12))).Code)
+	assert.Equal(t, http.StatusNotFound, get(fmt.Sprintf("?start=%d", unix(100))).Code)
+	assert.Equal(t, http.StatusNotFound, get(fmt.Sprintf("?start=%d&end=%d", unix(-100), unix(5))).Code)
+
+	m.Dispose()
+}
@@ -41,6 +41,11 @@ func RemoveAll(path string) error {
 	return fslCtx.RemoveAll(path)
 }
 
+// FileSystemLayer hls切片文件使用的文件系统，磁盘或者内存，见 SetUseMemoryAsDiskFlag
+func FileSystemLayer() filesystemlayer.IFileSystemLayer {
+	return fslCtx
+}
+
 func init() {
 	fslCtx = filesystemlayer.FslFactory(filesystemlayer.FslTypeDisk)
 }
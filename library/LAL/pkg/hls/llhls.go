@@ -0,0 +1,140 @@
+package hls
+
+import (
+	"sync"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+)
+
+// llhls.go
+//
+// LL-HLS的blocking playlist reload以及preload hint需要在 ServerHandler 中等待 Muxer 生成新的数据，
+// Muxer 每次更新playlist后将状态同步到 llhlsHub 中，ServerHandler 在 llhlsHub 中等待状态满足请求的条件。
+//
+// 注意，Muxer 和 ServerHandler 通过 Muxer 的输出路径关联，也即 IPathWriteStrategy.GetMuxerOutPath 的结果，
+// 需要和 IPathRequestStrategy.GetRequestInfo 得到的文件所在的目录一致。
+//
+
+var llhlsHub = &llhlsStateHub{
+	states: make(map[string]*llhlsState),
+}
+
+type llhlsState struct {
+	playlistFilename string
+	lastMsn          int // 最后一个完整的切片的序号，-1表示还没有
+	openMsn          int // 正在生成的切片的序号，-1表示没有
+	partNum          int // 正在生成的切片中，已经完成的partial segment的数量
+
+	holdTimeout time.Duration // blocking reload最长的等待时间
+
+	updated chan struct{} // 状态更新时关闭，并替换为新的chan
+}
+
+type llhlsStateHub struct {
+	mutex  sync.Mutex
+	states map[string]*llhlsState // key: Muxer 的输出路径
+}
+
+// ready 请求的切片是否已经出现在playlist中
+//
+// @param part: 小于0表示请求中没有`_HLS_part`参数
+func (s *llhlsState) ready(msn, part int) bool {
+	if msn <= s.lastMsn {
+		return true
+	}
+	return part >= 0 && msn == s.openMsn && part < s.partNum
+}
+
+func (h *llhlsStateHub) update(outPath string, state llhlsState) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if old, ok := h.states[outPath]; ok {
+		close(old.updated)
+	}
+	state.updated = make(chan struct{})
+	h.states[outPath] = &state
+}
+
+func (h *llhlsStateHub) remove(outPath string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if old, ok := h.states[outPath]; ok {
+		close(old.updated)
+		delete(h.states, outPath)
+	}
+}
+
+// waitPlaylist blocking playlist reload，等待playlist中出现请求的切片
+//
+// 流不存在或者没有开启LL-HLS时，直接返回nil
+//
+// @param done: 请求结束时关闭，比如http请求的context
+func (h *llhlsStateHub) waitPlaylist(outPath, playlistFilename string, msn, part int, done <-chan struct{}) error {
+	var timer *time.Timer
+	for {
+		h.mutex.Lock()
+		state, ok := h.states[outPath]
+		if !ok || state.playlistFilename != playlistFilename {
+			h.mutex.Unlock()
+			return nil
+		}
+		if state.ready(msn, part) {
+			h.mutex.Unlock()
+			return nil
+		}
+		// 协议规定，请求的序号超过当前最后一个切片的序号加2时，应该立即返回错误
+		if msn > state.lastMsn+2 {
+			h.mutex.Unlock()
+			return base.ErrHlsBlockingReloadInvalidMsn
+		}
+		updated := state.updated
+		if timer == nil {
+			timer = time.NewTimer(state.holdTimeout)
+			defer timer.Stop()
+		}
+		h.mutex.Unlock()
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			return base.ErrHlsBlockingReloadTimeout
+		case <-done:
+			return base.ErrHlsBlockingReloadTimeout
+		}
+	}
+}
+
+// waitFile 读取文件，文件不存在时等待 Muxer 生成，用于preload hint指向的partial segment
+func (h *llhlsStateHub) waitFile(outPath, filename string, done <-chan struct{}) ([]byte, error) {
+	var timer *time.Timer
+	for {
+		content, err := ReadFile(filename)
+		if err == nil {
+			return content, nil
+		}
+
+		h.mutex.Lock()
+		state, ok := h.states[outPath]
+		if !ok {
+			h.mutex.Unlock()
+			return nil, err
+		}
+		updated := state.updated
+		if timer == nil {
+			timer = time.NewTimer(state.holdTimeout)
+			defer timer.Stop()
+		}
+		h.mutex.Unlock()
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			return nil, err
+		case <-done:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package hls_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/hls"
+	"live-server/library/naza/pkg/assert"
+)
+
+func TestLowLatencyHls(t *testing.T) {
+	config := hls.MuxerConfig{
+		OutPath:            t.TempDir(),
+		FragmentDurationMs: 1000,
+		FragmentNum:        6,
+		DeleteThreshold:    6,
+		CleanupMode:        hls.CleanupModeNever,
+		SegmentType:        hls.SegmentTypeFmp4,
+		LowLatencyEnable:   true,
+		PartDurationMs:     200,
+	}
+	m := hls.NewMuxer("llhlstest", &config, nil)
+	m.Start()
+	defer m.Dispose()
+
+	sh := hls.NewServerHandler(config.OutPath, "/hls/", "", 0, nil)
+
+	m.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdVideo, 0, goldenAvcSeqHeader))
+	m.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdAudio, 0, []byte{0xaf, 0x00, 0x12, 0x10}))
+	frameIndex := uint32(0)
+	feedUntil := func(ms uint32) {
+		for ; frameIndex*40 < ms; frameIndex++ {
+			ts := frameIndex * 40
+			if frameIndex%25 == 0 {
+				m.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdVideo, ts, []byte{0x17, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88}))
+			} else {
+				m.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdVideo, ts, []byte{0x27, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9a}))
+			}
+			m.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdAudio, ts, []byte{0xaf, 0x01, 0x21, 0x22}))
+		}
+	}
+	feedUntil(1500)
+
+	get := func(uri string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		sh.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://127.0.0.1:8080"+uri, nil))
+		return w
+	}
+
+	w := get("/hls/llhlstest/playlist.m3u8")
+	assert.Equal(t, http.StatusOK, w.Code)
+	playlist := w.Body.String()
+	assert.Equal(t, true, strings.Contains(playlist, "#EXT-X-VERSION:9\n"))
+	assert.Equal(t, true, strings.Contains(playlist, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=0.600,CAN-SKIP-UNTIL="))
+	assert.Equal(t, true, strings.Contains(playlist, "#EXT-X-PART-INF:PART-TARGET=0.200\n"))
+	assert.Equal(t, true, strings.Contains(playlist, ",INDEPENDENT=YES\n"))
+	assert.Equal(t, 1, strings.Count(playlist, "#EXTINF:"))
+	assert.Equal(t, 1, strings.Count(playlist, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI="))
+
+	// partial segment文件可以访问
+	l := strings.Index(playlist, "#EXT-X-PART:") + len("#EXT-X-PART:")
+	l += strings.Index(playlist[l:], "URI=\"") + len("URI=\"")
+	r := strings.Index(playlist[l:], "\"")
+	part, err := hls.ReadFile(filepath.Join(m.OutPath(), playlist[l:l+r]))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "moof", string(part[4:8]))
+
+	// 参数错误
+	assert.Equal(t, http.StatusBadRequest, get("/hls/llhlstest/playlist.m3u8?_HLS_part=1").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/hls/llhlstest/playlist.m3u8?_HLS_msn=10").Code)
+
+	// delta playlist
+	assert.Equal(t, http.StatusOK, get("/hls/llhlstest/playlist.m3u8?_HLS_skip=YES").Code)
+
+	// 已经存在的切片立即返回
+	assert.Equal(t, http.StatusOK, get("/hls/llhlstest/playlist.m3u8?_HLS_msn=0").Code)
+
+	// blocking playlist reload，等待第二个切片的完成
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		done <- get("/hls/llhlstest/playlist.m3u8?_HLS_msn=1")
+	}()
+	select {
+	case <-done:
+		t.Fatal("blocking reload returned before the segment is ready")
+	case <-time.After(100 * time.Millisecond):
+	}
+	feedUntil(2100)
+	select {
+	case w = <-done:
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 2, strings.Count(w.Body.String(), "#EXTINF:"))
+	case <-time.After(time.Second):
+		t.Fatal("blocking reload not returned")
+	}
+}
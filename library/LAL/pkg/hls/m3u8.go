@@ -12,6 +12,7 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"live-server/library/LAL/pkg/base"
 
@@ -82,3 +83,111 @@ func CalcM3u8Duration(content []byte) (durationSec float64, err error) {
 	}
 	return
 }
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// M3u8Playlist 解析m3u8文件得到的内容，只包含拉流需要的部分
+type M3u8Playlist struct {
+	// Variants 如果是master playlist，则为子m3u8的地址（可能是相对地址），按在文件中出现的顺序，此时其他字段都没有意义
+	Variants []string
+
+	TargetDurationSec float64
+	MediaSequence     int64
+	Segments          []M3u8Segment
+	EndList           bool // 存在`#EXT-X-ENDLIST`，说明后续不会再有新的ts
+	HasMap            bool // 存在`#EXT-X-MAP`，说明切片是fmp4格式
+}
+
+type M3u8Segment struct {
+	Uri           string // 可能是相对地址
+	DurationSec   float64
+	Sequence      int64 // 由`#EXT-X-MEDIA-SEQUENCE`加上在列表中的位置计算得到
+	Discontinuity bool  // 前面有`#EXT-X-DISCONTINUITY`
+}
+
+// IsMaster 是否是master playlist
+func (pl *M3u8Playlist) IsMaster() bool {
+	return len(pl.Variants) != 0
+}
+
+// ParseM3u8Playlist
+//
+// @param content 传入m3u8文件内容，兼容`\r\n`换行
+func ParseM3u8Playlist(content []byte) (pl M3u8Playlist, err error) {
+	lines := strings.Split(string(content), "\n")
+
+	var (
+		hasHeader     bool
+		isStreamInf   bool // 上一个tag是`#EXT-X-STREAM-INF`，下一个uri是子m3u8
+		duration      float64
+		discontinuity bool
+		segments      []M3u8Segment
+	)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !hasHeader {
+			if line != "#EXTM3U" {
+				return pl, fmt.Errorf("%w. first line=%s", base.ErrHlsInvalidPlaylist, line)
+			}
+			hasHeader = true
+			continue
+		}
+
+		if !strings.HasPrefix(line, "#") {
+			if isStreamInf {
+				pl.Variants = append(pl.Variants, line)
+				isStreamInf = false
+				continue
+			}
+			segments = append(segments, M3u8Segment{
+				Uri:           line,
+				DurationSec:   duration,
+				Discontinuity: discontinuity,
+			})
+			duration = 0
+			discontinuity = false
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			isStreamInf = true
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			if pl.TargetDurationSec, err = strconv.ParseFloat(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"), 64); err != nil {
+				return pl, fmt.Errorf("%w. line=%s", base.ErrHlsInvalidPlaylist, line)
+			}
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			if pl.MediaSequence, err = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64); err != nil {
+				return pl, fmt.Errorf("%w. line=%s", base.ErrHlsInvalidPlaylist, line)
+			}
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// e.g. `#EXTINF:4.000,` 或者 `#EXTINF:4.000,title`
+			v := strings.TrimPrefix(line, "#EXTINF:")
+			if i := strings.IndexByte(v, ','); i != -1 {
+				v = v[:i]
+			}
+			if duration, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+				return pl, fmt.Errorf("%w. line=%s", base.ErrHlsInvalidPlaylist, line)
+			}
+		case line == "#EXT-X-DISCONTINUITY":
+			discontinuity = true
+		case line == "#EXT-X-ENDLIST":
+			pl.EndList = true
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			pl.HasMap = true
+		}
+	}
+	if !hasHeader {
+		return pl, fmt.Errorf("%w. empty content", base.ErrHlsInvalidPlaylist)
+	}
+
+	// `#EXT-X-MEDIA-SEQUENCE`可能出现在ts之后，所以最后统一计算序号
+	for i := range segments {
+		segments[i].Sequence = pl.MediaSequence + int64(i)
+	}
+	pl.Segments = segments
+	return pl, nil
+}
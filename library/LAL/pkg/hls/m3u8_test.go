@@ -9,8 +9,10 @@
 package hls_test
 
 import (
+	"errors"
 	"testing"
 
+	"live-server/library/LAL/pkg/base"
 	"live-server/library/LAL/pkg/hls"
 
 	"live-server/library/naza/pkg/assert"
@@ -50,3 +52,38 @@ func TestCalcM3u8Duration(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, float64(39.2), duration)
 }
+
+func TestParseM3u8Playlist(t *testing.T) {
+	pl, err := hls.ParseM3u8Playlist([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1280000\nlow/index.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=2560000\nhigh/index.m3u8\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, pl.IsMaster())
+	assert.Equal(t, []string{"low/index.m3u8", "high/index.m3u8"}, pl.Variants)
+
+	pl, err = hls.ParseM3u8Playlist([]byte(`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:5
+#EXT-X-MEDIA-SEQUENCE:7
+
+#EXTINF:4.000,
+test110-7.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:3.333,title
+http://127.0.0.1/live/test110-8.ts
+#EXT-X-ENDLIST
+`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, pl.IsMaster())
+	assert.Equal(t, float64(5), pl.TargetDurationSec)
+	assert.Equal(t, int64(7), pl.MediaSequence)
+	assert.Equal(t, true, pl.EndList)
+	assert.Equal(t, false, pl.HasMap)
+	assert.Equal(t, []hls.M3u8Segment{
+		{Uri: "test110-7.ts", DurationSec: 4, Sequence: 7},
+		{Uri: "http://127.0.0.1/live/test110-8.ts", DurationSec: 3.333, Sequence: 8, Discontinuity: true},
+	}, pl.Segments)
+
+	_, err = hls.ParseM3u8Playlist([]byte("<html></html>"))
+	assert.Equal(t, true, errors.Is(err, base.ErrHlsInvalidPlaylist))
+	_, err = hls.ParseM3u8Playlist([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:x\n"))
+	assert.Equal(t, true, errors.Is(err, base.ErrHlsInvalidPlaylist))
+}
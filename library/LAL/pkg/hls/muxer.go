@@ -11,6 +11,7 @@ package hls
 import (
 	"bytes"
 	"fmt"
+	"time"
 
 	"live-server/library/naza/pkg/nazaerrors"
 
@@ -40,6 +41,22 @@ type MuxerConfig struct {
 	FragmentNum        int    `json:"fragment_num"`
 	DeleteThreshold    int    `json:"delete_threshold"`
 	CleanupMode        int    `json:"cleanup_mode"` // TODO chef: lalserver的模式1的逻辑是在上层做的，应该重构到hls模块中
+
+	// SegmentType 切片格式，取值见 SegmentTypeTs 和 SegmentTypeFmp4，为空时使用 SegmentTypeTs
+	//
+	// fmp4格式的数据由 Muxer.FeedRtmpMsg 输入，ts格式的数据由 Muxer.FeedMpegts 输入
+	SegmentType string `json:"segment_type"`
+
+	// LowLatencyEnable 是否开启LL-HLS（partial segment、blocking playlist reload、preload hint、delta playlist）
+	//
+	// 只在fmp4模式下生效
+	LowLatencyEnable bool `json:"low_latency_enable"`
+	PartDurationMs   int  `json:"part_duration_ms"` // LL-HLS的partial segment的目标时长
+
+	// DvrRetentionSec 时移回看的保存时长，单位秒，超出该时长的切片会从录制的m3u8中移除，并删除对应的文件
+	//
+	// 为0时表示不清理。只在录制m3u8的模式（CleanupModeNever 或 CleanupModeInTheEnd）下生效
+	DvrRetentionSec int `json:"dvr_retention_sec"`
 }
 
 const (
@@ -48,9 +65,25 @@ const (
 	CleanupModeAsap     = 2
 )
 
+const (
+	SegmentTypeTs   = "ts"
+	SegmentTypeFmp4 = "fmp4"
+)
+
+// IsFmp4 是否使用fmp4格式的切片
+func (c *MuxerConfig) IsFmp4() bool {
+	return c.SegmentType == SegmentTypeFmp4
+}
+
+// IsLowLatency 是否开启LL-HLS
+func (c *MuxerConfig) IsLowLatency() bool {
+	return c.IsFmp4() && c.LowLatencyEnable && c.PartDurationMs > 0
+}
+
 // Muxer
 //
 // 输入mpegts流，输出hls(m3u8+ts)至文件中
+// 或者输入rtmp流，输出hls(m3u8+fmp4)至文件中，见 MuxerConfig.SegmentType
 type Muxer struct {
 	UniqueKey string
 
@@ -73,14 +106,15 @@ type Muxer struct {
 	// 中途切换Fragment时，调用close后会立即调用open
 	opened bool
 
-	fragTs                uint64 // 新建立fragment时的时间戳，毫秒 * 90
-	recordMaxFragDuration float64
+	fragTs uint64 // 新建立fragment时的时间戳，毫秒 * 90
 
 	nfrags int            // 该值代表直播m3u8列表中ts文件的数量
 	frag   int            // frag 写入m3u8的EXT-X-MEDIA-SEQUENCE字段
 	frags  []fragmentInfo // frags TS文件的固定大小环形队列，记录TS的信息
 
 	patpmt []byte
+
+	fmp4Ctx *fmp4Context // 只有fmp4模式才不为nil
 }
 
 // 记录fragment的一些信息，注意，写m3u8文件时可能还需要用到历史fragment的信息
@@ -89,6 +123,15 @@ type fragmentInfo struct {
 	duration float64 // 当前fragment中数据的时长，单位秒
 	discont  bool    // #EXT-X-DISCONTINUITY
 	filename string
+
+	// startTime fragment开始时的墙上时间，用于生成`#EXT-X-PROGRAM-DATE-TIME`
+	startTime time.Time
+
+	// parts LL-HLS模式时，该fragment的partial segment列表
+	parts []partInfo
+
+	// initFilename fmp4模式时，该fragment使用的init segment文件名，写m3u8时用于生成`#EXT-X-MAP`
+	initFilename string
 }
 
 // NewMuxer
@@ -112,6 +155,11 @@ func NewMuxer(streamName string, config *MuxerConfig, observer IMuxerObserver) *
 		config:                    config,
 		observer:                  observer,
 	}
+	if config.IsFmp4() {
+		m.fmp4Ctx = &fmp4Context{
+			startMs: int(Clock.Now().UnixNano() / 1e6),
+		}
+	}
 	m.makeFrags()
 	Log.Infof("[%s] lifecycle new hls muxer. muxer=%p, streamName=%s", uk, m, streamName)
 	return m
@@ -127,6 +175,9 @@ func (m *Muxer) Dispose() {
 	if err := m.closeFragment(true); err != nil {
 		Log.Errorf("[%s] close fragment error. err=%+v", m.UniqueKey, err)
 	}
+	if m.config.IsLowLatency() {
+		llhlsHub.remove(m.outPath)
+	}
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -149,6 +200,9 @@ func (m *Muxer) FeedPatPmt(b []byte) {
 }
 
 func (m *Muxer) FeedMpegts(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
+	if m.fmp4Ctx != nil {
+		return
+	}
 	//Log.Debugf("> FeedMpegts. boundary=%v, frame=%p, sid=%d", boundary, frame, frame.Sid)
 	if frame.Sid == mpegts.StreamIdAudio {
 		// TODO(chef): 为什么音频用pts，视频用dts
@@ -270,25 +324,46 @@ func (m *Muxer) openFragment(ts uint64, discont bool) error {
 	}
 
 	id := m.getFragmentId()
+	now := Clock.Now()
+	nowMs := int(now.UnixNano() / 1e6)
 
-	filename := PathStrategy.GetTsFileName(m.streamName, id, int(Clock.Now().UnixNano()/1e6))
+	var filename string
+	if m.fmp4Ctx != nil {
+		// init segment有更新时，新的fragment需要标记为不连续
+		changed, err := m.fmp4Ctx.prepareInitSegment(m.outPath, m.streamName, nowMs)
+		if err != nil {
+			return err
+		}
+		discont = discont || changed
+		filename = PathStrategy.GetFmp4SegmentFileName(m.streamName, id, nowMs)
+	} else {
+		filename = PathStrategy.GetTsFileName(m.streamName, id, nowMs)
+	}
 	filenameWithPath := PathStrategy.GetTsFileNameWithPath(m.outPath, filename)
 
 	if err := m.fragment.OpenFile(filenameWithPath); err != nil {
 		return err
 	}
 
-	if err := m.fragment.WriteFile(m.patpmt); err != nil {
-		return err
+	if m.fmp4Ctx == nil {
+		if err := m.fragment.WriteFile(m.patpmt); err != nil {
+			return err
+		}
 	}
 
 	m.opened = true
 
 	frag := m.getCurrFrag()
+	m.removeParts(frag)
 	frag.discont = discont
 	frag.id = id
 	frag.filename = filename
 	frag.duration = 0
+	frag.startTime = now
+	frag.initFilename = ""
+	if m.fmp4Ctx != nil {
+		frag.initFilename = m.fmp4Ctx.initFilename
+	}
 
 	m.fragTs = ts
 
@@ -297,7 +372,9 @@ func (m *Muxer) openFragment(ts uint64, discont bool) error {
 	}
 
 	// nrm said: start fragment with audio to make iPhone happy
-	m.observer.OnFragmentOpen()
+	if m.fmp4Ctx == nil {
+		m.observer.OnFragmentOpen()
+	}
 
 	m.observer.OnHlsMakeTs(base.HlsMakeTsInfo{
 		Event:          "open",
@@ -322,6 +399,22 @@ func (m *Muxer) closeFragment(isLast bool) error {
 		return nil
 	}
 
+	if m.config.IsLowLatency() {
+		// 最后一个partial segment
+		frag := m.getCurrFrag()
+		duration := frag.duration
+		for _, part := range frag.parts {
+			duration -= part.duration
+		}
+		if err := m.flushPart(duration); err != nil {
+			return err
+		}
+	} else if m.fmp4Ctx != nil {
+		if err := m.fragment.WriteFile(m.fmp4Ctx.muxer.Flush()); err != nil {
+			return err
+		}
+	}
+
 	if err := m.fragment.CloseFile(); err != nil {
 		return err
 	}
@@ -332,6 +425,11 @@ func (m *Muxer) closeFragment(isLast bool) error {
 	// 注意，后面使用序号的逻辑，都依赖该处
 	m.incrFrag()
 
+	// 超出列表中partial segment范围的fragment，删除partial segment文件
+	if m.config.IsLowLatency() && m.nfrags > llhlsPartSegmentNum {
+		m.removeParts(m.getFrag(m.nfrags - 1 - llhlsPartSegmentNum))
+	}
+
 	m.writePlaylist(isLast)
 
 	if m.config.CleanupMode == CleanupModeNever || m.config.CleanupMode == CleanupModeInTheEnd {
@@ -344,6 +442,9 @@ func (m *Muxer) closeFragment(isLast bool) error {
 			if err := fslCtx.Remove(filenameWithPath); err != nil {
 				Log.Warnf("[%s] remove stale fragment file failed. filename=%s, err=%+v", m.UniqueKey, filenameWithPath, err)
 			}
+			initFilename := frag.initFilename
+			frag.initFilename = ""
+			m.removeInitSegmentIfUnused(initFilename)
 		}
 	}
 
@@ -366,55 +467,104 @@ func (m *Muxer) closeFragment(isLast bool) error {
 }
 
 func (m *Muxer) writeRecordPlaylist() {
-	// 找出整个直播流从开始到结束最大的分片时长
 	currFrag := m.getClosedFrag()
-	if currFrag.duration > m.recordMaxFragDuration {
-		m.recordMaxFragDuration = currFrag.duration + 0.5
-	}
-
-	fragLines := fmt.Sprintf("#EXTINF:%.3f,\n%s\n", currFrag.duration, currFrag.filename)
 
+	p := recordPlaylist{version: m.playlistVersion()}
 	content, err := fslCtx.ReadFile(m.recordPlayListFilename)
 	if err == nil {
-		// m3u8文件已经存在
-
-		content = bytes.TrimSuffix(content, []byte("#EXT-X-ENDLIST\n"))
-		content, err = updateTargetDurationInM3u8(content, int(m.recordMaxFragDuration))
-		if err != nil {
-			Log.Errorf("[%s] update target duration failed. err=%+v", m.UniqueKey, err)
+		// m3u8文件已经存在，在原有的基础上追加
+		if p, err = parseRecordPlaylist(content); err != nil {
+			Log.Errorf("[%s] parse record m3u8 file failed. err=%+v", m.UniqueKey, err)
 			return
 		}
+	}
+
+	p.frags = append(p.frags, recordFragment{
+		discont:         currFrag.discont,
+		initFilename:    currFrag.initFilename,
+		programDateTime: currFrag.startTime,
+		duration:        currFrag.duration,
+		filename:        currFrag.filename,
+	})
+	m.removeExpiredRecordFragments(&p)
 
-		if currFrag.discont {
-			content = append(content, []byte("#EXT-X-DISCONTINUITY\n")...)
+	if err := writeM3u8File(p.marshal(), m.recordPlayListFilename, m.recordPlayListFilenameBak); err != nil {
+		Log.Errorf("[%s] write record m3u8 file error. err=%+v", m.UniqueKey, err)
+	}
+}
+
+// removeExpiredRecordFragments 从录制的m3u8中移除超出 MuxerConfig.DvrRetentionSec 的切片，并删除对应的文件
+//
+// 注意，还在直播m3u8中的切片不会被移除
+func (m *Muxer) removeExpiredRecordFragments(p *recordPlaylist) {
+	if m.config.DvrRetentionSec <= 0 {
+		return
+	}
+
+	live := make(map[string]struct{}, m.nfrags)
+	m.iterateFragsInPlaylist(func(frag *fragmentInfo) {
+		live[frag.filename] = struct{}{}
+	})
+
+	deadline := Clock.Now().Add(-time.Duration(m.config.DvrRetentionSec) * time.Second)
+	n := 0
+	for ; n < len(p.frags); n++ {
+		frag := &p.frags[n]
+		if _, ok := live[frag.filename]; ok {
+			break
+		}
+		// 没有`#EXT-X-PROGRAM-DATE-TIME`的切片无法判断时间，当作已经过期处理
+		if !frag.programDateTime.IsZero() && frag.endTime().After(deadline) {
+			break
 		}
+	}
+	if n == 0 {
+		return
+	}
 
-		content = append(content, []byte(fragLines)...)
-		content = append(content, []byte("#EXT-X-ENDLIST\n")...)
-	} else {
-		// m3u8文件不存在
-		var buf bytes.Buffer
-		buf.WriteString("#EXTM3U\n")
-		buf.WriteString("#EXT-X-VERSION:3\n")
-		buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(m.recordMaxFragDuration)))
-		buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n\n", 0))
-
-		if currFrag.discont {
-			buf.WriteString("#EXT-X-DISCONTINUITY\n")
+	expired := p.frags[:n]
+	p.frags = append([]recordFragment(nil), p.frags[n:]...)
+	p.mediaSeq += n
+
+	inUse := make(map[string]struct{})
+	for i := range p.frags {
+		inUse[p.frags[i].initFilename] = struct{}{}
+	}
+	for i := range expired {
+		filenameWithPath := PathStrategy.GetTsFileNameWithPath(m.outPath, expired[i].filename)
+		if err := fslCtx.Remove(filenameWithPath); err != nil {
+			Log.Warnf("[%s] remove expired fragment file failed. filename=%s, err=%+v", m.UniqueKey, filenameWithPath, err)
+		}
+		if _, ok := inUse[expired[i].initFilename]; !ok {
+			inUse[expired[i].initFilename] = struct{}{}
+			m.removeInitSegmentIfUnused(expired[i].initFilename)
 		}
+	}
+	Log.Debugf("[%s] remove expired record fragments. num=%d", m.UniqueKey, n)
+}
 
-		buf.WriteString(fragLines)
-		buf.WriteString("#EXT-X-ENDLIST\n")
+func (m *Muxer) writePlaylist(isLast bool) {
+	if err := writeM3u8File(m.makePlaylist(isLast, false), m.playlistFilename, m.playlistFilenameBak); err != nil {
+		Log.Errorf("[%s] write live m3u8 file error. err=%+v", m.UniqueKey, err)
+	}
 
-		content = buf.Bytes()
+	if !m.config.IsLowLatency() {
+		return
 	}
 
-	if err := writeM3u8File(content, m.recordPlayListFilename, m.recordPlayListFilenameBak); err != nil {
-		Log.Errorf("[%s] write record m3u8 file error. err=%+v", m.UniqueKey, err)
+	deltaFilename := getDeltaPlaylistFilename(m.playlistFilename)
+	if err := writeM3u8File(m.makePlaylist(isLast, true), deltaFilename, m.playlistFilenameBak); err != nil {
+		Log.Errorf("[%s] write delta m3u8 file error. err=%+v", m.UniqueKey, err)
 	}
+	m.notifyPlaylistUpdate()
 }
 
-func (m *Muxer) writePlaylist(isLast bool) {
+// makePlaylist
+//
+// @param skip: 是否生成LL-HLS的delta playlist，也即使用`#EXT-X-SKIP`替代较早的切片
+func (m *Muxer) makePlaylist(isLast bool, skip bool) []byte {
+	lowLatency := m.config.IsLowLatency()
+
 	// 找出时长最长的fragment
 	maxFrag := float64(m.config.FragmentDurationMs) / 1000
 	m.iterateFragsInPlaylist(func(frag *fragmentInfo) {
@@ -422,30 +572,82 @@ func (m *Muxer) writePlaylist(isLast bool) {
 			maxFrag = frag.duration + 0.5
 		}
 	})
+	targetDuration := int(maxFrag)
 
 	// TODO chef 优化这块buffer的构造
 	var buf bytes.Buffer
 	buf.WriteString("#EXTM3U\n")
-	buf.WriteString("#EXT-X-VERSION:3\n")
-	buf.WriteString("#EXT-X-ALLOW-CACHE:NO\n")
-	buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(maxFrag)))
+	buf.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", m.playlistVersion()))
+	if m.fmp4Ctx == nil {
+		// 版本7之后删除了该标签
+		buf.WriteString("#EXT-X-ALLOW-CACHE:NO\n")
+	}
+	buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", targetDuration))
+	if lowLatency {
+		partTarget := float64(m.config.PartDurationMs) / 1000
+		buf.WriteString(fmt.Sprintf("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f,CAN-SKIP-UNTIL=%.1f\n",
+			partTarget*3, m.canSkipUntil(targetDuration)))
+		buf.WriteString(fmt.Sprintf("#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget))
+	}
 	buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n\n", m.extXMediaSeq()))
 
-	m.iterateFragsInPlaylist(func(frag *fragmentInfo) {
+	skipped := 0
+	if skip {
+		skipped = m.skippedFragNum(targetDuration)
+		if skipped > 0 {
+			buf.WriteString(fmt.Sprintf("#EXT-X-SKIP:SKIPPED-SEGMENTS=%d\n", skipped))
+		}
+	}
+
+	var lastInitFilename string
+	writeFragHeader := func(frag *fragmentInfo) {
 		if frag.discont {
 			buf.WriteString("#EXT-X-DISCONTINUITY\n")
 		}
+		if frag.initFilename != "" && frag.initFilename != lastInitFilename {
+			buf.WriteString(fmt.Sprintf("#EXT-X-MAP:URI=\"%s\"\n", frag.initFilename))
+			lastInitFilename = frag.initFilename
+		}
+		writeProgramDateTime(&buf, frag.startTime)
+	}
+
+	i := 0
+	m.iterateFragsInPlaylist(func(frag *fragmentInfo) {
+		defer func() { i++ }()
+		if i < skipped {
+			return
+		}
 
+		writeFragHeader(frag)
+		if lowLatency && i >= m.nfrags-llhlsPartSegmentNum {
+			writeParts(&buf, frag.parts)
+		}
 		buf.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", frag.duration, frag.filename))
 	})
 
 	if isLast {
 		buf.WriteString("#EXT-X-ENDLIST\n")
+	} else if lowLatency && m.opened {
+		// 正在生成的fragment，只写入已经完成的partial segment，以及下一个partial segment的preload hint
+		frag := m.getCurrFrag()
+		writeFragHeader(frag)
+		writeParts(&buf, frag.parts)
+		buf.WriteString(fmt.Sprintf("#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n",
+			PathStrategy.GetFmp4PartFileName(m.streamName, m.fmp4Ctx.startMs, frag.id, len(frag.parts))))
 	}
 
-	if err := writeM3u8File(buf.Bytes(), m.playlistFilename, m.playlistFilenameBak); err != nil {
-		Log.Errorf("[%s] write live m3u8 file error. err=%+v", m.UniqueKey, err)
+	return buf.Bytes()
+}
+
+// playlistVersion fmp4需要`#EXT-X-MAP`，所以使用版本7，LL-HLS需要`#EXT-X-SKIP`，所以使用版本9
+func (m *Muxer) playlistVersion() int {
+	if m.config.IsLowLatency() {
+		return 9
+	}
+	if m.fmp4Ctx != nil {
+		return 7
 	}
+	return 3
 }
 
 func (m *Muxer) ensureDir() {
@@ -0,0 +1,188 @@
+package hls
+
+import (
+	"bytes"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/fmp4"
+	"live-server/library/LAL/pkg/mpegts"
+)
+
+// fmp4Context fmp4模式下 Muxer 的状态
+type fmp4Context struct {
+	video *fmp4.VideoTrack
+	audio *fmp4.AudioTrack
+
+	// initDirty 音视频的track信息发生了变化，需要在开启下一个fragment时生成新的init segment
+	initDirty    bool
+	initFilename string
+
+	muxer *fmp4.Muxer
+
+	// 以下字段只在LL-HLS模式下使用
+	startMs         int    // Muxer 创建时的时间戳，用于partial segment文件名，毫秒
+	partStartTs     uint64 // 当前partial segment第一个采样的时间戳，毫秒 * 90
+	partIndependent bool   // 当前partial segment是否以关键帧开始
+}
+
+// FeedRtmpMsg fmp4模式时使用，输入rtmp音视频数据
+//
+// 视频支持H264和H265（包括enhanced rtmp），音频只支持AAC，其他数据会被忽略
+func (m *Muxer) FeedRtmpMsg(msg base.RtmpMsg) {
+	if m.fmp4Ctx == nil {
+		return
+	}
+
+	switch msg.Header.MsgTypeId {
+	case base.RtmpTypeIdVideo:
+		if len(msg.Payload) <= 5 {
+			return
+		}
+		if msg.IsVideoKeySeqHeader() {
+			m.fmp4Ctx.updateVideoTrack(msg)
+			return
+		}
+		m.feedFmp4Video(msg)
+	case base.RtmpTypeIdAudio:
+		if len(msg.Payload) <= 2 || msg.AudioCodecId() != base.RtmpSoundFormatAac {
+			return
+		}
+		if msg.IsAacSeqHeader() {
+			m.fmp4Ctx.updateAudioTrack(msg.Payload[2:])
+			return
+		}
+		m.feedFmp4Audio(msg)
+	}
+}
+
+func (m *Muxer) feedFmp4Video(msg base.RtmpMsg) {
+	video := m.fmp4Ctx.video
+	if video == nil {
+		return
+	}
+
+	pkt, ok := fmp4.RtmpMsg2AvPacket(msg)
+	if !ok || pkt.PayloadType != video.PayloadType {
+		return
+	}
+
+	key := msg.IsVideoKeyNalu()
+	ts := uint64(pkt.Timestamp) * 90
+
+	// track信息变化后的第一个关键帧，不管当前fragment的时长，立即切换到新的init segment
+	if key && m.fmp4Ctx.initDirty && m.opened {
+		if err := m.closeFragment(false); err != nil {
+			Log.Errorf("[%s] close fragment error. err=%+v", m.UniqueKey, err)
+			return
+		}
+		if err := m.openFragment(ts, true); err != nil {
+			Log.Errorf("[%s] open fragment error. err=%+v", m.UniqueKey, err)
+			return
+		}
+	}
+
+	frame := &mpegts.Frame{Dts: ts, Pts: uint64(pkt.Pts) * 90, Sid: mpegts.StreamIdVideo, Key: key}
+	if err := m.updateFragment(ts, key, frame); err != nil {
+		Log.Errorf("[%s] update fragment error. err=%+v", m.UniqueKey, err)
+		return
+	}
+	if !m.opened {
+		return
+	}
+	m.cutPartIfNeeded(ts, true, key)
+	m.fmp4Ctx.muxer.FeedAvPacket(pkt)
+}
+
+func (m *Muxer) feedFmp4Audio(msg base.RtmpMsg) {
+	if m.fmp4Ctx.audio == nil {
+		return
+	}
+
+	pkt, ok := fmp4.RtmpMsg2AvPacket(msg)
+	if !ok {
+		return
+	}
+
+	// 没有视频时，音频帧都可以作为切片的边界
+	boundary := m.fmp4Ctx.video == nil
+	ts := uint64(pkt.Timestamp) * 90
+	frame := &mpegts.Frame{Dts: ts, Pts: ts, Sid: mpegts.StreamIdAudio}
+	if err := m.updateFragment(ts, boundary, frame); err != nil {
+		Log.Errorf("[%s] update fragment error. err=%+v", m.UniqueKey, err)
+		return
+	}
+	if !m.opened {
+		return
+	}
+	m.cutPartIfNeeded(ts, false, false)
+	m.fmp4Ctx.muxer.FeedAvPacket(pkt)
+}
+
+// removeInitSegmentIfUnused fmp4模式时，删除已经没有fragment引用的init segment文件
+func (m *Muxer) removeInitSegmentIfUnused(initFilename string) {
+	if m.fmp4Ctx == nil || initFilename == "" || initFilename == m.fmp4Ctx.initFilename {
+		return
+	}
+	for i := range m.frags {
+		if m.frags[i].initFilename == initFilename {
+			return
+		}
+	}
+	filenameWithPath := PathStrategy.GetTsFileNameWithPath(m.outPath, initFilename)
+	if err := fslCtx.Remove(filenameWithPath); err != nil {
+		Log.Warnf("[%s] remove stale init segment file failed. filename=%s, err=%+v", m.UniqueKey, filenameWithPath, err)
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (ctx *fmp4Context) updateVideoTrack(msg base.RtmpMsg) {
+	track, err := fmp4.NewVideoTrackFromRtmpSeqHeader(msg)
+	if err != nil {
+		Log.Warnf("parse video seq header failed. err=%+v", err)
+		return
+	}
+
+	if ctx.video != nil && ctx.video.PayloadType == track.PayloadType && bytes.Equal(ctx.video.Record, track.Record) {
+		return
+	}
+	ctx.video = track
+	ctx.initDirty = true
+}
+
+func (ctx *fmp4Context) updateAudioTrack(asc []byte) {
+	if ctx.audio != nil && bytes.Equal(ctx.audio.Asc, asc) {
+		return
+	}
+	track, err := fmp4.NewAudioTrack(asc)
+	if err != nil {
+		Log.Warnf("parse aac seq header failed. err=%+v", err)
+		return
+	}
+	ctx.audio = track
+	ctx.initDirty = true
+}
+
+// prepareInitSegment 开启新的fragment前调用，必要时生成新的init segment文件
+//
+// @return changed: 是否生成了新的init segment
+func (ctx *fmp4Context) prepareInitSegment(outPath string, streamName string, nowMs int) (changed bool, err error) {
+	if !ctx.initDirty && ctx.muxer != nil {
+		return false, nil
+	}
+
+	m := fmp4.NewMuxer(ctx.video, ctx.audio)
+	b, err := m.InitSegment()
+	if err != nil {
+		return false, err
+	}
+	filename := PathStrategy.GetFmp4InitFileName(streamName, nowMs)
+	if err = fslCtx.WriteFile(PathStrategy.GetTsFileNameWithPath(outPath, filename), b, 0666); err != nil {
+		return false, err
+	}
+
+	ctx.muxer = m
+	ctx.initFilename = filename
+	ctx.initDirty = false
+	return true, nil
+}
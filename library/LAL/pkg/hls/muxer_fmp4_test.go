@@ -0,0 +1,78 @@
+package hls_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/hls"
+	"live-server/library/naza/pkg/assert"
+)
+
+var goldenAvcSeqHeader = []byte{
+	0x17, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x64, 0x00, 0x20, 0xFF,
+	0xE1, 0x00, 0x19,
+	0x67, 0x64, 0x00, 0x20, 0xAC, 0xD9, 0x40, 0xC0, 0x29, 0xB0, 0x11, 0x00, 0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x03, 0x00, 0x32, 0x0F, 0x18, 0x31, 0x96,
+	0x01, 0x00, 0x05,
+	0x68, 0xEB, 0xEC, 0xB2, 0x2C,
+}
+
+func makeRtmpMsg(typeId uint8, ts uint32, payload []byte) base.RtmpMsg {
+	return base.RtmpMsg{
+		Header: base.RtmpHeader{
+			MsgTypeId:    typeId,
+			MsgLen:       uint32(len(payload)),
+			TimestampAbs: ts,
+		},
+		Payload: payload,
+	}
+}
+
+func TestMuxerFmp4(t *testing.T) {
+	config := hls.MuxerConfig{
+		OutPath:            t.TempDir(),
+		FragmentDurationMs: 1000,
+		FragmentNum:        3,
+		DeleteThreshold:    3,
+		CleanupMode:        hls.CleanupModeNever,
+		SegmentType:        hls.SegmentTypeFmp4,
+	}
+	m := hls.NewMuxer("fmp4test", &config, nil)
+	m.Start()
+
+	m.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdVideo, 0, goldenAvcSeqHeader))
+	m.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdAudio, 0, []byte{0xaf, 0x00, 0x12, 0x10}))
+	for i := uint32(0); i < 100; i++ {
+		ts := i * 40
+		if i%25 == 0 {
+			m.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdVideo, ts, []byte{0x17, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88}))
+		} else {
+			m.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdVideo, ts, []byte{0x27, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9a}))
+		}
+		m.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdAudio, ts, []byte{0xaf, 0x01, 0x21, 0x22}))
+	}
+	m.Dispose()
+
+	content, err := hls.ReadFile(filepath.Join(m.OutPath(), "playlist.m3u8"))
+	assert.Equal(t, nil, err)
+	playlist := string(content)
+	assert.Equal(t, true, strings.Contains(playlist, "#EXT-X-VERSION:7\n"))
+	assert.Equal(t, 1, strings.Count(playlist, "#EXT-X-MAP:URI="))
+	assert.Equal(t, 3, strings.Count(playlist, ".m4s\n"))
+	assert.Equal(t, true, strings.HasSuffix(playlist, "#EXT-X-ENDLIST\n"))
+
+	content, err = hls.ReadFile(filepath.Join(m.OutPath(), "record.m3u8"))
+	assert.Equal(t, nil, err)
+	record := string(content)
+	assert.Equal(t, 1, strings.Count(record, "#EXT-X-MAP:URI="))
+	assert.Equal(t, 4, strings.Count(record, ".m4s\n"))
+
+	// init segment文件
+	l := strings.Index(record, "#EXT-X-MAP:URI=\"") + len("#EXT-X-MAP:URI=\"")
+	r := strings.Index(record[l:], "\"")
+	init, err := hls.ReadFile(filepath.Join(m.OutPath(), record[l:l+r]))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ftyp", string(init[4:8]))
+}
@@ -0,0 +1,143 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// llhlsPartSegmentNum LL-HLS的playlist中，除了正在生成的切片，最近多少个完整的切片也列出partial segment
+//
+// 协议建议列出最近3个target duration内的partial segment
+const llhlsPartSegmentNum = 2
+
+type partInfo struct {
+	duration    float64 // 单位秒
+	filename    string
+	independent bool // 是否以关键帧开始，对应`INDEPENDENT=YES`
+}
+
+// cutPartIfNeeded LL-HLS模式时，在采样写入前调用，决定是否结束当前的partial segment
+//
+// 有视频时只在视频帧之前切割，保证partial segment的时长以视频为准
+func (m *Muxer) cutPartIfNeeded(ts uint64, isVideo bool, key bool) {
+	if !m.config.IsLowLatency() {
+		return
+	}
+
+	ctx := m.fmp4Ctx
+	if ctx.muxer.HasSamples() && (isVideo || ctx.video == nil) &&
+		ts > ctx.partStartTs && ts-ctx.partStartTs >= uint64(m.config.PartDurationMs*90) {
+
+		if err := m.flushPart(float64(ts-ctx.partStartTs) / 90000); err != nil {
+			Log.Errorf("[%s] flush part error. err=%+v", m.UniqueKey, err)
+			return
+		}
+		m.writePlaylist(false)
+	}
+
+	if !ctx.muxer.HasSamples() {
+		ctx.partStartTs = ts
+		ctx.partIndependent = key || ctx.video == nil
+	}
+}
+
+// flushPart 将缓存的采样写入partial segment文件，同时追加到切片文件中
+func (m *Muxer) flushPart(duration float64) error {
+	b := m.fmp4Ctx.muxer.Flush()
+	if b == nil {
+		return nil
+	}
+
+	frag := m.getCurrFrag()
+	filename := PathStrategy.GetFmp4PartFileName(m.streamName, m.fmp4Ctx.startMs, frag.id, len(frag.parts))
+	if err := fslCtx.WriteFile(PathStrategy.GetTsFileNameWithPath(m.outPath, filename), b, 0666); err != nil {
+		return err
+	}
+	if err := m.fragment.WriteFile(b); err != nil {
+		return err
+	}
+
+	if duration < 0 {
+		duration = 0
+	}
+	frag.parts = append(frag.parts, partInfo{
+		duration:    duration,
+		filename:    filename,
+		independent: m.fmp4Ctx.partIndependent,
+	})
+	return nil
+}
+
+// removeParts 删除fragment对应的partial segment文件
+func (m *Muxer) removeParts(frag *fragmentInfo) {
+	for _, part := range frag.parts {
+		filenameWithPath := PathStrategy.GetTsFileNameWithPath(m.outPath, part.filename)
+		if err := fslCtx.Remove(filenameWithPath); err != nil {
+			Log.Warnf("[%s] remove stale part file failed. filename=%s, err=%+v", m.UniqueKey, filenameWithPath, err)
+		}
+	}
+	frag.parts = nil
+}
+
+// canSkipUntil delta playlist中可以跳过的范围，协议规定最小为target duration的6倍
+func (m *Muxer) canSkipUntil(targetDuration int) float64 {
+	return float64(targetDuration * 6)
+}
+
+// skippedFragNum delta playlist中跳过的切片数量
+//
+// 距离playlist结尾超过 canSkipUntil 的切片才可以跳过
+func (m *Muxer) skippedFragNum(targetDuration int) int {
+	var total float64
+	m.iterateFragsInPlaylist(func(frag *fragmentInfo) {
+		total += frag.duration
+	})
+
+	// 越靠前的切片距离结尾越远，所以可以跳过的切片一定是列表开头的连续若干个
+	limit := m.canSkipUntil(targetDuration)
+	n := 0
+	var end float64
+	m.iterateFragsInPlaylist(func(frag *fragmentInfo) {
+		end += frag.duration
+		if total-end >= limit {
+			n++
+		}
+	})
+	return n
+}
+
+// notifyPlaylistUpdate 将playlist的状态同步给 ServerHandler ，唤醒等待中的blocking reload请求
+func (m *Muxer) notifyPlaylistUpdate() {
+	state := llhlsState{
+		playlistFilename: m.playlistFilename,
+		lastMsn:          -1,
+		openMsn:          -1,
+		holdTimeout:      time.Duration(m.config.FragmentDurationMs*3) * time.Millisecond,
+	}
+	if m.nfrags > 0 {
+		state.lastMsn = m.getClosedFrag().id
+	}
+	if m.opened {
+		frag := m.getCurrFrag()
+		state.openMsn = frag.id
+		state.partNum = len(frag.parts)
+	}
+	llhlsHub.update(m.outPath, state)
+}
+
+func writeParts(buf *bytes.Buffer, parts []partInfo) {
+	for _, part := range parts {
+		if part.independent {
+			buf.WriteString(fmt.Sprintf("#EXT-X-PART:DURATION=%.3f,URI=\"%s\",INDEPENDENT=YES\n", part.duration, part.filename))
+		} else {
+			buf.WriteString(fmt.Sprintf("#EXT-X-PART:DURATION=%.3f,URI=\"%s\"\n", part.duration, part.filename))
+		}
+	}
+}
+
+// getDeltaPlaylistFilename LL-HLS的delta playlist的文件名，和live m3u8在同一个目录
+func getDeltaPlaylistFilename(playlistFilename string) string {
+	return strings.TrimSuffix(playlistFilename, ".m3u8") + "_delta.m3u8"
+}
@@ -67,6 +67,21 @@ type IPathWriteStrategy interface {
 
 	// GetTsFileName ts文件名的生成策略
 	GetTsFileName(streamName string, index int, timestamp int) string
+
+	// GetFmp4SegmentFileName fmp4模式时，m4s切片文件名的生成策略
+	GetFmp4SegmentFileName(streamName string, index int, timestamp int) string
+
+	// GetFmp4InitFileName fmp4模式时，init segment文件名的生成策略
+	GetFmp4InitFileName(streamName string, timestamp int) string
+
+	// GetFmp4PartFileName LL-HLS模式时，partial segment文件名的生成策略
+	//
+	// 注意，preload hint需要提前知道下一个partial segment的文件名，所以只能由固定的参数生成
+	//
+	// @param timestamp: Muxer创建时的时间戳
+	// @param index:     partial segment所属切片的序号
+	// @param partIndex: partial segment在切片中的序号
+	GetFmp4PartFileName(streamName string, timestamp int, index int, partIndex int) string
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -86,6 +101,16 @@ const (
 // - test110-1620540716095-1.ts
 // - ...                        一系列的TS文件
 //
+// fmp4模式时，TS分片文件替换为:
+//
+// - test110-1620540712084-init.mp4 init segment文件，命名格式为{liveid}-{timestamp}-init.mp4，音视频参数变化时会生成新的文件
+// - test110-1620540712084-0.m4s    fmp4分片文件，命名格式为{liveid}-{timestamp}-{index}.m4s
+//
+// LL-HLS模式时，额外生成:
+//
+// - playlist_delta.m3u8            delta playlist，`_HLS_skip`请求时使用
+// - test110-1620540710000-0.1.m4s  partial segment文件，命名格式为{liveid}-{muxer timestamp}-{index}.{part index}.m4s
+//
 // 假设
 // 流名称="test110"
 // rootPath="/tmp/lal/hls/"
@@ -110,6 +135,8 @@ type DefaultPathStrategy struct {
 // /hls/test110/record.m3u8               -> record.m3u8               test110    m3u8     {rootOutPath}/test110/record.m3u8
 // /hls/test110/test110-1620540712084-.ts -> test110-1620540712084-.ts test110    ts       {rootOutPath/test110/test110-1620540712084-.ts
 // /hls/test110-1620540712084-.ts         -> test110-1620540712084-.ts test110    ts       {rootOutPath/test110/test110-1620540712084-.ts
+// /hls/test110/test110-1620540712084-0.m4s   -> ...                   test110    m4s      {rootOutPath/test110/test110-1620540712084-0.m4s
+// /hls/test110/test110-1620540712084-init.mp4 -> ...                  test110    mp4      {rootOutPath/test110/test110-1620540712084-init.mp4
 func (dps *DefaultPathStrategy) GetRequestInfo(urlCtx base.UrlContext, rootOutPath string) (ri RequestInfo) {
 	filename := urlCtx.LastItemOfPath
 	filetype := urlCtx.GetFileType()
@@ -124,7 +151,7 @@ func (dps *DefaultPathStrategy) GetRequestInfo(urlCtx base.UrlContext, rootOutPa
 			ri.StreamName = fileNameWithoutType
 			ri.FileNameWithPath = filepath.Join(rootOutPath, ri.StreamName, playlistM3u8FileName)
 		}
-	} else if filetype == "ts" {
+	} else if filetype == "ts" || filetype == "m4s" || filetype == "mp4" {
 		ri.StreamName = dps.getStreamNameFromTsFileName(filename)
 		ri.FileNameWithPath = filepath.Join(rootOutPath, ri.StreamName, filename)
 	}
@@ -153,6 +180,18 @@ func (*DefaultPathStrategy) GetTsFileName(streamName string, index int, timestam
 	return fmt.Sprintf("%s-%d-%d.ts", streamName, timestamp, index)
 }
 
+func (*DefaultPathStrategy) GetFmp4SegmentFileName(streamName string, index int, timestamp int) string {
+	return fmt.Sprintf("%s-%d-%d.m4s", streamName, timestamp, index)
+}
+
+func (*DefaultPathStrategy) GetFmp4InitFileName(streamName string, timestamp int) string {
+	return fmt.Sprintf("%s-%d-init.mp4", streamName, timestamp)
+}
+
+func (*DefaultPathStrategy) GetFmp4PartFileName(streamName string, timestamp int, index int, partIndex int) string {
+	return fmt.Sprintf("%s-%d-%d.%d.m4s", streamName, timestamp, index, partIndex)
+}
+
 func (*DefaultPathStrategy) getStreamNameFromTsFileName(fileName string) string {
 	sum := 0
 	index := strings.LastIndexFunc(fileName, func(r rune) bool {
@@ -97,6 +97,20 @@ func TestDefaultPathStrategy_GetRequestInfo(t *testing.T) {
 			wantFileNameWithPath:    "/tmp/lal/hls/中文测试-1620540712084.ts/中文测试-1620540712084.ts",
 			wantWinFileNameWithPath: "\\tmp\\lal\\hls\\中文测试-1620540712084.ts\\中文测试-1620540712084.ts",
 		},
+		{
+			name:                    "11 hls/[]/[]-timestamp-seq.m4s fmp4切片格式测试",
+			url:                     "http://127.0.0.1:8080/hls/test11/test11-1620540712084-0.m4s",
+			wantStreamName:          "test11",
+			wantFileNameWithPath:    "/tmp/lal/hls/test11/test11-1620540712084-0.m4s",
+			wantWinFileNameWithPath: "\\tmp\\lal\\hls\\test11\\test11-1620540712084-0.m4s",
+		},
+		{
+			name:                    "12 hls/[]-timestamp-init.mp4 fmp4 init segment格式测试",
+			url:                     "http://127.0.0.1:8080/hls/test12-1620540712084-init.mp4",
+			wantStreamName:          "test12",
+			wantFileNameWithPath:    "/tmp/lal/hls/test12/test12-1620540712084-init.mp4",
+			wantWinFileNameWithPath: "\\tmp\\lal\\hls\\test12\\test12-1620540712084-init.mp4",
+		},
 	}
 
 	dps := &hls.DefaultPathStrategy{}
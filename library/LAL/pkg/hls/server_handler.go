@@ -14,6 +14,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -79,7 +81,7 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, req *http.
 	// 如果开启了hls sub session功能
 	if s.isSubSessionModeEnable() {
 		sessionIdHash = urlObj.Query().Get("session_id")
-		if filetype == "ts" && sessionIdHash != "" {
+		if isSegmentFileType(filetype) && sessionIdHash != "" {
 			// 注意，为了增强容错性，不管是session_id字段无效，还是session_id为空，我们都依然返回ts文件内容给播放端
 			if sessionIdHash != "" {
 				err = s.keepSessionAlive(sessionIdHash)
@@ -118,19 +120,58 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, req *http.
 	ri := PathStrategy.GetRequestInfo(urlCtx, s.outPath)
 	//Log.Debugf("%+v", ri)
 
-	if filename == "" || (filetype != "m3u8" && filetype != "ts") || ri.StreamName == "" || ri.FileNameWithPath == "" {
+	if filename == "" || (filetype != "m3u8" && !isSegmentFileType(filetype)) || ri.StreamName == "" || ri.FileNameWithPath == "" {
 		err = errors.New(fmt.Sprintf("invalid hls request. url=%+v, request=%+v", urlCtx, ri))
 		Log.Warnf(err.Error())
 		resp.WriteHeader(http.StatusFound)
 		return
 	}
 
-	content, _err := ReadFile(ri.FileNameWithPath)
-	if _err != nil {
-		err = errors.New(fmt.Sprintf("read hls file failed. request=%+v, err=%+v", ri, _err))
-		Log.Warnf(err.Error())
-		resp.WriteHeader(http.StatusNotFound)
-		return
+	// LL-HLS的blocking playlist reload以及delta playlist
+	fileNameWithPath := ri.FileNameWithPath
+	var content []byte
+	if filetype == "m3u8" {
+		query, _ := url.ParseQuery(urlCtx.RawQuery)
+
+		// 时移回看
+		q, ok, err := parseDvrQuery(query)
+		if err != nil {
+			Log.Warnf("invalid dvr query. request=%+v, err=%+v", ri, err)
+			resp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if ok {
+			if content, err = s.readDvrPlaylist(ri, q); err != nil {
+				Log.Warnf("make dvr playlist failed. request=%+v, err=%+v", ri, err)
+				resp.WriteHeader(http.StatusNotFound)
+				return
+			}
+		} else if status, err := s.waitPlaylistIfNeeded(req, ri, query); err != nil {
+			Log.Warnf("blocking playlist reload failed. request=%+v, err=%+v", ri, err)
+			resp.WriteHeader(status)
+			return
+		}
+		if skip := query.Get("_HLS_skip"); !ok && (skip == "YES" || skip == "v2") {
+			deltaFilename := getDeltaPlaylistFilename(ri.FileNameWithPath)
+			if _, err := ReadFile(deltaFilename); err == nil {
+				fileNameWithPath = deltaFilename
+			}
+		}
+	}
+
+	if content == nil {
+		var _err error
+		content, _err = ReadFile(fileNameWithPath)
+		if _err != nil && filetype == "m4s" {
+			// LL-HLS的preload hint指向的partial segment可能还没有生成，等待生成
+			content, _err = llhlsHub.waitFile(filepath.Dir(fileNameWithPath), fileNameWithPath, req.Context().Done())
+		}
+		if _err != nil {
+			err = errors.New(fmt.Sprintf("read hls file failed. request=%+v, err=%+v", ri, _err))
+			Log.Warnf(err.Error())
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
 	}
 
 	switch filetype {
@@ -138,12 +179,21 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, req *http.
 		resp.Header().Add("Content-Type", "application/x-mpegurl")
 		resp.Header().Add("Server", base.LalHlsM3u8Server)
 		// 给ts文件都携带上session_id字段
+		// fmp4模式时，m4s切片文件以及`#EXT-X-MAP`中的init segment文件也一样
 		if sessionIdHash != "" {
 			content = bytes.ReplaceAll(content, []byte(".ts"), []byte(".ts?session_id="+sessionIdHash))
+			content = bytes.ReplaceAll(content, []byte(".m4s"), []byte(".m4s?session_id="+sessionIdHash))
+			content = bytes.ReplaceAll(content, []byte(".mp4"), []byte(".mp4?session_id="+sessionIdHash))
 		}
 	case "ts":
 		resp.Header().Add("Content-Type", "video/mp2t")
 		resp.Header().Add("Server", base.LalHlsTsServer)
+	case "m4s":
+		resp.Header().Add("Content-Type", "video/iso.segment")
+		resp.Header().Add("Server", base.LalHlsTsServer)
+	case "mp4":
+		resp.Header().Add("Content-Type", "video/mp4")
+		resp.Header().Add("Server", base.LalHlsTsServer)
 	}
 	resp.Header().Add("Cache-Control", "no-cache")
 	base.AddCorsHeaders2HlsIfNeeded(resp)
@@ -159,6 +209,60 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, req *http.
 	return
 }
 
+// readDvrPlaylist 读取录制的m3u8，并根据时移回看的请求参数生成新的m3u8
+func (s *ServerHandler) readDvrPlaylist(ri RequestInfo, q dvrQuery) ([]byte, error) {
+	recordFilename := PathStrategy.GetRecordM3u8FileName(filepath.Dir(ri.FileNameWithPath), ri.StreamName)
+	content, err := ReadFile(recordFilename)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parseRecordPlaylist(content)
+	if err != nil {
+		return nil, err
+	}
+	return makeDvrPlaylist(p, q, Clock.Now())
+}
+
+// waitPlaylistIfNeeded 请求携带`_HLS_msn`和`_HLS_part`时，等待playlist中出现对应的切片
+//
+// @return 失败时，返回对应的http状态码
+func (s *ServerHandler) waitPlaylistIfNeeded(req *http.Request, ri RequestInfo, query url.Values) (int, error) {
+	msnStr := query.Get("_HLS_msn")
+	partStr := query.Get("_HLS_part")
+	if msnStr == "" {
+		if partStr != "" {
+			return http.StatusBadRequest, errors.New("_HLS_part without _HLS_msn")
+		}
+		return 0, nil
+	}
+
+	msn, err := strconv.Atoi(msnStr)
+	if err != nil || msn < 0 {
+		return http.StatusBadRequest, fmt.Errorf("invalid _HLS_msn. value=%s", msnStr)
+	}
+	part := -1
+	if partStr != "" {
+		if part, err = strconv.Atoi(partStr); err != nil || part < 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid _HLS_part. value=%s", partStr)
+		}
+	}
+
+	err = llhlsHub.waitPlaylist(filepath.Dir(ri.FileNameWithPath), ri.FileNameWithPath, msn, part, req.Context().Done())
+	switch err {
+	case nil:
+		return 0, nil
+	case base.ErrHlsBlockingReloadInvalidMsn:
+		return http.StatusBadRequest, err
+	default:
+		return http.StatusServiceUnavailable, err
+	}
+}
+
+// isSegmentFileType 是否是切片类型的文件，包括ts切片，以及fmp4模式的m4s切片和init segment
+func isSegmentFileType(filetype string) bool {
+	return filetype == "ts" || filetype == "m4s" || filetype == "mp4"
+}
+
 // getSubSession 获取 SubSession，如果不存在，返回nil
 func (s *ServerHandler) getSubSession(sessionIdHash string) *SubSession {
 	s.mutex.Lock()
@@ -45,6 +45,8 @@ type PullSession struct {
 
 	urlCtx base.UrlContext
 
+	onPullSucc func()
+
 	disposeOnce sync.Once
 }
 
@@ -64,6 +66,14 @@ func NewPullSession(modOptions ...ModPullSessionOption) *PullSession {
 	return s
 }
 
+// WithOnPullSucc Pull成功
+//
+// 如果你想保证绝对时序，在回调音视频数据前，做一些操作，那么使用这个回调替代 Pull 返回成功
+func (session *PullSession) WithOnPullSucc(onPullSucc func()) *PullSession {
+	session.onPullSucc = onPullSucc
+	return session
+}
+
 // OnReadFlvTag @param tag: 底层保证回调上来的Raw数据长度是完整的（但是不会分析Raw内部的编码数据）
 type OnReadFlvTag func(tag Tag)
 
@@ -207,6 +217,10 @@ func (session *PullSession) pullContext(ctx context.Context, rawUrl string, onRe
 		}
 	}
 
+	if session.onPullSucc != nil {
+		session.onPullSucc()
+	}
+
 	// 握手成功，开启收数据协程
 	go session.runReadLoop(onReadFlvTag)
 	return nil
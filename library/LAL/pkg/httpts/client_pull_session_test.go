@@ -0,0 +1,79 @@
+package httpts_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/httpts"
+	"live-server/library/LAL/pkg/mpegts"
+	"live-server/library/naza/pkg/assert"
+)
+
+func TestPullSession(t *testing.T) {
+	sps, _ := hex.DecodeString("67640032ad84010c20086100430802184010c200843b5014005ad370101014000003000400000300ca100002")
+	pps, _ := hex.DecodeString("68ee3cb0")
+
+	var ts []byte
+	ts = append(ts, mpegts.FixedFragmentHeader...)
+	var cc uint8
+	for i := 0; i < 3; i++ {
+		var raw []byte
+		if i == 0 {
+			raw = append(raw, 0, 0, 0, 1)
+			raw = append(raw, sps...)
+			raw = append(raw, 0, 0, 0, 1)
+			raw = append(raw, pps...)
+			raw = append(raw, 0, 0, 0, 1, 0x65)
+		} else {
+			raw = append(raw, 0, 0, 0, 1, 0x41)
+		}
+		raw = append(raw, bytes.Repeat([]byte{0xaa}, 300)...)
+		frame := mpegts.Frame{
+			Pts: uint64(i) * 3600,
+			Dts: uint64(i) * 3600,
+			Cc:  cc,
+			Pid: mpegts.PidVideo,
+			Sid: mpegts.StreamIdVideo,
+			Key: i == 0,
+			Raw: raw,
+		}
+		ts = append(ts, frame.Pack()...)
+		cc = frame.Cc
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/live/test110.ts", r.URL.Path)
+		w.Header().Set("Content-Type", "video/mp2t")
+		_, _ = w.Write(ts)
+	}))
+	defer server.Close()
+
+	var msgs []base.RtmpMsg
+	session := httpts.NewPullSession()
+	err := session.Pull(server.URL+"/live/test110.ts", func(msg base.RtmpMsg) {
+		msgs = append(msgs, msg.Clone())
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "test110", session.StreamName())
+	assert.Equal(t, "live", session.AppName())
+	<-session.WaitChan()
+
+	var videoTs []uint32
+	var hasSeqHeader bool
+	for _, msg := range msgs {
+		if msg.IsAvcKeySeqHeader() {
+			hasSeqHeader = true
+		} else if msg.Header.MsgTypeId == base.RtmpTypeIdVideo {
+			videoTs = append(videoTs, msg.Header.TimestampAbs)
+		}
+	}
+	assert.Equal(t, true, hasSeqHeader)
+	assert.Equal(t, []uint32{700, 740, 780}, videoTs)
+
+	err = httpts.NewPullSession().Pull(server.URL+"/live/test110.flv", nil)
+	assert.IsNotNil(t, err)
+}
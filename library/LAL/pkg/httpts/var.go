@@ -16,3 +16,5 @@ var (
 
 	Log = nazalog.GetGlobalLogger()
 )
+
+var pullReadBufSize = 188 * 64 // PullSession读取数据时
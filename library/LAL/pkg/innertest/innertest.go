@@ -486,16 +486,22 @@ var goldenPlaylistM3u8List = []string{
 #EXT-X-TARGETDURATION:5
 #EXT-X-MEDIA-SEQUENCE:2
 
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.333,
 innertest-1642375465000-2.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.000,
 innertest-1642375465000-3.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.867,
 innertest-1642375465000-4.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.133,
 innertest-1642375465000-5.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.000,
 innertest-1642375465000-6.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:2.644,
 innertest-1642375465000-7.ts
 #EXT-X-ENDLIST
@@ -506,16 +512,22 @@ innertest-1642375465000-7.ts
 #EXT-X-TARGETDURATION:3
 #EXT-X-MEDIA-SEQUENCE:4
 
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.088,
 innertest-1642375465000-4.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.088,
 innertest-1642375465000-5.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.089,
 innertest-1642375465000-6.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.088,
 innertest-1642375465000-7.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.088,
 innertest-1642375465000-8.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:2.113,
 innertest-1642375465000-9.ts
 #EXT-X-ENDLIST
@@ -526,16 +538,22 @@ innertest-1642375465000-9.ts
 #EXT-X-TARGETDURATION:5
 #EXT-X-MEDIA-SEQUENCE:2
 
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.333,
 innertest-1642375465000-2.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.000,
 innertest-1642375465000-3.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.867,
 innertest-1642375465000-4.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.133,
 innertest-1642375465000-5.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.000,
 innertest-1642375465000-6.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:2.600,
 innertest-1642375465000-7.ts
 #EXT-X-ENDLIST
@@ -549,20 +567,28 @@ var goldenRecordM3u8List = []string{
 #EXT-X-MEDIA-SEQUENCE:0
 
 #EXT-X-DISCONTINUITY
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.000,
 innertest-1642375465000-0.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.000,
 innertest-1642375465000-1.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.333,
 innertest-1642375465000-2.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.000,
 innertest-1642375465000-3.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.867,
 innertest-1642375465000-4.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.133,
 innertest-1642375465000-5.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.000,
 innertest-1642375465000-6.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:2.644,
 innertest-1642375465000-7.ts
 #EXT-X-ENDLIST
@@ -573,24 +599,34 @@ innertest-1642375465000-7.ts
 #EXT-X-MEDIA-SEQUENCE:0
 
 #EXT-X-DISCONTINUITY
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.088,
 innertest-1642375465000-0.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.088,
 innertest-1642375465000-1.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.089,
 innertest-1642375465000-2.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.088,
 innertest-1642375465000-3.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.088,
 innertest-1642375465000-4.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.088,
 innertest-1642375465000-5.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.089,
 innertest-1642375465000-6.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.088,
 innertest-1642375465000-7.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.088,
 innertest-1642375465000-8.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:2.113,
 innertest-1642375465000-9.ts
 #EXT-X-ENDLIST
@@ -601,20 +637,28 @@ innertest-1642375465000-9.ts
 #EXT-X-MEDIA-SEQUENCE:0
 
 #EXT-X-DISCONTINUITY
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.000,
 innertest-1642375465000-0.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.000,
 innertest-1642375465000-1.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.333,
 innertest-1642375465000-2.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.000,
 innertest-1642375465000-3.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.867,
 innertest-1642375465000-4.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:3.133,
 innertest-1642375465000-5.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:4.000,
 innertest-1642375465000-6.ts
+#EXT-X-PROGRAM-DATE-TIME:2022-01-16T23:24:25.000Z
 #EXTINF:2.600,
 innertest-1642375465000-7.ts
 #EXT-X-ENDLIST
@@ -0,0 +1,243 @@
+package logic
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"sync"
+
+	"live-server/library/LAL/pkg/base"
+)
+
+// acl.go
+//
+// acl策略：
+// - 按 appName/streamName 匹配规则，使用ip或cidr做黑白名单
+// - 限制单路流的最大sub数量，以及整个server的最大pub、sub数量
+// - 本地发起的pub（customize pub、文件推流、udp ts pub、rtp pub）没有客户端地址，只检查最大pub数量
+//
+// 注意，重新加载策略后只对新的session生效，已经存在的session不受影响。
+//
+
+type aclRule struct {
+	base.AclRule
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+type AclEngine struct {
+	mutex  sync.Mutex
+	config base.AclConfig
+	rules  []aclRule
+
+	rejectedPubNum uint64
+	rejectedSubNum uint64
+
+	invalidErr error // 初始配置不合法时拒绝所有session，直到 Reload 成功
+}
+
+func NewAclEngine(config base.AclConfig) (*AclEngine, error) {
+	a := &AclEngine{}
+	if err := a.Reload(config); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func newAclEngineRejectAll(err error) *AclEngine {
+	return &AclEngine{
+		invalidErr: fmt.Errorf("%w. invalid acl config, err=%+v", base.ErrAclRejected, err),
+	}
+}
+
+// Reload 整体替换策略，策略不合法时返回错误，并且保持原有策略不变
+func (a *AclEngine) Reload(config base.AclConfig) error {
+	rules := make([]aclRule, 0, len(config.Rules))
+	for _, r := range config.Rules {
+		if _, err := path.Match(r.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid acl pattern. pattern=%s, err=%w", r.Pattern, err)
+		}
+		allow, err := parseIpNets(r.Allow)
+		if err != nil {
+			return err
+		}
+		deny, err := parseIpNets(r.Deny)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, aclRule{AclRule: r, allow: allow, deny: deny})
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.config = config
+	a.rules = rules
+	a.invalidErr = nil
+	return nil
+}
+
+// CheckPub
+//
+// @param serverPubNum: 当前整个server的pub数量，不包含本次的pub
+func (a *AclEngine) CheckPub(appName string, streamName string, remoteAddr string, serverPubNum int) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	err := a.check(appName, streamName, remoteAddr)
+	if err == nil && a.config.Enable && a.config.MaxPubNum > 0 && serverPubNum >= a.config.MaxPubNum {
+		err = fmt.Errorf("%w. server pub num exceed %d", base.ErrAclRejected, a.config.MaxPubNum)
+	}
+	if err != nil {
+		a.rejectedPubNum++
+	}
+	return err
+}
+
+// CheckSub
+//
+// @param streamSubNum: 当前这路流的sub数量，不包含本次的sub
+// @param serverSubNum: 当前整个server的sub数量，不包含本次的sub
+func (a *AclEngine) CheckSub(appName string, streamName string, remoteAddr string, streamSubNum int, serverSubNum int) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	err := a.check(appName, streamName, remoteAddr)
+	if err == nil && a.config.Enable {
+		if a.config.MaxSubNum > 0 && serverSubNum >= a.config.MaxSubNum {
+			err = fmt.Errorf("%w. server sub num exceed %d", base.ErrAclRejected, a.config.MaxSubNum)
+		} else if r := a.match(appName, streamName); r != nil && r.MaxSubNum > 0 && streamSubNum >= r.MaxSubNum {
+			err = fmt.Errorf("%w. stream sub num exceed %d, pattern=%s", base.ErrAclRejected, r.MaxSubNum, r.Pattern)
+		}
+	}
+	if err != nil {
+		a.rejectedSubNum++
+	}
+	return err
+}
+
+// CheckAddr 只检查黑白名单，不检查数量，用于hls这种没有长连接的请求，拒绝时计入sub的拒绝次数
+func (a *AclEngine) CheckAddr(appName string, streamName string, remoteAddr string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	err := a.check(appName, streamName, remoteAddr)
+	if err != nil {
+		a.rejectedSubNum++
+	}
+	return err
+}
+
+// CheckLocalPub 检查本地发起的pub，比如customize pub、文件推流、udp ts pub、rtp pub
+//
+// 这些pub没有客户端地址，所以不检查黑白名单，只检查整个server的最大pub数量
+//
+// @param serverPubNum: 当前整个server的pub数量，不包含本次的pub
+func (a *AclEngine) CheckLocalPub(serverPubNum int) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	err := a.invalidErr
+	if err == nil && a.config.Enable && a.config.MaxPubNum > 0 && serverPubNum >= a.config.MaxPubNum {
+		err = fmt.Errorf("%w. server pub num exceed %d", base.ErrAclRejected, a.config.MaxPubNum)
+	}
+	if err != nil {
+		a.rejectedPubNum++
+	}
+	return err
+}
+
+// Stat 注意，PubNum 和 SubNum 由调用方填写
+func (a *AclEngine) Stat() base.StatAcl {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return base.StatAcl{
+		Config:         a.config,
+		RejectedPubNum: a.rejectedPubNum,
+		RejectedSubNum: a.rejectedSubNum,
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (a *AclEngine) check(appName string, streamName string, remoteAddr string) error {
+	if a.invalidErr != nil {
+		return a.invalidErr
+	}
+	if !a.config.Enable {
+		return nil
+	}
+	r := a.match(appName, streamName)
+	if r == nil {
+		return nil
+	}
+
+	ip := parseRemoteIp(remoteAddr)
+	if ip == nil {
+		if len(r.allow) != 0 || len(r.deny) != 0 {
+			return fmt.Errorf("%w. invalid remote addr %s", base.ErrAclRejected, remoteAddr)
+		}
+		return nil
+	}
+	if containsIp(r.deny, ip) {
+		return fmt.Errorf("%w. %s in deny list, pattern=%s", base.ErrAclRejected, ip, r.Pattern)
+	}
+	if len(r.allow) != 0 && !containsIp(r.allow, ip) {
+		return fmt.Errorf("%w. %s not in allow list, pattern=%s", base.ErrAclRejected, ip, r.Pattern)
+	}
+	return nil
+}
+
+func (a *AclEngine) match(appName string, streamName string) *aclRule {
+	key := appName + "/" + streamName
+	for i := range a.rules {
+		if ok, _ := path.Match(a.rules[i].Pattern, key); ok {
+			return &a.rules[i]
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// parseIpNets 解析ip或cidr，单个ip等同于/32（ipv6为/128）
+func parseIpNets(items []string) ([]*net.IPNet, error) {
+	var ret []*net.IPNet
+	for _, item := range items {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid acl ip. ip=%s", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid acl cidr. cidr=%s, err=%w", item, err)
+		}
+		ret = append(ret, ipNet)
+	}
+	return ret, nil
+}
+
+func parseRemoteIp(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func containsIp(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
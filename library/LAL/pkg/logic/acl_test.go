@@ -0,0 +1,65 @@
+package logic
+
+import (
+	"errors"
+	"testing"
+
+	"live-server/library/LAL/pkg/base"
+
+	"live-server/library/naza/pkg/assert"
+)
+
+func TestAclEngine(t *testing.T) {
+	a, err := NewAclEngine(base.AclConfig{
+		Enable:    true,
+		MaxPubNum: 2,
+		MaxSubNum: 10,
+		Rules: []base.AclRule{
+			{Pattern: "live/private*", Allow: []string{"192.168.0.0/16", "10.0.0.1"}},
+			{Pattern: "*/*", Deny: []string{"172.16.0.0/12"}, MaxSubNum: 2},
+		},
+	})
+	assert.Equal(t, nil, err)
+
+	// 白名单
+	assert.Equal(t, nil, a.CheckPub("live", "private1", "192.168.1.1:1935", 0))
+	assert.Equal(t, nil, a.CheckPub("live", "private1", "10.0.0.1:1935", 0))
+	assert.Equal(t, true, errors.Is(a.CheckPub("live", "private1", "10.0.0.2:1935", 0), base.ErrAclRejected))
+
+	// 黑名单，rtsp等没有appName
+	assert.Equal(t, true, errors.Is(a.CheckSub("", "test110", "172.16.1.1:554", 0, 0), base.ErrAclRejected))
+	assert.Equal(t, nil, a.CheckSub("", "test110", "127.0.0.1:554", 0, 0))
+	assert.Equal(t, nil, a.CheckAddr("", "test110", "127.0.0.1"))
+
+	// 数量限制
+	assert.Equal(t, true, errors.Is(a.CheckPub("live", "test110", "127.0.0.1:1935", 2), base.ErrAclRejected))
+	assert.Equal(t, true, errors.Is(a.CheckSub("live", "test110", "127.0.0.1:1935", 2, 2), base.ErrAclRejected))
+	assert.Equal(t, true, errors.Is(a.CheckSub("live", "test110", "127.0.0.1:1935", 0, 10), base.ErrAclRejected))
+	// live/private1 匹配第一条规则，没有单路流的数量限制
+	assert.Equal(t, nil, a.CheckSub("live", "private1", "192.168.1.1:1935", 5, 5))
+
+	// hls的请求被拒绝时也计入sub的拒绝次数
+	assert.Equal(t, true, errors.Is(a.CheckAddr("hls", "test110", "172.16.1.1:8080"), base.ErrAclRejected))
+
+	// 本地发起的pub只检查数量
+	assert.Equal(t, nil, a.CheckLocalPub(1))
+	assert.Equal(t, true, errors.Is(a.CheckLocalPub(2), base.ErrAclRejected))
+
+	stat := a.Stat()
+	assert.Equal(t, uint64(3), stat.RejectedPubNum)
+	assert.Equal(t, uint64(4), stat.RejectedSubNum)
+
+	// 不合法的策略不生效
+	assert.IsNotNil(t, a.Reload(base.AclConfig{Enable: true, Rules: []base.AclRule{{Pattern: "*", Allow: []string{"invalid"}}}}))
+	assert.Equal(t, true, errors.Is(a.CheckSub("", "test110", "172.16.1.1:554", 0, 0), base.ErrAclRejected))
+
+	assert.Equal(t, nil, a.Reload(base.AclConfig{Enable: false}))
+	assert.Equal(t, nil, a.CheckSub("", "test110", "172.16.1.1:554", 100, 100))
+
+	// 初始配置不合法时拒绝所有
+	a = newAclEngineRejectAll(errors.New("mock"))
+	assert.Equal(t, true, errors.Is(a.CheckAddr("", "test110", "127.0.0.1"), base.ErrAclRejected))
+	assert.Equal(t, true, errors.Is(a.CheckLocalPub(0), base.ErrAclRejected))
+	assert.Equal(t, nil, a.Reload(base.AclConfig{}))
+	assert.Equal(t, nil, a.CheckAddr("", "test110", "127.0.0.1"))
+}
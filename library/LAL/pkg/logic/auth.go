@@ -9,6 +9,8 @@
 package logic
 
 import (
+	"fmt"
+
 	"live-server/library/LAL/pkg/base"
 )
 
@@ -17,5 +19,136 @@ import (
 type IAuthentication interface {
 	OnPubStart(info base.PubStartInfo) error
 	OnSubStart(info base.SubStartInfo) error
-	OnHls(streamName, urlParam string) error
+	OnHls(appName, streamName, urlParam, remoteAddr string) error
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 配置文件中 auth.type 的取值
+const (
+	AuthTypeSimple       = "simple"
+	AuthTypeHmac         = "hmac"
+	AuthTypeJwt          = "jwt"
+	AuthTypeHttpCallback = "http_callback"
+)
+
+const (
+	AuthActionPub = "pub"
+	AuthActionSub = "sub"
+)
+
+// AuthRequest 一次鉴权请求，http_callback鉴权时作为http body发送
+type AuthRequest struct {
+	Action     string `json:"action"` // AuthActionPub 或 AuthActionSub
+	Protocol   string `json:"protocol"`
+	AppName    string `json:"app_name"`
+	StreamName string `json:"stream_name"`
+	UrlParam   string `json:"url_param"`
+	RemoteAddr string `json:"remote_addr"`
+	SessionId  string `json:"session_id"`
+}
+
+// IAuthChecker 具体的鉴权方式，返回nil表示鉴权通过
+type IAuthChecker interface {
+	Check(req AuthRequest) error
+}
+
+// NewAuthentication 根据配置文件中的 auth.type 创建鉴权
+func NewAuthentication(config *Config) (IAuthentication, error) {
+	var checker IAuthChecker
+	switch config.AuthConfig.Type {
+	case "", AuthTypeSimple:
+		return NewSimpleAuthCtx(config.SimpleAuthConfig), nil
+	case AuthTypeHmac:
+		checker = NewHmacAuthChecker(config.AuthConfig.HmacConfig)
+	case AuthTypeJwt:
+		c, err := NewJwtAuthChecker(config.AuthConfig.JwtConfig)
+		if err != nil {
+			return nil, err
+		}
+		checker = c
+	case AuthTypeHttpCallback:
+		checker = NewHttpCallbackAuthChecker(config.AuthConfig.HttpCallbackConfig)
+	default:
+		return nil, fmt.Errorf("lal.logic: invalid auth type. type=%s", config.AuthConfig.Type)
+	}
+	return NewAuthCtx(config.AuthConfig, checker), nil
+}
+
+// AuthCtx 将 IAuthChecker 适配为 IAuthentication ，对所有协议的pub、sub统一鉴权
+type AuthCtx struct {
+	config  AuthConfig
+	checker IAuthChecker
+}
+
+func NewAuthCtx(config AuthConfig, checker IAuthChecker) *AuthCtx {
+	return &AuthCtx{
+		config:  config,
+		checker: checker,
+	}
+}
+
+func (a *AuthCtx) OnPubStart(info base.PubStartInfo) error {
+	if !a.config.PubEnable {
+		return nil
+	}
+	return a.check(AuthActionPub, info.SessionEventCommonInfo)
+}
+
+func (a *AuthCtx) OnSubStart(info base.SubStartInfo) error {
+	// hls在 OnHls 中鉴权，m3u8请求才携带鉴权参数
+	if !a.config.SubEnable || info.Protocol == base.SessionProtocolHlsStr {
+		return nil
+	}
+	return a.check(AuthActionSub, info.SessionEventCommonInfo)
+}
+
+func (a *AuthCtx) OnHls(appName, streamName, urlParam, remoteAddr string) error {
+	if !a.config.SubEnable {
+		return nil
+	}
+	return a.checker.Check(AuthRequest{
+		Action:     AuthActionSub,
+		Protocol:   base.SessionProtocolHlsStr,
+		AppName:    appName,
+		StreamName: streamName,
+		UrlParam:   urlParam,
+		RemoteAddr: remoteAddr,
+	})
+}
+
+func (a *AuthCtx) check(action string, info base.SessionEventCommonInfo) error {
+	err := a.checker.Check(AuthRequest{
+		Action:     action,
+		Protocol:   info.Protocol,
+		AppName:    info.AppName,
+		StreamName: info.StreamName,
+		UrlParam:   info.UrlParam,
+		RemoteAddr: info.RemoteAddr,
+		SessionId:  info.SessionId,
+	})
+	if err != nil {
+		Log.Warnf("[%s] auth failed. err=%+v, action=%s, streamName=%s, urlParam=%s",
+			info.SessionId, err, action, info.StreamName, info.UrlParam)
+	}
+	return err
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// authDenyAll 鉴权配置有误时使用，拒绝所有pub和sub，避免配置错误导致鉴权失效
+type authDenyAll struct {
+	err error
+}
+
+func (a *authDenyAll) OnPubStart(info base.PubStartInfo) error {
+	return a.err
+}
+
+func (a *authDenyAll) OnSubStart(info base.SubStartInfo) error {
+	return a.err
+}
+
+func (a *authDenyAll) OnHls(appName, streamName, urlParam, remoteAddr string) error {
+	return a.err
 }
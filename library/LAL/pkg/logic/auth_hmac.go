@@ -0,0 +1,70 @@
+package logic
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+)
+
+// auth_hmac.go
+//
+// 带过期时间的HMAC-SHA256签名url，比如 rtmp://127.0.0.1/live/test110?lal_expire=1700000000&lal_sign=xxx
+//
+// lal_expire 为过期时间的unix秒，lal_sign 为 HmacAuthSign 的结果，签名中包含action，所以pub和sub的签名不能混用
+//
+
+const (
+	hmacAuthExpireName = "lal_expire"
+	hmacAuthSignName   = "lal_sign"
+)
+
+// HmacAuthSign 计算签名，即 hex(HMAC-SHA256(key, action + "/" + streamName + "/" + expire))
+//
+// @param action: AuthActionPub 或 AuthActionSub
+// @param expire: 过期时间，unix秒
+func HmacAuthSign(key string, action string, streamName string, expire int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(action + "/" + streamName + "/" + strconv.FormatInt(expire, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type HmacAuthChecker struct {
+	config HmacAuthConfig
+}
+
+func NewHmacAuthChecker(config HmacAuthConfig) *HmacAuthChecker {
+	return &HmacAuthChecker{
+		config: config,
+	}
+}
+
+func (h *HmacAuthChecker) Check(req AuthRequest) error {
+	q, err := url.ParseQuery(req.UrlParam)
+	if err != nil {
+		return err
+	}
+	expireStr := q.Get(hmacAuthExpireName)
+	sign := q.Get(hmacAuthSignName)
+	if expireStr == "" || sign == "" {
+		return base.ErrAuthParamNotFound
+	}
+	expire, err := strconv.ParseInt(expireStr, 10, 64)
+	if err != nil {
+		return base.ErrAuthFailed
+	}
+
+	expect := HmacAuthSign(h.config.Key, req.Action, req.StreamName, expire)
+	if !hmac.Equal([]byte(expect), []byte(strings.ToLower(sign))) {
+		return base.ErrAuthFailed
+	}
+	if time.Now().Unix() > expire {
+		return base.ErrAuthExpired
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+
+	"live-server/library/naza/pkg/lru"
+)
+
+// auth_http_callback.go
+//
+// 将 AuthRequest 以json格式POST给业务方的http服务，http状态码为2xx表示鉴权通过，其他表示鉴权失败。
+//
+// 注意，鉴权在session的协程中同步执行（不持有lalserver的锁），业务方返回前该session会一直等待，
+// 鉴权结果会按 action+app+stream+url参数+客户端ip 缓存一段时间，不同客户端的鉴权结果不会互相复用。
+// 请求业务方失败（比如超时）时不缓存结果。
+//
+
+var defaultHttpCallbackAuthTimeoutMs = 3000
+
+type HttpCallbackAuthChecker struct {
+	config HttpCallbackAuthConfig
+	client *http.Client
+
+	mutex sync.Mutex
+	cache *lru.Lru // key: string, value: httpCallbackAuthResult
+}
+
+type httpCallbackAuthResult struct {
+	err        error
+	expireTime time.Time
+}
+
+func NewHttpCallbackAuthChecker(config HttpCallbackAuthConfig) *HttpCallbackAuthChecker {
+	timeoutMs := config.TimeoutMs
+	if timeoutMs <= 0 {
+		timeoutMs = defaultHttpCallbackAuthTimeoutMs
+	}
+	h := &HttpCallbackAuthChecker{
+		config: config,
+		client: &http.Client{
+			Timeout: time.Duration(timeoutMs) * time.Millisecond,
+		},
+	}
+	if config.CacheSize > 0 && config.CacheTtlMs > 0 {
+		h.cache = lru.New(config.CacheSize)
+	}
+	return h
+}
+
+func (h *HttpCallbackAuthChecker) Check(req AuthRequest) error {
+	key := req.Action + "/" + req.AppName + "/" + req.StreamName + "?" + req.UrlParam + "@" + remoteHost(req.RemoteAddr)
+	if ok, err := h.getCache(key); ok {
+		return err
+	}
+
+	ok, err := h.request(req)
+	if ok {
+		h.putCache(key, err)
+	}
+	return err
+}
+
+// request
+//
+// @return ok: 是否拿到了业务方的鉴权结果，为false时表示请求失败
+func (h *HttpCallbackAuthChecker) request(req AuthRequest) (ok bool, err error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return false, err
+	}
+	resp, err := h.client.Post(h.config.Url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		Log.Errorf("http callback auth request failed. err=%+v, url=%s", err, h.config.Url)
+		return false, base.ErrAuthFailed
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return true, base.ErrAuthFailed
+	}
+	return true, nil
+}
+
+func (h *HttpCallbackAuthChecker) getCache(key string) (bool, error) {
+	if h.cache == nil {
+		return false, nil
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	v, ok := h.cache.Get(key)
+	if !ok {
+		return false, nil
+	}
+	r := v.(httpCallbackAuthResult)
+	if time.Now().After(r.expireTime) {
+		return false, nil
+	}
+	return true, r.err
+}
+
+func (h *HttpCallbackAuthChecker) putCache(key string, err error) {
+	if h.cache == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.cache.Put(key, httpCallbackAuthResult{
+		err:        err,
+		expireTime: time.Now().Add(time.Duration(h.config.CacheTtlMs) * time.Millisecond),
+	})
+}
+
+// remoteHost 去掉客户端地址中的端口，同一个客户端的多个连接共用缓存
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,185 @@
+package logic
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+)
+
+// auth_jwt.go
+//
+// JWT鉴权，token通过url参数 lal_token 传入，比如 rtmp://127.0.0.1/live/test110?lal_token=xxx
+//
+// 支持HS256和RS256，只接受和配置一致的alg。payload中的字段见 JwtAuthClaims
+//
+
+const (
+	JwtAlgorithmHs256 = "HS256"
+	JwtAlgorithmRs256 = "RS256"
+)
+
+const jwtAuthTokenName = "lal_token"
+
+// JwtAuthClaims token的payload中lal关心的字段
+type JwtAuthClaims struct {
+	Stream string `json:"stream"`        // 允许的流名称，"*"表示所有流
+	Action string `json:"action"`        // 允许的操作，pub、sub，"*"表示两者都允许
+	Exp    int64  `json:"exp,omitempty"` // 过期时间，unix秒，为0时不检查
+	Nbf    int64  `json:"nbf,omitempty"` // 生效时间，unix秒，为0时不检查
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+type JwtAuthChecker struct {
+	config    JwtAuthConfig
+	publicKey *rsa.PublicKey
+}
+
+func NewJwtAuthChecker(config JwtAuthConfig) (*JwtAuthChecker, error) {
+	j := &JwtAuthChecker{
+		config: config,
+	}
+	switch config.Algorithm {
+	case JwtAlgorithmHs256:
+		if config.Secret == "" {
+			return nil, fmt.Errorf("lal.logic: jwt secret is empty")
+		}
+	case JwtAlgorithmRs256:
+		content, err := os.ReadFile(config.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if j.publicKey, err = parseRsaPublicKey(content); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("lal.logic: invalid jwt algorithm. algorithm=%s", config.Algorithm)
+	}
+	return j, nil
+}
+
+func (j *JwtAuthChecker) Check(req AuthRequest) error {
+	q, err := url.ParseQuery(req.UrlParam)
+	if err != nil {
+		return err
+	}
+	token := q.Get(jwtAuthTokenName)
+	if token == "" {
+		return base.ErrAuthParamNotFound
+	}
+
+	claims, err := j.verify(token)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	if claims.Exp != 0 && now > claims.Exp {
+		return base.ErrAuthExpired
+	}
+	if claims.Nbf != 0 && now < claims.Nbf {
+		return base.ErrAuthFailed
+	}
+	if claims.Stream != "*" && claims.Stream != req.StreamName {
+		return base.ErrAuthFailed
+	}
+	if claims.Action != "*" && claims.Action != req.Action {
+		return base.ErrAuthFailed
+	}
+	return nil
+}
+
+// verify 校验签名，并解析出payload
+func (j *JwtAuthChecker) verify(token string) (claims JwtAuthClaims, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, base.ErrAuthFailed
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, base.ErrAuthFailed
+	}
+	var header jwtHeader
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return claims, base.ErrAuthFailed
+	}
+	// 注意，必须和配置的算法一致，避免伪造alg绕过校验
+	if header.Alg != j.config.Algorithm {
+		return claims, base.ErrAuthFailed
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, base.ErrAuthFailed
+	}
+	signingInput := []byte(parts[0] + "." + parts[1])
+	switch j.config.Algorithm {
+	case JwtAlgorithmHs256:
+		mac := hmac.New(sha256.New, []byte(j.config.Secret))
+		mac.Write(signingInput)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return claims, base.ErrAuthFailed
+		}
+	case JwtAlgorithmRs256:
+		digest := sha256.Sum256(signingInput)
+		if rsa.VerifyPKCS1v15(j.publicKey, crypto.SHA256, digest[:], sig) != nil {
+			return claims, base.ErrAuthFailed
+		}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, base.ErrAuthFailed
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return claims, base.ErrAuthFailed
+	}
+	return claims, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// parseRsaPublicKey 支持PKIX（BEGIN PUBLIC KEY）、PKCS1（BEGIN RSA PUBLIC KEY）格式的公钥，以及证书
+func parseRsaPublicKey(content []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("lal.logic: decode pem failed")
+	}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	default:
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if rsaKey, ok := key.(*rsa.PublicKey); ok {
+			return rsaKey, nil
+		}
+	}
+	return nil, fmt.Errorf("lal.logic: not rsa public key")
+}
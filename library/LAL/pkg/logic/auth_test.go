@@ -0,0 +1,175 @@
+package logic
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+
+	"live-server/library/naza/pkg/assert"
+)
+
+func TestHmacAuthChecker(t *testing.T) {
+	c := NewHmacAuthChecker(HmacAuthConfig{Key: "q191201771"})
+
+	expire := time.Now().Unix() + 60
+	sign := HmacAuthSign("q191201771", AuthActionPub, "test110", expire)
+	urlParam := fmt.Sprintf("lal_expire=%d&lal_sign=%s", expire, sign)
+
+	assert.Equal(t, nil, c.Check(AuthRequest{Action: AuthActionPub, StreamName: "test110", UrlParam: urlParam}))
+	// pub的签名不能用于sub
+	assert.Equal(t, base.ErrAuthFailed, c.Check(AuthRequest{Action: AuthActionSub, StreamName: "test110", UrlParam: urlParam}))
+	assert.Equal(t, base.ErrAuthFailed, c.Check(AuthRequest{Action: AuthActionPub, StreamName: "test111", UrlParam: urlParam}))
+	assert.Equal(t, base.ErrAuthParamNotFound, c.Check(AuthRequest{Action: AuthActionPub, StreamName: "test110"}))
+
+	expire = time.Now().Unix() - 1
+	sign = HmacAuthSign("q191201771", AuthActionPub, "test110", expire)
+	urlParam = fmt.Sprintf("lal_expire=%d&lal_sign=%s", expire, sign)
+	assert.Equal(t, base.ErrAuthExpired, c.Check(AuthRequest{Action: AuthActionPub, StreamName: "test110", UrlParam: urlParam}))
+}
+
+func TestJwtAuthCheckerHs256(t *testing.T) {
+	c, err := NewJwtAuthChecker(JwtAuthConfig{Algorithm: JwtAlgorithmHs256, Secret: "q191201771"})
+	assert.Equal(t, nil, err)
+
+	sign := func(input []byte) []byte {
+		mac := hmac.New(sha256.New, []byte("q191201771"))
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+	token := testMakeJwt(JwtAlgorithmHs256, JwtAuthClaims{Stream: "test110", Action: AuthActionSub, Exp: time.Now().Unix() + 60}, sign)
+	assert.Equal(t, nil, c.Check(AuthRequest{Action: AuthActionSub, StreamName: "test110", UrlParam: "lal_token=" + token}))
+	assert.Equal(t, base.ErrAuthFailed, c.Check(AuthRequest{Action: AuthActionPub, StreamName: "test110", UrlParam: "lal_token=" + token}))
+	assert.Equal(t, base.ErrAuthFailed, c.Check(AuthRequest{Action: AuthActionSub, StreamName: "test111", UrlParam: "lal_token=" + token}))
+	assert.Equal(t, base.ErrAuthParamNotFound, c.Check(AuthRequest{Action: AuthActionSub, StreamName: "test110"}))
+
+	token = testMakeJwt(JwtAlgorithmHs256, JwtAuthClaims{Stream: "*", Action: "*", Exp: time.Now().Unix() - 1}, sign)
+	assert.Equal(t, base.ErrAuthExpired, c.Check(AuthRequest{Action: AuthActionSub, StreamName: "test110", UrlParam: "lal_token=" + token}))
+
+	// alg和配置不一致
+	token = testMakeJwt("none", JwtAuthClaims{Stream: "*", Action: "*"}, func(input []byte) []byte { return nil })
+	assert.Equal(t, base.ErrAuthFailed, c.Check(AuthRequest{Action: AuthActionSub, StreamName: "test110", UrlParam: "lal_token=" + token}))
+}
+
+func TestJwtAuthCheckerRs256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Equal(t, nil, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.Equal(t, nil, err)
+	filename := filepath.Join(t.TempDir(), "jwt.pub")
+	assert.Equal(t, nil, os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644))
+
+	c, err := NewJwtAuthChecker(JwtAuthConfig{Algorithm: JwtAlgorithmRs256, PublicKeyFile: filename})
+	assert.Equal(t, nil, err)
+
+	token := testMakeJwt(JwtAlgorithmRs256, JwtAuthClaims{Stream: "test110", Action: AuthActionPub}, func(input []byte) []byte {
+		digest := sha256.Sum256(input)
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		return sig
+	})
+	assert.Equal(t, nil, c.Check(AuthRequest{Action: AuthActionPub, StreamName: "test110", UrlParam: "lal_token=" + token}))
+	assert.Equal(t, base.ErrAuthFailed, c.Check(AuthRequest{Action: AuthActionPub, StreamName: "test110", UrlParam: "lal_token=" + token + "x"}))
+}
+
+func TestHttpCallbackAuthChecker(t *testing.T) {
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		var req AuthRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.StreamName != "test110" || strings.HasPrefix(req.RemoteAddr, "127.0.0.2:") {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewHttpCallbackAuthChecker(HttpCallbackAuthConfig{Url: srv.URL, CacheSize: 16, CacheTtlMs: 60000})
+	assert.Equal(t, nil, c.Check(AuthRequest{Action: AuthActionPub, StreamName: "test110"}))
+	assert.Equal(t, nil, c.Check(AuthRequest{Action: AuthActionPub, StreamName: "test110"}))
+	assert.Equal(t, base.ErrAuthFailed, c.Check(AuthRequest{Action: AuthActionPub, StreamName: "test111"}))
+	assert.Equal(t, base.ErrAuthFailed, c.Check(AuthRequest{Action: AuthActionPub, StreamName: "test111"}))
+	// 鉴权结果被缓存
+	assert.Equal(t, int32(2), atomic.LoadInt32(&n))
+
+	// 同一个客户端的不同连接复用缓存，不同客户端不复用
+	assert.Equal(t, nil, c.Check(AuthRequest{Action: AuthActionSub, StreamName: "test110", RemoteAddr: "127.0.0.1:10000"}))
+	assert.Equal(t, nil, c.Check(AuthRequest{Action: AuthActionSub, StreamName: "test110", RemoteAddr: "127.0.0.1:10001"}))
+	assert.Equal(t, base.ErrAuthFailed, c.Check(AuthRequest{Action: AuthActionSub, StreamName: "test110", RemoteAddr: "127.0.0.2:10000"}))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&n))
+}
+
+func TestAuthCtx(t *testing.T) {
+	config := &Config{}
+	config.AuthConfig.Type = AuthTypeHmac
+	config.AuthConfig.SubEnable = true
+	config.AuthConfig.HmacConfig.Key = "q191201771"
+	auth, err := NewAuthentication(config)
+	assert.Equal(t, nil, err)
+
+	// 没有开启pub鉴权
+	var pubInfo base.PubStartInfo
+	pubInfo.Protocol = base.SessionProtocolRtmpStr
+	pubInfo.StreamName = "test110"
+	assert.Equal(t, nil, auth.OnPubStart(pubInfo))
+
+	var subInfo base.SubStartInfo
+	subInfo.Protocol = base.SessionProtocolFlvStr
+	subInfo.StreamName = "test110"
+	assert.Equal(t, base.ErrAuthParamNotFound, auth.OnSubStart(subInfo))
+	assert.Equal(t, base.ErrAuthParamNotFound, auth.OnHls("hls", "test110", "", "127.0.0.1:10000"))
+
+	expire := time.Now().Unix() + 60
+	subInfo.UrlParam = fmt.Sprintf("lal_expire=%d&lal_sign=%s", expire, HmacAuthSign("q191201771", AuthActionSub, "test110", expire))
+	assert.Equal(t, nil, auth.OnSubStart(subInfo))
+	assert.Equal(t, nil, auth.OnHls("hls", "test110", subInfo.UrlParam, "127.0.0.1:10000"))
+
+	config.AuthConfig.Type = "invalid"
+	_, err = NewAuthentication(config)
+	assert.IsNotNil(t, err)
+}
+
+func testMakeJwt(alg string, claims JwtAuthClaims, sign func(input []byte) []byte) string {
+	header, _ := json.Marshal(jwtHeader{Alg: alg, Typ: "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(input)))
+}
+
+type testAuthChecker struct {
+	reqs []AuthRequest
+}
+
+func (c *testAuthChecker) Check(req AuthRequest) error {
+	c.reqs = append(c.reqs, req)
+	return nil
+}
+
+func TestAuthCtxOnHls(t *testing.T) {
+	checker := &testAuthChecker{}
+	auth := NewAuthCtx(AuthConfig{SubEnable: true}, checker)
+	assert.Equal(t, nil, auth.OnHls("hls", "test110", "token=abc", "127.0.0.1:10000"))
+	assert.Equal(t, AuthRequest{
+		Action:     AuthActionSub,
+		Protocol:   base.SessionProtocolHlsStr,
+		AppName:    "hls",
+		StreamName: "test110",
+		UrlParam:   "token=abc",
+		RemoteAddr: "127.0.0.1:10000",
+	}, checker.reqs[0])
+}
@@ -23,10 +23,24 @@ import (
 )
 
 const (
+	defaultHttpNotifyRetryNum           = 5
+	defaultHttpNotifyRetryIntervalMs    = 1000
+	defaultHttpNotifyRetryMaxIntervalMs = 30000
+
+	defaultRelayPushRetryNum           = base.PullRetryNumForever
+	defaultRelayPushRetryIntervalMs    = 1000
+	defaultRelayPushRetryMaxIntervalMs = 30000
+
 	defaultHlsCleanupMode    = hls.CleanupModeInTheEnd
 	defaultHttpflvUrlPattern = "/live/"
 	defaultHttptsUrlPattern  = "/live/"
 	defaultHlsUrlPattern     = "/hls/"
+	defaultHlsPartDurationMs = 500
+
+	defaultRtspOverHttpUrlPattern = "/rtsp/"
+
+	defaultRecordMp4FilenameTemplate       = "{app}/{stream}/{yyyy}/{mm}/{dd}/{HHMMSS}.mp4"
+	defaultRecordRetentionCheckIntervalSec = 60
 )
 
 type Config struct {
@@ -41,11 +55,16 @@ type Config struct {
 	RecordConfig          RecordConfig          `json:"record"`
 	RelayPushConfig       RelayPushConfig       `json:"relay_push"`
 	StaticRelayPullConfig StaticRelayPullConfig `json:"static_relay_pull"`
+	UdpTsOutConfig        UdpTsOutConfig        `json:"udp_ts_out"`
+	FilePubConfig         FilePubConfig         `json:"file_pub"`
 
 	HttpApiConfig    HttpApiConfig    `json:"http_api"`
 	ServerId         string           `json:"server_id"`
 	HttpNotifyConfig HttpNotifyConfig `json:"http_notify"`
+	NotifyConfig     NotifyConfig     `json:"notify"`
 	SimpleAuthConfig SimpleAuthConfig `json:"simple_auth"`
+	AuthConfig       AuthConfig       `json:"auth"`
+	AclConfig        base.AclConfig   `json:"acl"`
 	PprofConfig      PprofConfig      `json:"pprof"`
 	LogConfig        nazalog.Option   `json:"log"`
 	DebugConfig      DebugConfig      `json:"debug"`
@@ -103,19 +122,68 @@ type RtspConfig struct {
 	RtspsCertFile       string `json:"rtsps_cert_file"`
 	RtspsKeyFile        string `json:"rtsps_key_file"`
 	OutWaitKeyFrameFlag bool   `json:"out_wait_key_frame_flag"`
+
+	// rtsp over http隧道和rtsp over websocket，使用default_http的监听地址
+	RtspOverHttpEnable     bool   `json:"rtsp_over_http_enable"`
+	RtspOverHttpUrlPattern string `json:"rtsp_over_http_url_pattern"`
+
+	MulticastConfig RtspMulticastConfig `json:"multicast"`
+
 	rtsp.ServerAuthConfig
 }
 
+// RtspMulticastConfig rtsp拉流客户端可以在SETUP信令中选择UDP组播，同一个流的组播客户端共享一份数据，见 rtsp.MulticastSender
+type RtspMulticastConfig struct {
+	Enable    bool   `json:"enable"`
+	AddrStart string `json:"addr_start"` // 组播地址池的起始地址（包含），比如 "239.0.0.1"
+	AddrEnd   string `json:"addr_end"`   // 组播地址池的结束地址（包含），比如 "239.0.0.255"
+	PortMin   int    `json:"port_min"`   // 端口范围（包含两端），rtp使用偶数端口，rtcp使用rtp端口+1
+	PortMax   int    `json:"port_max"`
+	Ttl       int    `json:"ttl"`       // 组播的TTL，0表示使用系统默认值
+	Interface string `json:"interface"` // 发送组播时使用的网卡名，为空时由系统选择
+}
+
 type RecordConfig struct {
 	EnableFlv     bool   `json:"enable_flv"`
 	FlvOutPath    string `json:"flv_out_path"`
 	EnableMpegts  bool   `json:"enable_mpegts"`
 	MpegtsOutPath string `json:"mpegts_out_path"`
+
+	// mp4录制使用fragmented mp4格式，进程异常退出时已经写入的数据依然可以播放
+	EnableMp4             bool   `json:"enable_mp4"`
+	Mp4OutPath            string `json:"mp4_out_path"`
+	Mp4FilenameTemplate   string `json:"mp4_filename_template"`    // 相对于 Mp4OutPath 的文件名模板，见 expandRecordFilenameTemplate
+	Mp4SegmentDurationSec int    `json:"mp4_segment_duration_sec"` // 分段的时长，单位秒，0表示不按时长分段
+	Mp4SegmentMaxSizeMb   int    `json:"mp4_segment_max_size_mb"`  // 分段的文件大小上限，单位MB，0表示不按大小分段
+
+	RetentionConfig RecordRetentionConfig `json:"retention"`
 }
 
+// RecordRetentionConfig 录制文件的保留策略，见 RecordRetention
+type RecordRetentionConfig struct {
+	Enable             bool    `json:"enable"`
+	CheckIntervalSec   int     `json:"check_interval_sec"`
+	MaxAgeDays         int     `json:"max_age_days"`         // 录制文件的保存天数，0表示不按时间删除
+	StreamQuotaMb      int64   `json:"stream_quota_mb"`      // 每路流的录制文件总大小上限，单位MB，0表示不限制
+	TotalQuotaMb       int64   `json:"total_quota_mb"`       // 所有录制文件的总大小上限，单位MB，0表示不限制
+	DiskUsageWatermark float64 `json:"disk_usage_watermark"` // 磁盘使用率的百分比，超过时停止录制，0表示不检查
+}
+
+// RelayPushConfig
+//
+// AddrList 中的每一项是一个转推目标：
+// - host:port 按rtmp转推，比如 "127.0.0.1:19350" 转推至 rtmp://127.0.0.1:19350/{app}/{stream}
+// - 带协议头时按协议转推，支持rtmp和rtsp，比如 "rtsp://127.0.0.1:5544" 转推至 rtsp://127.0.0.1:5544/{app}/{stream}
+//
+// RetryNum 每个转推目标连续失败后的重试次数，-1表示永远重试，0表示不重试，
+// 重试间隔从 RetryIntervalMs 开始每次翻倍，不超过 RetryMaxIntervalMs
 type RelayPushConfig struct {
-	Enable   bool     `json:"enable"`
-	AddrList []string `json:"addr_list"`
+	Enable             bool     `json:"enable"`
+	AddrList           []string `json:"addr_list"`
+	RetryNum           int      `json:"retry_num"`
+	RetryIntervalMs    int      `json:"retry_interval_ms"`
+	RetryMaxIntervalMs int      `json:"retry_max_interval_ms"`
+	RtspMode           int      `json:"rtsp_mode"` // 转推rtsp时使用的传输方式，见 base.RtspModeTcp 和 base.RtspModeUdp
 }
 
 type StaticRelayPullConfig struct {
@@ -123,6 +191,26 @@ type StaticRelayPullConfig struct {
 	Addr   string `json:"addr"`
 }
 
+// UdpTsOutConfig 将指定的流以mpegts over udp的方式发送给单播或组播地址，见 udpts.Sender
+type UdpTsOutConfig struct {
+	Enable bool            `json:"enable"`
+	Outs   []UdpTsOutEntry `json:"outs"`
+}
+
+type FilePubConfig struct {
+	// RootDir 文件推流（start_file_pub）允许访问的根目录，path为相对路径时相对于该目录，为绝对路径时必须在该目录下。
+	// 为空时不允许文件推流
+	RootDir string `json:"root_dir"`
+}
+
+type UdpTsOutEntry struct {
+	StreamName string `json:"stream_name"`
+	Addr       string `json:"addr"`      // 目标地址，比如 "239.1.1.1:1234"
+	Interface  string `json:"interface"` // 发送组播时使用的网卡名，为空时由系统选择
+	Ttl        int    `json:"ttl"`       // 组播的TTL，0表示使用系统默认值
+	RtpEnable  bool   `json:"rtp_enable"`
+}
+
 type HttpApiConfig struct {
 	Enable bool   `json:"enable"`
 	Addr   string `json:"addr"`
@@ -141,6 +229,18 @@ type HttpNotifyConfig struct {
 	OnRelayPullStop   string `json:"on_relay_pull_stop"`
 	OnRtmpConnect     string `json:"on_rtmp_connect"`
 	OnHlsMakeTs       string `json:"on_hls_make_ts"`
+	OnRecordSegment   string `json:"on_record_segment"`
+	OnRecordError     string `json:"on_record_error"`
+
+	Secret             string `json:"secret"`                // 不为空时，使用HMAC-SHA256对通知内容签名，见 HttpNotifySign
+	RetryNum           int    `json:"retry_num"`             // 发送失败后的最大重试次数，-1表示一直重试
+	RetryIntervalMs    int    `json:"retry_interval_ms"`     // 第一次重试的间隔，之后每次翻倍
+	RetryMaxIntervalMs int    `json:"retry_max_interval_ms"` // 重试间隔的上限
+	RetryQueueFilename string `json:"retry_queue_filename"`  // 待重试的通知持久化到该文件，重启后继续发送，为空时只保存在内存中
+}
+
+type NotifyConfig struct {
+	DisabledEvents []string `json:"disabled_events"` // 关闭的事件，对所有sink生效，比如 ["on_update", "on_hls_make_ts"]
 }
 
 type SimpleAuthConfig struct {
@@ -155,6 +255,36 @@ type SimpleAuthConfig struct {
 	HlsM3u8Enable      bool   `json:"hls_m3u8_enable"`
 }
 
+// AuthConfig 鉴权方式的选择，以及各鉴权方式的配置
+//
+// 除simple外，其他鉴权方式对所有协议（rtmp、rtsp、httpflv、httpts、hls）统一生效
+type AuthConfig struct {
+	Type      string `json:"type"`       // 鉴权方式，取值见 AuthTypeSimple 等，为空时等同于 AuthTypeSimple ，使用 simple_auth 中的配置
+	PubEnable bool   `json:"pub_enable"` // 是否对pub鉴权，对simple无效
+	SubEnable bool   `json:"sub_enable"` // 是否对sub鉴权，包含hls的m3u8请求，对simple无效
+
+	HmacConfig         HmacAuthConfig         `json:"hmac"`
+	JwtConfig          JwtAuthConfig          `json:"jwt"`
+	HttpCallbackConfig HttpCallbackAuthConfig `json:"http_callback"`
+}
+
+type HmacAuthConfig struct {
+	Key string `json:"key"`
+}
+
+type JwtAuthConfig struct {
+	Algorithm     string `json:"algorithm"`       // HS256 或 RS256
+	Secret        string `json:"secret"`          // HS256使用
+	PublicKeyFile string `json:"public_key_file"` // RS256使用，PEM格式的公钥文件
+}
+
+type HttpCallbackAuthConfig struct {
+	Url        string `json:"url"`
+	TimeoutMs  int    `json:"timeout_ms"`
+	CacheSize  int    `json:"cache_size"`   // 鉴权结果缓存的条数，为0时不缓存
+	CacheTtlMs int    `json:"cache_ttl_ms"` // 鉴权结果缓存的时长
+}
+
 type PprofConfig struct {
 	Enable bool   `json:"enable"`
 	Addr   string `json:"addr"`
@@ -297,12 +427,74 @@ func LoadConfAndInitLog(rawContent []byte) *Config {
 			config.HlsConfig.FragmentNum)
 		config.HlsConfig.DeleteThreshold = config.HlsConfig.FragmentNum
 	}
+	if config.HlsConfig.SegmentType == "" {
+		config.HlsConfig.SegmentType = hls.SegmentTypeTs
+	} else if config.HlsConfig.SegmentType != hls.SegmentTypeTs && config.HlsConfig.SegmentType != hls.SegmentTypeFmp4 {
+		Log.Warnf("config hls.segment_type invalid. value=%s, set to default which is %s",
+			config.HlsConfig.SegmentType, hls.SegmentTypeTs)
+		config.HlsConfig.SegmentType = hls.SegmentTypeTs
+	}
+	if config.HlsConfig.LowLatencyEnable {
+		if config.HlsConfig.SegmentType != hls.SegmentTypeFmp4 {
+			Log.Warnf("config hls.low_latency_enable requires fmp4 segment. set hls.segment_type to %s", hls.SegmentTypeFmp4)
+			config.HlsConfig.SegmentType = hls.SegmentTypeFmp4
+		}
+		if config.HlsConfig.PartDurationMs <= 0 {
+			Log.Warnf("config hls.part_duration_ms invalid. set to default which is %d", defaultHlsPartDurationMs)
+			config.HlsConfig.PartDurationMs = defaultHlsPartDurationMs
+		}
+	}
+	if config.HlsConfig.DvrRetentionSec < 0 {
+		Log.Warnf("config hls.dvr_retention_sec invalid. value=%d, set to 0", config.HlsConfig.DvrRetentionSec)
+		config.HlsConfig.DvrRetentionSec = 0
+	}
+	if config.HlsConfig.DvrRetentionSec > 0 && config.HlsConfig.CleanupMode == hls.CleanupModeAsap {
+		Log.Warnf("config hls.dvr_retention_sec only works when hls.cleanup_mode is %d or %d",
+			hls.CleanupModeNever, hls.CleanupModeInTheEnd)
+	}
 	if config.HlsConfig.SubSessionHashKey != "" && config.HlsConfig.SubSessionTimeoutMs == 0 {
 		// 没有设置超时值，或者超时为0时
 		Log.Warnf("config hls.sub_session_timeout_ms is 0. set to %d(which is fragment_num * fragment_duration_ms * 2)",
 			config.HlsConfig.FragmentNum*config.HlsConfig.FragmentDurationMs*2)
 		config.HlsConfig.SubSessionTimeoutMs = config.HlsConfig.FragmentNum * config.HlsConfig.FragmentDurationMs * 2
 	}
+	// 通过http api按需开启的mp4录制也使用该模板，所以不管是否开启了mp4录制都需要设置默认值
+	if config.RecordConfig.Mp4FilenameTemplate == "" {
+		Log.Warnf("config record.mp4_filename_template not exist. set to default which is %s", defaultRecordMp4FilenameTemplate)
+		config.RecordConfig.Mp4FilenameTemplate = defaultRecordMp4FilenameTemplate
+	}
+	if config.RecordConfig.RetentionConfig.Enable && config.RecordConfig.RetentionConfig.CheckIntervalSec <= 0 {
+		Log.Warnf("config record.retention.check_interval_sec invalid. set to default which is %d", defaultRecordRetentionCheckIntervalSec)
+		config.RecordConfig.RetentionConfig.CheckIntervalSec = defaultRecordRetentionCheckIntervalSec
+	}
+	if config.HttpNotifyConfig.Enable && !j.Exist("http_notify.retry_num") {
+		Log.Warnf("config http_notify.retry_num not exist. set to default which is %d", defaultHttpNotifyRetryNum)
+		config.HttpNotifyConfig.RetryNum = defaultHttpNotifyRetryNum
+	}
+	if config.HttpNotifyConfig.Enable && config.HttpNotifyConfig.RetryIntervalMs <= 0 {
+		Log.Warnf("config http_notify.retry_interval_ms is %d. set to default which is %d",
+			config.HttpNotifyConfig.RetryIntervalMs, defaultHttpNotifyRetryIntervalMs)
+		config.HttpNotifyConfig.RetryIntervalMs = defaultHttpNotifyRetryIntervalMs
+	}
+	if config.HttpNotifyConfig.Enable && config.HttpNotifyConfig.RetryMaxIntervalMs < config.HttpNotifyConfig.RetryIntervalMs {
+		Log.Warnf("config http_notify.retry_max_interval_ms is %d. set to default which is %d",
+			config.HttpNotifyConfig.RetryMaxIntervalMs, defaultHttpNotifyRetryMaxIntervalMs)
+		config.HttpNotifyConfig.RetryMaxIntervalMs = defaultHttpNotifyRetryMaxIntervalMs
+	}
+	if !j.Exist("relay_push.retry_num") {
+		Log.Warnf("config relay_push.retry_num not exist. set to default which is %d", defaultRelayPushRetryNum)
+		config.RelayPushConfig.RetryNum = defaultRelayPushRetryNum
+	}
+	if config.RelayPushConfig.RetryIntervalMs <= 0 {
+		Log.Warnf("config relay_push.retry_interval_ms is %d. set to default which is %d",
+			config.RelayPushConfig.RetryIntervalMs, defaultRelayPushRetryIntervalMs)
+		config.RelayPushConfig.RetryIntervalMs = defaultRelayPushRetryIntervalMs
+	}
+	if config.RelayPushConfig.RetryMaxIntervalMs < config.RelayPushConfig.RetryIntervalMs {
+		Log.Warnf("config relay_push.retry_max_interval_ms is %d. set to default which is %d",
+			config.RelayPushConfig.RetryMaxIntervalMs, defaultRelayPushRetryMaxIntervalMs)
+		config.RelayPushConfig.RetryMaxIntervalMs = defaultRelayPushRetryMaxIntervalMs
+	}
 	if (config.HttpflvConfig.Enable || config.HttpflvConfig.EnableHttps) && !j.Exist("httpflv.url_pattern") {
 		Log.Warnf("config httpflv.url_pattern not exist. set to default which is %s", defaultHttpflvUrlPattern)
 		config.HttpflvConfig.UrlPattern = defaultHttpflvUrlPattern
@@ -315,6 +507,10 @@ func LoadConfAndInitLog(rawContent []byte) *Config {
 		Log.Warnf("config hls.url_pattern not exist. set to default which is %s", defaultHlsUrlPattern)
 		config.HttpflvConfig.UrlPattern = defaultHlsUrlPattern
 	}
+	if config.RtspConfig.RtspOverHttpEnable && config.RtspConfig.RtspOverHttpUrlPattern == "" {
+		Log.Warnf("config rtsp.rtsp_over_http_url_pattern not exist. set to default which is %s", defaultRtspOverHttpUrlPattern)
+		config.RtspConfig.RtspOverHttpUrlPattern = defaultRtspOverHttpUrlPattern
+	}
 
 	// 对一些常见的格式错误做修复
 	// 确保url pattern以`/`开始，并以`/`结束
@@ -330,6 +526,10 @@ func LoadConfAndInitLog(rawContent []byte) *Config {
 		Log.Warnf("fix config. hls.url_pattern %s -> %s", config.HlsConfig.UrlPattern, urlPattern)
 		config.HttpflvConfig.UrlPattern = urlPattern
 	}
+	if urlPattern, changed := ensureStartAndEndWithSlash(config.RtspConfig.RtspOverHttpUrlPattern); changed && config.RtspConfig.RtspOverHttpEnable {
+		Log.Warnf("fix config. rtsp.rtsp_over_http_url_pattern %s -> %s", config.RtspConfig.RtspOverHttpUrlPattern, urlPattern)
+		config.RtspConfig.RtspOverHttpUrlPattern = urlPattern
+	}
 
 	// 打印配置文件中的元素内容，以及解析后的最终值
 	// 把配置文件原始内容中的换行去掉，使得打印日志时紧凑一些
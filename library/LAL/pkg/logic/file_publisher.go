@@ -0,0 +1,443 @@
+package logic
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/httpflv"
+	"live-server/library/LAL/pkg/remux"
+
+	"live-server/library/naza/pkg/nazaerrors"
+)
+
+// file_publisher.go
+//
+// 将flv或mpegts文件作为直播流推入lalserver，类似于`ffmpeg -re -stream_loop -1 -i xxx.flv -c copy -f flv rtmp://...`，
+// 常用于模拟摄像头、测试以及演示。
+//
+// - 文件路径支持glob，匹配到多个文件时按文件名顺序依次推送，文件格式根据扩展名判断
+// - 按照时间戳的间隔推送，时间戳在文件之间以及循环之间重新计算，保证线性增长
+// - metadata只在最开始推送一次
+// - 支持 SeekMs ，比如rtsp PLAY信令中的Range，跳转后从目标位置之后的第一个视频关键帧开始推送
+//
+
+const (
+	// filePubGapMs 文件之间，以及循环之间，时间戳的间隔，与 httpflv.FlvFilePump 保持一致
+	filePubGapMs = 1
+
+	filePubTsReadSize = 188 * 348
+)
+
+var (
+	errFilePubStopped = errors.New("lal.logic: file publisher stopped")
+	errFilePubSeek    = errors.New("lal.logic: file publisher seek")
+)
+
+// FilePublisher 见 file_publisher.go 的说明
+type FilePublisher struct {
+	session ICustomizePubSessionContext
+	files   []string
+	loop    bool
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+
+	seekMutex  sync.Mutex
+	seekChan   chan filePubSeekPos
+	seekPos    filePubSeekPos // RunLoop协程中正在处理的seek
+	durationMs []int64        // 每个文件的时长，第一次 SeekMs 时读取所有文件计算
+
+	// 时间戳重新计算
+	metadataSent   bool
+	hasFileFirstTs bool
+	fileFirstTs    int64 // 当前文件中第一个音视频消息的原始时间戳
+	baseTs         int64 // 当前文件的起始时间戳
+	hasSent        bool
+	prevTs         int64 // 上一个推送的消息的时间戳
+	startTs        int64 // 第一个推送的消息的时间戳
+	startTime      time.Time
+	roundHasAv     bool // 当前这一轮是否推送过音视频消息
+	resetPacing    bool // seek后重新开始计算推送的节奏
+
+	// seek后跳过目标位置之前的消息
+	skipping      bool
+	skipUntilMs   int64 // 相对于文件中第一个音视频消息的时间
+	skipSeenVideo bool
+	skippedMs     int64 // 跳过的时长，推送的时间戳减去该值，保证时间戳连续
+}
+
+type filePubSeekPos struct {
+	index    int   // 文件下标
+	offsetMs int64 // 在文件中的位置
+}
+
+// NewFilePublisher
+//
+// @param files: 见 GlobFilePubFiles
+func NewFilePublisher(session ICustomizePubSessionContext, files []string, loop bool) *FilePublisher {
+	return &FilePublisher{
+		session:  session,
+		files:    files,
+		loop:     loop,
+		stopChan: make(chan struct{}),
+		seekChan: make(chan filePubSeekPos, 1),
+	}
+}
+
+// GlobFilePubFiles 根据路径或glob匹配要推送的文件，只保留支持的格式（flv、ts、m2ts）
+//
+// @param rootDir: 见 FilePubConfig.RootDir ，只允许匹配该目录下的文件（包括符号链接指向的真实路径）
+func GlobFilePubFiles(rootDir string, pattern string) ([]string, error) {
+	root, pattern, err := resolveFilePubPath(rootDir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, filename := range matches {
+		if fi, err := os.Stat(filename); err != nil || fi.IsDir() {
+			continue
+		}
+		if realFilename, err := filepath.EvalSymlinks(filename); err != nil || !isSubPath(root, realFilename) {
+			Log.Warnf("file pub ignore file outside of root dir. filename=%s, root=%s", filename, root)
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".flv", ".ts", ".m2ts":
+			files = append(files, filename)
+		}
+	}
+	if len(files) == 0 {
+		return nil, base.ErrFilePubNoFile
+	}
+	return files, nil
+}
+
+// RunLoop 阻塞直到所有文件推送完成（不循环时），或者调用了 Dispose ，或者发生错误
+func (p *FilePublisher) RunLoop() error {
+	var pos filePubSeekPos
+	for round := 0; ; {
+		err := p.pumpFile(p.files[pos.index], pos.offsetMs)
+		switch err {
+		case nil:
+			pos = filePubSeekPos{index: pos.index + 1}
+		case errFilePubStopped:
+			return nil
+		case errFilePubSeek:
+			pos = p.seekPos
+			continue
+		default:
+			return err
+		}
+		if pos.index < len(p.files) {
+			continue
+		}
+
+		// 一轮下来没有任何音视频数据（比如空文件），直接结束，避免循环推送时不停地重新打开文件
+		if !p.roundHasAv {
+			return base.ErrFilePubNoAvData
+		}
+		if !p.loop {
+			return nil
+		}
+		round++
+		Log.Debugf("[%s] file publisher new round. index=%d", p.session.UniqueKey(), round)
+		p.roundHasAv = false
+		pos = filePubSeekPos{}
+	}
+}
+
+// SeekMs 跳转到posMs的位置，posMs是从第一个文件开头开始计算的时间，多个文件的时长累加
+//
+// 第一次调用时需要读取所有文件计算时长，文件较多时比较耗时。
+// 同一个流的所有订阅者都会受影响。
+func (p *FilePublisher) SeekMs(posMs int64) error {
+	durationMs, err := p.fileDurationMs()
+	if err != nil {
+		return err
+	}
+	if posMs < 0 {
+		return base.ErrFilePubSeekOutOfRange
+	}
+	for i, d := range durationMs {
+		if posMs >= d {
+			posMs -= d
+			continue
+		}
+		Log.Infof("[%s] file publisher seek. filename=%s, offset=%dms", p.session.UniqueKey(), p.files[i], posMs)
+		p.seekMutex.Lock()
+		defer p.seekMutex.Unlock()
+		// 替换掉还没有处理的seek
+		select {
+		case <-p.seekChan:
+		default:
+		}
+		p.seekChan <- filePubSeekPos{index: i, offsetMs: posMs}
+		return nil
+	}
+	return base.ErrFilePubSeekOutOfRange
+}
+
+func (p *FilePublisher) Dispose() {
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+	})
+}
+
+func (p *FilePublisher) StreamName() string {
+	return p.session.StreamName()
+}
+
+func (p *FilePublisher) UniqueKey() string {
+	return p.session.UniqueKey()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// resolveFilePubPath 将path转换为rootDir下的绝对路径，path不允许包含".."
+//
+// @return root: rootDir解析符号链接后的绝对路径
+func resolveFilePubPath(rootDir string, path string) (root string, absPath string, err error) {
+	if rootDir == "" {
+		return "", "", nazaerrors.Wrap(base.ErrFilePubPathNotAllowed, "file_pub.root_dir not configured")
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if elem == ".." {
+			return "", "", nazaerrors.Wrap(base.ErrFilePubPathNotAllowed, path)
+		}
+	}
+
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return "", "", err
+	}
+	root = absRoot
+	if realRoot, err := filepath.EvalSymlinks(absRoot); err == nil {
+		root = realRoot
+	}
+	if filepath.IsAbs(path) {
+		absPath = filepath.Clean(path)
+	} else {
+		absPath = filepath.Join(absRoot, path)
+	}
+	if !isSubPath(absRoot, absPath) && !isSubPath(root, absPath) {
+		return "", "", nazaerrors.Wrap(base.ErrFilePubPathNotAllowed, path)
+	}
+	return root, absPath, nil
+}
+
+func isSubPath(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (p *FilePublisher) fileDurationMs() ([]int64, error) {
+	p.seekMutex.Lock()
+	defer p.seekMutex.Unlock()
+	if p.durationMs != nil {
+		return p.durationMs, nil
+	}
+
+	durationMs := make([]int64, len(p.files))
+	for i, filename := range p.files {
+		var hasTs bool
+		var firstTs, lastTs int64
+		err := readFilePubFile(filename, func(msg base.RtmpMsg) error {
+			if msg.Header.MsgTypeId == base.RtmpTypeIdMetadata {
+				return nil
+			}
+			if !hasTs {
+				firstTs = int64(msg.Header.TimestampAbs)
+				hasTs = true
+			}
+			lastTs = int64(msg.Header.TimestampAbs)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if hasTs && lastTs >= firstTs {
+			durationMs[i] = lastTs - firstTs + filePubGapMs
+		}
+	}
+	p.durationMs = durationMs
+	return durationMs, nil
+}
+
+// pumpFile
+//
+// @param offsetMs: 大于0时，跳过文件中该位置之前的消息
+func (p *FilePublisher) pumpFile(filename string, offsetMs int64) error {
+	Log.Infof("[%s] file publisher start file. filename=%s", p.session.UniqueKey(), filename)
+	p.hasFileFirstTs = false
+	p.skippedMs = 0
+	p.skipping = offsetMs > 0
+	p.skipUntilMs = offsetMs
+	p.skipSeenVideo = false
+	if p.hasSent {
+		p.baseTs = p.prevTs + filePubGapMs
+	}
+	return readFilePubFile(filename, p.onFileMsg)
+}
+
+// readFilePubFile 读取flv或mpegts文件，文件格式根据扩展名判断
+func readFilePubFile(filename string, onMsg func(msg base.RtmpMsg) error) error {
+	if strings.ToLower(filepath.Ext(filename)) == ".flv" {
+		return readFlvFile(filename, onMsg)
+	}
+	return readTsFile(filename, onMsg)
+}
+
+func readFlvFile(filename string, onMsg func(msg base.RtmpMsg) error) error {
+	var reader httpflv.FlvFileReader
+	if err := reader.Open(filename); err != nil {
+		return err
+	}
+	defer reader.Dispose()
+
+	for {
+		tag, err := reader.ReadTag()
+		if err != nil {
+			// 文件结尾不完整的tag也认为是正常结束，比如录制中断的文件
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		if err = onMsg(remux.FlvTag2RtmpMsg(tag)); err != nil {
+			return err
+		}
+	}
+}
+
+func readTsFile(filename string, onMsg func(msg base.RtmpMsg) error) error {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	// 每个文件使用新的remuxer，使得文件之间的编码参数可以不同
+	var pendingMsgs []base.RtmpMsg // mpegts解析出来，还没有回调的消息
+	remuxer := remux.NewMpegts2RtmpRemuxer(func(msg base.RtmpMsg) {
+		pendingMsgs = append(pendingMsgs, msg)
+	})
+	buf := make([]byte, filePubTsReadSize)
+	for {
+		n, err := fp.Read(buf)
+		if n > 0 {
+			remuxer.Feed(buf[:n])
+		}
+		if err == io.EOF {
+			remuxer.Flush()
+		} else if err != nil {
+			return err
+		}
+		for _, msg := range pendingMsgs {
+			if msgErr := onMsg(msg); msgErr != nil {
+				return msgErr
+			}
+		}
+		pendingMsgs = pendingMsgs[:0]
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// onFileMsg 重新计算时间戳，并且按照时间戳的间隔推送
+func (p *FilePublisher) onFileMsg(msg base.RtmpMsg) error {
+	select {
+	case <-p.stopChan:
+		return errFilePubStopped
+	case p.seekPos = <-p.seekChan:
+		return errFilePubSeek
+	default:
+	}
+
+	if msg.Header.MsgTypeId == base.RtmpTypeIdMetadata {
+		if p.metadataSent {
+			return nil
+		}
+		p.metadataSent = true
+		msg.Header.TimestampAbs = 0
+		return p.session.FeedRtmpMsg(msg)
+	}
+
+	if !p.hasFileFirstTs {
+		p.fileFirstTs = int64(msg.Header.TimestampAbs)
+		p.hasFileFirstTs = true
+	}
+	relTs := int64(msg.Header.TimestampAbs) - p.fileFirstTs
+
+	if p.skipping {
+		// 跳过的过程中，seq header仍然需要推送，否则跳转后无法解码
+		if msg.IsVideoKeySeqHeader() || msg.IsAacSeqHeader() {
+			return p.feed(msg, p.baseTs)
+		}
+		if !p.skipDone(msg, relTs) {
+			return nil
+		}
+		p.skipping = false
+		p.skippedMs = relTs
+		p.resetPacing = true
+	}
+	return p.feed(msg, p.baseTs+relTs-p.skippedMs)
+}
+
+// skipDone 是否到达seek的目标位置，有视频时从视频关键帧开始，纯音频时从第一个音频消息开始
+func (p *FilePublisher) skipDone(msg base.RtmpMsg, relTs int64) bool {
+	isVideo := msg.Header.MsgTypeId == base.RtmpTypeIdVideo
+	if isVideo {
+		p.skipSeenVideo = true
+	}
+	if relTs < p.skipUntilMs {
+		return false
+	}
+	if isVideo {
+		return msg.IsVideoKeyNalu()
+	}
+	return !p.skipSeenVideo
+}
+
+func (p *FilePublisher) feed(msg base.RtmpMsg, ts int64) error {
+	// 时间戳回退，可能发生了跳跃，使用上一个消息的时间戳，不sleep直接推送
+	if p.hasSent && ts < p.prevTs {
+		ts = p.prevTs
+	}
+
+	if !p.hasSent || p.resetPacing {
+		p.startTs = ts
+		p.startTime = time.Now()
+		p.resetPacing = false
+	} else {
+		wait := time.Duration(ts-p.startTs)*time.Millisecond - time.Since(p.startTime)
+		if wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-p.stopChan:
+				t.Stop()
+				return errFilePubStopped
+			case p.seekPos = <-p.seekChan:
+				t.Stop()
+				return errFilePubSeek
+			}
+		}
+	}
+
+	msg.Header.TimestampAbs = uint32(ts)
+	p.hasSent = true
+	p.roundHasAv = true
+	p.prevTs = ts
+	return p.session.FeedRtmpMsg(msg)
+}
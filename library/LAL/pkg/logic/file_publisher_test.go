@@ -0,0 +1,171 @@
+package logic
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/httpflv"
+	"live-server/library/LAL/pkg/remux"
+	"live-server/library/LAL/pkg/rtmp"
+	"live-server/library/naza/pkg/assert"
+)
+
+type testFilePubSession struct {
+	base.IAvPacketStream
+
+	msgs []base.RtmpMsg
+}
+
+func (s *testFilePubSession) FeedRtmpMsg(msg base.RtmpMsg) error {
+	s.msgs = append(s.msgs, msg.Clone())
+	return nil
+}
+
+func (s *testFilePubSession) UniqueKey() string {
+	return "FILEPUB1"
+}
+
+func (s *testFilePubSession) StreamName() string {
+	return "test110"
+}
+
+func TestFilePublisher(t *testing.T) {
+	dir := t.TempDir()
+
+	metadata, err := rtmp.BuildMetadata(1920, 1080, 10, 7)
+	assert.Equal(t, nil, err)
+	writeFlv := func(filename string, firstTs uint32) {
+		var w httpflv.FlvFileWriter
+		assert.Equal(t, nil, w.Open(filepath.Join(dir, filename)))
+		assert.Equal(t, nil, w.WriteFlvHeader())
+		msgs := []base.RtmpMsg{
+			{Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdMetadata}, Payload: metadata},
+			{Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdAudio, TimestampAbs: firstTs}, Payload: []byte{0xaf, 0x01, 0x01}},
+			{Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo, TimestampAbs: firstTs + 10}, Payload: []byte{0x27, 0x01, 0, 0, 0}},
+			{Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdAudio, TimestampAbs: firstTs + 20}, Payload: []byte{0xaf, 0x01, 0x02}},
+		}
+		for _, msg := range msgs {
+			msg.Header.MsgLen = uint32(len(msg.Payload))
+			assert.Equal(t, nil, w.WriteTag(*remux.RtmpMsg2FlvTag(msg)))
+		}
+		assert.Equal(t, nil, w.Dispose())
+	}
+	writeFlv("a.flv", 1000)
+	writeFlv("b.flv", 5000)
+	assert.Equal(t, nil, os.WriteFile(filepath.Join(dir, "c.txt"), []byte("c"), 0666))
+
+	files, err := GlobFilePubFiles(dir, filepath.Join(dir, "*"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{filepath.Join(dir, "a.flv"), filepath.Join(dir, "b.flv")}, files)
+	relFiles, err := GlobFilePubFiles(dir, "*.flv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(files), len(relFiles))
+	_, err = GlobFilePubFiles(dir, filepath.Join(dir, "*.mp4"))
+	assert.Equal(t, base.ErrFilePubNoFile, err)
+
+	// 不允许访问根目录之外的文件
+	subDir := filepath.Join(dir, "sub")
+	assert.Equal(t, nil, os.Mkdir(subDir, 0777))
+	for _, path := range []string{filepath.Join(dir, "a.flv"), "../a.flv", "x/../../a.flv", "*.flv"} {
+		_, err = GlobFilePubFiles(subDir, path)
+		assert.IsNotNil(t, err, path)
+	}
+	_, err = GlobFilePubFiles("", filepath.Join(dir, "a.flv"))
+	assert.Equal(t, true, errors.Is(err, base.ErrFilePubPathNotAllowed))
+	// 根目录下指向根目录外的符号链接
+	if os.Symlink(filepath.Join(dir, "a.flv"), filepath.Join(subDir, "link.flv")) == nil {
+		_, err = GlobFilePubFiles(subDir, "link.flv")
+		assert.Equal(t, base.ErrFilePubNoFile, err)
+	}
+
+	var session testFilePubSession
+	p := NewFilePublisher(&session, files, false)
+	assert.Equal(t, nil, p.RunLoop())
+
+	// metadata只推送一次，时间戳在文件之间连续
+	var ts []uint32
+	for i, msg := range session.msgs {
+		if i == 0 {
+			assert.Equal(t, base.RtmpTypeIdMetadata, msg.Header.MsgTypeId)
+			continue
+		}
+		assert.Equal(t, true, msg.Header.MsgTypeId != base.RtmpTypeIdMetadata)
+		ts = append(ts, msg.Header.TimestampAbs)
+	}
+	assert.Equal(t, []uint32{0, 10, 20, 21, 31, 41}, ts)
+
+	// 循环推送，直到Dispose
+	session.msgs = nil
+	p = NewFilePublisher(&session, files, true)
+	done := make(chan error)
+	go func() {
+		done <- p.RunLoop()
+	}()
+	p.Dispose()
+	assert.Equal(t, nil, <-done)
+
+	// 循环推送没有音视频数据的文件时直接结束
+	assert.Equal(t, nil, os.WriteFile(filepath.Join(dir, "empty.flv"), nil, 0666))
+	p = NewFilePublisher(&session, []string{filepath.Join(dir, "empty.flv")}, true)
+	go func() {
+		done <- p.RunLoop()
+	}()
+	select {
+	case err = <-done:
+		assert.Equal(t, base.ErrFilePubNoAvData, err)
+	case <-time.After(time.Second):
+		p.Dispose()
+		t.Fatal("file publisher loop on empty file")
+	}
+}
+
+func TestFilePublisher_Seek(t *testing.T) {
+	dir := t.TempDir()
+
+	// 每100毫秒一个视频关键帧，中间有一个非关键帧
+	writeFlv := func(filename string) string {
+		filename = filepath.Join(dir, filename)
+		var w httpflv.FlvFileWriter
+		assert.Equal(t, nil, w.Open(filename))
+		assert.Equal(t, nil, w.WriteFlvHeader())
+		msgs := []base.RtmpMsg{
+			{Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo}, Payload: []byte{0x17, 0x00, 0, 0, 0}},
+		}
+		for ts := uint32(0); ts <= 300; ts += 50 {
+			payload := []byte{0x27, 0x01, 0, 0, 0}
+			if ts%100 == 0 {
+				payload[0] = 0x17
+			}
+			msgs = append(msgs, base.RtmpMsg{Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo, TimestampAbs: ts}, Payload: payload})
+		}
+		for _, msg := range msgs {
+			msg.Header.MsgLen = uint32(len(msg.Payload))
+			assert.Equal(t, nil, w.WriteTag(*remux.RtmpMsg2FlvTag(msg)))
+		}
+		assert.Equal(t, nil, w.Dispose())
+		return filename
+	}
+	files := []string{writeFlv("a.flv"), writeFlv("b.flv")}
+
+	var session testFilePubSession
+	p := NewFilePublisher(&session, files, false)
+	assert.Equal(t, base.ErrFilePubSeekOutOfRange, p.SeekMs(1000))
+	assert.Equal(t, base.ErrFilePubSeekOutOfRange, p.SeekMs(-1))
+
+	// 每个文件的时长为301毫秒，跳转到第二个文件的119毫秒处，从200毫秒的关键帧开始推送，seq header仍然推送
+	assert.Equal(t, nil, p.SeekMs(420))
+	assert.Equal(t, nil, p.RunLoop())
+
+	var ts []uint32
+	var keys []bool
+	for _, msg := range session.msgs {
+		ts = append(ts, msg.Header.TimestampAbs)
+		keys = append(keys, msg.IsVideoKeySeqHeader() || msg.IsVideoKeyNalu())
+	}
+	assert.Equal(t, []uint32{0, 0, 50, 100}, ts)
+	assert.Equal(t, []bool{true, true, false, true}, keys)
+}
@@ -25,6 +25,7 @@ import (
 	"live-server/library/LAL/pkg/rtmp"
 	"live-server/library/LAL/pkg/rtsp"
 	"live-server/library/LAL/pkg/sdp"
+	"live-server/library/LAL/pkg/udpts"
 )
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -32,28 +33,30 @@ import (
 // TODO(chef): [refactor] 考虑抽象出通用接口 202208
 //
 // checklist表格
-// | .                                           | rtmp pub | ps pub |
-// | 添加到group中                                | Y        | Y      |
-// | 到输出流的转换路径关系                         | Y        | Y      |
-// | 删除                                        | Y        | Y      |
-// | group.hasPubSession()                       | Y        | Y      |
-// | group.disposeInactiveSessions()检查超时并清理 | Y        | Y      |
-// | group.Dispose()时销毁                        | Y        | Y      |
-// | group.GetStat()时获取信息                     | Y        | Y      |
-// | group.KickSession()时踢出                    | Y        | Y      |
-// | group.updateAllSessionStat()更新信息         | Y        | Y      |
-// | group.inSessionUniqueKey()                  | Y        | Y      |
+// | .                                           | rtmp pub | ps pub | udp ts pub |
+// | 添加到group中                                | Y        | Y      | Y          |
+// | 到输出流的转换路径关系                         | Y        | Y      | Y          |
+// | 删除                                        | Y        | Y      | Y          |
+// | group.hasPubSession()                       | Y        | Y      | Y          |
+// | group.disposeInactiveSessions()检查超时并清理 | Y        | Y      | Y          |
+// | group.Dispose()时销毁                        | Y        | Y      | Y          |
+// | group.GetStat()时获取信息                     | Y        | Y      | Y          |
+// | group.KickSession()时踢出                    | Y        | Y      | Y          |
+// | group.updateAllSessionStat()更新信息         | Y        | Y      | Y          |
+// | group.inSessionUniqueKey()                  | Y        | Y      | Y          |
 
 // TODO(chef): [refactor] 整理sub类型流接入需要做的事情的文档 202211
 
 // ---------------------------------------------------------------------------------------------------------------------
-// 输入流到输出流的转换路径关系（一共6种输入）：
+// 输入流到输出流的转换路径关系（一共8种输入）：
 //
 // rtmpPullSession.WithOnReadRtmpAvMsg  ->
+// httpflv/httpts/hls pullSession       ->
+// udpTsPubSession.WithOnRtmpMsg        ->
 // rtmpPubSession.SetPubSessionObserver ->
 //    customizePubSession.WithOnRtmpMsg -> OnReadRtmpAvMsg(enter Lock) -> [dummyAudioFilter] -> broadcastByRtmpMsg -> rtmp, http-flv
 //                                                                                                                 -> rtmp2RtspRemuxer -> rtsp
-//                                                                                                                 -> rtmp2MpegtsRemuxer -> ts, hls
+//                                                                                                                 -> rtmp2MpegtsRemuxer -> ts, hls, udp ts out
 //
 // ---------------------------------------------------------------------------------------------------------------------
 // rtspPullSession ->
@@ -68,11 +71,15 @@ import (
 
 type GroupOption struct {
 	onHookSession func(uniqueKey string, streamName string) ICustomizeHookSessionContext
+
+	rtspMulticastAddrPool *rtsp.MulticastAddrPool // 为nil时不支持rtsp组播拉流
 }
 
 type IGroupObserver interface {
 	CleanupHlsIfNeeded(appName string, streamName string, path string)
 	OnHlsMakeTs(info base.HlsMakeTsInfo)
+	OnRecordSegment(info base.RecordSegmentInfo)
+	OnRecordError(info base.RecordErrorInfo)
 	OnRelayPullStart(info base.PullStartInfo) // TODO(chef): refactor me
 	OnRelayPullStop(info base.PullStopInfo)
 }
@@ -96,6 +103,7 @@ type Group struct {
 	rtspPubSession      *rtsp.PubSession
 	customizePubSession *CustomizePubSessionContext
 	psPubSession        *gb28181.PubSession
+	udpTsPubSession     *udpts.PubSession
 	rtsp2RtmpRemuxer    *remux.AvPacket2RtmpRemuxer // TODO(chef): [refactor] 重命名为avPacket2RtmpRemuxer，因为除了rtsp，customize pub和gb28181 pub都是 202208
 	rtmp2RtspRemuxer    *remux.Rtmp2RtspRemuxer
 	rtmp2MpegtsRemuxer  *remux.Rtmp2MpegtsRemuxer
@@ -106,6 +114,9 @@ type Group struct {
 	// ps pub使用
 	psPubTimeoutSec            uint32 // 超时时间
 	psPubPrevInactiveCheckTick int64  // 上次检查时间
+	// udp ts pub使用
+	udpTsPubTimeoutSec            uint32
+	udpTsPubPrevInactiveCheckTick int64
 	// rtmp sub使用
 	rtmpGopCache *remux.GopCache
 	// httpflv sub使用
@@ -116,6 +127,10 @@ type Group struct {
 	sdpCtx *sdp.LogicContext
 	// mpegts使用
 	patpmt []byte
+	// udp ts out使用
+	udpTsSenders []*udpts.Sender
+	// rtsp组播拉流使用，第一个rtsp拉流客户端describe时创建
+	rtspMulticastSender *rtsp.MulticastSender
 	// sub
 	rtmpSubSessionSet    map[*rtmp.ServerSession]struct{}
 	httpflvSubSessionSet map[*httpflv.SubSession]struct{}
@@ -123,13 +138,21 @@ type Group struct {
 	rtspSubSessionSet    map[*rtsp.SubSession]struct{} // 注意，使用这个容器时，一定要注意 session 的 Stage 属性
 	hlsSubSessionSet     map[*hls.SubSession]struct{}
 	// push
-	pushEnable    bool
 	url2PushProxy map[string]*pushProxy
 	// hls
 	hlsMuxer *hls.Muxer
 	// record
 	recordFlv    *httpflv.FlvFileWriter
 	recordMpegts *mpegts.FileWriter
+	recordMp4    *mp4Recorder
+	// 通过http api按需开启的录制
+	onDemandRecords map[string]*onDemandRecord // key: 录制格式
+	seqHeaderCache  rtmpSeqHeaderCache
+	// 不为空时表示录制被暂停，见 SuspendRecord
+	recordSuspendReason string
+	// snapshot使用
+	snapshotKeyFrame *base.RtmpMsg
+	snapshotTime     time.Time
 	// rtmp sub使用
 	rtmpMergeWriter *base.MergeWriter // TODO(chef): 后面可以在业务层加一个定时Flush
 	//
@@ -163,6 +186,7 @@ func NewGroup(appName string, streamName string, config *Config, option GroupOpt
 		httptsSubSessionSet:        make(map[*httpts.SubSession]struct{}),
 		rtspSubSessionSet:          make(map[*rtsp.SubSession]struct{}),
 		hlsSubSessionSet:           make(map[*hls.SubSession]struct{}),
+		onDemandRecords:            make(map[string]*onDemandRecord),
 		rtmpGopCache:               remux.NewGopCache("rtmp", uk, config.RtmpConfig.GopNum, config.RtmpConfig.SingleGopMaxFrameNum),
 		httpflvGopCache:            remux.NewGopCache("httpflv", uk, config.HttpflvConfig.GopNum, config.HttpflvConfig.SingleGopMaxFrameNum),
 		httptsGopCache:             remux.NewGopCacheMpegts(uk, config.HttptsConfig.GopNum, config.HttptsConfig.SingleGopMaxFrameNum),
@@ -199,6 +223,7 @@ func (group *Group) Tick(tickCount uint32) {
 
 	group.tickPullModule()
 	group.startPushIfNeeded()
+	group.stopExpiredRecords()
 
 	// 定时关闭没有数据的session
 	group.disposeInactiveSessions(tickCount)
@@ -234,6 +259,9 @@ func (group *Group) Dispose() {
 	if group.psPubSession != nil {
 		group.psPubSession.Dispose()
 	}
+	if group.udpTsPubSession != nil {
+		group.udpTsPubSession.Dispose()
+	}
 
 	for session := range group.rtmpSubSessionSet {
 		session.Dispose()
@@ -244,6 +272,10 @@ func (group *Group) Dispose() {
 		session.Dispose()
 	}
 	group.rtspSubSessionSet = nil
+	if group.rtspMulticastSender != nil {
+		group.rtspMulticastSender.Dispose()
+		group.rtspMulticastSender = nil
+	}
 
 	for session := range group.httpflvSubSessionSet {
 		session.Dispose()
@@ -255,6 +287,11 @@ func (group *Group) Dispose() {
 	}
 	group.httptsSubSessionSet = nil
 
+	// 回源的pull session不在上面的集合中，需要单独关闭，并且避免定时器再次触发回源
+	group.pullProxy.staticRelayPullEnable = false
+	group.pullProxy.apiEnable = false
+	group.stopPull()
+
 	group.delIn()
 }
 
@@ -277,11 +314,15 @@ func (group *Group) GetStat(maxsub int) base.StatGroup {
 		group.stat.StatPub = base.Session2StatPub(group.rtspPubSession)
 	} else if group.psPubSession != nil {
 		group.stat.StatPub = base.Session2StatPub(group.psPubSession)
+	} else if group.udpTsPubSession != nil {
+		group.stat.StatPub = base.Session2StatPub(group.udpTsPubSession)
 	} else {
 		group.stat.StatPub = base.StatPub{}
 	}
 
 	group.stat.StatPull = group.getStatPull()
+	group.stat.StatPushes = group.statRelayPush()
+	group.stat.StatRecords = group.statRecords()
 
 	group.stat.StatSubs = nil
 	var statSubCount int
@@ -344,7 +385,9 @@ func (group *Group) KickSession(sessionId string) bool {
 				return true
 			}
 		}
-	} else if strings.HasPrefix(sessionId, base.UkPreRtmpPullSession) || strings.HasPrefix(sessionId, base.UkPreRtspPullSession) {
+	} else if strings.HasPrefix(sessionId, base.UkPreRtmpPullSession) || strings.HasPrefix(sessionId, base.UkPreRtspPullSession) ||
+		strings.HasPrefix(sessionId, base.UkPreFlvPullSession) || strings.HasPrefix(sessionId, base.UkPreTsPullSession) ||
+		strings.HasPrefix(sessionId, base.UkPreHlsPullSession) {
 		return group.kickPull(sessionId)
 	} else if strings.HasPrefix(sessionId, base.UkPreRtspPubSession) {
 		if group.rtspPubSession != nil && group.rtspPubSession.UniqueKey() == sessionId {
@@ -378,6 +421,11 @@ func (group *Group) KickSession(sessionId string) bool {
 				return true
 			}
 		}
+	} else if strings.HasPrefix(sessionId, base.UkPreTsPubSession) {
+		if group.udpTsPubSession != nil && group.udpTsPubSession.UniqueKey() == sessionId {
+			group.udpTsPubSession.Dispose()
+			return true
+		}
 	} else {
 		Log.Errorf("[%s] kick session while session id format invalid. %s", group.UniqueKey, sessionId)
 	}
@@ -411,8 +459,7 @@ func (group *Group) OutSessionNum() int {
 
 	pushNum := 0
 	for _, item := range group.url2PushProxy {
-		// TODO(chef): [refactor] 考虑只判断session是否为nil 202205
-		if item.isPushing && item.pushSession != nil {
+		if item.session != nil {
 			pushNum++
 		}
 	}
@@ -420,6 +467,21 @@ func (group *Group) OutSessionNum() int {
 		len(group.httpflvSubSessionSet) + len(group.httptsSubSessionSet) + pushNum
 }
 
+// HasPubSession 是否有pub（不包含relay pull）
+func (group *Group) HasPubSession() bool {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	return group.hasPubSession()
+}
+
+// SubSessionNum 所有协议的sub数量，不包含relay push
+func (group *Group) SubSessionNum() int {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	return len(group.rtmpSubSessionSet) + len(group.rtspSubSessionSet) +
+		len(group.httpflvSubSessionSet) + len(group.httptsSubSessionSet) + len(group.hlsSubSessionSet)
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 // disposeInactiveSessions 关闭不活跃的session
@@ -441,6 +503,18 @@ func (group *Group) disposeInactiveSessions(tickCount uint32) {
 			}
 		}
 	}
+	if group.udpTsPubSession != nil && group.udpTsPubTimeoutSec != 0 {
+		if group.udpTsPubPrevInactiveCheckTick == -1 ||
+			tickCount-uint32(group.udpTsPubPrevInactiveCheckTick) >= group.udpTsPubTimeoutSec {
+
+			if readAlive, _ := group.udpTsPubSession.IsAlive(); !readAlive {
+				Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, group.udpTsPubSession.UniqueKey())
+				group.udpTsPubSession.Dispose()
+			}
+
+			group.udpTsPubPrevInactiveCheckTick = int64(tickCount)
+		}
+	}
 
 	// 以下都是以 CheckSessionAliveIntervalSec 为间隔的清理逻辑
 
@@ -489,8 +563,7 @@ func (group *Group) disposeInactiveSessions(tickCount uint32) {
 		}
 	}
 	for _, item := range group.url2PushProxy {
-		session := item.pushSession
-		if item.isPushing && session != nil {
+		if session := item.session; session != nil {
 			if _, writeAlive := session.IsAlive(); !writeAlive {
 				Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, session.UniqueKey())
 				session.Dispose()
@@ -510,6 +583,9 @@ func (group *Group) updateAllSessionStat() {
 	if group.psPubSession != nil {
 		group.psPubSession.UpdateStat(calcSessionStatIntervalSec)
 	}
+	if group.udpTsPubSession != nil {
+		group.udpTsPubSession.UpdateStat(calcSessionStatIntervalSec)
+	}
 
 	group.updatePullSessionStat()
 
@@ -527,8 +603,7 @@ func (group *Group) updateAllSessionStat() {
 	}
 
 	for _, item := range group.url2PushProxy {
-		session := item.pushSession
-		if item.isPushing && session != nil {
+		if session := item.session; session != nil {
 			session.UpdateStat(calcSessionStatIntervalSec)
 		}
 	}
@@ -536,7 +611,7 @@ func (group *Group) updateAllSessionStat() {
 
 func (group *Group) hasPubSession() bool {
 	return group.rtmpPubSession != nil || group.rtspPubSession != nil || group.customizePubSession != nil ||
-		group.psPubSession != nil
+		group.psPubSession != nil || group.udpTsPubSession != nil
 }
 
 func (group *Group) hasSubSession() bool {
@@ -550,7 +625,7 @@ func (group *Group) hasSubSession() bool {
 
 func (group *Group) hasPushSession() bool {
 	for _, item := range group.url2PushProxy {
-		if item.isPushing && item.pushSession != nil {
+		if item.session != nil {
 			return true
 		}
 	}
@@ -581,17 +656,21 @@ func (group *Group) inSessionUniqueKey() string {
 	if group.psPubSession != nil {
 		return group.psPubSession.UniqueKey()
 	}
+	if group.udpTsPubSession != nil {
+		return group.udpTsPubSession.UniqueKey()
+	}
 	return group.pullSessionUniqueKey()
 }
 
 func (group *Group) shouldStartRtspRemuxer() bool {
-	return group.config.RtspConfig.Enable || group.config.RtspConfig.RtspsEnable
+	return group.config.RtspConfig.Enable || group.config.RtspConfig.RtspsEnable || group.hasRtspPushProxy()
 }
 
 func (group *Group) shouldStartMpegtsRemuxer() bool {
-	return (group.config.HlsConfig.Enable || group.config.HlsConfig.EnableHttps) ||
+	return ((group.config.HlsConfig.Enable || group.config.HlsConfig.EnableHttps) && !group.config.HlsConfig.IsFmp4()) ||
 		(group.config.HttptsConfig.Enable || group.config.HttptsConfig.EnableHttps) ||
-		group.config.RecordConfig.EnableMpegts
+		group.config.RecordConfig.EnableMpegts ||
+		len(group.udpTsOutEntries()) != 0
 }
 
 func (group *Group) OnHlsMakeTs(info base.HlsMakeTsInfo) {
@@ -58,6 +58,9 @@ func (group *Group) OnSdp(sdpCtx sdp.LogicContext) {
 	defer group.mutex.Unlock()
 	group.sdpCtx = &sdpCtx
 	group.feedWaitRtspSubSessions()
+	if group.rtspMulticastSender != nil {
+		group.rtspMulticastSender.OnSdp(sdpCtx)
+	}
 	if group.rtsp2RtmpRemuxer != nil {
 		group.rtsp2RtmpRemuxer.OnSdp(sdpCtx)
 	}
@@ -125,6 +128,10 @@ func (group *Group) OnPatPmt(b []byte) {
 			Log.Errorf("[%s] record mpegts write fragment header error. err=%+v", group.UniqueKey, err)
 		}
 	}
+
+	for _, sender := range group.udpTsSenders {
+		sender.FeedPatPmt(b)
+	}
 }
 
 // OnTsPackets ...
@@ -155,6 +162,9 @@ func (group *Group) onRtmpMsgFromRemux(msg base.RtmpMsg) {
 func (group *Group) onSdpFromRemux(sdpCtx sdp.LogicContext) {
 	group.sdpCtx = &sdpCtx
 	group.feedWaitRtspSubSessions()
+	if group.rtspMulticastSender != nil {
+		group.rtspMulticastSender.OnSdp(sdpCtx)
+	}
 }
 
 // onRtpPacketFromRemux ...
@@ -235,6 +245,11 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 		group.rtmp2MpegtsRemuxer.FeedRtmpMessage(msg)
 	}
 
+	// # hls fmp4模式，直接使用rtmp数据，不经过mpegts remuxer
+	if group.hlsMuxer != nil && group.config.HlsConfig.IsFmp4() {
+		group.hlsMuxer.FeedRtmpMsg(msg)
+	}
+
 	// # rtsp
 	if group.rtmp2RtspRemuxer != nil {
 		group.rtmp2RtspRemuxer.FeedRtmpMsg(msg)
@@ -306,33 +321,32 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 	}
 
 	// TODO chef: rtmp sub, rtmp push, httpflv sub 的发送逻辑都差不多，可以考虑封装一下
-	if group.pushEnable {
-		for _, v := range group.url2PushProxy {
-			if v.pushSession == nil {
-				continue
-			}
+	for _, v := range group.url2PushProxy {
+		pushSession := v.rtmpSession
+		if pushSession == nil {
+			continue
+		}
 
-			if v.pushSession.IsFresh {
-				if group.rtmpGopCache.MetadataEnsureWithSetDataFrame != nil {
-					_ = v.pushSession.Write(group.rtmpGopCache.MetadataEnsureWithSetDataFrame)
-				}
-				if group.rtmpGopCache.VideoSeqHeader != nil {
-					_ = v.pushSession.Write(group.rtmpGopCache.VideoSeqHeader)
-				}
-				if group.rtmpGopCache.AacSeqHeader != nil {
-					_ = v.pushSession.Write(group.rtmpGopCache.AacSeqHeader)
-				}
-				for i := 0; i < group.rtmpGopCache.GetGopCount(); i++ {
-					for _, item := range group.rtmpGopCache.GetGopDataAt(i) {
-						_ = v.pushSession.Write(item)
-					}
+		if pushSession.IsFresh {
+			if group.rtmpGopCache.MetadataEnsureWithSetDataFrame != nil {
+				_ = pushSession.Write(group.rtmpGopCache.MetadataEnsureWithSetDataFrame)
+			}
+			if group.rtmpGopCache.VideoSeqHeader != nil {
+				_ = pushSession.Write(group.rtmpGopCache.VideoSeqHeader)
+			}
+			if group.rtmpGopCache.AacSeqHeader != nil {
+				_ = pushSession.Write(group.rtmpGopCache.AacSeqHeader)
+			}
+			for i := 0; i < group.rtmpGopCache.GetGopCount(); i++ {
+				for _, item := range group.rtmpGopCache.GetGopDataAt(i) {
+					_ = pushSession.Write(item)
 				}
-
-				v.pushSession.IsFresh = false
 			}
 
-			_ = v.pushSession.Write(lazyRtmpChunkDivider.GetEnsureWithSdf())
+			pushSession.IsFresh = false
 		}
+
+		_ = pushSession.Write(lazyRtmpChunkDivider.GetEnsureWithSdf())
 	}
 
 	// # 广播。遍历所有 httpflv sub session，转发数据
@@ -379,6 +393,17 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 		}
 	}
 
+	// # 录制mp4文件
+	if group.recordMp4 != nil {
+		group.recordMp4.FeedRtmpMsg(msg)
+	}
+
+	// # 按需开启的录制
+	group.feedRecords(msg)
+
+	// # 缓存最近的关键帧，用于snapshot
+	group.feedSnapshot(msg)
+
 	// # 缓存关键信息，以及gop
 	if group.config.RtmpConfig.Enable || group.config.RtmpConfig.RtmpsEnable {
 		if !group.rtmpGopCache.Feed(msg, lazyRtmpChunkDivider.GetEnsureWithoutSdf()) {
@@ -460,6 +485,13 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (group *Group) feedRtpPacket(pkt rtprtcp.RtpPacket) {
+	group.feedRtpPacket2RelayPush(pkt)
+
+	// 组播只需要发送一份，是否等待视频关键帧由 rtsp.MulticastSender 自己处理
+	if group.rtspMulticastSender != nil {
+		group.rtspMulticastSender.WriteRtpPacket(pkt)
+	}
+
 	// 如果配置项 OutWaitKeyFrameFlag 为false，则音频和视频都直接发送。（音频和视频都不等待视频关键帧，都不等待任何数据）
 	if !group.config.RtspConfig.OutWaitKeyFrameFlag {
 		for s := range group.rtspSubSessionSet {
@@ -485,15 +517,7 @@ func (group *Group) feedRtpPacket(pkt rtprtcp.RtpPacket) {
 		}
 
 		if !boundaryChecked {
-			switch group.sdpCtx.GetVideoPayloadTypeBase() {
-			case base.AvPacketPtAvc:
-				boundary = rtprtcp.IsAvcBoundary(pkt)
-			case base.AvPacketPtHevc:
-				boundary = rtprtcp.IsHevcBoundary(pkt)
-			default:
-				// 注意，不是avc和hevc时，直接发送
-				boundary = true
-			}
+			boundary = group.isRtpVideoBoundary(pkt)
 			boundaryChecked = true
 		}
 
@@ -504,11 +528,22 @@ func (group *Group) feedRtpPacket(pkt rtprtcp.RtpPacket) {
 	}
 }
 
+// isRtpVideoBoundary 是否是视频GOP起始位置，不是avc和hevc时总是返回true
+func (group *Group) isRtpVideoBoundary(pkt rtprtcp.RtpPacket) bool {
+	switch group.sdpCtx.GetVideoPayloadTypeBase() {
+	case base.AvPacketPtAvc:
+		return rtprtcp.IsAvcBoundary(pkt)
+	case base.AvPacketPtHevc:
+		return rtprtcp.IsHevcBoundary(pkt)
+	}
+	return true
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (group *Group) feedTsPackets(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
 	// 注意，hls的处理放在前面，让hls先判断是否打开新的fragment并flush audio
-	if group.hlsMuxer != nil {
+	if group.hlsMuxer != nil && !group.config.HlsConfig.IsFmp4() {
 		group.hlsMuxer.FeedMpegts(tsPackets, frame, boundary)
 	}
 
@@ -556,6 +591,10 @@ func (group *Group) feedTsPackets(tsPackets []byte, frame *mpegts.Frame, boundar
 		}
 	}
 
+	for _, sender := range group.udpTsSenders {
+		sender.FeedTsPackets(tsPackets, frame, boundary)
+	}
+
 	group.httptsGopCache.Feed(tsPackets, boundary)
 }
 
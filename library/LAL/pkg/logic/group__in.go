@@ -10,6 +10,7 @@ package logic
 
 import (
 	"live-server/library/LAL/pkg/gb28181"
+	"live-server/library/LAL/pkg/udpts"
 	"time"
 
 	"live-server/library/naza/pkg/nazalog"
@@ -160,6 +161,60 @@ func (group *Group) StartRtpPub(req base.ApiCtrlStartRtpPubReq) (ret base.ApiCtr
 	return
 }
 
+// StartUdpTsPub 接收udp单播或组播的mpegts流，作为输入流
+func (group *Group) StartUdpTsPub(req base.ApiCtrlStartUdpTsPubReq) (ret base.ApiCtrlStartUdpTsPubResp) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if group.hasInSession() {
+		Log.Errorf("[%s] in stream already exist at group. start udp ts pub, exist=%s",
+			group.UniqueKey, group.inSessionUniqueKey())
+		ret.ErrorCode = base.ErrorCodeStartUdpTsPubFail
+		ret.Desp = base.ErrDupInStream.Error()
+		return
+	}
+
+	pubSession := udpts.NewPubSession(func(option *udpts.PubSessionOption) {
+		option.Addr = req.Addr
+		option.Interface = req.Interface
+	}).WithStreamName(req.StreamName)
+	if err := pubSession.Listen(); err != nil {
+		Log.Errorf("[%s] [%s] udp ts PubSession listen failed. addr=%s, err=%+v",
+			group.UniqueKey, pubSession.UniqueKey(), req.Addr, err)
+		ret.ErrorCode = base.ErrorCodeStartUdpTsPubFail
+		ret.Desp = err.Error()
+		return
+	}
+
+	Log.Debugf("[%s] [%s] add udp ts PubSession into group.", group.UniqueKey, pubSession.UniqueKey())
+
+	group.udpTsPubSession = pubSession
+	group.udpTsPubTimeoutSec = uint32(req.TimeoutMs / 1000)
+	group.udpTsPubPrevInactiveCheckTick = -1
+	group.addIn()
+
+	if group.shouldStartRtspRemuxer() {
+		group.rtmp2RtspRemuxer = remux.NewRtmp2RtspRemuxer(
+			group.onSdpFromRemux,
+			group.onRtpPacketFromRemux,
+		)
+	}
+
+	pubSession.WithOnRtmpMsg(group.OnReadRtmpAvMsg)
+	go func() {
+		runErr := pubSession.RunLoop()
+		Log.Debugf("[%s] [%s] udp ts PubSession run loop exit, err=%v", group.UniqueKey, pubSession.UniqueKey(), runErr)
+		group.DelUdpTsPubSession(pubSession)
+	}()
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data.StreamName = pubSession.StreamName()
+	ret.Data.SessionId = pubSession.UniqueKey()
+	ret.Data.LocalAddr = pubSession.LocalAddr()
+	return
+}
+
 func (group *Group) AddRtmpPullSession(session *rtmp.PullSession) error {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
@@ -227,6 +282,43 @@ func (group *Group) AddRtspPullSession(session *rtsp.PullSession) error {
 	return nil
 }
 
+// AddHttpPullSession httpflv、httpts、hls的pull session，数据都通过 OnReadRtmpAvMsg 进入group
+func (group *Group) AddHttpPullSession(session base.IClientSession) error {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if group.hasInSession() {
+		Log.Errorf("[%s] in stream already exist. wanna add=%s", group.UniqueKey, session.UniqueKey())
+		return base.ErrDupInStream
+	}
+
+	Log.Debugf("[%s] [%s] add PullSession into group.", group.UniqueKey, session.UniqueKey())
+
+	group.setHttpPullSession(session)
+	group.addIn()
+
+	if group.shouldStartRtspRemuxer() {
+		group.rtmp2RtspRemuxer = remux.NewRtmp2RtspRemuxer(
+			group.onSdpFromRemux,
+			group.onRtpPacketFromRemux,
+		)
+	}
+
+	var info base.PullStartInfo
+	info.SessionId = session.UniqueKey()
+	info.Url = session.Url()
+	info.Protocol = session.GetStat().Protocol
+	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.AppName = session.AppName()
+	info.StreamName = session.StreamName()
+	info.UrlParam = session.RawQuery()
+	info.HasInSession = group.hasInSession()
+	info.HasOutSession = group.hasOutSession()
+	group.observer.OnRelayPullStart(info)
+
+	return nil
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (group *Group) DelPsPubSession(session *gb28181.PubSession) {
@@ -235,6 +327,12 @@ func (group *Group) DelPsPubSession(session *gb28181.PubSession) {
 	group.delPsPubSession(session)
 }
 
+func (group *Group) DelUdpTsPubSession(session *udpts.PubSession) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.delUdpTsPubSession(session)
+}
+
 func (group *Group) DelCustomizePubSession(sessionCtx ICustomizePubSessionContext) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
@@ -289,6 +387,24 @@ func (group *Group) DelRtspPullSession(session *rtsp.PullSession) {
 	group.observer.OnRelayPullStop(info)
 }
 
+func (group *Group) DelHttpPullSession(session base.IClientSession) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.delPullSession(session)
+
+	var info base.PullStopInfo
+	info.SessionId = session.UniqueKey()
+	info.Url = session.Url()
+	info.Protocol = session.GetStat().Protocol
+	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.AppName = session.AppName()
+	info.StreamName = session.StreamName()
+	info.UrlParam = session.RawQuery()
+	info.HasInSession = group.hasInSession()
+	info.HasOutSession = group.hasOutSession()
+	group.observer.OnRelayPullStop(info)
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (group *Group) delPsPubSession(session *gb28181.PubSession) {
@@ -303,6 +419,18 @@ func (group *Group) delPsPubSession(session *gb28181.PubSession) {
 	group.delIn()
 }
 
+func (group *Group) delUdpTsPubSession(session *udpts.PubSession) {
+	Log.Debugf("[%s] [%s] del udp ts PubSession from group.", group.UniqueKey, session.UniqueKey())
+
+	if session != group.udpTsPubSession {
+		Log.Warnf("[%s] del udp ts pub session but not match. del session=%s, group session=%p",
+			group.UniqueKey, session.UniqueKey(), group.udpTsPubSession)
+		return
+	}
+
+	group.delIn()
+}
+
 func (group *Group) delCustomizePubSession(sessionCtx ICustomizePubSessionContext) {
 	Log.Debugf("[%s] [%s] del customize PubSession from group.", group.UniqueKey, sessionCtx.UniqueKey())
 
@@ -370,6 +498,8 @@ func (group *Group) addIn() {
 	group.startHlsIfNeeded()
 	group.startRecordFlvIfNeeded(now)
 	group.startRecordMpegtsIfNeeded(now)
+	group.startRecordMp4IfNeeded()
+	group.startUdpTsOutIfNeeded()
 }
 
 // delIn 有pub或pull的输入型session离开时，需要调用该函数
@@ -389,11 +519,16 @@ func (group *Group) delIn() {
 	group.stopHlsIfNeeded()
 	group.stopRecordFlvIfNeeded()
 	group.stopRecordMpegtsIfNeeded()
+	group.stopRecordMp4IfNeeded()
+	group.stopAllRecords()
+	group.clearSnapshot()
+	group.stopUdpTsOutIfNeeded()
 
 	group.rtmpPubSession = nil
 	group.rtspPubSession = nil
 	group.customizePubSession = nil
 	group.psPubSession = nil
+	group.udpTsPubSession = nil
 	group.rtsp2RtmpRemuxer = nil
 	group.rtmp2RtspRemuxer = nil
 	group.dummyAudioFilter = nil
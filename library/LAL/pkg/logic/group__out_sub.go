@@ -80,6 +80,18 @@ func (group *Group) HandleNewRtspSubSessionDescribe(session *rtsp.SubSession) (o
 
 	group.rtspSubSessionSet[session] = struct{}{}
 
+	if group.option.rtspMulticastAddrPool != nil {
+		if group.rtspMulticastSender == nil {
+			multicastConfig := group.config.RtspConfig.MulticastConfig
+			group.rtspMulticastSender = rtsp.NewMulticastSender(group.UniqueKey, group.option.rtspMulticastAddrPool, func(option *rtsp.MulticastSenderOption) {
+				option.Interface = multicastConfig.Interface
+				option.Ttl = multicastConfig.Ttl
+				option.WaitKeyFrame = group.config.RtspConfig.OutWaitKeyFrameFlag
+			})
+		}
+		session.WithMulticastSender(group.rtspMulticastSender)
+	}
+
 	if group.sdpCtx == nil {
 		Log.Warnf("[%s] [%s] rtsp subSession describe but sdp not exist.", group.UniqueKey, session.UniqueKey())
 
@@ -0,0 +1,47 @@
+package logic
+
+import (
+	"live-server/library/LAL/pkg/udpts"
+)
+
+// group__out_udpts.go
+//
+// 按配置文件中的 udp_ts_out ，将流的mpegts数据通过udp发送出去，数据来自 feedTsPackets
+//
+
+// udpTsOutEntries 配置中属于当前流的发送目标
+func (group *Group) udpTsOutEntries() (entries []UdpTsOutEntry) {
+	if !group.config.UdpTsOutConfig.Enable {
+		return nil
+	}
+	for _, entry := range group.config.UdpTsOutConfig.Outs {
+		if entry.StreamName == group.streamName {
+			entries = append(entries, entry)
+		}
+	}
+	return
+}
+
+func (group *Group) startUdpTsOutIfNeeded() {
+	for _, entry := range group.udpTsOutEntries() {
+		entry := entry
+		sender := udpts.NewSender(group.UniqueKey, func(option *udpts.SenderOption) {
+			option.Addr = entry.Addr
+			option.Interface = entry.Interface
+			option.Ttl = entry.Ttl
+			option.RtpEnable = entry.RtpEnable
+		})
+		if err := sender.Start(); err != nil {
+			Log.Errorf("[%s] start udp ts out failed. addr=%s, err=%+v", group.UniqueKey, entry.Addr, err)
+			continue
+		}
+		group.udpTsSenders = append(group.udpTsSenders, sender)
+	}
+}
+
+func (group *Group) stopUdpTsOutIfNeeded() {
+	for _, sender := range group.udpTsSenders {
+		sender.Dispose()
+	}
+	group.udpTsSenders = nil
+}
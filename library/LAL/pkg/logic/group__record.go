@@ -0,0 +1,486 @@
+package logic
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/httpflv"
+	"live-server/library/LAL/pkg/mpegts"
+	"live-server/library/LAL/pkg/remux"
+)
+
+// group__record.go
+//
+// 通过http api按需开启的录制，和配置文件中开启的录制相互独立。
+//
+// 按需开启的录制可能从流的中间开始，所以 Group 缓存了最近的metadata以及音视频seq header，
+// 开启录制时先将它们输入给录制模块，录制模块再从下一个关键帧开始写入音视频数据。
+// 流结束时，按需开启的录制也随之结束。
+//
+
+type iRecorder interface {
+	FeedRtmpMsg(msg base.RtmpMsg)
+	Dispose()
+	Filename() string
+}
+
+type onDemandRecord struct {
+	format         string
+	recorder       iRecorder
+	startTime      time.Time
+	maxDurationSec int
+}
+
+func (r *onDemandRecord) stat() base.StatRecord {
+	return base.StatRecord{
+		Format:         r.format,
+		Filename:       r.recorder.Filename(),
+		OnDemand:       true,
+		StartTime:      r.startTime.Format("2006-01-02 15:04:05.999"),
+		MaxDurationSec: r.maxDurationSec,
+	}
+}
+
+// StartRecord 按需开启录制，每种格式同时只能有一个按需开启的录制
+func (group *Group) StartRecord(info base.ApiCtrlStartRecordReq) (base.StatRecord, error) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if !group.hasInSession() {
+		return base.StatRecord{}, base.ErrRecordNoInSession
+	}
+	if group.recordSuspendReason != "" {
+		return base.StatRecord{}, base.ErrRecordSuspended
+	}
+	if _, ok := group.onDemandRecords[info.Format]; ok {
+		return base.StatRecord{}, base.ErrRecordExist
+	}
+
+	now := time.Now()
+	c := group.config.RecordConfig
+	var recorder iRecorder
+	switch info.Format {
+	case base.RecordFormatFlv:
+		filename := uniqueFilename(filepath.Join(c.FlvOutPath, fmt.Sprintf("%s-%d.flv", group.streamName, now.Unix())))
+		r, err := newFlvRecorder(group.UniqueKey, group.appName, group.streamName, filename, group.onRecordSegment)
+		if err != nil {
+			return base.StatRecord{}, err
+		}
+		recorder = r
+	case base.RecordFormatMpegts:
+		filename := uniqueFilename(filepath.Join(c.MpegtsOutPath, fmt.Sprintf("%s-%d.ts", group.streamName, now.Unix())))
+		r, err := newMpegtsRecorder(group.UniqueKey, group.appName, group.streamName, filename, group.onRecordSegment)
+		if err != nil {
+			return base.StatRecord{}, err
+		}
+		recorder = r
+	case base.RecordFormatMp4:
+		recorder = newMp4Recorder(group.UniqueKey, group.appName, group.streamName, mp4RecorderConfig{
+			outPath:          c.Mp4OutPath,
+			filenameTemplate: c.Mp4FilenameTemplate,
+			segmentDuration:  time.Duration(c.Mp4SegmentDurationSec) * time.Second,
+			segmentMaxSize:   int64(c.Mp4SegmentMaxSizeMb) * 1024 * 1024,
+		}, group.onRecordSegment)
+	default:
+		return base.StatRecord{}, base.ErrRecordUnsupportedFormat
+	}
+
+	group.seqHeaderCache.replay(recorder.FeedRtmpMsg)
+
+	record := &onDemandRecord{
+		format:         info.Format,
+		recorder:       recorder,
+		startTime:      now,
+		maxDurationSec: info.MaxDurationSec,
+	}
+	group.onDemandRecords[info.Format] = record
+	Log.Infof("[%s] start record. format=%s, max duration=%d", group.UniqueKey, info.Format, info.MaxDurationSec)
+	return record.stat(), nil
+}
+
+// StopRecord 停止按需开启的录制
+//
+// @param format: 为空时停止所有按需开启的录制
+//
+// @return 被停止的录制
+func (group *Group) StopRecord(format string) ([]base.StatRecord, error) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	var ret []base.StatRecord
+	for _, f := range group.sortedOnDemandRecordFormats() {
+		if format != "" && format != f {
+			continue
+		}
+		ret = append(ret, group.stopRecord(f))
+	}
+	if len(ret) == 0 {
+		return nil, base.ErrRecordNotFound
+	}
+	return ret, nil
+}
+
+func (group *Group) stopRecord(format string) base.StatRecord {
+	record := group.onDemandRecords[format]
+	stat := record.stat()
+	record.recorder.Dispose()
+	delete(group.onDemandRecords, format)
+	Log.Infof("[%s] stop record. format=%s", group.UniqueKey, format)
+	return stat
+}
+
+func (group *Group) stopAllRecords() {
+	for format := range group.onDemandRecords {
+		group.stopRecord(format)
+	}
+	group.seqHeaderCache = rtmpSeqHeaderCache{}
+}
+
+// SuspendRecord 停止所有正在进行的录制，包括配置文件中开启的和按需开启的，并且在 ResumeRecord 之前不再开启新的录制
+//
+// 每个被停止的录制都会通过 IGroupObserver.OnRecordError 通知上层。
+// 注意，hls同时用于直播，所以不会被停止
+func (group *Group) SuspendRecord(reason string) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if group.recordSuspendReason != "" {
+		return
+	}
+	group.recordSuspendReason = reason
+	Log.Warnf("[%s] suspend record. reason=%s", group.UniqueKey, reason)
+
+	// 注意，统计信息需要在停止前获取，停止后文件名就拿不到了
+	stats := group.statRecords()
+	if group.recordFlv != nil {
+		_ = group.recordFlv.Dispose()
+		group.recordFlv = nil
+	}
+	if group.recordMpegts != nil {
+		_ = group.recordMpegts.Dispose()
+		group.recordMpegts = nil
+	}
+	group.stopRecordMp4IfNeeded()
+	for format := range group.onDemandRecords {
+		group.stopRecord(format)
+	}
+
+	for _, stat := range stats {
+		group.observer.OnRecordError(base.RecordErrorInfo{
+			AppName:    group.appName,
+			StreamName: group.streamName,
+			Format:     stat.Format,
+			Filename:   stat.Filename,
+			Reason:     reason,
+		})
+	}
+}
+
+// ResumeRecord 恢复录制，之后的输入流以及按需开启的录制可以正常录制
+//
+// 注意，被 SuspendRecord 停止的录制不会自动恢复
+func (group *Group) ResumeRecord() {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if group.recordSuspendReason == "" {
+		return
+	}
+	group.recordSuspendReason = ""
+	Log.Infof("[%s] resume record.", group.UniqueKey)
+}
+
+func (group *Group) isRecordSuspended(format string) bool {
+	if group.recordSuspendReason == "" {
+		return false
+	}
+	Log.Warnf("[%s] record is suspended, skip. format=%s, reason=%s", group.UniqueKey, format, group.recordSuspendReason)
+	return true
+}
+
+// recordFiles 正在写入的录制文件，以及hls的输出目录
+func (group *Group) recordFiles() []string {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	var ret []string
+	for _, stat := range group.statRecords() {
+		if stat.Filename != "" {
+			ret = append(ret, stat.Filename)
+		}
+	}
+	if group.hlsMuxer != nil {
+		ret = append(ret, group.hlsMuxer.OutPath())
+	}
+	return ret
+}
+
+// stopExpiredRecords 定时检查，停止达到最长录制时间的录制
+func (group *Group) stopExpiredRecords() {
+	now := time.Now()
+	for format, record := range group.onDemandRecords {
+		if record.maxDurationSec > 0 && now.Sub(record.startTime) >= time.Duration(record.maxDurationSec)*time.Second {
+			group.stopRecord(format)
+		}
+	}
+}
+
+func (group *Group) feedRecords(msg base.RtmpMsg) {
+	group.seqHeaderCache.feed(msg)
+	for _, record := range group.onDemandRecords {
+		record.recorder.FeedRtmpMsg(msg)
+	}
+}
+
+// statRecords 包含配置文件中开启的录制和按需开启的录制
+func (group *Group) statRecords() []base.StatRecord {
+	var ret []base.StatRecord
+	if group.recordFlv != nil {
+		ret = append(ret, base.StatRecord{Format: base.RecordFormatFlv, Filename: group.recordFlv.Name()})
+	}
+	if group.recordMpegts != nil {
+		ret = append(ret, base.StatRecord{Format: base.RecordFormatMpegts, Filename: group.recordMpegts.Name()})
+	}
+	if group.recordMp4 != nil {
+		ret = append(ret, base.StatRecord{Format: base.RecordFormatMp4, Filename: group.recordMp4.Filename()})
+	}
+	for _, format := range group.sortedOnDemandRecordFormats() {
+		ret = append(ret, group.onDemandRecords[format].stat())
+	}
+	return ret
+}
+
+func (group *Group) sortedOnDemandRecordFormats() []string {
+	formats := make([]string, 0, len(group.onDemandRecords))
+	for format := range group.onDemandRecords {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// rtmpSeqHeaderCache 缓存最近的metadata以及音视频seq header
+type rtmpSeqHeaderCache struct {
+	metadata       *base.RtmpMsg
+	videoSeqHeader *base.RtmpMsg
+	aacSeqHeader   *base.RtmpMsg
+}
+
+func (c *rtmpSeqHeaderCache) feed(msg base.RtmpMsg) {
+	switch {
+	case msg.Header.MsgTypeId == base.RtmpTypeIdMetadata:
+		m := msg.Clone()
+		c.metadata = &m
+	case msg.IsVideoKeySeqHeader():
+		m := msg.Clone()
+		c.videoSeqHeader = &m
+	case msg.IsAacSeqHeader():
+		m := msg.Clone()
+		c.aacSeqHeader = &m
+	}
+}
+
+func (c *rtmpSeqHeaderCache) replay(fn func(msg base.RtmpMsg)) {
+	for _, msg := range []*base.RtmpMsg{c.metadata, c.videoSeqHeader, c.aacSeqHeader} {
+		if msg != nil {
+			fn(*msg)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// flvRecorder 按需开启的flv录制，写入metadata和seq header后，从第一个关键帧开始写入音视频数据
+type flvRecorder struct {
+	uniqueKey  string
+	appName    string
+	streamName string
+	onSegment  func(info base.RecordSegmentInfo)
+
+	fw        httpflv.FlvFileWriter
+	filename  string
+	startTime time.Time
+	size      int64
+
+	hasVideo bool
+	started  bool
+	firstTs  uint32
+	lastTs   uint32
+}
+
+func newFlvRecorder(uniqueKey, appName, streamName, filename string, onSegment func(info base.RecordSegmentInfo)) (*flvRecorder, error) {
+	r := &flvRecorder{
+		uniqueKey:  uniqueKey,
+		appName:    appName,
+		streamName: streamName,
+		onSegment:  onSegment,
+		filename:   filename,
+		startTime:  time.Now(),
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return nil, err
+	}
+	if err := r.fw.Open(filename); err != nil {
+		return nil, err
+	}
+	if err := r.fw.WriteFlvHeader(); err != nil {
+		_ = r.fw.Dispose()
+		return nil, err
+	}
+	r.size = int64(len(httpflv.FlvHeader))
+	return r, nil
+}
+
+func (r *flvRecorder) FeedRtmpMsg(msg base.RtmpMsg) {
+	var lazyRtmpMsg2FlvTag remux.LazyRtmpMsg2FlvTag
+	lazyRtmpMsg2FlvTag.Init(msg)
+
+	switch {
+	case msg.Header.MsgTypeId == base.RtmpTypeIdMetadata || msg.IsAacSeqHeader():
+	case msg.IsVideoKeySeqHeader():
+		r.hasVideo = true
+	default:
+		if !r.started {
+			// 有视频时从关键帧开始，没有视频时从第一个音频帧开始
+			if msg.IsVideoKeyNalu() || (!r.hasVideo && msg.Header.MsgTypeId == base.RtmpTypeIdAudio) {
+				r.started = true
+				r.firstTs = msg.Header.TimestampAbs
+			} else {
+				return
+			}
+		}
+		if msg.Header.TimestampAbs > r.lastTs {
+			r.lastTs = msg.Header.TimestampAbs
+		}
+	}
+
+	b := lazyRtmpMsg2FlvTag.GetEnsureWithoutSdf()
+	if err := r.fw.WriteRaw(b); err != nil {
+		Log.Errorf("[%s] record flv write error. err=%+v", r.uniqueKey, err)
+		return
+	}
+	r.size += int64(len(b))
+}
+
+func (r *flvRecorder) Dispose() {
+	if err := r.fw.Dispose(); err != nil {
+		Log.Errorf("[%s] record flv close file failed. filename=%s, err=%+v", r.uniqueKey, r.filename, err)
+	}
+	if r.onSegment != nil {
+		r.onSegment(base.RecordSegmentInfo{
+			AppName:    r.appName,
+			StreamName: r.streamName,
+			Format:     base.RecordFormatFlv,
+			Filename:   r.filename,
+			StartTime:  r.startTime.Format("2006-01-02 15:04:05.999"),
+			DurationMs: int64(r.lastTs - r.firstTs),
+			Size:       r.size,
+		})
+	}
+}
+
+func (r *flvRecorder) Filename() string {
+	return r.filename
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// mpegtsRecorder 按需开启的mpegts录制，使用独立的 remux.Rtmp2MpegtsRemuxer ，从第一个边界处开始写入
+type mpegtsRecorder struct {
+	uniqueKey  string
+	appName    string
+	streamName string
+	onSegment  func(info base.RecordSegmentInfo)
+
+	remuxer   *remux.Rtmp2MpegtsRemuxer
+	fw        mpegts.FileWriter
+	filename  string
+	startTime time.Time
+	size      int64
+
+	patpmt  []byte
+	started bool
+	firstTs uint64 // 单位（毫秒*90）
+	lastTs  uint64
+}
+
+func newMpegtsRecorder(uniqueKey, appName, streamName, filename string, onSegment func(info base.RecordSegmentInfo)) (*mpegtsRecorder, error) {
+	r := &mpegtsRecorder{
+		uniqueKey:  uniqueKey,
+		appName:    appName,
+		streamName: streamName,
+		onSegment:  onSegment,
+		filename:   filename,
+		startTime:  time.Now(),
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return nil, err
+	}
+	if err := r.fw.Create(filename); err != nil {
+		return nil, err
+	}
+	r.remuxer = remux.NewRtmp2MpegtsRemuxer(r)
+	return r, nil
+}
+
+func (r *mpegtsRecorder) FeedRtmpMsg(msg base.RtmpMsg) {
+	r.remuxer.FeedRtmpMessage(msg)
+}
+
+func (r *mpegtsRecorder) Dispose() {
+	r.remuxer.Dispose()
+	if err := r.fw.Dispose(); err != nil {
+		Log.Errorf("[%s] record mpegts close file failed. filename=%s, err=%+v", r.uniqueKey, r.filename, err)
+	}
+	if r.onSegment != nil {
+		r.onSegment(base.RecordSegmentInfo{
+			AppName:    r.appName,
+			StreamName: r.streamName,
+			Format:     base.RecordFormatMpegts,
+			Filename:   r.filename,
+			StartTime:  r.startTime.Format("2006-01-02 15:04:05.999"),
+			DurationMs: int64(r.lastTs-r.firstTs) / 90,
+			Size:       r.size,
+		})
+	}
+}
+
+func (r *mpegtsRecorder) Filename() string {
+	return r.filename
+}
+
+func (r *mpegtsRecorder) OnPatPmt(b []byte) {
+	r.patpmt = b
+	if r.started {
+		r.write(b)
+	}
+}
+
+func (r *mpegtsRecorder) OnTsPackets(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
+	if !r.started {
+		if !boundary || r.patpmt == nil {
+			return
+		}
+		r.started = true
+		r.firstTs = frame.Dts
+		r.write(r.patpmt)
+	}
+	if frame.Dts > r.lastTs {
+		r.lastTs = frame.Dts
+	}
+	r.write(tsPackets)
+}
+
+func (r *mpegtsRecorder) write(b []byte) {
+	if err := r.fw.Write(b); err != nil {
+		Log.Errorf("[%s] record mpegts write error. err=%+v", r.uniqueKey, err)
+		return
+	}
+	r.size += int64(len(b))
+}
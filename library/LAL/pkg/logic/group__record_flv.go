@@ -12,12 +12,13 @@ import (
 	"fmt"
 	"path/filepath"
 
+	"live-server/library/LAL/pkg/base"
 	"live-server/library/LAL/pkg/httpflv"
 )
 
 // startRecordFlvIfNeeded 必要时开启flv录制
 func (group *Group) startRecordFlvIfNeeded(nowUnix int64) {
-	if !group.config.RecordConfig.EnableFlv {
+	if !group.config.RecordConfig.EnableFlv || group.isRecordSuspended(base.RecordFormatFlv) {
 		return
 	}
 
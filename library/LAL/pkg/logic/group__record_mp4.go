@@ -0,0 +1,298 @@
+package logic
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/fmp4"
+	"live-server/library/naza/pkg/nazaerrors"
+)
+
+// startRecordMp4IfNeeded 必要时开启mp4录制
+func (group *Group) startRecordMp4IfNeeded() {
+	if !group.config.RecordConfig.EnableMp4 || group.isRecordSuspended(base.RecordFormatMp4) {
+		return
+	}
+
+	c := group.config.RecordConfig
+	group.recordMp4 = newMp4Recorder(group.UniqueKey, group.appName, group.streamName, mp4RecorderConfig{
+		outPath:          c.Mp4OutPath,
+		filenameTemplate: c.Mp4FilenameTemplate,
+		segmentDuration:  time.Duration(c.Mp4SegmentDurationSec) * time.Second,
+		segmentMaxSize:   int64(c.Mp4SegmentMaxSizeMb) * 1024 * 1024,
+	}, group.onRecordSegment)
+}
+
+func (group *Group) stopRecordMp4IfNeeded() {
+	if group.recordMp4 != nil {
+		group.recordMp4.Dispose()
+		group.recordMp4 = nil
+	}
+}
+
+func (group *Group) onRecordSegment(info base.RecordSegmentInfo) {
+	group.observer.OnRecordSegment(info)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type mp4RecorderConfig struct {
+	outPath          string
+	filenameTemplate string
+	segmentDuration  time.Duration // 0表示不按时长分段
+	segmentMaxSize   int64         // 0表示不按大小分段
+}
+
+// mp4AudioOnlyFragmentMs 没有视频时，每个fragment的最小时长
+const mp4AudioOnlyFragmentMs = 1000
+
+// mp4Recorder 将rtmp流录制成fragmented mp4文件
+//
+// 每个文件都从关键帧开始，达到时长或者大小的阈值后，在下一个关键帧处切换到新的文件。
+// 每个GOP写入一个fragment，所以进程异常退出时，最多丢失最后一个GOP的数据。
+// 没有视频时，每 mp4AudioOnlyFragmentMs 写入一个fragment。
+//
+// 非并发安全，由 Group 加锁后调用
+type mp4Recorder struct {
+	uniqueKey  string
+	appName    string
+	streamName string
+	config     mp4RecorderConfig
+	onSegment  func(info base.RecordSegmentInfo)
+
+	video      *fmp4.VideoTrack
+	audio      *fmp4.AudioTrack
+	trackDirty bool // 音视频的track信息发生了变化，需要在下一个关键帧切换到新的文件
+
+	fw        *fmp4.FileWriter
+	startTime time.Time
+	firstTs   int64 // 当前文件第一帧的时间戳，毫秒，写入文件的时间戳都减去该值
+	lastTs    int64
+	fragTs    int64 // 当前fragment第一帧的时间戳，毫秒
+}
+
+func newMp4Recorder(uniqueKey, appName, streamName string, config mp4RecorderConfig, onSegment func(info base.RecordSegmentInfo)) *mp4Recorder {
+	return &mp4Recorder{
+		uniqueKey:  uniqueKey,
+		appName:    appName,
+		streamName: streamName,
+		config:     config,
+		onSegment:  onSegment,
+	}
+}
+
+func (r *mp4Recorder) FeedRtmpMsg(msg base.RtmpMsg) {
+	switch {
+	case msg.IsVideoKeySeqHeader():
+		r.updateVideoTrack(msg)
+		return
+	case msg.IsAacSeqHeader():
+		r.updateAudioTrack(msg)
+		return
+	}
+
+	pkt, ok := fmp4.RtmpMsg2AvPacket(msg)
+	if !ok {
+		return
+	}
+
+	var boundary bool
+	if pkt.IsVideo() {
+		if r.video == nil || pkt.PayloadType != r.video.PayloadType {
+			return
+		}
+		boundary = msg.IsVideoKeyNalu()
+	} else {
+		if r.audio == nil {
+			return
+		}
+		// 没有视频时，音频帧都可以作为分段的边界，但是按最小时长写fragment，避免每个音频帧一个fragment
+		boundary = r.video == nil && (r.fw == nil || pkt.Timestamp-r.fragTs >= mp4AudioOnlyFragmentMs)
+	}
+
+	if boundary {
+		if r.fw != nil && r.shouldRotate(pkt.Timestamp) {
+			r.closeSegment(pkt.Timestamp)
+		}
+		if r.fw == nil {
+			r.openSegment(pkt.Timestamp)
+		} else if err := r.fw.Flush(); err != nil {
+			Log.Errorf("[%s] record mp4 write error. err=%+v", r.uniqueKey, err)
+		}
+		r.fragTs = pkt.Timestamp
+	}
+	if r.fw == nil {
+		// 还没有等到第一个关键帧
+		return
+	}
+
+	pkt.Timestamp -= r.firstTs
+	pkt.Pts -= r.firstTs
+	_ = r.fw.WriteAvPacket(pkt)
+	if ts := pkt.Timestamp + r.firstTs; ts > r.lastTs {
+		r.lastTs = ts
+	}
+}
+
+func (r *mp4Recorder) Dispose() {
+	if r.fw != nil {
+		r.closeSegment(r.lastTs)
+	}
+}
+
+// Filename 正在写入的文件，没有时返回空字符串
+func (r *mp4Recorder) Filename() string {
+	if r.fw == nil {
+		return ""
+	}
+	return r.fw.Name()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (r *mp4Recorder) shouldRotate(ts int64) bool {
+	if r.trackDirty {
+		return true
+	}
+	if r.config.segmentDuration > 0 && time.Duration(ts-r.firstTs)*time.Millisecond >= r.config.segmentDuration {
+		return true
+	}
+	return r.config.segmentMaxSize > 0 && r.fw.Size() >= r.config.segmentMaxSize
+}
+
+func (r *mp4Recorder) openSegment(ts int64) {
+	now := time.Now()
+	filename, err := recordFilename(r.config.outPath, r.config.filenameTemplate, r.appName, r.streamName, now)
+	if err != nil {
+		Log.Errorf("[%s] record mp4 invalid filename. err=%+v", r.uniqueKey, err)
+		return
+	}
+	filename = uniqueFilename(filename)
+	fw := &fmp4.FileWriter{}
+	if err := fw.Create(filename, r.video, r.audio); err != nil {
+		Log.Errorf("[%s] record mp4 open file failed. filename=%s, err=%+v", r.uniqueKey, filename, err)
+		return
+	}
+	Log.Infof("[%s] record mp4 open file. filename=%s", r.uniqueKey, filename)
+
+	r.fw = fw
+	r.trackDirty = false
+	r.startTime = now
+	r.firstTs = ts
+	r.lastTs = ts
+}
+
+// closeSegment
+//
+// @param endTs: 分段结束的时间戳，也即下一个分段第一帧的时间戳，用于计算分段的时长
+func (r *mp4Recorder) closeSegment(endTs int64) {
+	filename := r.fw.Name()
+	if err := r.fw.Dispose(); err != nil {
+		Log.Errorf("[%s] record mp4 close file failed. filename=%s, err=%+v", r.uniqueKey, filename, err)
+	}
+
+	info := base.RecordSegmentInfo{
+		AppName:    r.appName,
+		StreamName: r.streamName,
+		Format:     base.RecordFormatMp4,
+		Filename:   filename,
+		StartTime:  r.startTime.Format("2006-01-02 15:04:05.999"),
+		DurationMs: endTs - r.firstTs,
+		Size:       r.fw.Size(),
+	}
+	r.fw = nil
+	Log.Infof("[%s] record mp4 segment done. info=%+v", r.uniqueKey, info)
+	if r.onSegment != nil {
+		r.onSegment(info)
+	}
+}
+
+func (r *mp4Recorder) updateVideoTrack(msg base.RtmpMsg) {
+	track, err := fmp4.NewVideoTrackFromRtmpSeqHeader(msg)
+	if err != nil {
+		Log.Warnf("[%s] record mp4 parse video seq header failed. err=%+v", r.uniqueKey, err)
+		return
+	}
+	if r.video != nil && r.video.PayloadType == track.PayloadType && bytes.Equal(r.video.Record, track.Record) {
+		return
+	}
+	r.video = track
+	r.trackDirty = true
+}
+
+func (r *mp4Recorder) updateAudioTrack(msg base.RtmpMsg) {
+	track, err := fmp4.NewAudioTrackFromRtmpSeqHeader(msg)
+	if err != nil {
+		Log.Warnf("[%s] record mp4 parse aac seq header failed. err=%+v", r.uniqueKey, err)
+		return
+	}
+	if r.audio != nil && bytes.Equal(r.audio.Asc, track.Asc) {
+		return
+	}
+	r.audio = track
+	r.trackDirty = true
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// recordFilename 根据模板生成outPath下的录制文件名
+//
+// app和stream来自客户端，其中的路径分隔符以及".."会被替换，生成的文件名不在outPath下时返回错误
+func recordFilename(outPath, template, appName, streamName string, t time.Time) (string, error) {
+	filename := filepath.Join(outPath, expandRecordFilenameTemplate(template, sanitizeRecordPathElem(appName), sanitizeRecordPathElem(streamName), t))
+	if filename == filepath.Clean(outPath) || !isSubPath(outPath, filename) {
+		return "", nazaerrors.Wrap(base.ErrRecordPathNotAllowed, filename)
+	}
+	return filename, nil
+}
+
+// sanitizeRecordPathElem 将name转换为单个合法的路径元素
+func sanitizeRecordPathElem(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+// expandRecordFilenameTemplate 展开录制文件名模板
+//
+// 支持的变量：
+//
+//	{app}    {stream}
+//	{yyyy}   {mm}     {dd}
+//	{HH}     {MM}     {SS}     {HHMMSS}
+//	{unix}   unix时间戳，单位秒
+//
+// 例如 "{app}/{stream}/{yyyy}/{mm}/{dd}/{HHMMSS}.mp4" 展开为 "live/test110/2023/06/01/150405.mp4"
+func expandRecordFilenameTemplate(template, appName, streamName string, t time.Time) string {
+	return strings.NewReplacer(
+		"{app}", appName,
+		"{stream}", streamName,
+		"{yyyy}", fmt.Sprintf("%04d", t.Year()),
+		"{mm}", fmt.Sprintf("%02d", int(t.Month())),
+		"{dd}", fmt.Sprintf("%02d", t.Day()),
+		"{HHMMSS}", t.Format("150405"),
+		"{HH}", fmt.Sprintf("%02d", t.Hour()),
+		"{MM}", fmt.Sprintf("%02d", t.Minute()),
+		"{SS}", fmt.Sprintf("%02d", t.Second()),
+		"{unix}", fmt.Sprintf("%d", t.Unix()),
+	).Replace(template)
+}
+
+// uniqueFilename 文件已经存在时，在扩展名前加上序号，避免同一秒内多次分段时覆盖之前的文件
+func uniqueFilename(filename string) string {
+	ext := filepath.Ext(filename)
+	prefix := strings.TrimSuffix(filename, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			return filename
+		}
+		filename = fmt.Sprintf("%s-%d%s", prefix, i, ext)
+	}
+}
@@ -0,0 +1,135 @@
+package logic
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/naza/pkg/assert"
+)
+
+var goldenAvcSeqHeader = []byte{
+	0x17, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x64, 0x00, 0x20, 0xFF,
+	0xE1, 0x00, 0x19,
+	0x67, 0x64, 0x00, 0x20, 0xAC, 0xD9, 0x40, 0xC0, 0x29, 0xB0, 0x11, 0x00, 0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x03, 0x00, 0x32, 0x0F, 0x18, 0x31, 0x96,
+	0x01, 0x00, 0x05,
+	0x68, 0xEB, 0xEC, 0xB2, 0x2C,
+}
+
+func makeRtmpMsg(typeId uint8, ts uint32, payload []byte) base.RtmpMsg {
+	return base.RtmpMsg{
+		Header: base.RtmpHeader{
+			MsgTypeId:    typeId,
+			MsgLen:       uint32(len(payload)),
+			TimestampAbs: ts,
+		},
+		Payload: payload,
+	}
+}
+
+func TestExpandRecordFilenameTemplate(t *testing.T) {
+	now := time.Date(2023, 6, 1, 15, 4, 5, 0, time.Local)
+	assert.Equal(t, "live/test110/2023/06/01/150405.mp4",
+		expandRecordFilenameTemplate(defaultRecordMp4FilenameTemplate, "live", "test110", now))
+	assert.Equal(t, "test110-15_04_05-"+strconv.FormatInt(now.Unix(), 10)+".mp4",
+		expandRecordFilenameTemplate("{stream}-{HH}_{MM}_{SS}-{unix}.mp4", "live", "test110", now))
+}
+
+func TestMp4Recorder(t *testing.T) {
+	outPath := t.TempDir()
+	var segments []base.RecordSegmentInfo
+	r := newMp4Recorder("test", "live", "test110", mp4RecorderConfig{
+		outPath:          outPath,
+		filenameTemplate: "{stream}-{unix}.mp4",
+		segmentDuration:  2 * time.Second,
+	}, func(info base.RecordSegmentInfo) {
+		segments = append(segments, info)
+	})
+
+	r.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdVideo, 0, goldenAvcSeqHeader))
+	r.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdAudio, 0, []byte{0xaf, 0x00, 0x12, 0x10}))
+	// 第一个关键帧之前的数据被丢弃
+	r.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdVideo, 0, []byte{0x27, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9a}))
+	assert.Equal(t, "", r.Filename())
+
+	// 每秒一个关键帧，共5秒
+	for i := uint32(1); i <= 125; i++ {
+		ts := i * 40
+		if i%25 == 1 {
+			r.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdVideo, ts, []byte{0x17, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88}))
+		} else {
+			r.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdVideo, ts, []byte{0x27, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9a}))
+		}
+		r.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdAudio, ts, []byte{0xaf, 0x01, 0x21, 0x22}))
+	}
+	r.Dispose()
+
+	assert.Equal(t, 3, len(segments))
+	assert.Equal(t, int64(2000), segments[0].DurationMs)
+	assert.Equal(t, int64(2000), segments[1].DurationMs)
+	assert.Equal(t, int64(960), segments[2].DurationMs)
+	for _, seg := range segments {
+		assert.Equal(t, "mp4", seg.Format)
+		assert.Equal(t, "test110", seg.StreamName)
+		assert.Equal(t, outPath, filepath.Dir(seg.Filename))
+		fi, err := os.Stat(seg.Filename)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, fi.Size(), seg.Size)
+	}
+	// 同一秒内的分段不会覆盖之前的文件
+	assert.Equal(t, true, segments[0].Filename != segments[1].Filename)
+
+	b, err := os.ReadFile(segments[0].Filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ftyp", string(b[4:8]))
+}
+
+func TestRecordFilename(t *testing.T) {
+	now := time.Date(2023, 6, 1, 15, 4, 5, 0, time.Local)
+	filename, err := recordFilename("/tmp/record", defaultRecordMp4FilenameTemplate, "live", "test110", now)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "/tmp/record/live/test110/2023/06/01/150405.mp4", filename)
+
+	// app和stream中的路径分隔符以及".."被替换
+	filename, err = recordFilename("/tmp/record", defaultRecordMp4FilenameTemplate, "..", "../../etc/a", now)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "/tmp/record/_/.._.._etc_a/2023/06/01/150405.mp4", filename)
+	filename, err = recordFilename("/tmp/record", "{stream}", "live", "..", now)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "/tmp/record/_", filename)
+
+	// 模板本身不能跳出out path
+	_, err = recordFilename("/tmp/record", "../{stream}.mp4", "live", "test110", now)
+	assert.IsNotNil(t, err)
+	_, err = recordFilename("/tmp/record", "{unix}/..", "live", "test110", now)
+	assert.IsNotNil(t, err)
+}
+
+func TestMp4RecorderAudioOnly(t *testing.T) {
+	outPath := t.TempDir()
+	var segments []base.RecordSegmentInfo
+	r := newMp4Recorder("test", "live", "test110", mp4RecorderConfig{
+		outPath:          outPath,
+		filenameTemplate: "{stream}-{unix}.mp4",
+	}, func(info base.RecordSegmentInfo) {
+		segments = append(segments, info)
+	})
+
+	r.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdAudio, 0, []byte{0xaf, 0x00, 0x12, 0x10}))
+	// 3秒的音频，每帧约23毫秒
+	for i := uint32(0); i < 130; i++ {
+		r.FeedRtmpMsg(makeRtmpMsg(base.RtmpTypeIdAudio, i*23, []byte{0xaf, 0x01, 0x21, 0x22}))
+	}
+	r.Dispose()
+
+	assert.Equal(t, 1, len(segments))
+	b, err := os.ReadFile(segments[0].Filename)
+	assert.Equal(t, nil, err)
+	// 按最小时长写fragment，而不是每个音频帧一个fragment
+	assert.Equal(t, 3, bytes.Count(b, []byte("moof")))
+}
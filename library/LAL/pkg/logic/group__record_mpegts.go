@@ -12,12 +12,13 @@ import (
 	"fmt"
 	"path/filepath"
 
+	"live-server/library/LAL/pkg/base"
 	"live-server/library/LAL/pkg/mpegts"
 )
 
 // startRecordMpegtsIfNeeded 必要时开启ts录制
 func (group *Group) startRecordMpegtsIfNeeded(nowUnix int64) {
-	if !group.config.RecordConfig.EnableMpegts {
+	if !group.config.RecordConfig.EnableMpegts || group.isRecordSuspended(base.RecordFormatMpegts) {
 		return
 	}
 
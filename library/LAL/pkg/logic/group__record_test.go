@@ -0,0 +1,67 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/naza/pkg/assert"
+)
+
+func TestOnDemandRecorder(t *testing.T) {
+	// 录制开启前收到的seq header被缓存下来
+	var cache rtmpSeqHeaderCache
+	cache.feed(makeRtmpMsg(base.RtmpTypeIdVideo, 0, goldenAvcSeqHeader))
+	cache.feed(makeRtmpMsg(base.RtmpTypeIdAudio, 0, []byte{0xaf, 0x00, 0x12, 0x10}))
+	cache.feed(makeRtmpMsg(base.RtmpTypeIdVideo, 0, []byte{0x17, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88}))
+
+	outPath := t.TempDir()
+	var segments []base.RecordSegmentInfo
+	onSegment := func(info base.RecordSegmentInfo) {
+		segments = append(segments, info)
+	}
+	flv, err := newFlvRecorder("test", "live", "test110", filepath.Join(outPath, "flv", "test110.flv"), onSegment)
+	assert.Equal(t, nil, err)
+	ts, err := newMpegtsRecorder("test", "live", "test110", filepath.Join(outPath, "mpegts", "test110.ts"), onSegment)
+	assert.Equal(t, nil, err)
+	recorders := []iRecorder{flv, ts}
+
+	for _, r := range recorders {
+		cache.replay(r.FeedRtmpMsg)
+	}
+	// 从中间开始录制，第一个关键帧之前的数据被丢弃
+	for i := uint32(1); i <= 100; i++ {
+		ts := i * 40
+		var msgs []base.RtmpMsg
+		if i%25 == 10 {
+			msgs = append(msgs, makeRtmpMsg(base.RtmpTypeIdVideo, ts, []byte{0x17, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88}))
+		} else {
+			msgs = append(msgs, makeRtmpMsg(base.RtmpTypeIdVideo, ts, []byte{0x27, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9a}))
+		}
+		msgs = append(msgs, makeRtmpMsg(base.RtmpTypeIdAudio, ts, []byte{0xaf, 0x01, 0x21, 0x22}))
+		for _, r := range recorders {
+			for _, msg := range msgs {
+				r.FeedRtmpMsg(msg)
+			}
+		}
+	}
+	for _, r := range recorders {
+		r.Dispose()
+	}
+
+	assert.Equal(t, 2, len(segments))
+	assert.Equal(t, base.RecordFormatFlv, segments[0].Format)
+	assert.Equal(t, int64(3600), segments[0].DurationMs)
+	assert.Equal(t, base.RecordFormatMpegts, segments[1].Format)
+	for _, seg := range segments {
+		fi, err := os.Stat(seg.Filename)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, fi.Size(), seg.Size)
+	}
+	assert.Equal(t, int64(0), segments[1].Size%188)
+
+	b, err := os.ReadFile(segments[0].Filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "FLV", string(b[:3]))
+}
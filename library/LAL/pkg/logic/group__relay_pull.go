@@ -11,10 +11,15 @@ package logic
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 
 	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/hls"
+	"live-server/library/LAL/pkg/httpflv"
+	"live-server/library/LAL/pkg/httpts"
+	"live-server/library/LAL/pkg/remux"
 	"live-server/library/LAL/pkg/rtsp"
 
 	"live-server/library/naza/pkg/nazalog"
@@ -22,8 +27,24 @@ import (
 	"live-server/library/LAL/pkg/rtmp"
 )
 
+// group__relay_pull.go
+//
+// relay pull回源拉流，根据url的scheme以及扩展名选择拉流协议，见 relayPullProtocol ：
+// - rtmp使用 rtmp.PullSession
+// - rtsp使用 rtsp.PullSession
+// - http-flv使用 httpflv.PullSession ，flv tag通过 remux.FlvTag2RtmpMsg 转换为rtmp消息
+// - http-ts使用 httpts.PullSession ，hls使用 hls.PullSession ，内部都是解析mpegts后回调rtmp消息
+//
+// 除rtsp外，其他协议都通过 OnReadRtmpAvMsg 进入group，和rtmp pull走相同的转换路径。
+// 所有协议共享相同的重试、没有观看者时自动停止、统计等逻辑。
+//
+
 // StartPull 外部命令主动触发pull拉流
 func (group *Group) StartPull(info base.ApiCtrlStartRelayPullReq) (string, error) {
+	if _, err := relayPullProtocol(info.Url); err != nil {
+		return "", err
+	}
+
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
 
@@ -64,9 +85,8 @@ type pullProxy struct {
 	startCount   int
 	lastHasOutTs int64
 
-	isSessionPulling bool // 是否正在pull，注意，这是一个内部状态，表示的是session的状态，而不是整体任务应该处于的状态
-	rtmpSession      *rtmp.PullSession
-	rtspSession      *rtsp.PullSession
+	isSessionPulling bool                // 是否正在pull，注意，这是一个内部状态，表示的是session的状态，而不是整体任务应该处于的状态
+	session          base.IClientSession // pull中时不为nil，可能是rtmp、rtsp、httpflv、httpts、hls中的任意一种
 }
 
 // initRelayPullByConfig 根据配置文件中的静态回源配置来初始化回源设置
@@ -84,6 +104,11 @@ func (group *Group) initRelayPullByConfig() {
 	var pullUrl string
 	if enable {
 		pullUrl = fmt.Sprintf("rtmp://%s/%s/%s", addr, appName, streamName)
+		if _, err := relayPullProtocol(pullUrl); err != nil {
+			Log.Errorf("[%s] invalid static relay pull addr, disable static relay pull. addr=%s, err=%+v", group.UniqueKey, addr, err)
+			enable = false
+			pullUrl = ""
+		}
 	}
 
 	group.pullProxy.pullUrl = pullUrl
@@ -94,11 +119,11 @@ func (group *Group) initRelayPullByConfig() {
 }
 
 func (group *Group) setRtmpPullSession(session *rtmp.PullSession) {
-	group.pullProxy.rtmpSession = session
+	group.pullProxy.session = session
 }
 
 func (group *Group) setRtspPullSession(session *rtsp.PullSession) {
-	group.pullProxy.rtspSession = session
+	group.pullProxy.session = session
 	if group.pullProxy.debugDumpPacket != "" {
 		group.rtspPullDumpFile = base.NewDumpFile()
 		if err := group.rtspPullDumpFile.OpenToWrite(group.pullProxy.debugDumpPacket); err != nil {
@@ -109,45 +134,36 @@ func (group *Group) setRtspPullSession(session *rtsp.PullSession) {
 
 func (group *Group) resetRelayPullSession() {
 	group.pullProxy.isSessionPulling = false
-	group.pullProxy.rtmpSession = nil
-	group.pullProxy.rtspSession = nil
+	group.pullProxy.session = nil
 	if group.rtspPullDumpFile != nil {
 		group.rtspPullDumpFile.Close()
 		group.rtspPullDumpFile = nil
 	}
 }
 
+func (group *Group) setHttpPullSession(session base.IClientSession) {
+	group.pullProxy.session = session
+}
+
 func (group *Group) getStatPull() base.StatPull {
-	if group.pullProxy.rtmpSession != nil {
-		return base.Session2StatPull(group.pullProxy.rtmpSession)
-	}
-	if group.pullProxy.rtspSession != nil {
-		return base.Session2StatPull(group.pullProxy.rtspSession)
+	if group.pullProxy.session != nil {
+		return base.Session2StatPull(group.pullProxy.session)
 	}
 	return base.StatPull{}
 }
 
 func (group *Group) disposeInactivePullSession() {
-	if group.pullProxy.rtmpSession != nil {
-		if readAlive, _ := group.pullProxy.rtmpSession.IsAlive(); !readAlive {
-			Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, group.pullProxy.rtmpSession.UniqueKey())
-			group.pullProxy.rtmpSession.Dispose()
-		}
-	}
-	if group.pullProxy.rtspSession != nil {
-		if readAlive, _ := group.pullProxy.rtspSession.IsAlive(); !readAlive {
-			Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, group.pullProxy.rtspSession.UniqueKey())
-			group.pullProxy.rtspSession.Dispose()
+	if group.pullProxy.session != nil {
+		if readAlive, _ := group.pullProxy.session.IsAlive(); !readAlive {
+			Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, group.pullProxy.session.UniqueKey())
+			group.pullProxy.session.Dispose()
 		}
 	}
 }
 
 func (group *Group) updatePullSessionStat() {
-	if group.pullProxy.rtmpSession != nil {
-		group.pullProxy.rtmpSession.UpdateStat(calcSessionStatIntervalSec)
-	}
-	if group.pullProxy.rtspSession != nil {
-		group.pullProxy.rtspSession.UpdateStat(calcSessionStatIntervalSec)
+	if group.pullProxy.session != nil {
+		group.pullProxy.session.UpdateStat(calcSessionStatIntervalSec)
 	}
 }
 
@@ -172,15 +188,12 @@ func (group *Group) tickPullModule() {
 }
 
 func (group *Group) hasPullSession() bool {
-	return group.pullProxy.rtmpSession != nil || group.pullProxy.rtspSession != nil
+	return group.pullProxy.session != nil
 }
 
 func (group *Group) pullSessionUniqueKey() string {
-	if group.pullProxy.rtmpSession != nil {
-		return group.pullProxy.rtmpSession.UniqueKey()
-	}
-	if group.pullProxy.rtspSession != nil {
-		return group.pullProxy.rtspSession.UniqueKey()
+	if group.pullProxy.session != nil {
+		return group.pullProxy.session.UniqueKey()
 	}
 	return ""
 }
@@ -189,8 +202,7 @@ func (group *Group) pullSessionUniqueKey() string {
 //
 // @return 返回true，表示找到对应的session，并关闭
 func (group *Group) kickPull(sessionId string) bool {
-	if (group.pullProxy.rtmpSession != nil && group.pullProxy.rtmpSession.UniqueKey() == sessionId) ||
-		(group.pullProxy.rtspSession != nil && group.pullProxy.rtspSession.UniqueKey() == sessionId) {
+	if group.pullProxy.session != nil && group.pullProxy.session.UniqueKey() == sessionId {
 		group.pullProxy.apiEnable = false
 		group.stopPull()
 		return true
@@ -209,18 +221,25 @@ func (group *Group) pullIfNeeded() (string, error) {
 		return "", err
 	}
 
+	protocol, err := relayPullProtocol(group.pullProxy.pullUrl)
+	if err != nil {
+		return "", err
+	}
+
 	Log.Infof("[%s] start relay pull. url=%s", group.UniqueKey, group.pullProxy.pullUrl)
 
 	group.pullProxy.isSessionPulling = true
 	group.pullProxy.startCount++
 
-	isPullByRtmp := strings.HasPrefix(group.pullProxy.pullUrl, "rtmp")
-
-	var rtmpSession *rtmp.PullSession
-	var rtspSession *rtsp.PullSession
-	var uk string
+	var (
+		session base.IClientSession
+		pull    func(rawUrl string) error // 阻塞直到拉流成功或失败
+		del     func()                    // 拉流失败或结束后，从group中删除
+	)
 
-	if isPullByRtmp {
+	switch protocol {
+	case base.SessionProtocolRtmpStr:
+		var rtmpSession *rtmp.PullSession
 		rtmpSession = rtmp.NewPullSession(func(option *rtmp.PullSessionOption) {
 			option.PullTimeoutMs = group.pullProxy.pullTimeoutMs
 		}).WithOnPullSucc(func() {
@@ -231,8 +250,10 @@ func (group *Group) pullIfNeeded() (string, error) {
 			}
 		}).WithOnReadRtmpAvMsg(group.OnReadRtmpAvMsg)
 
-		uk = rtmpSession.UniqueKey()
-	} else {
+		// TODO(chef): 处理数据回调，是否应该等待Add成功之后。避免竞态条件中途加入了其他in session
+		session, pull, del = rtmpSession, rtmpSession.Pull, func() { group.DelRtmpPullSession(rtmpSession) }
+	case base.SessionProtocolRtspStr:
+		var rtspSession *rtsp.PullSession
 		rtspSession = rtsp.NewPullSession(group, func(option *rtsp.PullSessionOption) {
 			option.PullTimeoutMs = group.pullProxy.pullTimeoutMs
 			option.OverTcp = group.pullProxy.rtspMode == 0
@@ -244,54 +265,70 @@ func (group *Group) pullIfNeeded() (string, error) {
 			}
 		})
 
-		uk = rtspSession.UniqueKey()
-	}
-
-	go func(rtPullUrl string, rtIsPullByRtmp bool, rtRtmpSession *rtmp.PullSession, rtRtspSession *rtsp.PullSession) {
-		if rtIsPullByRtmp {
-			// TODO(chef): 处理数据回调，是否应该等待Add成功之后。避免竞态条件中途加入了其他in session
-			err := rtRtmpSession.Pull(rtPullUrl)
-			if err != nil {
-				Log.Errorf("[%s] relay pull fail. err=%v", rtRtmpSession.UniqueKey(), err)
-				group.DelRtmpPullSession(rtRtmpSession)
-				return
-			}
+		session, pull, del = rtspSession, rtspSession.Pull, func() { group.DelRtspPullSession(rtspSession) }
+	case base.SessionProtocolFlvStr:
+		var flvSession *httpflv.PullSession
+		flvSession = httpflv.NewPullSession(func(option *httpflv.PullSessionOption) {
+			option.PullTimeoutMs = group.pullProxy.pullTimeoutMs
+		}).WithOnPullSucc(func() {
+			group.addHttpPullSessionOrDispose(flvSession)
+		})
 
-			err = <-rtRtmpSession.WaitChan()
-			Log.Infof("[%s] relay pull done. err=%v", rtRtmpSession.UniqueKey(), err)
-			group.DelRtmpPullSession(rtRtmpSession)
-			return
+		session = flvSession
+		pull = func(rawUrl string) error {
+			return flvSession.Pull(rawUrl, func(tag httpflv.Tag) {
+				group.OnReadRtmpAvMsg(remux.FlvTag2RtmpMsg(tag))
+			})
 		}
+		del = func() { group.DelHttpPullSession(flvSession) }
+	case base.SessionProtocolTsStr:
+		var tsSession *httpts.PullSession
+		tsSession = httpts.NewPullSession(func(option *httpts.PullSessionOption) {
+			option.PullTimeoutMs = group.pullProxy.pullTimeoutMs
+		}).WithOnPullSucc(func() {
+			group.addHttpPullSessionOrDispose(tsSession)
+		})
 
-		err := rtRtspSession.Pull(rtPullUrl)
+		session = tsSession
+		pull = func(rawUrl string) error { return tsSession.Pull(rawUrl, group.OnReadRtmpAvMsg) }
+		del = func() { group.DelHttpPullSession(tsSession) }
+	case base.SessionProtocolHlsStr:
+		var hlsSession *hls.PullSession
+		hlsSession = hls.NewPullSession(func(option *hls.PullSessionOption) {
+			option.PullTimeoutMs = group.pullProxy.pullTimeoutMs
+		}).WithOnPullSucc(func() {
+			group.addHttpPullSessionOrDispose(hlsSession)
+		})
+
+		session = hlsSession
+		pull = func(rawUrl string) error { return hlsSession.Pull(rawUrl, group.OnReadRtmpAvMsg) }
+		del = func() { group.DelHttpPullSession(hlsSession) }
+	}
+
+	go func(rtPullUrl string) {
+		err := pull(rtPullUrl)
 		if err != nil {
-			Log.Errorf("[%s] relay pull fail. err=%v", rtRtspSession.UniqueKey(), err)
-			group.DelRtspPullSession(rtRtspSession)
+			Log.Errorf("[%s] relay pull fail. err=%v", session.UniqueKey(), err)
+			del()
 			return
 		}
 
-		err = <-rtRtspSession.WaitChan()
-		Log.Infof("[%s] relay pull done. err=%v", rtRtspSession.UniqueKey(), err)
-		group.DelRtspPullSession(rtRtspSession)
-		return
-	}(group.pullProxy.pullUrl, isPullByRtmp, rtmpSession, rtspSession)
+		err = <-session.WaitChan()
+		Log.Infof("[%s] relay pull done. err=%v", session.UniqueKey(), err)
+		del()
+	}(group.pullProxy.pullUrl)
 
-	return uk, nil
+	return session.UniqueKey(), nil
 }
 
 func (group *Group) stopPull() string {
 	// 关闭时，清空用于重试的计数
 	group.pullProxy.startCount = 0
 
-	if group.pullProxy.rtmpSession != nil {
-		Log.Infof("[%s] stop pull session.", group.UniqueKey)
-		group.pullProxy.rtmpSession.Dispose()
-		return group.pullProxy.rtmpSession.UniqueKey()
-	}
-	if group.pullProxy.rtspSession != nil {
+	if group.pullProxy.session != nil {
 		Log.Infof("[%s] stop pull session.", group.UniqueKey)
-		group.pullProxy.rtspSession.Dispose()
-		return group.pullProxy.rtspSession.UniqueKey()
+		group.pullProxy.session.Dispose()
+		return group.pullProxy.session.UniqueKey()
 	}
 	return ""
 }
@@ -349,3 +386,40 @@ func (group *Group) shouldAutoStopPull() bool {
 	nazalog.Debugf("%d %d %d", group.pullProxy.lastHasOutTs, time.Now().UnixNano(), group.pullProxy.autoStopPullAfterNoOutMs)
 	return group.pullProxy.lastHasOutTs != -1 && time.Now().UnixNano()/1e6-group.pullProxy.lastHasOutTs >= int64(group.pullProxy.autoStopPullAfterNoOutMs)
 }
+
+// addHttpPullSessionOrDispose httpflv、httpts、hls拉流成功时调用，加入group失败时关闭session
+func (group *Group) addHttpPullSessionOrDispose(session base.IClientSession) {
+	if err := group.AddHttpPullSession(session); err != nil {
+		session.Dispose()
+	}
+}
+
+// relayPullProtocol 根据url的scheme以及扩展名判断拉流协议
+//
+// @return protocol: base.SessionProtocolRtmpStr, base.SessionProtocolRtspStr, base.SessionProtocolFlvStr,
+//
+//	base.SessionProtocolTsStr, base.SessionProtocolHlsStr 之一
+func relayPullProtocol(rawUrl string) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "rtmp", "rtmps":
+		return base.SessionProtocolRtmpStr, nil
+	case "rtsp", "rtsps":
+		return base.SessionProtocolRtspStr, nil
+	case "http", "https":
+		// 注意，和 base.ParseHttpflvUrl 等保持一致，扩展名区分大小写
+		switch {
+		case strings.HasSuffix(u.Path, ".flv"):
+			return base.SessionProtocolFlvStr, nil
+		case strings.HasSuffix(u.Path, ".ts"):
+			return base.SessionProtocolTsStr, nil
+		case strings.HasSuffix(u.Path, ".m3u8"):
+			return base.SessionProtocolHlsStr, nil
+		}
+	}
+	return "", fmt.Errorf("%w. url=%s", base.ErrRelayPullUnsupportedUrl, rawUrl)
+}
@@ -0,0 +1,108 @@
+package logic
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+
+	"live-server/library/naza/pkg/assert"
+)
+
+type testRelayPullObserver struct {
+	mutex     sync.Mutex
+	startInfo []base.PullStartInfo
+	stopInfo  []base.PullStopInfo
+}
+
+func (o *testRelayPullObserver) CleanupHlsIfNeeded(appName string, streamName string, path string) {}
+func (o *testRelayPullObserver) OnHlsMakeTs(info base.HlsMakeTsInfo)                               {}
+func (o *testRelayPullObserver) OnRecordSegment(info base.RecordSegmentInfo)                       {}
+func (o *testRelayPullObserver) OnRecordError(info base.RecordErrorInfo)                           {}
+func (o *testRelayPullObserver) OnRelayPullStart(info base.PullStartInfo) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.startInfo = append(o.startInfo, info)
+}
+func (o *testRelayPullObserver) OnRelayPullStop(info base.PullStopInfo) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.stopInfo = append(o.stopInfo, info)
+}
+
+func TestRelayPullProtocol(t *testing.T) {
+	golden := map[string]string{
+		"rtmp://127.0.0.1/live/test110":                base.SessionProtocolRtmpStr,
+		"rtmps://127.0.0.1/live/test110":               base.SessionProtocolRtmpStr,
+		"rtsp://127.0.0.1:5544/live/test110":           base.SessionProtocolRtspStr,
+		"RTSP://127.0.0.1:5544/live/test110":           base.SessionProtocolRtspStr,
+		"http://127.0.0.1:8080/live/test110.flv":       base.SessionProtocolFlvStr,
+		"https://127.0.0.1/live/test110.flv?token=abc": base.SessionProtocolFlvStr,
+		"http://127.0.0.1:8080/live/test110.ts":        base.SessionProtocolTsStr,
+		"http://127.0.0.1:8080/hls/test110.m3u8":       base.SessionProtocolHlsStr,
+	}
+	for url, protocol := range golden {
+		p, err := relayPullProtocol(url)
+		assert.Equal(t, nil, err, url)
+		assert.Equal(t, protocol, p, url)
+	}
+
+	for _, url := range []string{"http://127.0.0.1:8080/live/test110", "srt://127.0.0.1:8080/live/test110.ts", "127.0.0.1/live/test110"} {
+		_, err := relayPullProtocol(url)
+		assert.Equal(t, true, errors.Is(err, base.ErrRelayPullUnsupportedUrl), url)
+	}
+
+	// 不支持的url，不会开启pull
+	var config Config
+	g := NewGroup("live", "test110", &config, GroupOption{}, nil)
+	_, err := g.StartPull(base.ApiCtrlStartRelayPullReq{Url: "http://127.0.0.1:8080/live/test110.mp4"})
+	assert.Equal(t, true, errors.Is(err, base.ErrRelayPullUnsupportedUrl))
+	assert.Equal(t, false, g.pullProxy.apiEnable)
+	assert.Equal(t, false, g.pullProxy.isSessionPulling)
+
+	// 静态回源的地址不合法时，关闭静态回源
+	config.StaticRelayPullConfig = StaticRelayPullConfig{Enable: true, Addr: "127.0.0.1:abc"}
+	g = NewGroup("live", "test110", &config, GroupOption{}, nil)
+	assert.Equal(t, false, g.pullProxy.staticRelayPullEnable)
+	assert.Equal(t, "", g.pullProxy.pullUrl)
+	config.StaticRelayPullConfig.Addr = "127.0.0.1:19350"
+	g = NewGroup("live", "test110", &config, GroupOption{}, nil)
+	assert.Equal(t, true, g.pullProxy.staticRelayPullEnable)
+	assert.Equal(t, "rtmp://127.0.0.1:19350/live/test110", g.pullProxy.pullUrl)
+}
+
+func TestRelayPullFail(t *testing.T) {
+	// 连接失败时，和rtmp pull一样回调stop并允许重试
+	var config Config
+	observer := &testRelayPullObserver{}
+	g := NewGroup("live", "test110", &config, GroupOption{}, observer)
+	for _, url := range []string{"http://127.0.0.1:1/live/test110.flv", "http://127.0.0.1:1/live/test110.ts", "http://127.0.0.1:1/live/test110.m3u8"} {
+		sessionId, err := g.StartPull(base.ApiCtrlStartRelayPullReq{Url: url, PullTimeoutMs: 1000, PullRetryNum: base.PullRetryNumForever, AutoStopPullAfterNoOutMs: base.AutoStopPullAfterNoOutMsNever})
+		assert.Equal(t, nil, err)
+
+		for i := 0; i < 100; i++ {
+			observer.mutex.Lock()
+			n := len(observer.stopInfo)
+			observer.mutex.Unlock()
+			if n != 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		observer.mutex.Lock()
+		assert.Equal(t, 1, len(observer.stopInfo), url)
+		assert.Equal(t, sessionId, observer.stopInfo[0].SessionId)
+		assert.Equal(t, 0, len(observer.startInfo))
+		observer.stopInfo = nil
+		observer.mutex.Unlock()
+
+		g.mutex.Lock()
+		assert.Equal(t, false, g.pullProxy.isSessionPulling)
+		assert.Equal(t, false, g.hasPullSession())
+		g.mutex.Unlock()
+
+		g.StopPull()
+	}
+}
@@ -10,118 +10,412 @@ package logic
 
 import (
 	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
 
+	"live-server/library/LAL/pkg/base"
 	"live-server/library/LAL/pkg/rtmp"
+	"live-server/library/LAL/pkg/rtprtcp"
+	"live-server/library/LAL/pkg/rtsp"
+	"live-server/library/LAL/pkg/sdp"
 )
 
-// TODO(chef): [refactor] 参照relay pull，整体重构一次relay push 202205
+// group__relay_push.go
+//
+// relay push转推，每个转推目标（url）对应一个 pushProxy，各自维护重试策略和状态：
+// - 转推目标来自配置文件 relay_push，或者通过HTTP-API动态添加、删除
+// - 有pub（rtmp、rtsp、customize、gb28181）时开始转推，relay pull的流不转推
+// - rtmp目标使用 rtmp.PushSession，rtsp目标使用 rtsp.PushSession，rtsp的数据来自rtsp pub，或者 rtmp2RtspRemuxer
+// - 转推失败或者转推中断开后，按退避间隔重试，重试次数用完后不再重试，直到下一次pub
+//
+// 注意，rtsp目标需要sdp才能开始转推。
+// 对于非rtsp的输入流，pub时只有开启了rtsp，或者有rtsp转推目标，才会创建 rtmp2RtspRemuxer，
+// 所以pub之后通过HTTP-API动态添加的rtsp目标，如果当前没有sdp，会一直处于 base.RelayPushStatusIdle 状态，直到下一次pub。
+//
+
+type pushProxy struct {
+	url                string // 不包含参数，也是转推目标的唯一标识
+	rawQuery           string // url自身的参数，为空时携带pub的参数
+	protocol           string // base.SessionProtocolRtmpStr 或 base.SessionProtocolRtspStr
+	retryNum           int
+	retryIntervalMs    int
+	retryMaxIntervalMs int
+	rtspMode           int
+
+	status        string
+	failedCount   int
+	nextRetryTime time.Time
+	lastErr       string
+
+	// 每次开始转推或停止转推时递增，用于丢弃已经失效的转推协程的回调
+	generation int
+
+	// 以下三个session，转推中时不为nil。rtmp和rtsp只有一个不为nil，session和其中不为nil的那个相同
+	session     base.IClientSession
+	rtmpSession *rtmp.PushSession
+	rtspSession *rtsp.PushSession
+
+	// rtsp转推使用，等待视频关键帧再开始发送
+	rtspShouldWaitVideoKeyFrame bool
+}
+
+// newPushProxy
+//
+// @param rawUrl: 支持rtmp://和rtsp://
+func newPushProxy(rawUrl string, retryNum int, retryIntervalMs int, retryMaxIntervalMs int, rtspMode int) (*pushProxy, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	var protocol string
+	switch strings.ToLower(u.Scheme) {
+	case "rtmp":
+		protocol = base.SessionProtocolRtmpStr
+	case "rtsp":
+		protocol = base.SessionProtocolRtspStr
+	default:
+		return nil, fmt.Errorf("%w. url=%s", base.ErrRelayPushUnsupportedUrl, rawUrl)
+	}
+
+	rawQuery := u.RawQuery
+	u.RawQuery = ""
+	return &pushProxy{
+		url:                u.String(),
+		rawQuery:           rawQuery,
+		protocol:           protocol,
+		retryNum:           retryNum,
+		retryIntervalMs:    retryIntervalMs,
+		retryMaxIntervalMs: retryMaxIntervalMs,
+		rtspMode:           rtspMode,
+		status:             base.RelayPushStatusIdle,
+	}, nil
+}
+
+// retryBackoff 第failedCount次失败后的等待时间，从 retryIntervalMs 开始每次翻倍，不超过 retryMaxIntervalMs
+func (p *pushProxy) retryBackoff() time.Duration {
+	interval := p.retryIntervalMs
+	if interval <= 0 {
+		interval = defaultRelayPushRetryIntervalMs
+	}
+	for i := 1; i < p.failedCount && interval < p.retryMaxIntervalMs; i++ {
+		interval *= 2
+	}
+	if p.retryMaxIntervalMs > 0 && interval > p.retryMaxIntervalMs {
+		interval = p.retryMaxIntervalMs
+	}
+	return time.Duration(interval) * time.Millisecond
+}
+
+// reset 停止转推，并清空失败的状态
+func (p *pushProxy) reset() {
+	if p.session != nil {
+		_ = p.session.Dispose()
+	}
+	p.generation++
+	p.session = nil
+	p.rtmpSession = nil
+	p.rtspSession = nil
+	p.status = base.RelayPushStatusIdle
+	p.failedCount = 0
+}
+
+func (p *pushProxy) stat() base.StatRelayPush {
+	s := base.StatRelayPush{
+		Url:         p.url,
+		Protocol:    p.protocol,
+		Status:      p.status,
+		RetryNum:    p.retryNum,
+		FailedCount: p.failedCount,
+		LastError:   p.lastErr,
+	}
+	if p.status == base.RelayPushStatusRetryWait {
+		s.NextRetryTime = p.nextRetryTime.Format("2006-01-02 15:04:05.999")
+	}
+	if p.session != nil {
+		s.Session = p.session.GetStat()
+	}
+	return s
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// StartRelayPush 添加一个转推目标，有pub时立即开始转推
+//
+// info 中的 RetryIntervalMs 、 RetryMaxIntervalMs 为0时分别使用配置文件 relay_push 中的值
+func (group *Group) StartRelayPush(info base.ApiCtrlStartRelayPushReq) (string, error) {
+	if info.RetryIntervalMs <= 0 {
+		info.RetryIntervalMs = group.config.RelayPushConfig.RetryIntervalMs
+	}
+	if info.RetryMaxIntervalMs <= 0 {
+		info.RetryMaxIntervalMs = group.config.RelayPushConfig.RetryMaxIntervalMs
+	}
+	proxy, err := newPushProxy(info.Url, info.RetryNum, info.RetryIntervalMs, info.RetryMaxIntervalMs, info.RtspMode)
+	if err != nil {
+		return "", err
+	}
 
-func (group *Group) AddRtmpPushSession(url string, session *rtmp.PushSession) {
-	Log.Debugf("[%s] [%s] add rtmp PushSession into group.", group.UniqueKey, session.UniqueKey())
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
-	if group.url2PushProxy != nil {
-		group.url2PushProxy[url].pushSession = session
+
+	if _, ok := group.url2PushProxy[proxy.url]; ok {
+		return "", fmt.Errorf("%w. url=%s", base.ErrRelayPushExist, proxy.url)
 	}
+	Log.Infof("[%s] add relay push. url=%s", group.UniqueKey, proxy.url)
+	group.url2PushProxy[proxy.url] = proxy
+	group.startPushIfNeeded()
+	return proxy.url, nil
 }
 
-func (group *Group) DelRtmpPushSession(url string, session *rtmp.PushSession) {
-	Log.Debugf("[%s] [%s] del rtmp PushSession into group.", group.UniqueKey, session.UniqueKey())
+// StopRelayPush 删除一个转推目标，正在转推时关闭转推
+//
+// @return 正在转推时返回被关闭的session id，否则为空
+func (group *Group) StopRelayPush(rawUrl string) (string, error) {
+	key := rawUrl
+	if u, err := url.Parse(rawUrl); err == nil {
+		u.RawQuery = ""
+		key = u.String()
+	}
+
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
-	if group.url2PushProxy != nil {
-		group.url2PushProxy[url].pushSession = nil
-		group.url2PushProxy[url].isPushing = false
+
+	proxy, ok := group.url2PushProxy[key]
+	if !ok {
+		return "", fmt.Errorf("%w. url=%s", base.ErrRelayPushNotFound, rawUrl)
 	}
-}
+	Log.Infof("[%s] del relay push. url=%s", group.UniqueKey, key)
 
-// ---------------------------------------------------------------------------------------------------------------------
+	var sessionId string
+	if proxy.session != nil {
+		sessionId = proxy.session.UniqueKey()
+	}
+	proxy.reset()
+	delete(group.url2PushProxy, key)
+	return sessionId, nil
+}
 
-type pushProxy struct {
-	isPushing   bool
-	pushSession *rtmp.PushSession
+func (group *Group) StatRelayPush() []base.StatRelayPush {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	return group.statRelayPush()
 }
 
+// ---------------------------------------------------------------------------------------------------------------------
+
 func (group *Group) initRelayPushByConfig() {
-	enable := group.config.RelayPushConfig.Enable
-	addrList := group.config.RelayPushConfig.AddrList
-	appName := group.appName
-	streamName := group.streamName
-
-	url2PushProxy := make(map[string]*pushProxy)
-	if enable {
-		for _, addr := range addrList {
-			pushUrl := fmt.Sprintf("rtmp://%s/%s/%s", addr, appName, streamName)
-			url2PushProxy[pushUrl] = &pushProxy{
-				isPushing:   false,
-				pushSession: nil,
-			}
+	c := group.config.RelayPushConfig
+
+	group.url2PushProxy = make(map[string]*pushProxy)
+	if !c.Enable {
+		return
+	}
+	for _, addr := range c.AddrList {
+		var pushUrl string
+		if strings.Contains(addr, "://") {
+			pushUrl = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(addr, "/"), group.appName, group.streamName)
+		} else {
+			pushUrl = fmt.Sprintf("rtmp://%s/%s/%s", addr, group.appName, group.streamName)
+		}
+		proxy, err := newPushProxy(pushUrl, c.RetryNum, c.RetryIntervalMs, c.RetryMaxIntervalMs, c.RtspMode)
+		if err != nil {
+			Log.Errorf("[%s] invalid relay push addr. addr=%s, err=%+v", group.UniqueKey, addr, err)
+			continue
 		}
+		group.url2PushProxy[proxy.url] = proxy
 	}
-
-	group.pushEnable = group.config.RelayPushConfig.Enable
-	group.url2PushProxy = url2PushProxy
 }
 
 // startPushIfNeeded 必要时进行replay push转推
 func (group *Group) startPushIfNeeded() {
-	// push转推功能没开
-	if !group.pushEnable {
-		return
-	}
 	// 没有pub发布者
-	// TODO(chef): [refactor] 判断所有pub是否存在的方式 202208
-	if group.rtmpPubSession == nil && group.rtspPubSession == nil {
+	if !group.hasPubSession() {
 		return
 	}
 
-	// relay push时携带rtmp pub的参数
-	// TODO chef: 这个逻辑放这里不太好看
+	// relay push时携带pub的参数
 	var urlParam string
 	if group.rtmpPubSession != nil {
 		urlParam = group.rtmpPubSession.RawQuery()
+	} else if group.rtspPubSession != nil {
+		urlParam = group.rtspPubSession.RawQuery()
 	}
 
-	for url, v := range group.url2PushProxy {
-		// 正在转推中
-		if v.isPushing {
+	now := time.Now()
+	for _, proxy := range group.url2PushProxy {
+		switch proxy.status {
+		case base.RelayPushStatusIdle:
+			// noop
+		case base.RelayPushStatusRetryWait:
+			if now.Before(proxy.nextRetryTime) {
+				continue
+			}
+		default:
 			continue
 		}
-		v.isPushing = true
 
-		urlWithParam := url
-		if urlParam != "" {
+		var sdpCtx sdp.LogicContext
+		if proxy.protocol == base.SessionProtocolRtspStr {
+			if group.sdpCtx == nil {
+				continue
+			}
+			sdpCtx = *group.sdpCtx
+		}
+
+		urlWithParam := proxy.url
+		if proxy.rawQuery != "" {
+			urlWithParam += "?" + proxy.rawQuery
+		} else if urlParam != "" {
 			urlWithParam += "?" + urlParam
 		}
-		Log.Infof("[%s] start relay push. url=%s", group.UniqueKey, urlWithParam)
-
-		go func(u, u2 string) {
-			pushSession := rtmp.NewPushSession(func(option *rtmp.PushSessionOption) {
-				option.PushTimeoutMs = RelayPushTimeoutMs
-				option.WriteAvTimeoutMs = RelayPushWriteAvTimeoutMs
-			})
-			err := pushSession.Push(u2)
-			if err != nil {
-				Log.Errorf("[%s] relay push done. err=%v", pushSession.UniqueKey(), err)
-				group.DelRtmpPushSession(u, pushSession)
-				return
-			}
-			group.AddRtmpPushSession(u, pushSession)
-			err = <-pushSession.WaitChan()
-			Log.Infof("[%s] relay push done. err=%v", pushSession.UniqueKey(), err)
-			group.DelRtmpPushSession(u, pushSession)
-		}(url, urlWithParam)
+		Log.Infof("[%s] start relay push. url=%s, failed=%d", group.UniqueKey, urlWithParam, proxy.failedCount)
+
+		proxy.status = base.RelayPushStatusConnecting
+		proxy.generation++
+		go group.runPush(proxy, proxy.generation, urlWithParam, sdpCtx)
 	}
 }
 
+// stopPushIfNeeded pub结束时关闭所有转推，转推目标保留，等待下一次pub
 func (group *Group) stopPushIfNeeded() {
-	if !group.pushEnable {
+	for _, proxy := range group.url2PushProxy {
+		proxy.reset()
+	}
+}
+
+// runPush 在独立的协程中执行，直到转推结束
+func (group *Group) runPush(proxy *pushProxy, generation int, rawUrl string, sdpCtx sdp.LogicContext) {
+	var (
+		session     base.IClientSession
+		rtmpSession *rtmp.PushSession
+		rtspSession *rtsp.PushSession
+		err         error
+	)
+	if proxy.protocol == base.SessionProtocolRtspStr {
+		rtspSession = rtsp.NewPushSession(func(option *rtsp.PushSessionOption) {
+			option.PushTimeoutMs = RelayPushTimeoutMs
+			option.OverTcp = proxy.rtspMode == base.RtspModeTcp
+		})
+		session = rtspSession
+		err = rtspSession.Push(rawUrl, sdpCtx)
+	} else {
+		rtmpSession = rtmp.NewPushSession(func(option *rtmp.PushSessionOption) {
+			option.PushTimeoutMs = RelayPushTimeoutMs
+			option.WriteAvTimeoutMs = RelayPushWriteAvTimeoutMs
+		})
+		session = rtmpSession
+		err = rtmpSession.Push(rawUrl)
+	}
+	if err != nil {
+		Log.Errorf("[%s] relay push done. err=%v", session.UniqueKey(), err)
+		group.onPushDone(proxy, generation, err)
 		return
 	}
-	for _, v := range group.url2PushProxy {
-		if v.pushSession != nil {
-			v.pushSession.Dispose()
+
+	if !group.onPushStart(proxy, generation, session, rtmpSession, rtspSession) {
+		_ = session.Dispose()
+		return
+	}
+	err = <-session.WaitChan()
+	Log.Infof("[%s] relay push done. err=%v", session.UniqueKey(), err)
+	group.onPushDone(proxy, generation, err)
+}
+
+// onPushStart
+//
+// @return 为false时表示转推已经被停止，调用方需要关闭session
+func (group *Group) onPushStart(proxy *pushProxy, generation int, session base.IClientSession, rtmpSession *rtmp.PushSession, rtspSession *rtsp.PushSession) bool {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	if proxy.generation != generation {
+		return false
+	}
+
+	Log.Debugf("[%s] [%s] add %s PushSession into group.", group.UniqueKey, session.UniqueKey(), proxy.protocol)
+	proxy.session = session
+	proxy.rtmpSession = rtmpSession
+	proxy.rtspSession = rtspSession
+	proxy.rtspShouldWaitVideoKeyFrame = true
+	proxy.status = base.RelayPushStatusPushing
+	proxy.failedCount = 0
+	proxy.lastErr = ""
+	return true
+}
+
+func (group *Group) onPushDone(proxy *pushProxy, generation int, err error) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	if proxy.generation != generation {
+		return
+	}
+
+	if proxy.session != nil {
+		Log.Debugf("[%s] [%s] del %s PushSession from group.", group.UniqueKey, proxy.session.UniqueKey(), proxy.protocol)
+	}
+	proxy.session = nil
+	proxy.rtmpSession = nil
+	proxy.rtspSession = nil
+
+	// 对端正常关闭也算作一次失败，因为pub还在
+	proxy.failedCount++
+	if err != nil {
+		proxy.lastErr = err.Error()
+	} else {
+		proxy.lastErr = ""
+	}
+	if proxy.retryNum != base.PullRetryNumForever && proxy.failedCount > proxy.retryNum {
+		Log.Warnf("[%s] relay push failed and no more retry. url=%s, failed=%d", group.UniqueKey, proxy.url, proxy.failedCount)
+		proxy.status = base.RelayPushStatusFailed
+		return
+	}
+	proxy.status = base.RelayPushStatusRetryWait
+	proxy.nextRetryTime = time.Now().Add(proxy.retryBackoff())
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// feedRtpPacket2RelayPush 把rtp数据发送给rtsp转推，等待视频关键帧后再开始发送
+func (group *Group) feedRtpPacket2RelayPush(pkt rtprtcp.RtpPacket) {
+	var (
+		boundary        bool
+		boundaryChecked bool
+	)
+	for _, proxy := range group.url2PushProxy {
+		if proxy.rtspSession == nil {
+			continue
 		}
-		v.pushSession = nil
+		if proxy.rtspShouldWaitVideoKeyFrame {
+			if !boundaryChecked {
+				boundary = group.isRtpVideoBoundary(pkt)
+				boundaryChecked = true
+			}
+			if !boundary {
+				continue
+			}
+			proxy.rtspShouldWaitVideoKeyFrame = false
+		}
+		_ = proxy.rtspSession.WriteRtpPacket(pkt)
+	}
+}
+
+func (group *Group) hasRtspPushProxy() bool {
+	for _, proxy := range group.url2PushProxy {
+		if proxy.protocol == base.SessionProtocolRtspStr {
+			return true
+		}
+	}
+	return false
+}
+
+func (group *Group) statRelayPush() []base.StatRelayPush {
+	var ret []base.StatRelayPush
+	for _, proxy := range group.url2PushProxy {
+		ret = append(ret, proxy.stat())
 	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Url < ret[j].Url
+	})
+	return ret
 }
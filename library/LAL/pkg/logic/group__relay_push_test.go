@@ -0,0 +1,84 @@
+package logic
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+
+	"live-server/library/naza/pkg/assert"
+)
+
+func TestRelayPush(t *testing.T) {
+	var config Config
+	config.RelayPushConfig = RelayPushConfig{
+		Enable:             true,
+		AddrList:           []string{"127.0.0.1:19350", "rtsp://127.0.0.1:5544/", "http://127.0.0.1:8080"},
+		RetryNum:           2,
+		RetryIntervalMs:    1000,
+		RetryMaxIntervalMs: 3000,
+	}
+	g := NewGroup("live", "test110", &config, GroupOption{}, nil)
+
+	// 不支持的协议被忽略
+	stats := g.StatRelayPush()
+	assert.Equal(t, 2, len(stats))
+	assert.Equal(t, "rtmp://127.0.0.1:19350/live/test110", stats[0].Url)
+	assert.Equal(t, base.SessionProtocolRtmpStr, stats[0].Protocol)
+	assert.Equal(t, "rtsp://127.0.0.1:5544/live/test110", stats[1].Url)
+	assert.Equal(t, base.SessionProtocolRtspStr, stats[1].Protocol)
+	assert.Equal(t, base.RelayPushStatusIdle, stats[1].Status)
+
+	// 动态添加，参数不参与唯一标识
+	_, err := g.StartRelayPush(base.ApiCtrlStartRelayPushReq{Url: "http://127.0.0.1/live/test110"})
+	assert.Equal(t, true, errors.Is(err, base.ErrRelayPushUnsupportedUrl))
+	u, err := g.StartRelayPush(base.ApiCtrlStartRelayPushReq{Url: "rtmp://127.0.0.1:19351/live/test110?k=v", RetryNum: 0})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "rtmp://127.0.0.1:19351/live/test110", u)
+	_, err = g.StartRelayPush(base.ApiCtrlStartRelayPushReq{Url: "rtmp://127.0.0.1:19351/live/test110"})
+	assert.Equal(t, true, errors.Is(err, base.ErrRelayPushExist))
+
+	// 重试间隔以及上限都可以由调用方指定，不指定时使用配置文件中的值
+	assert.Equal(t, 1000, g.url2PushProxy[u].retryIntervalMs)
+	assert.Equal(t, 3000, g.url2PushProxy[u].retryMaxIntervalMs)
+	u2, err := g.StartRelayPush(base.ApiCtrlStartRelayPushReq{Url: "rtmp://127.0.0.1:19352/live/test110", RetryIntervalMs: 500, RetryMaxIntervalMs: 60000})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 500, g.url2PushProxy[u2].retryIntervalMs)
+	assert.Equal(t, 60000, g.url2PushProxy[u2].retryMaxIntervalMs)
+
+	// 没有pub时不转推
+	assert.Equal(t, base.RelayPushStatusIdle, g.url2PushProxy[u].status)
+
+	// 模拟转推失败后的重试
+	proxy := g.url2PushProxy["rtmp://127.0.0.1:19350/live/test110"]
+	proxy.status = base.RelayPushStatusConnecting
+	proxy.generation++
+	g.onPushDone(proxy, proxy.generation, errors.New("mock"))
+	assert.Equal(t, base.RelayPushStatusRetryWait, proxy.status)
+	assert.Equal(t, 1, proxy.failedCount)
+	assert.Equal(t, time.Second, proxy.retryBackoff())
+	g.onPushDone(proxy, proxy.generation, errors.New("mock"))
+	assert.Equal(t, 2*time.Second, proxy.retryBackoff())
+	g.onPushDone(proxy, proxy.generation, errors.New("mock"))
+	assert.Equal(t, base.RelayPushStatusFailed, proxy.status)
+	assert.Equal(t, 3*time.Second, proxy.retryBackoff())
+	assert.Equal(t, "mock", g.StatRelayPush()[0].LastError)
+
+	// 已经失效的转推协程的回调被忽略
+	g.onPushDone(proxy, proxy.generation-1, nil)
+	assert.Equal(t, 3, proxy.failedCount)
+
+	// pub结束后清空失败的状态
+	g.mutex.Lock()
+	g.stopPushIfNeeded()
+	g.mutex.Unlock()
+	assert.Equal(t, base.RelayPushStatusIdle, proxy.status)
+	assert.Equal(t, 0, proxy.failedCount)
+
+	sessionId, err := g.StopRelayPush("rtmp://127.0.0.1:19351/live/test110?k=v")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "", sessionId)
+	_, err = g.StopRelayPush("rtmp://127.0.0.1:19351/live/test110")
+	assert.Equal(t, true, errors.Is(err, base.ErrRelayPushNotFound))
+}
@@ -0,0 +1,47 @@
+package logic
+
+import (
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/remux"
+)
+
+// group__snapshot.go
+//
+// 获取直播流最近的一个视频关键帧，见 /api/stat/snapshot
+//
+// 注意，gop缓存可能没有开启（gop_num为0），所以这里单独缓存最近的一个关键帧
+//
+
+// Snapshot
+//
+// @param format: 见 base.SnapshotFormatAnnexb 等
+func (group *Group) Snapshot(format string) (base.Snapshot, error) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if group.snapshotKeyFrame == nil {
+		return base.Snapshot{}, base.ErrSnapshotNoKeyFrame
+	}
+	ret, err := remux.MakeSnapshot(group.seqHeaderCache.videoSeqHeader, *group.snapshotKeyFrame, format)
+	if err != nil {
+		return ret, err
+	}
+	ret.StreamName = group.streamName
+	ret.CaptureTime = group.snapshotTime.Format("2006-01-02 15:04:05.999")
+	return ret, nil
+}
+
+func (group *Group) feedSnapshot(msg base.RtmpMsg) {
+	if msg.Header.MsgTypeId != base.RtmpTypeIdVideo || !msg.IsVideoKeyNalu() {
+		return
+	}
+	m := msg.Clone()
+	group.snapshotKeyFrame = &m
+	group.snapshotTime = time.Now()
+}
+
+func (group *Group) clearSnapshot() {
+	group.snapshotKeyFrame = nil
+}
@@ -57,11 +57,24 @@ func (h *HttpApiServer) RunLoop() error {
 	mux.HandleFunc("/api/stat/group", h.statGroupHandler)
 	mux.HandleFunc("/api/stat/all_group", h.statAllGroupHandler)
 	mux.HandleFunc("/api/stat/lal_info", h.statLalInfoHandler)
+	mux.HandleFunc("/api/stat/notify", h.statNotifyHandler)
+	mux.HandleFunc("/api/stat/acl", h.statAclHandler)
+	mux.HandleFunc("/api/stat/relay_push", h.statRelayPushHandler)
+	mux.HandleFunc("/api/stat/record_retention", h.statRecordRetentionHandler)
+	mux.HandleFunc("/api/stat/snapshot", h.statSnapshotHandler)
 
 	mux.HandleFunc("/api/ctrl/start_relay_pull", h.ctrlStartRelayPullHandler)
 	mux.HandleFunc("/api/ctrl/stop_relay_pull", h.ctrlStopRelayPullHandler)
+	mux.HandleFunc("/api/ctrl/start_relay_push", h.ctrlStartRelayPushHandler)
+	mux.HandleFunc("/api/ctrl/stop_relay_push", h.ctrlStopRelayPushHandler)
+	mux.HandleFunc("/api/ctrl/start_record", h.ctrlStartRecordHandler)
+	mux.HandleFunc("/api/ctrl/stop_record", h.ctrlStopRecordHandler)
+	mux.HandleFunc("/api/ctrl/start_file_pub", h.ctrlStartFilePubHandler)
+	mux.HandleFunc("/api/ctrl/stop_file_pub", h.ctrlStopFilePubHandler)
+	mux.HandleFunc("/api/ctrl/start_udp_ts_pub", h.ctrlStartUdpTsPubHandler)
 	mux.HandleFunc("/api/ctrl/kick_session", h.ctrlKickSessionHandler)
 	mux.HandleFunc("/api/ctrl/start_rtp_pub", h.ctrlStartRtpPubHandler)
+	mux.HandleFunc("/api/ctrl/reload_acl", h.ctrlReloadAclHandler)
 	// 所有没有注册路由的走下面这个处理函数
 	mux.HandleFunc("/", h.notFoundHandler)
 
@@ -70,7 +83,14 @@ func (h *HttpApiServer) RunLoop() error {
 	return srv.Serve(h.ln)
 }
 
-// TODO chef: dispose
+func (h *HttpApiServer) Dispose() {
+	if h.ln == nil {
+		return
+	}
+	if err := h.ln.Close(); err != nil {
+		Log.Error(err)
+	}
+}
 
 // ---------------------------------------------------------------------------------------------------------------------
 
@@ -115,6 +135,72 @@ func (h *HttpApiServer) statGroupHandler(w http.ResponseWriter, req *http.Reques
 	feedback(v, w)
 }
 
+func (h *HttpApiServer) statNotifyHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiStatNotifyResp
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	v.Data = h.sm.StatNotify()
+	feedback(v, w)
+}
+
+func (h *HttpApiServer) statAclHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiStatAclResp
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	v.Data = h.sm.StatAcl()
+	feedback(v, w)
+}
+
+func (h *HttpApiServer) statRecordRetentionHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiStatRecordRetentionResp
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	v.Data = h.sm.StatRecordRetention()
+	feedback(v, w)
+}
+
+func (h *HttpApiServer) statRelayPushHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiStatRelayPushResp
+
+	q := req.URL.Query()
+	streamName := q.Get("stream_name")
+	if streamName == "" {
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	resp := h.sm.StatRelayPush(streamName)
+	feedback(resp, w)
+}
+
+// statSnapshotHandler
+//
+// 参数format见 base.SnapshotFormatAnnexb 等。
+// 默认返回json，关键帧数据为base64编码；参数raw=1时成功直接返回关键帧数据，方便作为<video>的src使用，失败依然返回json
+func (h *HttpApiServer) statSnapshotHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiStatSnapshotResp
+
+	q := req.URL.Query()
+	streamName := q.Get("stream_name")
+	if streamName == "" {
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	resp := h.sm.StatSnapshot(streamName, q.Get("format"))
+	if resp.ErrorCode == base.ErrorCodeSucc && q.Get("raw") == "1" {
+		w.Header().Set("Content-Type", resp.Data.MimeType)
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write(resp.Data.Data)
+		return
+	}
+	feedback(resp, w)
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (h *HttpApiServer) ctrlStartRelayPullHandler(w http.ResponseWriter, req *http.Request) {
@@ -167,6 +253,156 @@ func (h *HttpApiServer) ctrlStopRelayPullHandler(w http.ResponseWriter, req *htt
 	feedback(resp, w)
 }
 
+func (h *HttpApiServer) ctrlStartRelayPushHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiCtrlStartRelayPushResp
+	var info base.ApiCtrlStartRelayPushReq
+
+	j, err := unmarshalRequestJsonBody(req, &info, "stream_name", "url")
+	if err != nil {
+		Log.Warnf("http api start push error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	if !j.Exist("retry_num") {
+		info.RetryNum = h.sm.config.RelayPushConfig.RetryNum
+	}
+	if !j.Exist("retry_interval_ms") {
+		info.RetryIntervalMs = h.sm.config.RelayPushConfig.RetryIntervalMs
+	}
+	if !j.Exist("retry_max_interval_ms") {
+		info.RetryMaxIntervalMs = h.sm.config.RelayPushConfig.RetryMaxIntervalMs
+	}
+	if !j.Exist("rtsp_mode") {
+		info.RtspMode = h.sm.config.RelayPushConfig.RtspMode
+	}
+
+	Log.Infof("http api start push. req info=%+v", info)
+
+	resp := h.sm.CtrlStartRelayPush(info)
+	feedback(resp, w)
+}
+
+func (h *HttpApiServer) ctrlStopRelayPushHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiCtrlStopRelayPushResp
+	var info base.ApiCtrlStopRelayPushReq
+
+	_, err := unmarshalRequestJsonBody(req, &info, "stream_name", "url")
+	if err != nil {
+		Log.Warnf("http api stop push error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	Log.Infof("http api stop push. req info=%+v", info)
+
+	resp := h.sm.CtrlStopRelayPush(info)
+	feedback(resp, w)
+}
+
+func (h *HttpApiServer) ctrlStartRecordHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiCtrlStartRecordResp
+	var info base.ApiCtrlStartRecordReq
+
+	_, err := unmarshalRequestJsonBody(req, &info, "stream_name", "format")
+	if err != nil {
+		Log.Warnf("http api start record error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	Log.Infof("http api start record. req info=%+v", info)
+
+	resp := h.sm.CtrlStartRecord(info)
+	feedback(resp, w)
+}
+
+func (h *HttpApiServer) ctrlStopRecordHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiCtrlStopRecordResp
+	var info base.ApiCtrlStopRecordReq
+
+	_, err := unmarshalRequestJsonBody(req, &info, "stream_name")
+	if err != nil {
+		Log.Warnf("http api stop record error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	Log.Infof("http api stop record. req info=%+v", info)
+
+	resp := h.sm.CtrlStopRecord(info)
+	feedback(resp, w)
+}
+
+func (h *HttpApiServer) ctrlStartFilePubHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiCtrlStartFilePubResp
+	var info base.ApiCtrlStartFilePubReq
+
+	_, err := unmarshalRequestJsonBody(req, &info, "stream_name", "path")
+	if err != nil {
+		Log.Warnf("http api start file pub error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	Log.Infof("http api start file pub. req info=%+v", info)
+
+	resp := h.sm.CtrlStartFilePub(info)
+	feedback(resp, w)
+}
+
+func (h *HttpApiServer) ctrlStopFilePubHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiCtrlStopFilePubResp
+	var info base.ApiCtrlStopFilePubReq
+
+	_, err := unmarshalRequestJsonBody(req, &info, "stream_name")
+	if err != nil {
+		Log.Warnf("http api stop file pub error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	Log.Infof("http api stop file pub. req info=%+v", info)
+
+	resp := h.sm.CtrlStopFilePub(info)
+	feedback(resp, w)
+}
+
+func (h *HttpApiServer) ctrlStartUdpTsPubHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiCtrlStartUdpTsPubResp
+	var info base.ApiCtrlStartUdpTsPubReq
+
+	j, err := unmarshalRequestJsonBody(req, &info, "stream_name", "addr")
+	if err != nil {
+		Log.Warnf("http api start udp ts pub error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	if !j.Exist("timeout_ms") {
+		info.TimeoutMs = DefaultApiCtrlStartUdpTsPubReqTimeoutMs
+	}
+
+	Log.Infof("http api start udp ts pub. req info=%+v", info)
+
+	resp := h.sm.CtrlStartUdpTsPub(info)
+	feedback(resp, w)
+}
+
 func (h *HttpApiServer) ctrlKickSessionHandler(w http.ResponseWriter, req *http.Request) {
 	var v base.ApiCtrlKickSessionResp
 	var info base.ApiCtrlKickSessionReq
@@ -186,6 +422,25 @@ func (h *HttpApiServer) ctrlKickSessionHandler(w http.ResponseWriter, req *http.
 	feedback(resp, w)
 }
 
+func (h *HttpApiServer) ctrlReloadAclHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiCtrlReloadAclResp
+	var info base.ApiCtrlReloadAclReq
+
+	_, err := unmarshalRequestJsonBody(req, &info, "enable")
+	if err != nil {
+		Log.Warnf("http api reload acl error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	Log.Infof("http api reload acl. req info=%+v", info)
+
+	resp := h.sm.CtrlReloadAcl(info)
+	feedback(resp, w)
+}
+
 func (h *HttpApiServer) ctrlStartRtpPubHandler(w http.ResponseWriter, req *http.Request) {
 	var v base.ApiCtrlStartRtpPubResp
 	var info base.ApiCtrlStartRtpPubReq
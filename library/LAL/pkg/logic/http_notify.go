@@ -9,14 +9,29 @@
 package logic
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"live-server/library/LAL/pkg/base"
-
-	"live-server/library/naza/pkg/nazahttp"
 )
 
+// http_notify.go
+//
+// - 所有通知在一个协程中按顺序发送，队首的通知发送失败时，按指数退避重试，重试期间后面的通知排队等待
+// - 配置了 retry_queue_filename 时，有通知待重试时将队列持久化到文件中，重启后继续发送
+// - 配置了 secret 时，在http header中携带签名，接收方使用 HttpNotifySign 校验
+//
+
 // TODO(chef): refactor 配置参数供外部传入
 // TODO(chef): refactor maxTaskLen修改为能表示是阻塞任务的意思
 var (
@@ -24,9 +39,32 @@ var (
 	notifyTimeoutSec = 3
 )
 
+// http notify携带的http header
+const (
+	HttpNotifyHeaderEvent     = "X-Lal-Event"
+	HttpNotifyHeaderSeq       = "X-Lal-Seq"
+	HttpNotifyHeaderTimestamp = "X-Lal-Timestamp"
+	HttpNotifyHeaderSignature = "X-Lal-Signature"
+)
+
+// HttpNotifySign 计算http notify的签名，即 hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// @param timestamp: http header X-Lal-Timestamp 的值，unix秒
+// @param body:      http body原始内容
+func HttpNotifySign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 type PostTask struct {
-	url  string
-	info interface{}
+	Seq        uint64          `json:"seq"`
+	Event      string          `json:"event"`
+	Url        string          `json:"url"`
+	Body       json.RawMessage `json:"body"`
+	RetryCount int             `json:"retry_count"`
 }
 
 type HttpNotify struct {
@@ -36,6 +74,16 @@ type HttpNotify struct {
 
 	taskQueue chan PostTask
 	client    *http.Client
+
+	disposeOnce sync.Once
+	exitChan    chan struct{}
+	doneChan    chan struct{}
+
+	mutex     sync.Mutex
+	seq       uint64
+	pending   []PostTask // 只在RunLoop中修改，加锁是为了统计
+	persisted bool       // 持久化文件中是否有内容
+	stat      base.StatNotifySink
 }
 
 func NewHttpNotify(cfg HttpNotifyConfig, serverId string) *HttpNotify {
@@ -46,64 +94,94 @@ func NewHttpNotify(cfg HttpNotifyConfig, serverId string) *HttpNotify {
 		client: &http.Client{
 			Timeout: time.Duration(notifyTimeoutSec) * time.Second,
 		},
+		exitChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
 	}
+	httpNotify.loadRetryQueue()
 	go httpNotify.RunLoop()
 
 	return httpNotify
 }
 
-// TODO(chef): Dispose
+// Dispose 停止发送协程
+//
+// 队列中还没有发送的通知，配置了 retry_queue_filename 时持久化到文件中，否则丢弃
+func (h *HttpNotify) Dispose() {
+	h.disposeOnce.Do(func() {
+		close(h.exitChan)
+		<-h.doneChan
+	})
+}
+
+// StatNotifySink 实现 INotifySinkStat
+func (h *HttpNotify) StatNotifySink() base.StatNotifySink {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	ret := h.stat
+	ret.PendingNum = len(h.pending) + len(h.taskQueue)
+	return ret
+}
 
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (h *HttpNotify) NotifyServerStart(info base.LalInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnServerStart, info)
+	h.asyncPost(NotifyEventServerStart, h.cfg.OnServerStart, info)
 }
 
 func (h *HttpNotify) NotifyUpdate(info base.UpdateInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnUpdate, info)
+	h.asyncPost(NotifyEventUpdate, h.cfg.OnUpdate, info)
 }
 
 func (h *HttpNotify) NotifyPubStart(info base.PubStartInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnPubStart, info)
+	h.asyncPost(NotifyEventPubStart, h.cfg.OnPubStart, info)
 }
 
 func (h *HttpNotify) NotifyPubStop(info base.PubStopInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnPubStop, info)
+	h.asyncPost(NotifyEventPubStop, h.cfg.OnPubStop, info)
 }
 
 func (h *HttpNotify) NotifySubStart(info base.SubStartInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnSubStart, info)
+	h.asyncPost(NotifyEventSubStart, h.cfg.OnSubStart, info)
 }
 
 func (h *HttpNotify) NotifySubStop(info base.SubStopInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnSubStop, info)
+	h.asyncPost(NotifyEventSubStop, h.cfg.OnSubStop, info)
 }
 
 func (h *HttpNotify) NotifyPullStart(info base.PullStartInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnRelayPullStart, info)
+	h.asyncPost(NotifyEventRelayPullStart, h.cfg.OnRelayPullStart, info)
 }
 
 func (h *HttpNotify) NotifyPullStop(info base.PullStopInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnRelayPullStop, info)
+	h.asyncPost(NotifyEventRelayPullStop, h.cfg.OnRelayPullStop, info)
 }
 
 func (h *HttpNotify) NotifyRtmpConnect(info base.RtmpConnectInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnRtmpConnect, info)
+	h.asyncPost(NotifyEventRtmpConnect, h.cfg.OnRtmpConnect, info)
 }
 
 func (h *HttpNotify) NotifyOnHlsMakeTs(info base.HlsMakeTsInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnHlsMakeTs, info)
+	h.asyncPost(NotifyEventHlsMakeTs, h.cfg.OnHlsMakeTs, info)
+}
+
+func (h *HttpNotify) NotifyOnRecordSegment(info base.RecordSegmentInfo) {
+	info.ServerId = h.serverId
+	h.asyncPost(NotifyEventRecordSegment, h.cfg.OnRecordSegment, info)
+}
+
+func (h *HttpNotify) NotifyOnRecordError(info base.RecordErrorInfo) {
+	info.ServerId = h.serverId
+	h.asyncPost(NotifyEventRecordError, h.cfg.OnRecordError, info)
 }
 
 // ----- implement INotifyHandler interface ----------------------------------------------------------------------------
@@ -148,34 +226,248 @@ func (h *HttpNotify) OnHlsMakeTs(info base.HlsMakeTsInfo) {
 	h.NotifyOnHlsMakeTs(info)
 }
 
+func (h *HttpNotify) OnRecordSegment(info base.RecordSegmentInfo) {
+	h.NotifyOnRecordSegment(info)
+}
+
+func (h *HttpNotify) OnRecordError(info base.RecordErrorInfo) {
+	h.NotifyOnRecordError(info)
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (h *HttpNotify) RunLoop() {
+	defer close(h.doneChan)
+
+	var retryTimer <-chan time.Time
 	for {
+		// 队首的通知不在重试等待中时，持续发送，直到队列为空或者发送失败
+		if retryTimer == nil && h.pendingNum() > 0 {
+			h.drainTaskQueue()
+			if ok, backoff := h.postHead(); !ok {
+				retryTimer = time.After(backoff)
+			}
+			select {
+			case <-h.exitChan:
+				h.drainTaskQueue()
+				h.persistRetryQueue()
+				return
+			default:
+				continue
+			}
+		}
+
 		select {
 		case t := <-h.taskQueue:
-			h.post(t.url, t.info)
+			h.pushPending(t)
+		case <-retryTimer:
+			retryTimer = nil
+		case <-h.exitChan:
+			h.drainTaskQueue()
+			h.persistRetryQueue()
+			return
 		}
 	}
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
 
-func (h *HttpNotify) asyncPost(url string, info interface{}) {
+func (h *HttpNotify) asyncPost(event string, url string, info interface{}) {
 	if !h.cfg.Enable || url == "" {
 		return
 	}
 
+	body, err := json.Marshal(info)
+	if err != nil {
+		Log.Errorf("http notify marshal failed. err=%+v, info=%+v", err, info)
+		return
+	}
+
+	h.mutex.Lock()
+	h.seq++
+	task := PostTask{Seq: h.seq, Event: event, Url: url, Body: body}
+	h.stat.DispatchedNum++
+	h.mutex.Unlock()
+
 	select {
-	case h.taskQueue <- PostTask{url: url, info: info}:
+	case h.taskQueue <- task:
 		// noop
 	default:
 		Log.Error("http notify queue full.")
+		h.mutex.Lock()
+		h.stat.DroppedNum++
+		h.mutex.Unlock()
+	}
+}
+
+// postHead 发送队首的通知
+//
+// @return ok:      true表示队首的通知已经出队（发送成功或者放弃重试），false表示需要等待 backoff 后重试
+func (h *HttpNotify) postHead() (ok bool, backoff time.Duration) {
+	h.mutex.Lock()
+	task := h.pending[0]
+	h.mutex.Unlock()
+
+	err := h.post(task)
+
+	h.mutex.Lock()
+	if err == nil {
+		h.stat.DeliveredNum++
+		h.pending = h.pending[1:]
+		h.mutex.Unlock()
+		if h.persisted {
+			h.persistRetryQueue()
+		}
+		return true, 0
+	}
+
+	h.stat.FailedNum++
+	h.stat.LastError = err.Error()
+	h.stat.LastErrorTime = base.ReadableNowTime()
+	if h.cfg.RetryNum >= 0 && task.RetryCount >= h.cfg.RetryNum {
+		h.stat.DroppedNum++
+		h.pending = h.pending[1:]
+		h.mutex.Unlock()
+		Log.Errorf("http notify post failed, drop it. err=%+v, event=%s, seq=%d, url=%s, retry=%d",
+			err, task.Event, task.Seq, task.Url, task.RetryCount)
+		h.persistRetryQueue()
+		return true, 0
+	}
+	h.stat.RetriedNum++
+	h.pending[0].RetryCount++
+	h.mutex.Unlock()
+
+	backoff = h.retryBackoff(task.RetryCount)
+	Log.Warnf("http notify post failed, retry after %s. err=%+v, event=%s, seq=%d, url=%s, retry=%d",
+		backoff, err, task.Event, task.Seq, task.Url, task.RetryCount)
+	h.persistRetryQueue()
+	return false, backoff
+}
+
+func (h *HttpNotify) post(task PostTask) error {
+	req, err := http.NewRequest(http.MethodPost, task.Url, bytes.NewReader(task.Body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HttpNotifyHeaderEvent, task.Event)
+	req.Header.Set(HttpNotifyHeaderSeq, strconv.FormatUint(task.Seq, 10))
+	req.Header.Set(HttpNotifyHeaderTimestamp, timestamp)
+	if h.cfg.Secret != "" {
+		req.Header.Set(HttpNotifyHeaderSignature, HttpNotifySign(h.cfg.Secret, timestamp, task.Body))
 	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("http notify response status invalid. status=%d", resp.StatusCode)
+	}
+	return nil
+}
+
+// retryBackoff 第retryCount次重试前的等待时间，从 retry_interval_ms 开始每次翻倍，不超过 retry_max_interval_ms
+func (h *HttpNotify) retryBackoff(retryCount int) time.Duration {
+	interval := h.cfg.RetryIntervalMs
+	for i := 0; i < retryCount && interval < h.cfg.RetryMaxIntervalMs; i++ {
+		interval *= 2
+	}
+	if h.cfg.RetryMaxIntervalMs > 0 && interval > h.cfg.RetryMaxIntervalMs {
+		interval = h.cfg.RetryMaxIntervalMs
+	}
+	return time.Duration(interval) * time.Millisecond
+}
+
+func (h *HttpNotify) pendingNum() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.pending)
+}
+
+func (h *HttpNotify) pushPending(task PostTask) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	// 队列满时丢弃最早的通知，但是不丢弃正在重试中的队首
+	if len(h.pending) >= maxTaskLen {
+		Log.Warnf("http notify pending queue full, drop oldest. event=%s, seq=%d", h.pending[1].Event, h.pending[1].Seq)
+		h.pending = append(h.pending[:1], h.pending[2:]...)
+		h.stat.DroppedNum++
+	}
+	h.pending = append(h.pending, task)
 }
 
-func (h *HttpNotify) post(url string, info interface{}) {
-	if _, err := nazahttp.PostJson(url, info, h.client); err != nil {
-		Log.Errorf("http notify post error. err=%+v, url=%s, info=%+v", err, url, info)
+func (h *HttpNotify) drainTaskQueue() {
+	for {
+		select {
+		case t := <-h.taskQueue:
+			h.pushPending(t)
+		default:
+			return
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// persistRetryQueue 将待发送的通知写入持久化文件，队列为空时删除文件
+func (h *HttpNotify) persistRetryQueue() {
+	if h.cfg.RetryQueueFilename == "" {
+		return
+	}
+
+	h.mutex.Lock()
+	if len(h.pending) == 0 {
+		h.mutex.Unlock()
+		if err := os.Remove(h.cfg.RetryQueueFilename); err != nil && !os.IsNotExist(err) {
+			Log.Errorf("http notify remove retry queue file failed. err=%+v", err)
+		}
+		h.persisted = false
+		return
+	}
+	content, err := json.Marshal(h.pending)
+	h.mutex.Unlock()
+	if err != nil {
+		Log.Errorf("http notify marshal retry queue failed. err=%+v", err)
+		return
+	}
+
+	if err = os.WriteFile(h.cfg.RetryQueueFilename, content, 0644); err != nil {
+		Log.Errorf("http notify write retry queue file failed. err=%+v, filename=%s", err, h.cfg.RetryQueueFilename)
+		return
+	}
+	h.persisted = true
+}
+
+func (h *HttpNotify) loadRetryQueue() {
+	if h.cfg.RetryQueueFilename == "" {
+		return
+	}
+	content, err := os.ReadFile(h.cfg.RetryQueueFilename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Log.Errorf("http notify read retry queue file failed. err=%+v, filename=%s", err, h.cfg.RetryQueueFilename)
+		}
+		return
+	}
+	var tasks []PostTask
+	if err = json.Unmarshal(content, &tasks); err != nil {
+		Log.Errorf("http notify unmarshal retry queue file failed. err=%+v, filename=%s", err, h.cfg.RetryQueueFilename)
+		return
+	}
+	for _, t := range tasks {
+		if t.Seq > h.seq {
+			h.seq = t.Seq
+		}
+	}
+	if len(tasks) > maxTaskLen {
+		h.stat.DroppedNum += uint64(len(tasks) - maxTaskLen)
+		tasks = tasks[len(tasks)-maxTaskLen:]
 	}
+	h.pending = tasks
+	h.persisted = true
+	Log.Infof("http notify load retry queue. num=%d, filename=%s", len(tasks), h.cfg.RetryQueueFilename)
 }
@@ -9,6 +9,9 @@
 package logic
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"strings"
 
@@ -72,6 +75,9 @@ func (h *HttpServerHandler) ServeSubSession(writer http.ResponseWriter, req *htt
 		Log.Debugf("[%s] < read http request. url=%s", session.UniqueKey(), session.Url())
 		if err = h.observer.OnNewHttpflvSubSession(session); err != nil {
 			Log.Infof("[%s] dispose by observer. err=%+v", session.UniqueKey(), err)
+			if errors.Is(err, base.ErrAclRejected) {
+				writeAclRejectedRawResp(conn)
+			}
 			_ = session.Dispose()
 			return
 		}
@@ -86,6 +92,9 @@ func (h *HttpServerHandler) ServeSubSession(writer http.ResponseWriter, req *htt
 		Log.Debugf("[%s] < read http request. url=%s", session.UniqueKey(), session.Url())
 		if err = h.observer.OnNewHttptsSubSession(session); err != nil {
 			Log.Infof("[%s] dispose by observer. err=%+v", session.UniqueKey(), err)
+			if errors.Is(err, base.ErrAclRejected) {
+				writeAclRejectedRawResp(conn)
+			}
 			_ = session.Dispose()
 			return
 		}
@@ -95,3 +104,10 @@ func (h *HttpServerHandler) ServeSubSession(writer http.ResponseWriter, req *htt
 		return
 	}
 }
+
+// writeAclRejectedRawResp 连接已经被hijack，直接写http响应
+func writeAclRejectedRawResp(conn net.Conn) {
+	body := aclRejectedResp()
+	_, _ = fmt.Fprintf(conn, "HTTP/1.1 403 Forbidden\r\nContent-Type: application/json\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		len(body), body)
+}
@@ -53,6 +53,63 @@ type ILalServer interface {
 	CtrlStartRelayPull(info base.ApiCtrlStartRelayPullReq) base.ApiCtrlStartRelayPullResp
 	CtrlStopRelayPull(streamName string) base.ApiCtrlStopRelayPullResp
 	CtrlKickSession(info base.ApiCtrlKickSessionReq) base.ApiCtrlKickSessionResp
+	CtrlStartRtpPub(info base.ApiCtrlStartRtpPubReq) base.ApiCtrlStartRtpPubResp
+
+	// StatRelayPush CtrlStartRelayPush CtrlStopRelayPush
+	//
+	// relay push转推目标相关的API，包含配置文件中的转推目标
+	//
+	StatRelayPush(streamName string) base.ApiStatRelayPushResp
+	CtrlStartRelayPush(info base.ApiCtrlStartRelayPushReq) base.ApiCtrlStartRelayPushResp
+	CtrlStopRelayPush(info base.ApiCtrlStopRelayPushReq) base.ApiCtrlStopRelayPushResp
+
+	// CtrlStartRecord CtrlStopRecord
+	//
+	// 按需开启和停止录制，正在进行的录制见 StatGroup 中的 StatRecords
+	//
+	CtrlStartRecord(info base.ApiCtrlStartRecordReq) base.ApiCtrlStartRecordResp
+	CtrlStopRecord(info base.ApiCtrlStopRecordReq) base.ApiCtrlStopRecordResp
+
+	// CtrlStartFilePub CtrlStopFilePub
+	//
+	// 将flv或mpegts文件作为直播流推入，见 FilePublisher
+	//
+	CtrlStartFilePub(info base.ApiCtrlStartFilePubReq) base.ApiCtrlStartFilePubResp
+	CtrlStopFilePub(info base.ApiCtrlStopFilePubReq) base.ApiCtrlStopFilePubResp
+
+	// CtrlStartUdpTsPub
+	//
+	// 接收udp单播或组播的mpegts流作为输入流，停止时使用 CtrlKickSession
+	//
+	CtrlStartUdpTsPub(info base.ApiCtrlStartUdpTsPubReq) base.ApiCtrlStartUdpTsPubResp
+
+	// StatSnapshot
+	//
+	// 获取直播流最近的一个视频关键帧，format见 base.SnapshotFormatAnnexb 等，为空时使用 base.SnapshotFormatAnnexb
+	//
+	StatSnapshot(streamName string, format string) base.ApiStatSnapshotResp
+
+	// StatRecordRetention
+	//
+	// 录制文件保留策略的状态，见 RecordRetention
+	//
+	StatRecordRetention() base.StatRecordRetention
+
+	// StatAcl CtrlReloadAcl
+	//
+	// acl策略相关的API，重新加载的策略只对新的session生效
+	//
+	StatAcl() base.StatAcl
+	CtrlReloadAcl(info base.ApiCtrlReloadAclReq) base.ApiCtrlReloadAclResp
+
+	// StatNotify AddNotifySink DelNotifySink SetNotifyEventEnable
+	//
+	// 事件通知相关的API，详细说明见 NotifyDispatcher
+	//
+	StatNotify() base.StatNotify
+	AddNotifySink(name string, handler INotifyHandler) error
+	DelNotifySink(name string) bool
+	SetNotifyEventEnable(event string, enable bool)
 }
 
 // NewLalServer 创建一个lal server
@@ -102,6 +159,8 @@ type INotifyHandler interface {
 	OnRelayPullStop(info base.PullStopInfo)
 	OnRtmpConnect(info base.RtmpConnectInfo)
 	OnHlsMakeTs(info base.HlsMakeTsInfo)
+	OnRecordSegment(info base.RecordSegmentInfo)
+	OnRecordError(info base.RecordErrorInfo)
 }
 
 type Option struct {
@@ -121,8 +180,8 @@ type Option struct {
 	//
 	// 事件监听
 	// 业务方可实现 INotifyHandler 接口并传入从而获取到对应的事件通知。
-	// 如果不填写保持默认值nil，内部默认走http notify的逻辑（当然，还需要在配置文件中开启http notify功能）。
-	// 注意，如果业务方实现了自己的事件监听，则lal server内部不再走http notify的逻辑（也即二选一）。
+	// 注意，业务方的事件监听和http notify（需要在配置文件中开启）可以同时存在，事件会分发给两者。
+	// 运行时还可以通过 ILalServer.AddNotifySink 注册更多的事件监听。
 	//
 	NotifyHandler INotifyHandler
 
@@ -137,10 +196,17 @@ type Option struct {
 	// Authentication
 	// This interface make authenticate customizable so that we can implement any authenticate strategy like jwt...
 	Authentication IAuthentication
+
+	// DisableSignalHandler
+	//
+	// 为true时，RunLoop 内部不监听SIGUSR1、SIGUSR2信号。
+	// 应用场景：lalserver作为package集成到其他程序中，并且会多次创建（比如重启）时，应该由业务方在进程级别监听一次信号，
+	// 否则每次 RunLoop 都会启动一个信号监听协程，收到信号时已经释放的 ILalServer 也会被再次 Dispose 。
+	DisableSignalHandler bool
 }
 
 var defaultOption = Option{
-	NotifyHandler: nil, // 注意，为nil时，内部会赋值为 HttpNotify
+	NotifyHandler: nil,
 }
 
 type ModOption func(option *Option)
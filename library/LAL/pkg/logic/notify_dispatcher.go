@@ -0,0 +1,208 @@
+package logic
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"live-server/library/LAL/pkg/base"
+)
+
+// notify_dispatcher.go
+//
+// 事件通知的分发器，将事件分发给多个sink（比如http notify和业务方自己实现的 INotifyHandler ）。
+// 可以按事件类型关闭分发，关闭后所有sink都收不到该类型的事件。
+//
+
+var ErrNotifySinkExist = errors.New("lal.logic: notify sink already exist")
+
+// 事件名，和http notify配置中的字段名保持一致
+const (
+	NotifyEventServerStart    = "on_server_start"
+	NotifyEventUpdate         = "on_update"
+	NotifyEventPubStart       = "on_pub_start"
+	NotifyEventPubStop        = "on_pub_stop"
+	NotifyEventSubStart       = "on_sub_start"
+	NotifyEventSubStop        = "on_sub_stop"
+	NotifyEventRelayPullStart = "on_relay_pull_start"
+	NotifyEventRelayPullStop  = "on_relay_pull_stop"
+	NotifyEventRtmpConnect    = "on_rtmp_connect"
+	NotifyEventHlsMakeTs      = "on_hls_make_ts"
+	NotifyEventRecordSegment  = "on_record_segment"
+	NotifyEventRecordError    = "on_record_error"
+)
+
+// 内部注册的sink的名字
+const (
+	NotifySinkNameHttp      = "http_notify"
+	NotifySinkNameCustomize = "customize" // Option.NotifyHandler
+)
+
+// INotifySinkStat sink可以选择实现该接口，提供更详细的投递统计，比如 HttpNotify
+//
+// 没有实现该接口的sink，同步调用完成即认为投递成功
+type INotifySinkStat interface {
+	StatNotifySink() base.StatNotifySink
+}
+
+type notifySink struct {
+	name          string
+	handler       INotifyHandler
+	dispatchedNum uint64
+}
+
+type NotifyDispatcher struct {
+	mutex          sync.Mutex
+	sinks          []*notifySink
+	disabledEvents map[string]bool
+	filteredNum    uint64
+}
+
+func NewNotifyDispatcher(cfg NotifyConfig) *NotifyDispatcher {
+	d := &NotifyDispatcher{
+		disabledEvents: make(map[string]bool),
+	}
+	for _, event := range cfg.DisabledEvents {
+		d.disabledEvents[event] = true
+	}
+	return d
+}
+
+// AddSink 注册sink，name不能重复
+func (d *NotifyDispatcher) AddSink(name string, handler INotifyHandler) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, s := range d.sinks {
+		if s.name == name {
+			return ErrNotifySinkExist
+		}
+	}
+	d.sinks = append(d.sinks, &notifySink{name: name, handler: handler})
+	return nil
+}
+
+// DelSink 删除sink，注意，不会调用sink的Dispose
+//
+// @return 是否找到并删除了该sink
+func (d *NotifyDispatcher) DelSink(name string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for i, s := range d.sinks {
+		if s.name == name {
+			d.sinks = append(d.sinks[:i], d.sinks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SetEventEnable 开启或关闭某个类型的事件，event取值见 NotifyEventServerStart 等
+func (d *NotifyDispatcher) SetEventEnable(event string, enable bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if enable {
+		delete(d.disabledEvents, event)
+	} else {
+		d.disabledEvents[event] = true
+	}
+}
+
+func (d *NotifyDispatcher) Stat() base.StatNotify {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	ret := base.StatNotify{
+		DisabledEvents: make([]string, 0, len(d.disabledEvents)),
+		FilteredNum:    d.filteredNum,
+		Sinks:          make([]base.StatNotifySink, 0, len(d.sinks)),
+	}
+	for event := range d.disabledEvents {
+		ret.DisabledEvents = append(ret.DisabledEvents, event)
+	}
+	sort.Strings(ret.DisabledEvents)
+
+	for _, s := range d.sinks {
+		var ss base.StatNotifySink
+		if st, ok := s.handler.(INotifySinkStat); ok {
+			ss = st.StatNotifySink()
+		} else {
+			ss.DispatchedNum = s.dispatchedNum
+			ss.DeliveredNum = s.dispatchedNum
+		}
+		ss.Name = s.name
+		ret.Sinks = append(ret.Sinks, ss)
+	}
+	return ret
+}
+
+// ----- implement INotifyHandler interface ----------------------------------------------------------------------------
+
+func (d *NotifyDispatcher) OnServerStart(info base.LalInfo) {
+	d.dispatch(NotifyEventServerStart, func(h INotifyHandler) { h.OnServerStart(info) })
+}
+
+func (d *NotifyDispatcher) OnUpdate(info base.UpdateInfo) {
+	d.dispatch(NotifyEventUpdate, func(h INotifyHandler) { h.OnUpdate(info) })
+}
+
+func (d *NotifyDispatcher) OnPubStart(info base.PubStartInfo) {
+	d.dispatch(NotifyEventPubStart, func(h INotifyHandler) { h.OnPubStart(info) })
+}
+
+func (d *NotifyDispatcher) OnPubStop(info base.PubStopInfo) {
+	d.dispatch(NotifyEventPubStop, func(h INotifyHandler) { h.OnPubStop(info) })
+}
+
+func (d *NotifyDispatcher) OnSubStart(info base.SubStartInfo) {
+	d.dispatch(NotifyEventSubStart, func(h INotifyHandler) { h.OnSubStart(info) })
+}
+
+func (d *NotifyDispatcher) OnSubStop(info base.SubStopInfo) {
+	d.dispatch(NotifyEventSubStop, func(h INotifyHandler) { h.OnSubStop(info) })
+}
+
+func (d *NotifyDispatcher) OnRelayPullStart(info base.PullStartInfo) {
+	d.dispatch(NotifyEventRelayPullStart, func(h INotifyHandler) { h.OnRelayPullStart(info) })
+}
+
+func (d *NotifyDispatcher) OnRelayPullStop(info base.PullStopInfo) {
+	d.dispatch(NotifyEventRelayPullStop, func(h INotifyHandler) { h.OnRelayPullStop(info) })
+}
+
+func (d *NotifyDispatcher) OnRtmpConnect(info base.RtmpConnectInfo) {
+	d.dispatch(NotifyEventRtmpConnect, func(h INotifyHandler) { h.OnRtmpConnect(info) })
+}
+
+func (d *NotifyDispatcher) OnHlsMakeTs(info base.HlsMakeTsInfo) {
+	d.dispatch(NotifyEventHlsMakeTs, func(h INotifyHandler) { h.OnHlsMakeTs(info) })
+}
+
+func (d *NotifyDispatcher) OnRecordSegment(info base.RecordSegmentInfo) {
+	d.dispatch(NotifyEventRecordSegment, func(h INotifyHandler) { h.OnRecordSegment(info) })
+}
+
+func (d *NotifyDispatcher) OnRecordError(info base.RecordErrorInfo) {
+	d.dispatch(NotifyEventRecordError, func(h INotifyHandler) { h.OnRecordError(info) })
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (d *NotifyDispatcher) dispatch(event string, fn func(h INotifyHandler)) {
+	d.mutex.Lock()
+	if d.disabledEvents[event] {
+		d.filteredNum++
+		d.mutex.Unlock()
+		return
+	}
+	// 在锁外回调，避免sink中再调用dispatcher的方法造成死锁
+	handlers := make([]INotifyHandler, len(d.sinks))
+	for i, s := range d.sinks {
+		s.dispatchedNum++
+		handlers[i] = s.handler
+	}
+	d.mutex.Unlock()
+
+	for _, h := range handlers {
+		fn(h)
+	}
+}
@@ -0,0 +1,181 @@
+package logic
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+
+	"live-server/library/naza/pkg/assert"
+)
+
+type testNotifyHandler struct {
+	mutex    sync.Mutex
+	pubStart []base.PubStartInfo
+	update   []base.UpdateInfo
+}
+
+func (h *testNotifyHandler) OnServerStart(info base.LalInfo)             {}
+func (h *testNotifyHandler) OnPubStop(info base.PubStopInfo)             {}
+func (h *testNotifyHandler) OnSubStart(info base.SubStartInfo)           {}
+func (h *testNotifyHandler) OnSubStop(info base.SubStopInfo)             {}
+func (h *testNotifyHandler) OnRelayPullStart(info base.PullStartInfo)    {}
+func (h *testNotifyHandler) OnRelayPullStop(info base.PullStopInfo)      {}
+func (h *testNotifyHandler) OnRtmpConnect(info base.RtmpConnectInfo)     {}
+func (h *testNotifyHandler) OnHlsMakeTs(info base.HlsMakeTsInfo)         {}
+func (h *testNotifyHandler) OnRecordSegment(info base.RecordSegmentInfo) {}
+func (h *testNotifyHandler) OnRecordError(info base.RecordErrorInfo)     {}
+
+func (h *testNotifyHandler) OnPubStart(info base.PubStartInfo) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.pubStart = append(h.pubStart, info)
+}
+
+func (h *testNotifyHandler) OnUpdate(info base.UpdateInfo) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.update = append(h.update, info)
+}
+
+func TestNotifyDispatcher(t *testing.T) {
+	d := NewNotifyDispatcher(NotifyConfig{DisabledEvents: []string{NotifyEventUpdate}})
+	h1 := &testNotifyHandler{}
+	h2 := &testNotifyHandler{}
+	assert.Equal(t, nil, d.AddSink("h1", h1))
+	assert.Equal(t, nil, d.AddSink("h2", h2))
+	assert.Equal(t, ErrNotifySinkExist, d.AddSink("h1", h1))
+
+	var info base.PubStartInfo
+	info.StreamName = "test110"
+	d.OnPubStart(info)
+	d.OnUpdate(base.UpdateInfo{})
+	assert.Equal(t, 1, len(h1.pubStart))
+	assert.Equal(t, "test110", h2.pubStart[0].StreamName)
+	assert.Equal(t, 0, len(h1.update))
+
+	d.SetEventEnable(NotifyEventUpdate, true)
+	d.OnUpdate(base.UpdateInfo{})
+	assert.Equal(t, 1, len(h2.update))
+
+	assert.Equal(t, true, d.DelSink("h2"))
+	assert.Equal(t, false, d.DelSink("h2"))
+	d.OnPubStart(info)
+	assert.Equal(t, 2, len(h1.pubStart))
+	assert.Equal(t, 1, len(h2.pubStart))
+
+	stat := d.Stat()
+	assert.Equal(t, uint64(1), stat.FilteredNum)
+	assert.Equal(t, 0, len(stat.DisabledEvents))
+	assert.Equal(t, 1, len(stat.Sinks))
+	assert.Equal(t, "h1", stat.Sinks[0].Name)
+	assert.Equal(t, uint64(3), stat.Sinks[0].DispatchedNum)
+	assert.Equal(t, uint64(3), stat.Sinks[0].DeliveredNum)
+}
+
+func TestHttpNotifySign(t *testing.T) {
+	res := HttpNotifySign("q191201771", "1700000000", []byte(`{"server_id":"1"}`))
+	assert.Equal(t, "d22c076a49af1d90c5d64090c3f579914ee78e42f19a11b65817669d5752fb1b", res)
+}
+
+func TestHttpNotifyRetry(t *testing.T) {
+	var mutex sync.Mutex
+	var failNum = 2
+	var seqs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, HttpNotifySign("q191201771", r.Header.Get(HttpNotifyHeaderTimestamp), body), r.Header.Get(HttpNotifyHeaderSignature))
+		assert.Equal(t, NotifyEventPubStart, r.Header.Get(HttpNotifyHeaderEvent))
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if failNum > 0 {
+			failNum--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		seqs = append(seqs, r.Header.Get(HttpNotifyHeaderSeq))
+	}))
+	defer srv.Close()
+
+	filename := filepath.Join(t.TempDir(), "retry_queue.json")
+	h := NewHttpNotify(HttpNotifyConfig{
+		Enable:             true,
+		OnPubStart:         srv.URL,
+		Secret:             "q191201771",
+		RetryNum:           -1,
+		RetryIntervalMs:    10,
+		RetryMaxIntervalMs: 20,
+		RetryQueueFilename: filename,
+	}, "1")
+	h.OnPubStart(base.PubStartInfo{})
+	h.OnPubStart(base.PubStartInfo{})
+
+	for i := 0; i < 100 && h.StatNotifySink().DeliveredNum < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.Dispose()
+
+	stat := h.StatNotifySink()
+	assert.Equal(t, uint64(2), stat.DispatchedNum)
+	assert.Equal(t, uint64(2), stat.DeliveredNum)
+	assert.Equal(t, uint64(2), stat.FailedNum)
+	assert.Equal(t, uint64(2), stat.RetriedNum)
+	assert.Equal(t, 0, stat.PendingNum)
+	// 按顺序送达
+	assert.Equal(t, []string{"1", "2"}, seqs)
+	// 队列为空时持久化文件被删除
+	_, err := os.Stat(filename)
+	assert.Equal(t, true, os.IsNotExist(err))
+}
+
+func TestHttpNotifyPersist(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "retry_queue.json")
+	cfg := HttpNotifyConfig{
+		Enable:             true,
+		OnPubStart:         "http://127.0.0.1:1/on_pub_start",
+		RetryNum:           -1,
+		RetryIntervalMs:    10000,
+		RetryMaxIntervalMs: 10000,
+		RetryQueueFilename: filename,
+	}
+	h := NewHttpNotify(cfg, "1")
+	h.OnPubStart(base.PubStartInfo{})
+	h.OnPubStart(base.PubStartInfo{})
+	for i := 0; i < 100 && h.StatNotifySink().FailedNum < 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.Dispose()
+	assert.Equal(t, 2, h.StatNotifySink().PendingNum)
+
+	// 重启后继续发送持久化的通知，新通知的序号接在后面
+	var mutex sync.Mutex
+	var seqs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		seqs = append(seqs, r.Header.Get(HttpNotifyHeaderSeq))
+	}))
+	defer srv.Close()
+
+	content, err := os.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	content = []byte(strings.ReplaceAll(string(content), cfg.OnPubStart, srv.URL))
+	assert.Equal(t, nil, os.WriteFile(filename, content, 0644))
+
+	cfg.OnPubStart = srv.URL
+	h = NewHttpNotify(cfg, "1")
+	h.OnPubStart(base.PubStartInfo{})
+	for i := 0; i < 100 && h.StatNotifySink().DeliveredNum < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.Dispose()
+	assert.Equal(t, []string{"1", "2", "3"}, seqs)
+}
@@ -0,0 +1,470 @@
+package logic
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/hls"
+	"live-server/library/naza/pkg/filesystemlayer"
+)
+
+// record_retention.go
+//
+// 录制文件的保留策略，后台定时检查：
+//
+// - 删除最后修改时间超过 RecordRetentionConfig.MaxAgeDays 天的录制文件
+// - 每路流的录制文件总大小超过 RecordRetentionConfig.StreamQuotaMb ，或者所有录制文件的总大小超过 RecordRetentionConfig.TotalQuotaMb 时，
+//   从最旧的文件开始删除
+// - 录制目录所在磁盘的使用率超过 RecordRetentionConfig.DiskUsageWatermark 时，停止所有录制，并发送 on_record_error 事件，
+//   使用率回落后，新的输入流以及按需开启的录制可以正常录制
+//
+// 管理的目录包括flv、mpegts、mp4的录制目录，以及hls在录制模式（CleanupModeNever 或 CleanupModeInTheEnd）下的输出目录。
+// hls每路流的所有文件作为一个整体管理，只有流结束后才会被整体删除，流进行中的切片清理见 hls.MuxerConfig.DvrRetentionSec 。
+// hls使用内存作为磁盘时同样适用，只是不检查磁盘使用率。
+//
+// 正在写入的文件，以及最近修改过的文件不会被删除。
+//
+
+// recordRecentlyModifiedDuration 最近修改过的文件认为可能正在写入，不删除
+const recordRecentlyModifiedDuration = time.Minute
+
+type IRecordRetentionObserver interface {
+	// RecordFiles 正在写入的录制文件，以及正在输出的hls目录，这些文件不会被删除
+	RecordFiles() []string
+
+	// OnRecordSuspend 磁盘使用率超过水位线，需要停止所有录制
+	OnRecordSuspend(reason string)
+
+	// OnRecordResume 磁盘使用率回落到水位线以下
+	OnRecordResume()
+}
+
+type RecordRetention struct {
+	config   RecordRetentionConfig
+	sources  []recordSource
+	observer IRecordRetentionObserver
+	exitChan chan struct{}
+
+	getDiskUsage func(path string) (base.DiskUsage, error) // 默认为 base.GetDiskUsage
+
+	mutex sync.Mutex
+	stat  base.StatRecordRetention
+}
+
+// recordSource 一个录制目录
+type recordSource struct {
+	format    string
+	root      string
+	fsl       filesystemlayer.IFileSystemLayer
+	checkDisk bool // 是否检查所在磁盘的使用率，使用内存作为磁盘时不检查
+
+	// parseStreamName 根据相对于root的路径（使用/分隔）解析出流名称，解析失败时返回空字符串
+	parseStreamName func(rel string) string
+}
+
+// recordItem 保留策略管理的最小单元，一个录制文件，或者hls一路流的目录
+type recordItem struct {
+	src        *recordSource
+	streamName string
+	path       string
+	isDir      bool
+	size       int64
+	fileNum    int
+	modTime    time.Time // 目录时为目录下最后修改的文件的时间
+	deleted    bool
+}
+
+func NewRecordRetention(config *Config, observer IRecordRetentionObserver) *RecordRetention {
+	r := &RecordRetention{
+		config:       config.RecordConfig.RetentionConfig,
+		observer:     observer,
+		exitChan:     make(chan struct{}, 1),
+		getDiskUsage: base.GetDiskUsage,
+	}
+	r.stat.Enable = r.config.Enable
+
+	disk := filesystemlayer.DefaultDiskFileSystemLayer
+	rc := config.RecordConfig
+	if rc.FlvOutPath != "" {
+		r.sources = append(r.sources, recordSource{
+			format:          base.RecordFormatFlv,
+			root:            rc.FlvOutPath,
+			fsl:             disk,
+			checkDisk:       true,
+			parseStreamName: parseStreamNameFromRecordFilename,
+		})
+	}
+	if rc.MpegtsOutPath != "" {
+		r.sources = append(r.sources, recordSource{
+			format:          base.RecordFormatMpegts,
+			root:            rc.MpegtsOutPath,
+			fsl:             disk,
+			checkDisk:       true,
+			parseStreamName: parseStreamNameFromRecordFilename,
+		})
+	}
+	if rc.Mp4OutPath != "" {
+		r.sources = append(r.sources, recordSource{
+			format:          base.RecordFormatMp4,
+			root:            rc.Mp4OutPath,
+			fsl:             disk,
+			checkDisk:       true,
+			parseStreamName: newRecordTemplateStreamNameParser(rc.Mp4FilenameTemplate),
+		})
+	}
+	hc := config.HlsConfig
+	if (hc.Enable || hc.EnableHttps) && hc.OutPath != "" && hc.CleanupMode != hls.CleanupModeAsap {
+		fsl := hls.FileSystemLayer()
+		r.sources = append(r.sources, recordSource{
+			format:    "hls",
+			root:      hc.OutPath,
+			fsl:       fsl,
+			checkDisk: fsl.Type() == filesystemlayer.FslTypeDisk,
+			parseStreamName: func(rel string) string {
+				// hls的目录结构见 hls.DefaultPathStrategy
+				if i := strings.IndexByte(rel, '/'); i > 0 {
+					return rel[:i]
+				}
+				return ""
+			},
+		})
+	}
+	return r
+}
+
+func (r *RecordRetention) RunLoop() {
+	if !r.config.Enable {
+		return
+	}
+
+	Log.Infof("start record retention. config=%+v", r.config)
+	r.Check()
+	t := time.NewTicker(time.Duration(r.config.CheckIntervalSec) * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.exitChan:
+			return
+		case <-t.C:
+			r.Check()
+		}
+	}
+}
+
+func (r *RecordRetention) Dispose() {
+	select {
+	case r.exitChan <- struct{}{}:
+	default:
+	}
+}
+
+func (r *RecordRetention) Stat() base.StatRecordRetention {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ret := r.stat
+	ret.Streams = append([]base.StatRecordRetentionStream(nil), r.stat.Streams...)
+	return ret
+}
+
+// Check 执行一次检查，正常情况下由 RunLoop 定时调用
+func (r *RecordRetention) Check() {
+	now := time.Now()
+	active := make(map[string]struct{})
+	for _, filename := range r.observer.RecordFiles() {
+		active[filepath.Clean(filename)] = struct{}{}
+	}
+	deletable := func(item *recordItem) bool {
+		if item.deleted || now.Sub(item.modTime) < recordRecentlyModifiedDuration {
+			return false
+		}
+		_, ok := active[filepath.Clean(item.path)]
+		return !ok
+	}
+
+	items := r.scan()
+	var deletedFileNum, deletedSize uint64
+	remove := func(item *recordItem, reason string) {
+		if err := r.removeItem(item); err != nil {
+			Log.Warnf("record retention remove failed. path=%s, err=%+v", item.path, err)
+			return
+		}
+		Log.Infof("record retention remove. path=%s, size=%d, reason=%s", item.path, item.size, reason)
+		item.deleted = true
+		deletedFileNum += uint64(item.fileNum)
+		deletedSize += uint64(item.size)
+	}
+
+	// 超过保存天数
+	if r.config.MaxAgeDays > 0 {
+		deadline := now.Add(-time.Duration(r.config.MaxAgeDays) * 24 * time.Hour)
+		for _, item := range items {
+			if item.modTime.Before(deadline) && deletable(item) {
+				remove(item, "expired")
+			}
+		}
+	}
+
+	// 每路流的配额
+	if r.config.StreamQuotaMb > 0 {
+		streams := make(map[string][]*recordItem)
+		for _, item := range items {
+			if item.streamName != "" {
+				streams[item.streamName] = append(streams[item.streamName], item)
+			}
+		}
+		for _, streamItems := range streams {
+			r.enforceQuota(streamItems, r.config.StreamQuotaMb*1024*1024, deletable, func(item *recordItem) {
+				remove(item, "stream quota")
+			})
+		}
+	}
+
+	// 总配额
+	if r.config.TotalQuotaMb > 0 {
+		r.enforceQuota(items, r.config.TotalQuotaMb*1024*1024, deletable, func(item *recordItem) {
+			remove(item, "total quota")
+		})
+	}
+
+	diskUsagePercent, diskErr := r.diskUsagePercent()
+
+	r.mutex.Lock()
+	r.stat.LastCheckTime = now.Format("2006-01-02 15:04:05.999")
+	r.stat.DeletedFileNum += deletedFileNum
+	r.stat.DeletedSize += deletedSize
+	if diskErr == nil {
+		r.stat.DiskUsagePercent = diskUsagePercent
+	}
+	r.stat.FileNum, r.stat.TotalSize, r.stat.Streams = statRecordItems(items)
+	suspended := r.stat.Suspended
+	r.mutex.Unlock()
+
+	// 磁盘水位线
+	if r.config.DiskUsageWatermark <= 0 {
+		return
+	}
+	// 获取不到磁盘使用率时保持当前的暂停状态，避免磁盘仍然是满的时候恢复录制
+	if diskErr != nil {
+		Log.Warnf("record retention get disk usage failed, keep record suspended=%v. err=%+v", suspended, diskErr)
+		return
+	}
+	if !suspended && diskUsagePercent >= r.config.DiskUsageWatermark {
+		reason := fmt.Sprintf("disk usage %.1f%% exceeds the watermark %.1f%%", diskUsagePercent, r.config.DiskUsageWatermark)
+		Log.Errorf("record retention suspend record. reason=%s", reason)
+		r.setSuspended(true, reason)
+		r.observer.OnRecordSuspend(reason)
+	} else if suspended && diskUsagePercent < r.config.DiskUsageWatermark {
+		Log.Infof("record retention resume record. disk usage=%.1f%%", diskUsagePercent)
+		r.setSuspended(false, "")
+		r.observer.OnRecordResume()
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// scan 遍历所有录制目录，返回的结果按最后修改时间从旧到新排序
+func (r *RecordRetention) scan() []*recordItem {
+	var items []*recordItem
+	for i := range r.sources {
+		src := &r.sources[i]
+		dirs := make(map[string]*recordItem) // hls按流的目录聚合
+		err := src.fsl.Walk(src.root, func(info filesystemlayer.FileInfo) error {
+			rel, err := filepath.Rel(src.root, info.Name)
+			if err != nil {
+				return nil
+			}
+			rel = filepath.ToSlash(rel)
+			streamName := src.parseStreamName(rel)
+
+			if src.format != "hls" {
+				items = append(items, &recordItem{
+					src:        src,
+					streamName: streamName,
+					path:       info.Name,
+					size:       info.Size,
+					fileNum:    1,
+					modTime:    info.ModTime,
+				})
+				return nil
+			}
+
+			if streamName == "" {
+				return nil
+			}
+			item, ok := dirs[streamName]
+			if !ok {
+				item = &recordItem{
+					src:        src,
+					streamName: streamName,
+					path:       hls.PathStrategy.GetMuxerOutPath(src.root, streamName),
+					isDir:      true,
+				}
+				dirs[streamName] = item
+				items = append(items, item)
+			}
+			item.size += info.Size
+			item.fileNum++
+			if info.ModTime.After(item.modTime) {
+				item.modTime = info.ModTime
+			}
+			return nil
+		})
+		if err != nil {
+			Log.Warnf("record retention walk failed. path=%s, err=%+v", src.root, err)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].modTime.Before(items[j].modTime)
+	})
+	return items
+}
+
+// enforceQuota 总大小超过配额时，从最旧的开始删除
+//
+// @param items: 按最后修改时间从旧到新排序
+func (r *RecordRetention) enforceQuota(items []*recordItem, quota int64, deletable func(item *recordItem) bool, remove func(item *recordItem)) {
+	var total int64
+	for _, item := range items {
+		if !item.deleted {
+			total += item.size
+		}
+	}
+	for _, item := range items {
+		if total <= quota {
+			return
+		}
+		if !deletable(item) {
+			continue
+		}
+		remove(item)
+		if item.deleted {
+			total -= item.size
+		}
+	}
+}
+
+func (r *RecordRetention) removeItem(item *recordItem) error {
+	if item.isDir {
+		return item.src.fsl.RemoveAll(item.path)
+	}
+	return item.src.fsl.Remove(item.path)
+}
+
+// diskUsagePercent 录制目录所在磁盘的使用率，多个磁盘时取最大值
+//
+// 录制目录还没有创建时，使用已经存在的上级目录
+func (r *RecordRetention) diskUsagePercent() (float64, error) {
+	var ret float64
+	for _, src := range r.sources {
+		if !src.checkDisk {
+			continue
+		}
+		path := src.root
+		du, err := r.getDiskUsage(path)
+		for err != nil && os.IsNotExist(err) && filepath.Dir(path) != path {
+			path = filepath.Dir(path)
+			du, err = r.getDiskUsage(path)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if p := du.UsedPercent(); p > ret {
+			ret = p
+		}
+	}
+	return ret, nil
+}
+
+func (r *RecordRetention) setSuspended(suspended bool, reason string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stat.Suspended = suspended
+	r.stat.SuspendReason = reason
+}
+
+func statRecordItems(items []*recordItem) (fileNum int, totalSize int64, streams []base.StatRecordRetentionStream) {
+	m := make(map[string]*base.StatRecordRetentionStream)
+	for _, item := range items {
+		if item.deleted {
+			continue
+		}
+		fileNum++
+		totalSize += item.size
+
+		s, ok := m[item.streamName]
+		if !ok {
+			s = &base.StatRecordRetentionStream{StreamName: item.streamName}
+			m[item.streamName] = s
+		}
+		s.FileNum++
+		s.Size += item.size
+	}
+	for _, s := range m {
+		streams = append(streams, *s)
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].StreamName < streams[j].StreamName
+	})
+	return
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// recordFilenameRegexp flv和mpegts的录制文件名，格式为"{stream}-{unix}.flv"，同一秒多次录制时为"{stream}-{unix}-{n}.flv"
+var recordFilenameRegexp = regexp.MustCompile(`^(.+)-\d{9,}(-\d+)?\.[a-zA-Z0-9]+$`)
+
+func parseStreamNameFromRecordFilename(rel string) string {
+	ms := recordFilenameRegexp.FindStringSubmatch(filepath.Base(rel))
+	if ms == nil {
+		return ""
+	}
+	return ms[1]
+}
+
+// newRecordTemplateStreamNameParser 根据文件名模板解析出流名称，模板见 expandRecordFilenameTemplate
+func newRecordTemplateStreamNameParser(template string) func(rel string) string {
+	var (
+		b       strings.Builder
+		capture bool
+		last    int
+	)
+	b.WriteString("^")
+	for _, loc := range regexp.MustCompile(`\{[a-zA-Z]+\}`).FindAllStringIndex(template, -1) {
+		b.WriteString(regexp.QuoteMeta(template[last:loc[0]]))
+		if template[loc[0]:loc[1]] == "{stream}" && !capture {
+			b.WriteString(`([^/]+)`)
+			capture = true
+		} else {
+			b.WriteString(`[^/]*`)
+		}
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(template[last:]))
+	b.WriteString("$")
+	if !capture {
+		return func(rel string) string {
+			return ""
+		}
+	}
+
+	re := regexp.MustCompile(b.String())
+	// uniqueFilename 会在扩展名前加上序号
+	suffixRe := regexp.MustCompile(`-\d+(\.[a-zA-Z0-9]+)$`)
+	return func(rel string) string {
+		ms := re.FindStringSubmatch(rel)
+		if ms == nil {
+			ms = re.FindStringSubmatch(suffixRe.ReplaceAllString(rel, "$1"))
+		}
+		if ms == nil {
+			return ""
+		}
+		return ms[1]
+	}
+}
@@ -0,0 +1,212 @@
+package logic
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/hls"
+	"live-server/library/naza/pkg/assert"
+	"live-server/library/naza/pkg/filesystemlayer"
+)
+
+type testRecordRetentionObserver struct {
+	recordFiles []string
+	suspended   []string
+	resumed     int
+}
+
+func (o *testRecordRetentionObserver) RecordFiles() []string {
+	return o.recordFiles
+}
+
+func (o *testRecordRetentionObserver) OnRecordSuspend(reason string) {
+	o.suspended = append(o.suspended, reason)
+}
+
+func (o *testRecordRetentionObserver) OnRecordResume() {
+	o.resumed++
+}
+
+func TestParseRecordStreamName(t *testing.T) {
+	assert.Equal(t, "test110", parseStreamNameFromRecordFilename("test110-1690000000.flv"))
+	assert.Equal(t, "test110", parseStreamNameFromRecordFilename("test110-1690000000-2.ts"))
+	assert.Equal(t, "a-1", parseStreamNameFromRecordFilename("a-1-1690000000.flv"))
+	assert.Equal(t, "", parseStreamNameFromRecordFilename("test110.flv"))
+
+	parse := newRecordTemplateStreamNameParser(defaultRecordMp4FilenameTemplate)
+	assert.Equal(t, "test110", parse("live/test110/2023/06/01/150405.mp4"))
+	assert.Equal(t, "test110", parse("live/test110/2023/06/01/150405-1.mp4"))
+	assert.Equal(t, "", parse("live/test110/150405.mp4"))
+
+	parse = newRecordTemplateStreamNameParser("{unix}.mp4")
+	assert.Equal(t, "", parse("1690000000.mp4"))
+}
+
+func TestRecordRetention(t *testing.T) {
+	dir := t.TempDir()
+	var config Config
+	config.RecordConfig.FlvOutPath = filepath.Join(dir, "flv")
+	config.RecordConfig.Mp4OutPath = filepath.Join(dir, "mp4")
+	config.RecordConfig.Mp4FilenameTemplate = defaultRecordMp4FilenameTemplate
+	config.RecordConfig.RetentionConfig = RecordRetentionConfig{
+		Enable:        true,
+		MaxAgeDays:    7,
+		StreamQuotaMb: 3,
+		TotalQuotaMb:  5,
+	}
+
+	now := time.Now()
+	mb := make([]byte, 1024*1024)
+	write := func(filename string, age time.Duration) {
+		assert.Equal(t, nil, os.MkdirAll(filepath.Dir(filename), 0777))
+		assert.Equal(t, nil, os.WriteFile(filename, mb, 0666))
+		assert.Equal(t, nil, os.Chtimes(filename, now.Add(-age), now.Add(-age)))
+	}
+	exist := func(filename string) bool {
+		_, err := os.Stat(filename)
+		return err == nil
+	}
+
+	day := 24 * time.Hour
+	var (
+		expired  = filepath.Join(dir, "flv", "a-1690000000.flv")
+		a1       = filepath.Join(dir, "flv", "a-1690000001.flv")
+		a2       = filepath.Join(dir, "flv", "a-1690000002.flv")
+		a3       = filepath.Join(dir, "flv", "a-1690000003.flv")
+		a4       = filepath.Join(dir, "flv", "a-1690000004.flv")
+		b1       = filepath.Join(dir, "mp4", "live", "b", "2023", "06", "01", "150405.mp4")
+		b2       = filepath.Join(dir, "mp4", "live", "b", "2023", "06", "01", "160405.mp4")
+		b3       = filepath.Join(dir, "mp4", "live", "b", "2023", "06", "01", "170405.mp4")
+		writing  = filepath.Join(dir, "mp4", "live", "b", "2023", "06", "01", "180405.mp4")
+		modified = filepath.Join(dir, "flv", "c-1690000000.flv")
+	)
+	write(expired, 8*day)
+	write(a1, 6*day)
+	write(a2, 5*day)
+	write(a3, 4*day)
+	write(a4, 3*day)
+	write(b1, 2*day)
+	write(b2, 2*day-time.Hour)
+	write(b3, 1*day)
+	write(writing, 10*time.Hour)
+	write(modified, 0)
+
+	observer := &testRecordRetentionObserver{recordFiles: []string{writing}}
+	r := NewRecordRetention(&config, observer)
+	r.Check()
+
+	// 超过保存天数
+	assert.Equal(t, false, exist(expired))
+	// 超过每路流的配额，删除该流最旧的文件
+	assert.Equal(t, false, exist(a1))
+	assert.Equal(t, false, exist(b1))
+	// 超过总配额，删除最旧的文件
+	assert.Equal(t, false, exist(a2))
+	assert.Equal(t, false, exist(a3))
+	assert.Equal(t, true, exist(a4))
+	assert.Equal(t, true, exist(b2))
+	assert.Equal(t, true, exist(b3))
+	// 正在写入以及最近修改的文件不会被删除
+	assert.Equal(t, true, exist(writing))
+	assert.Equal(t, true, exist(modified))
+
+	stat := r.Stat()
+	assert.Equal(t, 5, stat.FileNum)
+	assert.Equal(t, int64(5*len(mb)), stat.TotalSize)
+	assert.Equal(t, uint64(5), stat.DeletedFileNum)
+	assert.Equal(t, uint64(5*len(mb)), stat.DeletedSize)
+	assert.Equal(t, 3, len(stat.Streams))
+	assert.Equal(t, "b", stat.Streams[1].StreamName)
+	assert.Equal(t, 3, stat.Streams[1].FileNum)
+	assert.Equal(t, false, stat.Suspended)
+}
+
+func TestRecordRetentionHlsMemory(t *testing.T) {
+	var config Config
+	config.HlsConfig.Enable = true
+	config.HlsConfig.OutPath = "/tmp/lal/retention/hls/"
+	config.HlsConfig.CleanupMode = hls.CleanupModeNever
+	config.RecordConfig.RetentionConfig = RecordRetentionConfig{
+		Enable:             true,
+		TotalQuotaMb:       1,
+		DiskUsageWatermark: 0.001,
+	}
+
+	fsl := filesystemlayer.FslFactory(filesystemlayer.FslTypeMemory)
+	data := make([]byte, 800*1024)
+	assert.Equal(t, nil, fsl.WriteFile("/tmp/lal/retention/hls/a/record.m3u8", data[:10], 0666))
+	assert.Equal(t, nil, fsl.WriteFile("/tmp/lal/retention/hls/a/a-0.ts", data, 0666))
+	assert.Equal(t, nil, fsl.WriteFile("/tmp/lal/retention/hls/b/b-0.ts", data, 0666))
+
+	observer := &testRecordRetentionObserver{recordFiles: []string{"/tmp/lal/retention/hls/b"}}
+	r := NewRecordRetention(&config, observer)
+	// 替换成使用内存作为磁盘，避免修改hls的全局设置
+	assert.Equal(t, 1, len(r.sources))
+	r.sources[0].fsl = fsl
+	r.sources[0].checkDisk = false
+	r.Check()
+
+	// 内存中的文件都是刚刚写入的，虽然超过了配额也不会被删除，hls每路流按一个统计
+	stat := r.Stat()
+	assert.Equal(t, 2, stat.FileNum)
+	assert.Equal(t, int64(2*len(data)+10), stat.TotalSize)
+	assert.Equal(t, 2, len(stat.Streams))
+	assert.Equal(t, "a", stat.Streams[0].StreamName)
+	assert.Equal(t, int64(len(data)+10), stat.Streams[0].Size)
+	assert.Equal(t, uint64(0), stat.DeletedFileNum)
+
+	// 使用内存时不检查磁盘使用率
+	assert.Equal(t, false, stat.Suspended)
+	assert.Equal(t, 0, len(observer.suspended))
+}
+
+func TestRecordRetentionDiskWatermark(t *testing.T) {
+	dir := t.TempDir()
+	var config Config
+	config.RecordConfig.FlvOutPath = filepath.Join(dir, "notexist", "flv")
+	config.RecordConfig.RetentionConfig = RecordRetentionConfig{
+		Enable:             true,
+		DiskUsageWatermark: 50,
+	}
+
+	observer := &testRecordRetentionObserver{}
+	r := NewRecordRetention(&config, observer)
+	var usedPercent uint64
+	var diskErr error
+	var paths []string
+	r.getDiskUsage = func(path string) (base.DiskUsage, error) {
+		paths = append(paths, path)
+		if diskErr != nil {
+			return base.DiskUsage{}, diskErr
+		}
+		if path != dir {
+			return base.DiskUsage{}, os.ErrNotExist
+		}
+		return base.DiskUsage{Total: 100, Free: 100 - usedPercent}, nil
+	}
+
+	// 录制目录还没有创建时，使用已经存在的上级目录
+	usedPercent = 90
+	r.Check()
+	assert.Equal(t, dir, paths[len(paths)-1])
+	assert.Equal(t, true, r.Stat().Suspended)
+	assert.Equal(t, 1, len(observer.suspended))
+
+	// 获取不到磁盘使用率时保持暂停
+	diskErr = errors.New("permission denied")
+	r.Check()
+	stat := r.Stat()
+	assert.Equal(t, true, stat.Suspended)
+	assert.Equal(t, float64(90), stat.DiskUsagePercent)
+	assert.Equal(t, 0, observer.resumed)
+
+	diskErr = nil
+	usedPercent = 10
+	r.Check()
+	assert.Equal(t, false, r.Stat().Suspended)
+	assert.Equal(t, 1, observer.resumed)
+}
@@ -15,6 +15,7 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,6 +29,7 @@ import (
 	"live-server/library/LAL/pkg/rtsp"
 
 	"live-server/library/naza/pkg/defertaskthread"
+	"live-server/library/naza/pkg/nazaerrors"
 	"live-server/library/naza/pkg/nazalog"
 	//"github.com/felixge/fgprof"
 )
@@ -54,13 +56,25 @@ type ServerManager struct {
 
 	onHookSession func(uniqueKey string, streamName string) ICustomizeHookSessionContext
 
+	rtspMulticastAddrPool *rtsp.MulticastAddrPool
+
 	notifyHandlerThread taskpool.Pool
+	notifyDispatcher    *NotifyDispatcher
+	httpNotify          *HttpNotify
+
+	aclEngine *AclEngine
+
+	recordRetention     *RecordRetention
+	recordSuspendReason string // 不为空时，新创建的group也需要暂停录制
+
+	filePublishers map[string]*FilePublisher // key: stream name
 }
 
 func NewServerManager(modOption ...ModOption) *ServerManager {
 	sm := &ServerManager{
 		serverStartTime: base.ReadableNowTime(),
 		exitChan:        make(chan struct{}, 1),
+		filePublishers:  make(map[string]*FilePublisher),
 	}
 	sm.groupManager = NewSimpleGroupManager(sm)
 
@@ -107,6 +121,8 @@ Doc: %s
 		hls.SetUseMemoryAsDiskFlag(true)
 	}
 
+	sm.recordRetention = NewRecordRetention(sm.config, sm)
+
 	if sm.config.RecordConfig.EnableFlv {
 		if err := os.MkdirAll(sm.config.RecordConfig.FlvOutPath, 0777); err != nil {
 			Log.Errorf("record flv mkdir error. path=%s, err=%+v", sm.config.RecordConfig.FlvOutPath, err)
@@ -123,7 +139,8 @@ Doc: %s
 
 	if sm.config.HttpflvConfig.Enable || sm.config.HttpflvConfig.EnableHttps ||
 		sm.config.HttptsConfig.Enable || sm.config.HttptsConfig.EnableHttps ||
-		sm.config.HlsConfig.Enable || sm.config.HlsConfig.EnableHttps {
+		sm.config.HlsConfig.Enable || sm.config.HlsConfig.EnableHttps ||
+		(sm.config.RtspConfig.Enable && sm.config.RtspConfig.RtspOverHttpEnable) {
 		sm.httpServerManager = base.NewHttpServerManager()
 		sm.httpServerHandler = NewHttpServerHandler(sm)
 		sm.hlsServerHandler = hls.NewServerHandler(sm.config.HlsConfig.OutPath, sm.config.HlsConfig.UrlPattern, sm.config.HlsConfig.SubSessionHashKey, sm.config.HlsConfig.SubSessionTimeoutMs, sm)
@@ -138,6 +155,15 @@ Doc: %s
 	if sm.config.RtspConfig.Enable {
 		sm.rtspServer = rtsp.NewServer(sm.config.RtspConfig.Addr, sm, sm.config.RtspConfig.ServerAuthConfig)
 	}
+	if sm.config.RtspConfig.Enable && sm.config.RtspConfig.MulticastConfig.Enable {
+		multicastConfig := sm.config.RtspConfig.MulticastConfig
+		pool, err := rtsp.NewMulticastAddrPool(multicastConfig.AddrStart, multicastConfig.AddrEnd, multicastConfig.PortMin, multicastConfig.PortMax)
+		if err != nil {
+			Log.Errorf("create rtsp multicast addr pool failed, rtsp multicast disabled. config=%+v, err=%+v", multicastConfig, err)
+		} else {
+			sm.rtspMulticastAddrPool = pool
+		}
+	}
 	if sm.config.RtspConfig.RtspsEnable {
 		sm.rtspsServer = rtsp.NewServer(sm.config.RtspConfig.RtspsAddr, sm, sm.config.RtspConfig.ServerAuthConfig)
 	}
@@ -149,8 +175,22 @@ Doc: %s
 		sm.pprofServer = &http.Server{Addr: sm.config.PprofConfig.Addr, Handler: nil}
 	}
 
+	aclEngine, err := NewAclEngine(sm.config.AclConfig)
+	if err != nil {
+		// acl配置有误时拒绝所有pub和sub，直到通过http api重新加载了正确的策略
+		Log.Errorf("create acl failed, all pub and sub will be rejected. err=%+v", err)
+		aclEngine = newAclEngineRejectAll(err)
+	}
+	sm.aclEngine = aclEngine
+
 	if sm.option.Authentication == nil {
-		sm.option.Authentication = NewSimpleAuthCtx(sm.config.SimpleAuthConfig)
+		auth, err := NewAuthentication(sm.config)
+		if err != nil {
+			// 鉴权配置有误时拒绝所有pub和sub，而不是不鉴权
+			Log.Errorf("create authentication failed, all pub and sub will be rejected. err=%+v", err)
+			auth = &authDenyAll{err: err}
+		}
+		sm.option.Authentication = auth
 	}
 
 	return sm
@@ -174,9 +214,13 @@ func (sm *ServerManager) RunLoop() error {
 		}()
 	}
 
-	go base.RunSignalHandler(func() {
-		sm.Dispose()
-	})
+	go sm.recordRetention.RunLoop()
+
+	if !sm.option.DisableSignalHandler {
+		go base.RunSignalHandler(func() {
+			sm.Dispose()
+		})
+	}
 
 	var addMux = func(config CommonHttpServerConfig, handler base.Handler, name string) error {
 		if config.Enable {
@@ -215,6 +259,18 @@ func (sm *ServerManager) RunLoop() error {
 	if err := addMux(sm.config.HlsConfig.CommonHttpServerConfig, sm.serveHls, "hls"); err != nil {
 		return err
 	}
+	if sm.rtspServer != nil && sm.config.RtspConfig.RtspOverHttpEnable {
+		// rtsp over http隧道和rtsp over websocket没有单独的监听配置，使用default_http的
+		rtspOverHttpConfig := CommonHttpServerConfig{
+			CommonHttpAddrConfig: sm.config.DefaultHttpConfig.CommonHttpAddrConfig,
+			Enable:               sm.config.DefaultHttpConfig.HttpListenAddr != "",
+			EnableHttps:          sm.config.DefaultHttpConfig.HttpsListenAddr != "",
+			UrlPattern:           sm.config.RtspConfig.RtspOverHttpUrlPattern,
+		}
+		if err := addMux(rtspOverHttpConfig, sm.rtspServer.ServeHttp, "rtsp over http"); err != nil {
+			return err
+		}
+	}
 
 	if sm.httpServerManager != nil {
 		go func() {
@@ -363,15 +419,28 @@ func (sm *ServerManager) Dispose() {
 		sm.httpServerManager.Dispose()
 	}
 
+	if sm.httpApiServer != nil {
+		sm.httpApiServer.Dispose()
+	}
+
 	if sm.pprofServer != nil {
 		sm.pprofServer.Close()
 	}
 
+	if sm.httpNotify != nil {
+		sm.httpNotify.Dispose()
+	}
+
+	sm.recordRetention.Dispose()
+
 	//if sm.hlsServer != nil {
 	//	sm.hlsServer.Dispose()
 	//}
 
 	sm.mutex.Lock()
+	for _, p := range sm.filePublishers {
+		p.Dispose()
+	}
 	sm.groupManager.Iterate(func(group *Group) bool {
 		group.Dispose()
 		return true
@@ -386,6 +455,9 @@ func (sm *ServerManager) Dispose() {
 func (sm *ServerManager) AddCustomizePubSession(streamName string) (ICustomizePubSessionContext, error) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
+	if err := sm.aclCheckLocalPub(streamName); err != nil {
+		return nil, err
+	}
 	group := sm.getOrCreateGroup("", streamName)
 	return group.AddCustomizePubSession(streamName)
 }
@@ -420,16 +492,20 @@ func (sm *ServerManager) OnRtmpConnect(session *rtmp.ServerSession, opa rtmp.Obj
 }
 
 func (sm *ServerManager) OnNewRtmpPubSession(session *rtmp.ServerSession) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
 	info := base.Session2PubStartInfo(session)
 
-	// 先做simple auth鉴权
+	// 鉴权可能比较耗时（比如http回调鉴权），所以在锁外执行，避免阻塞其他流程
 	if err := sm.option.Authentication.OnPubStart(info); err != nil {
 		return err
 	}
 
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if err := sm.aclCheckPub(info); err != nil {
+		return err
+	}
+
 	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	if err := group.AddRtmpPubSession(session); err != nil {
 		return err
@@ -460,15 +536,19 @@ func (sm *ServerManager) OnDelRtmpPubSession(session *rtmp.ServerSession) {
 }
 
 func (sm *ServerManager) OnNewRtmpSubSession(session *rtmp.ServerSession) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
 	info := base.Session2SubStartInfo(session)
 
 	if err := sm.option.Authentication.OnSubStart(info); err != nil {
 		return err
 	}
 
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if err := sm.aclCheckSub(info); err != nil {
+		return err
+	}
+
 	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	group.AddRtmpSubSession(session)
 
@@ -499,15 +579,19 @@ func (sm *ServerManager) OnDelRtmpSubSession(session *rtmp.ServerSession) {
 // ----- implement IHttpServerHandlerObserver interface -----------------------------------------------------------------
 
 func (sm *ServerManager) OnNewHttpflvSubSession(session *httpflv.SubSession) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
 	info := base.Session2SubStartInfo(session)
 
 	if err := sm.option.Authentication.OnSubStart(info); err != nil {
 		return err
 	}
 
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if err := sm.aclCheckSub(info); err != nil {
+		return err
+	}
+
 	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	group.AddHttpflvSubSession(session)
 
@@ -536,15 +620,19 @@ func (sm *ServerManager) OnDelHttpflvSubSession(session *httpflv.SubSession) {
 }
 
 func (sm *ServerManager) OnNewHttptsSubSession(session *httpts.SubSession) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
 	info := base.Session2SubStartInfo(session)
 
 	if err := sm.option.Authentication.OnSubStart(info); err != nil {
 		return err
 	}
 
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if err := sm.aclCheckSub(info); err != nil {
+		return err
+	}
+
 	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	group.AddHttptsSubSession(session)
 
@@ -584,15 +672,19 @@ func (sm *ServerManager) OnDelRtspSession(session *rtsp.ServerCommandSession) {
 }
 
 func (sm *ServerManager) OnNewRtspPubSession(session *rtsp.PubSession) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
 	info := base.Session2PubStartInfo(session)
 
 	if err := sm.option.Authentication.OnPubStart(info); err != nil {
 		return err
 	}
 
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if err := sm.aclCheckPub(info); err != nil {
+		return err
+	}
+
 	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	if err := group.AddRtspPubSession(session); err != nil {
 		return err
@@ -622,20 +714,28 @@ func (sm *ServerManager) OnDelRtspPubSession(session *rtsp.PubSession) {
 }
 
 func (sm *ServerManager) OnNewRtspSubSessionDescribe(session *rtsp.SubSession) (ok bool, sdp []byte) {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
 	info := base.Session2SubStartInfo(session)
 
 	if err := sm.option.Authentication.OnSubStart(info); err != nil {
 		return false, nil
 	}
 
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if err := sm.aclCheckSub(info); err != nil {
+		return false, nil
+	}
+
 	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	ok, sdp = group.HandleNewRtspSubSessionDescribe(session)
 	if !ok {
 		return
 	}
+	// 文件推流的流，支持通过PLAY信令中的Range进行seek
+	if _, exist := sm.filePublishers[session.StreamName()]; exist {
+		session.WithOnSeek(sm.onRtspSubSessionSeek)
+	}
 
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
@@ -644,6 +744,28 @@ func (sm *ServerManager) OnNewRtspSubSessionDescribe(session *rtsp.SubSession) (
 	return
 }
 
+// onRtspSubSessionSeek 见 rtsp.OnSeek ，跳转文件推流的位置，注意，同一个流的所有订阅者都会受影响
+func (sm *ServerManager) onRtspSubSessionSeek(session *rtsp.SubSession, rangeNpt *rtsp.RangeNpt, scale float64) error {
+	if scale != 1 {
+		return nazaerrors.Wrap(base.ErrRtspInvalidScale, "file pub only supports scale 1")
+	}
+	if rangeNpt == nil || rangeNpt.Now {
+		return nil
+	}
+	// 大部分客户端第一次PLAY都会携带`npt=0.000-`，此时不跳转，避免影响其他订阅者
+	if session.Stage.Load() == rtsp.SubSessionStageWriteSdp && rangeNpt.Start == 0 {
+		return nil
+	}
+
+	sm.mutex.Lock()
+	publisher, ok := sm.filePublishers[session.StreamName()]
+	sm.mutex.Unlock()
+	if !ok {
+		return base.ErrFilePubNotFound
+	}
+	return publisher.SeekMs(int64(rangeNpt.Start * 1000))
+}
+
 func (sm *ServerManager) OnNewRtspSubSessionPlay(session *rtsp.SubSession) error {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
@@ -670,15 +792,19 @@ func (sm *ServerManager) OnDelRtspSubSession(session *rtsp.SubSession) {
 }
 
 func (sm *ServerManager) OnNewHlsSubSession(session *hls.SubSession) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
 	info := base.Session2SubStartInfo(session)
 
 	if err := sm.option.Authentication.OnSubStart(info); err != nil {
 		return err
 	}
 
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if err := sm.aclCheckSub(info); err != nil {
+		return err
+	}
+
 	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	group.AddHlsSubSession(session)
 
@@ -719,9 +845,14 @@ func (sm *ServerManager) CreateGroup(appName string, streamName string) *Group {
 		config = sm.config
 	}
 	option := GroupOption{
-		onHookSession: sm.onHookSession,
+		onHookSession:         sm.onHookSession,
+		rtspMulticastAddrPool: sm.rtspMulticastAddrPool,
+	}
+	group := NewGroup(appName, streamName, config, option, sm)
+	if sm.recordSuspendReason != "" {
+		group.SuspendRecord(sm.recordSuspendReason)
 	}
-	return NewGroup(appName, streamName, config, option, sm)
+	return group
 }
 
 // ----- implement IGroupObserver interface -----------------------------------------------------------------------------
@@ -767,6 +898,47 @@ func (sm *ServerManager) OnHlsMakeTs(info base.HlsMakeTsInfo) {
 	sm.nhOnHlsMakeTs(info)
 }
 
+func (sm *ServerManager) OnRecordSegment(info base.RecordSegmentInfo) {
+	sm.nhOnRecordSegment(info)
+}
+
+func (sm *ServerManager) OnRecordError(info base.RecordErrorInfo) {
+	sm.nhOnRecordError(info)
+}
+
+// ----- implement IRecordRetentionObserver interface ------------------------------------------------------------------
+
+func (sm *ServerManager) RecordFiles() []string {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	var ret []string
+	sm.groupManager.Iterate(func(group *Group) bool {
+		ret = append(ret, group.recordFiles()...)
+		return true
+	})
+	return ret
+}
+
+func (sm *ServerManager) OnRecordSuspend(reason string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.recordSuspendReason = reason
+	sm.groupManager.Iterate(func(group *Group) bool {
+		group.SuspendRecord(reason)
+		return true
+	})
+}
+
+func (sm *ServerManager) OnRecordResume() {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.recordSuspendReason = ""
+	sm.groupManager.Iterate(func(group *Group) bool {
+		group.ResumeRecord()
+		return true
+	})
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (sm *ServerManager) Config() *Config {
@@ -800,11 +972,17 @@ func (sm *ServerManager) serveHls(writer http.ResponseWriter, req *http.Request)
 		Log.Errorf("parse url. err=%+v", err)
 		return
 	}
+	// TODO(chef): [refactor] 需要整理，这里使用 hls.PathStrategy 不太好 202207
+	streamName := hls.PathStrategy.GetRequestInfo(urlCtx, sm.config.HlsConfig.OutPath).StreamName
+	appName := hls.GetAppNameFromUrlCtx(urlCtx, strings.TrimPrefix(sm.config.HlsConfig.UrlPattern, "/"))
+	if err = sm.aclCheckHls(appName, streamName, req.RemoteAddr); err != nil {
+		writeAclRejectedResp(writer)
+		return
+	}
 	if urlCtx.GetFileType() == "m3u8" {
-		// TODO(chef): [refactor] 需要整理，这里使用 hls.PathStrategy 不太好 202207
-		streamName := hls.PathStrategy.GetRequestInfo(urlCtx, sm.config.HlsConfig.OutPath).StreamName
-		if err = sm.option.Authentication.OnHls(streamName, urlCtx.RawQuery); err != nil {
-			Log.Errorf("simple auth failed. err=%+v", err)
+		if err = sm.option.Authentication.OnHls(appName, streamName, urlCtx.RawQuery, req.RemoteAddr); err != nil {
+			Log.Errorf("auth failed. err=%+v", err)
+			writer.WriteHeader(http.StatusForbidden)
 			return
 		}
 	}
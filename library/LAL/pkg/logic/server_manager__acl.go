@@ -0,0 +1,106 @@
+package logic
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"live-server/library/LAL/pkg/base"
+)
+
+// server_manager__acl.go
+//
+// acl策略部分，策略的实现见 AclEngine
+//
+
+func (sm *ServerManager) StatAcl() base.StatAcl {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	ret := sm.aclEngine.Stat()
+	ret.PubNum, ret.SubNum = sm.aclSessionNum()
+	return ret
+}
+
+func (sm *ServerManager) CtrlReloadAcl(info base.ApiCtrlReloadAclReq) (ret base.ApiCtrlReloadAclResp) {
+	if err := sm.aclEngine.Reload(info.AclConfig); err != nil {
+		ret.ErrorCode = base.ErrorCodeReloadAclFail
+		ret.Desp = err.Error()
+		return
+	}
+	Log.Infof("reload acl. config=%+v", info.AclConfig)
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	return
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// aclCheckPub 注意，调用方需要持有 sm.mutex
+func (sm *ServerManager) aclCheckPub(info base.PubStartInfo) error {
+	pubNum, _ := sm.aclSessionNum()
+	err := sm.aclEngine.CheckPub(info.AppName, info.StreamName, info.RemoteAddr, pubNum)
+	if err != nil {
+		Log.Warnf("[%s] pub rejected by acl. err=%+v", info.SessionId, err)
+	}
+	return err
+}
+
+// aclCheckSub 注意，调用方需要持有 sm.mutex
+func (sm *ServerManager) aclCheckSub(info base.SubStartInfo) error {
+	streamSubNum := 0
+	if group := sm.getGroup(info.AppName, info.StreamName); group != nil {
+		streamSubNum = group.SubSessionNum()
+	}
+	_, subNum := sm.aclSessionNum()
+	err := sm.aclEngine.CheckSub(info.AppName, info.StreamName, info.RemoteAddr, streamSubNum, subNum)
+	if err != nil {
+		Log.Warnf("[%s] sub rejected by acl. err=%+v", info.SessionId, err)
+	}
+	return err
+}
+
+// aclCheckLocalPub 注意，调用方需要持有 sm.mutex
+func (sm *ServerManager) aclCheckLocalPub(streamName string) error {
+	pubNum, _ := sm.aclSessionNum()
+	err := sm.aclEngine.CheckLocalPub(pubNum)
+	if err != nil {
+		Log.Warnf("local pub rejected by acl. streamName=%s, err=%+v", streamName, err)
+	}
+	return err
+}
+
+// aclCheckHls hls没有长连接，每次请求都检查黑白名单
+func (sm *ServerManager) aclCheckHls(appName string, streamName string, remoteAddr string) error {
+	err := sm.aclEngine.CheckAddr(appName, streamName, remoteAddr)
+	if err != nil {
+		Log.Warnf("hls request rejected by acl. remoteAddr=%s, err=%+v", remoteAddr, err)
+	}
+	return err
+}
+
+func (sm *ServerManager) aclSessionNum() (pubNum int, subNum int) {
+	sm.groupManager.Iterate(func(group *Group) bool {
+		if group.HasPubSession() {
+			pubNum++
+		}
+		subNum += group.SubSessionNum()
+		return true
+	})
+	return
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// aclRejectedResp http协议的拉流被acl拒绝时，返回给拉流端的http body
+func aclRejectedResp() []byte {
+	resp, _ := json.Marshal(base.ApiRespBasic{
+		ErrorCode: base.ErrorCodeAclRejected,
+		Desp:      base.DespAclRejected,
+	})
+	return resp
+}
+
+func writeAclRejectedResp(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	_, _ = w.Write(aclRejectedResp())
+}
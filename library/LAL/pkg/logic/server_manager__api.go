@@ -9,8 +9,11 @@
 package logic
 
 import (
+	"errors"
 	"live-server/library/LAL/pkg/base"
 	"math"
+	"path"
+	"strings"
 
 	"live-server/library/naza/pkg/bininfo"
 )
@@ -55,6 +58,33 @@ func (sm *ServerManager) StatGroup(streamName string) *base.StatGroup {
 	return &ret
 }
 
+func (sm *ServerManager) StatSnapshot(streamName string, format string) (ret base.ApiStatSnapshotResp) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	g := sm.getGroup("", streamName)
+	if g == nil {
+		ret.ErrorCode = base.ErrorCodeGroupNotFound
+		ret.Desp = base.DespGroupNotFound
+		return
+	}
+
+	if format == "" {
+		format = base.SnapshotFormatAnnexb
+	}
+	snapshot, err := g.Snapshot(format)
+	if err != nil {
+		ret.ErrorCode = base.ErrorCodeSnapshotFail
+		ret.Desp = err.Error()
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data = &snapshot
+	return
+}
+
 func (sm *ServerManager) CtrlStartRelayPull(info base.ApiCtrlStartRelayPullReq) (ret base.ApiCtrlStartRelayPullResp) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
@@ -68,6 +98,10 @@ func (sm *ServerManager) CtrlStartRelayPull(info base.ApiCtrlStartRelayPullReq)
 			return
 		}
 		streamName = ctx.LastItemOfPath
+		// http-flv、http-ts、hls的url，去掉扩展名
+		if ctx.Scheme == "http" || ctx.Scheme == "https" {
+			streamName = strings.TrimSuffix(streamName, path.Ext(streamName))
+		}
 	}
 
 	// 注意，如果group不存在，我们依然relay pull
@@ -112,6 +146,203 @@ func (sm *ServerManager) CtrlStopRelayPull(streamName string) (ret base.ApiCtrlS
 	return
 }
 
+func (sm *ServerManager) StatRelayPush(streamName string) (ret base.ApiStatRelayPushResp) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	g := sm.getGroup("", streamName)
+	if g == nil {
+		ret.ErrorCode = base.ErrorCodeGroupNotFound
+		ret.Desp = base.DespGroupNotFound
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data = g.StatRelayPush()
+	return
+}
+
+// CtrlStartRelayPush
+//
+// 注意，group不存在时返回错误，动态添加的转推目标跟随group的生命周期
+func (sm *ServerManager) CtrlStartRelayPush(info base.ApiCtrlStartRelayPushReq) (ret base.ApiCtrlStartRelayPushResp) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		ret.ErrorCode = base.ErrorCodeGroupNotFound
+		ret.Desp = base.DespGroupNotFound
+		return
+	}
+
+	pushUrl, err := g.StartRelayPush(info)
+	if err != nil {
+		ret.ErrorCode = base.ErrorCodeStartRelayPushFail
+		ret.Desp = err.Error()
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data.StreamName = info.StreamName
+	ret.Data.Url = pushUrl
+	return
+}
+
+func (sm *ServerManager) CtrlStopRelayPush(info base.ApiCtrlStopRelayPushReq) (ret base.ApiCtrlStopRelayPushResp) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		ret.ErrorCode = base.ErrorCodeGroupNotFound
+		ret.Desp = base.DespGroupNotFound
+		return
+	}
+
+	sessionId, err := g.StopRelayPush(info.Url)
+	if err != nil {
+		ret.ErrorCode = base.ErrorCodeSessionNotFound
+		ret.Desp = err.Error()
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data.SessionId = sessionId
+	return
+}
+
+// CtrlStartRecord
+//
+// 注意，group不存在或者没有输入流时返回错误，按需开启的录制跟随输入流的生命周期
+func (sm *ServerManager) CtrlStartRecord(info base.ApiCtrlStartRecordReq) (ret base.ApiCtrlStartRecordResp) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		ret.ErrorCode = base.ErrorCodeGroupNotFound
+		ret.Desp = base.DespGroupNotFound
+		return
+	}
+
+	stat, err := g.StartRecord(info)
+	if err != nil {
+		ret.ErrorCode = base.ErrorCodeStartRecordFail
+		ret.Desp = err.Error()
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data = stat
+	return
+}
+
+func (sm *ServerManager) CtrlStopRecord(info base.ApiCtrlStopRecordReq) (ret base.ApiCtrlStopRecordResp) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		ret.ErrorCode = base.ErrorCodeGroupNotFound
+		ret.Desp = base.DespGroupNotFound
+		return
+	}
+
+	stats, err := g.StopRecord(info.Format)
+	if err != nil {
+		ret.ErrorCode = base.ErrorCodeRecordNotFound
+		ret.Desp = base.DespRecordNotFound
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data = stats
+	return
+}
+
+func (sm *ServerManager) CtrlStartFilePub(info base.ApiCtrlStartFilePubReq) (ret base.ApiCtrlStartFilePubResp) {
+	files, err := GlobFilePubFiles(sm.config.FilePubConfig.RootDir, info.Path)
+	if err != nil {
+		ret.ErrorCode = base.ErrorCodeStartFilePubFail
+		ret.Desp = err.Error()
+		return
+	}
+
+	// 注意，流已经存在输入时，AddCustomizePubSession 会返回错误
+	session, err := sm.AddCustomizePubSession(info.StreamName)
+	if err != nil {
+		ret.ErrorCode = base.ErrorCodeStartFilePubFail
+		if errors.Is(err, base.ErrAclRejected) {
+			ret.ErrorCode = base.ErrorCodeAclRejected
+		}
+		ret.Desp = err.Error()
+		return
+	}
+	publisher := NewFilePublisher(session, files, info.Loop)
+
+	sm.mutex.Lock()
+	sm.filePublishers[info.StreamName] = publisher
+	sm.mutex.Unlock()
+
+	go func() {
+		if err := publisher.RunLoop(); err != nil {
+			Log.Errorf("[%s] file publisher error. err=%+v", publisher.UniqueKey(), err)
+		}
+		Log.Infof("[%s] file publisher done.", publisher.UniqueKey())
+		sm.DelCustomizePubSession(session)
+
+		sm.mutex.Lock()
+		if sm.filePublishers[info.StreamName] == publisher {
+			delete(sm.filePublishers, info.StreamName)
+		}
+		sm.mutex.Unlock()
+	}()
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data.StreamName = info.StreamName
+	ret.Data.SessionId = session.UniqueKey()
+	ret.Data.Files = files
+	return
+}
+
+func (sm *ServerManager) CtrlStopFilePub(info base.ApiCtrlStopFilePubReq) (ret base.ApiCtrlStopFilePubResp) {
+	sm.mutex.Lock()
+	publisher, ok := sm.filePublishers[info.StreamName]
+	sm.mutex.Unlock()
+
+	if !ok {
+		ret.ErrorCode = base.ErrorCodeSessionNotFound
+		ret.Desp = base.DespSessionNotFound
+		return
+	}
+	publisher.Dispose()
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data.SessionId = publisher.UniqueKey()
+	return
+}
+
+func (sm *ServerManager) CtrlStartUdpTsPub(info base.ApiCtrlStartUdpTsPubReq) (ret base.ApiCtrlStartUdpTsPubResp) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if err := sm.aclCheckLocalPub(info.StreamName); err != nil {
+		ret.ErrorCode = base.ErrorCodeAclRejected
+		ret.Desp = err.Error()
+		return
+	}
+	g := sm.getOrCreateGroup("", info.StreamName)
+	return g.StartUdpTsPub(info)
+}
+
 // CtrlKickSession
 //
 // TODO(chef): refactor 不要返回http结果，返回error吧
@@ -140,9 +371,34 @@ func (sm *ServerManager) CtrlStartRtpPub(info base.ApiCtrlStartRtpPubReq) (ret b
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
+	if err := sm.aclCheckLocalPub(info.StreamName); err != nil {
+		ret.ErrorCode = base.ErrorCodeAclRejected
+		ret.Desp = err.Error()
+		return
+	}
 	// 注意，如果group不存在，我们依然relay pull
 	g := sm.getOrCreateGroup("", info.StreamName)
 	ret = g.StartRtpPub(info)
 
 	return
 }
+
+func (sm *ServerManager) StatRecordRetention() base.StatRecordRetention {
+	return sm.recordRetention.Stat()
+}
+
+func (sm *ServerManager) StatNotify() base.StatNotify {
+	return sm.notifyDispatcher.Stat()
+}
+
+func (sm *ServerManager) AddNotifySink(name string, handler INotifyHandler) error {
+	return sm.notifyDispatcher.AddSink(name, handler)
+}
+
+func (sm *ServerManager) DelNotifySink(name string) bool {
+	return sm.notifyDispatcher.DelSink(name)
+}
+
+func (sm *ServerManager) SetNotifyEventEnable(event string, enable bool) {
+	sm.notifyDispatcher.SetEventEnable(event, enable)
+}
@@ -25,9 +25,14 @@ import (
 func (sm *ServerManager) nhInitNotifyHandler() {
 	// TODO(chef): [opt] 这里已经做了异步化处理，http notify那边的异步可以去掉 202304
 
-	// 如果外部没有传入，则使用默认的http notify handler
-	if sm.option.NotifyHandler == nil {
-		sm.option.NotifyHandler = NewHttpNotify(sm.config.HttpNotifyConfig, sm.config.ServerId)
+	// 配置文件中开启了http notify，以及外部传入了NotifyHandler时，都注册到分发器中，两者可以同时存在
+	sm.notifyDispatcher = NewNotifyDispatcher(sm.config.NotifyConfig)
+	if sm.config.HttpNotifyConfig.Enable {
+		sm.httpNotify = NewHttpNotify(sm.config.HttpNotifyConfig, sm.config.ServerId)
+		_ = sm.notifyDispatcher.AddSink(NotifySinkNameHttp, sm.httpNotify)
+	}
+	if sm.option.NotifyHandler != nil {
+		_ = sm.notifyDispatcher.AddSink(NotifySinkNameCustomize, sm.option.NotifyHandler)
 	}
 
 	sm.notifyHandlerThread, _ = taskpool.NewPool(func(option *taskpool.Option) {
@@ -39,69 +44,83 @@ func (sm *ServerManager) nhInitNotifyHandler() {
 func (sm *ServerManager) nhOnServerStart(info base.LalInfo) {
 	sm.notifyHandlerThread.Go(func(param ...interface{}) {
 		p := param[0].(base.LalInfo)
-		sm.option.NotifyHandler.OnServerStart(p)
+		sm.notifyDispatcher.OnServerStart(p)
 	}, info)
 }
 
 func (sm *ServerManager) nhOnUpdate(info base.UpdateInfo) {
 	sm.notifyHandlerThread.Go(func(param ...interface{}) {
 		p := param[0].(base.UpdateInfo)
-		sm.option.NotifyHandler.OnUpdate(p)
+		sm.notifyDispatcher.OnUpdate(p)
 	}, info)
 }
 
 func (sm *ServerManager) nhOnPubStart(info base.PubStartInfo) {
 	sm.notifyHandlerThread.Go(func(param ...interface{}) {
 		p := param[0].(base.PubStartInfo)
-		sm.option.NotifyHandler.OnPubStart(p)
+		sm.notifyDispatcher.OnPubStart(p)
 	}, info)
 }
 
 func (sm *ServerManager) nhOnPubStop(info base.PubStopInfo) {
 	sm.notifyHandlerThread.Go(func(param ...interface{}) {
 		p := param[0].(base.PubStopInfo)
-		sm.option.NotifyHandler.OnPubStop(p)
+		sm.notifyDispatcher.OnPubStop(p)
 	}, info)
 }
 
 func (sm *ServerManager) nhOnSubStart(info base.SubStartInfo) {
 	sm.notifyHandlerThread.Go(func(param ...interface{}) {
 		p := param[0].(base.SubStartInfo)
-		sm.option.NotifyHandler.OnSubStart(p)
+		sm.notifyDispatcher.OnSubStart(p)
 	}, info)
 }
 
 func (sm *ServerManager) nhOnSubStop(info base.SubStopInfo) {
 	sm.notifyHandlerThread.Go(func(param ...interface{}) {
 		p := param[0].(base.SubStopInfo)
-		sm.option.NotifyHandler.OnSubStop(p)
+		sm.notifyDispatcher.OnSubStop(p)
 	}, info)
 }
 
 func (sm *ServerManager) nhOnRelayPullStart(info base.PullStartInfo) {
 	sm.notifyHandlerThread.Go(func(param ...interface{}) {
 		p := param[0].(base.PullStartInfo)
-		sm.option.NotifyHandler.OnRelayPullStart(p)
+		sm.notifyDispatcher.OnRelayPullStart(p)
 	}, info)
 }
 
 func (sm *ServerManager) nhOnRelayPullStop(info base.PullStopInfo) {
 	sm.notifyHandlerThread.Go(func(param ...interface{}) {
 		p := param[0].(base.PullStopInfo)
-		sm.option.NotifyHandler.OnRelayPullStop(p)
+		sm.notifyDispatcher.OnRelayPullStop(p)
 	}, info)
 }
 
 func (sm *ServerManager) nhOnRtmpConnect(info base.RtmpConnectInfo) {
 	sm.notifyHandlerThread.Go(func(param ...interface{}) {
 		p := param[0].(base.RtmpConnectInfo)
-		sm.option.NotifyHandler.OnRtmpConnect(p)
+		sm.notifyDispatcher.OnRtmpConnect(p)
 	}, info)
 }
 
 func (sm *ServerManager) nhOnHlsMakeTs(info base.HlsMakeTsInfo) {
 	sm.notifyHandlerThread.Go(func(param ...interface{}) {
 		p := param[0].(base.HlsMakeTsInfo)
-		sm.option.NotifyHandler.OnHlsMakeTs(p)
+		sm.notifyDispatcher.OnHlsMakeTs(p)
+	}, info)
+}
+
+func (sm *ServerManager) nhOnRecordSegment(info base.RecordSegmentInfo) {
+	sm.notifyHandlerThread.Go(func(param ...interface{}) {
+		p := param[0].(base.RecordSegmentInfo)
+		sm.notifyDispatcher.OnRecordSegment(p)
+	}, info)
+}
+
+func (sm *ServerManager) nhOnRecordError(info base.RecordErrorInfo) {
+	sm.notifyHandlerThread.Go(func(param ...interface{}) {
+		p := param[0].(base.RecordErrorInfo)
+		sm.notifyDispatcher.OnRecordError(p)
 	}, info)
 }
@@ -0,0 +1,68 @@
+package logic
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/rtmp"
+
+	"live-server/library/naza/pkg/assert"
+)
+
+type testBlockAuthentication struct {
+	enterChan   chan struct{}
+	releaseChan chan struct{}
+}
+
+func (a *testBlockAuthentication) OnPubStart(info base.PubStartInfo) error {
+	return nil
+}
+
+func (a *testBlockAuthentication) OnSubStart(info base.SubStartInfo) error {
+	a.enterChan <- struct{}{}
+	<-a.releaseChan
+	return base.ErrAuthFailed
+}
+
+func (a *testBlockAuthentication) OnHls(appName, streamName, urlParam, remoteAddr string) error {
+	return nil
+}
+
+func TestServerManager_AuthOutsideLock(t *testing.T) {
+	auth := &testBlockAuthentication{
+		enterChan:   make(chan struct{}),
+		releaseChan: make(chan struct{}),
+	}
+	sm := NewServerManager(func(option *Option) {
+		option.ConfRawContent = []byte(fmt.Sprintf(`{"conf_version": "%s", "log": {"filename": ""}}`, base.ConfVersion))
+		option.Authentication = auth
+	})
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	session := rtmp.NewServerSession(nil, conn)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- sm.OnNewRtmpSubSession(session)
+	}()
+	<-auth.enterChan
+
+	// 鉴权阻塞期间，其他需要加锁的调用不受影响
+	done := make(chan struct{})
+	go func() {
+		sm.StatAllGroup()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("StatAllGroup blocked by authentication")
+	}
+
+	close(auth.releaseChan)
+	assert.Equal(t, base.ErrAuthFailed, <-errChan)
+	assert.Equal(t, 0, len(sm.StatAllGroup()))
+}
@@ -55,7 +55,7 @@ func (s *SimpleAuthCtx) OnSubStart(info base.SubStartInfo) error {
 	return nil
 }
 
-func (s *SimpleAuthCtx) OnHls(streamName string, urlParam string) error {
+func (s *SimpleAuthCtx) OnHls(appName, streamName, urlParam, remoteAddr string) error {
 	if s.config.HlsM3u8Enable {
 		return s.check(streamName, urlParam)
 	}
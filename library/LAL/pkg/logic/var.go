@@ -28,8 +28,8 @@ var (
 	// - rtmp sub, rtsp sub, httpflv sub, httpts sub,
 	// - rtmp push,
 	//
-	// (1.2.) HTTP-API参数 ApiCtrlStartRtpPubReq.TimeoutMs
-	// - ps pub,
+	// (1.2.) HTTP-API参数 ApiCtrlStartRtpPubReq.TimeoutMs ApiCtrlStartUdpTsPubReq.TimeoutMs
+	// - ps pub, udp ts pub,
 	//
 	// (1.3.) 无
 	// - customize pub,
@@ -39,10 +39,10 @@ var (
 	//
 	// (2.x.) rtmp pub, rtmp sub: 底层naza connection，并且设置了超时 rtmp.serverSessionReadAvTimeoutMs rtmp.serverSessionWriteAvTimeoutMs
 	// (2.x.) rtsp pub, rtsp sub: cmd以及tcp模式时底层naza connection，但是没有设置超时(udp使用 nazanet.UdpConnection),
-	// (2.x.) rtmp pull, rtsp pull: HTTP-API参数 ApiCtrlStartRelayPullReq.PullTimeoutMs 静态回源时 StaticRelayPullTimeoutMs
+	// (2.x.) rtmp pull, rtsp pull, httpflv pull, httpts pull, hls pull: HTTP-API参数 ApiCtrlStartRelayPullReq.PullTimeoutMs 静态回源时 StaticRelayPullTimeoutMs
 	// (2.x.) httpflv sub, httpts sub:  httpflv.SubSessionWriteTimeoutMs , httpts.SubSessionWriteTimeoutMs
 	// (2.x.) rtmp push: RelayPushTimeoutMs, RelayPushWriteAvTimeoutMs,
-	// (2.x.) 无: ps pub, customize pub,
+	// (2.x.) 无: ps pub, udp ts pub, customize pub,
 	// (2.x.) hls sub: 配置文件中配置项 sub_session_timeout_ms
 	//
 	// (3.) client类型session默认超时：
@@ -51,6 +51,8 @@ var (
 	// - rtmp pull: rtmp.PullSessionOption.PullTimeoutMs ReadAvTimeoutMs
 	// - rtsp pull: rtsp.PullSessionOption.PullTimeoutMs
 	// - httpflv pull: httpflv.PullSessionOption.PullTimeoutMs ReadTimeoutMs
+	// - httpts pull: httpts.PullSessionOption.PullTimeoutMs ReadTimeoutMs
+	// - hls pull: hls.PullSessionOption.PullTimeoutMs ReadTimeoutMs
 
 	// CheckSessionAliveIntervalSec
 	//
@@ -69,6 +71,7 @@ var (
 	StaticRelayPullTimeoutMs = 10000
 
 	DefaultApiCtrlStartRtpPubReqTimeoutMs        = 60000
+	DefaultApiCtrlStartUdpTsPubReqTimeoutMs      = 60000
 	DefaultApiCtrlStartRelayPullReqPullTimeoutMs = 10000
 )
 
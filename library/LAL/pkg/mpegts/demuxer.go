@@ -0,0 +1,305 @@
+package mpegts
+
+import (
+	"live-server/library/naza/pkg/bele"
+)
+
+// demuxer.go
+//
+// 将mpegts流解析成音视频帧，是 Frame.Pack 的逆过程
+//
+// - 只处理PAT中的第一个节目，以及PMT中的H264、H265、AAC流
+// - 记录PMT中PCR_PID上最新的PCR
+// - 检查每个pid的continuity_counter，不连续时丢弃正在组装的PES，直到下一个PES开始
+//
+
+const tsPacketSize = 188
+
+// OnDemuxFrame
+//
+// @param streamType: 见 StreamTypeAvc 等
+//
+// @param frame: 各字段含义见 Frame 结构体定义，视频的 Frame.Raw 为Annexb格式，音频的 Frame.Raw 为一个或多个ADTS帧。
+//
+//	注意，回调结束后，Demuxer 会继续使用 Frame.Raw 的内存块，上层如果需要持有，应该拷贝
+type OnDemuxFrame func(streamType uint8, frame *Frame)
+
+type DemuxerStat struct {
+	PacketNum  uint64 // 解析的ts packet数量
+	CcErrorNum uint64 // continuity_counter不连续的次数
+	DropPesNum uint64 // 因为continuity_counter不连续而丢弃的PES数量
+
+	HasPcr bool
+	Pcr    uint64 // 最新的PCR，只取program_clock_reference_base部分，单位与pts、dts相同（1/90000秒）
+}
+
+type Demuxer struct {
+	onFrame OnDemuxFrame
+
+	buf       []byte // 不足一个ts packet的数据
+	hasPmtPid bool
+	pmtPid    uint16
+	hasPcrPid bool
+	pcrPid    uint16
+	streams   map[uint16]*demuxStream // key: pid
+	ccs       map[uint16]uint8        // key: pid, value: 上一个带payload的ts packet的continuity_counter
+
+	stat DemuxerStat
+}
+
+type demuxStream struct {
+	streamType uint8
+	key        bool   // 当前PES的首个ts packet是否带有random_access_indicator
+	buf        []byte // 正在组装的PES
+}
+
+func NewDemuxer(onFrame OnDemuxFrame) *Demuxer {
+	return &Demuxer{
+		onFrame: onFrame,
+		streams: make(map[uint16]*demuxStream),
+		ccs:     make(map[uint16]uint8),
+	}
+}
+
+// Feed
+//
+// @param b: 任意长度的mpegts数据，不需要按188字节对齐。函数调用结束后，内部不持有该内存块
+func (d *Demuxer) Feed(b []byte) {
+	if len(d.buf) != 0 {
+		n := tsPacketSize - len(d.buf)
+		if n > len(b) {
+			n = len(b)
+		}
+		d.buf = append(d.buf, b[:n]...)
+		b = b[n:]
+		if len(d.buf) < tsPacketSize {
+			return
+		}
+		d.feedPacket(d.buf)
+		d.buf = d.buf[:0]
+	}
+
+	for len(b) >= tsPacketSize {
+		// 没有对齐时，跳过数据直到找到下一个sync byte
+		if b[0] != syncByte {
+			b = b[1:]
+			continue
+		}
+		d.feedPacket(b[:tsPacketSize])
+		b = b[tsPacketSize:]
+	}
+	if len(b) != 0 && b[0] == syncByte {
+		d.buf = append(d.buf, b...)
+	}
+}
+
+// Flush 将还在组装中的PES回调出去，比如文件读取结束时
+func (d *Demuxer) Flush() {
+	for pid, stream := range d.streams {
+		d.emit(pid, stream)
+	}
+}
+
+func (d *Demuxer) Stat() DemuxerStat {
+	return d.stat
+}
+
+func (d *Demuxer) feedPacket(packet []byte) {
+	h := ParseTsPacketHeader(packet)
+	if h.Err == 1 || h.Adaptation == AdaptationFieldControlReserved {
+		return
+	}
+	d.stat.PacketNum++
+
+	payload := packet[4:]
+	var randomAccess, discontinuity bool
+	if h.Adaptation == AdaptationFieldControlOnly || h.Adaptation == AdaptationFieldControlFollowed {
+		length := int(payload[0])
+		if 1+length > len(payload) {
+			return
+		}
+		if length > 0 {
+			flags := payload[1]
+			discontinuity = flags&0x80 != 0
+			randomAccess = flags&0x40 != 0
+			if flags&0x10 != 0 && length >= 7 && d.hasPcrPid && h.Pid == d.pcrPid {
+				d.stat.Pcr = readPcr(payload[2:])
+				d.stat.HasPcr = true
+			}
+		}
+		payload = payload[1+length:]
+		if h.Adaptation == AdaptationFieldControlOnly {
+			// 没有payload的packet，continuity_counter不递增
+			return
+		}
+	}
+
+	if !d.checkCc(h.Pid, h.Cc, discontinuity) {
+		return
+	}
+
+	switch {
+	case h.Pid == PidPat:
+		if h.PayloadUnitStart == 1 {
+			d.parsePat(payload)
+		}
+	case d.hasPmtPid && h.Pid == d.pmtPid:
+		if h.PayloadUnitStart == 1 {
+			d.parsePmt(payload)
+		}
+	default:
+		stream, ok := d.streams[h.Pid]
+		if !ok {
+			return
+		}
+		if h.PayloadUnitStart == 1 {
+			d.emit(h.Pid, stream)
+			stream.key = randomAccess
+			stream.buf = append(stream.buf[:0], payload...)
+		} else if len(stream.buf) != 0 {
+			stream.buf = append(stream.buf, payload...)
+		} else {
+			// 还没有收到PES的开始，或者PES已经被丢弃
+			return
+		}
+
+		// PES_packet_length不为0时，数据完整后直接回调，不需要等待下一个PES的开始
+		if len(stream.buf) >= 6 {
+			ppl := int(bele.BeUint16(stream.buf[4:]))
+			if ppl != 0 && len(stream.buf) >= 6+ppl {
+				d.emit(h.Pid, stream)
+			}
+		}
+	}
+}
+
+// checkCc
+//
+// @return 是否继续处理这个packet，重复的packet返回false
+func (d *Demuxer) checkCc(pid uint16, cc uint8, discontinuity bool) bool {
+	prev, ok := d.ccs[pid]
+	d.ccs[pid] = cc
+	if !ok || discontinuity {
+		return true
+	}
+	if cc == prev {
+		// 标准允许同一个packet重复发送一次
+		return false
+	}
+	if cc == (prev+1)&0x0f {
+		return true
+	}
+
+	d.stat.CcErrorNum++
+	Log.Warnf("mpegts continuity counter error. pid=%d, prev=%d, curr=%d", pid, prev, cc)
+	if stream, ok := d.streams[pid]; ok && len(stream.buf) != 0 {
+		stream.buf = stream.buf[:0]
+		d.stat.DropPesNum++
+	}
+	return true
+}
+
+// emit 解析组装好的PES，并回调
+func (d *Demuxer) emit(pid uint16, stream *demuxStream) {
+	b := stream.buf
+	stream.buf = stream.buf[:0]
+	if len(b) < 9 || b[0] != 0 || b[1] != 0 || b[2] != 1 {
+		return
+	}
+
+	var frame Frame
+	frame.Pid = pid
+	frame.Sid = b[3]
+	frame.Key = stream.key
+	if ppl := int(bele.BeUint16(b[4:])); ppl != 0 && 6+ppl < len(b) {
+		b = b[:6+ppl]
+	}
+	ptsDtsFlag := b[7] >> 6
+	headerLength := 9 + int(b[8])
+	if headerLength > len(b) {
+		return
+	}
+	if ptsDtsFlag&PtsDtsFlags2 != 0 && len(b) >= 14 {
+		_, frame.Pts = readPts(b[9:])
+	}
+	if ptsDtsFlag == PtsDtsFlags3 && len(b) >= 19 {
+		_, frame.Dts = readPts(b[14:])
+	} else {
+		frame.Dts = frame.Pts
+	}
+	frame.Raw = b[headerLength:]
+	if len(frame.Raw) == 0 {
+		return
+	}
+	d.onFrame(stream.streamType, &frame)
+}
+
+// parsePsiSection 跳过pointer_field，返回完整的section，目前只支持section在一个ts packet中的情况
+func parsePsiSection(payload []byte) []byte {
+	if len(payload) < 1 {
+		return nil
+	}
+	pointer := int(payload[0])
+	if 1+pointer+3 > len(payload) {
+		return nil
+	}
+	s := payload[1+pointer:]
+	sectionLength := int(bele.BeUint16(s[1:]) & 0x0fff)
+	// 至少包含到last_section_number以及CRC32
+	if sectionLength < 9 || 3+sectionLength > len(s) {
+		return nil
+	}
+	return s[:3+sectionLength]
+}
+
+func (d *Demuxer) parsePat(payload []byte) {
+	s := parsePsiSection(payload)
+	if s == nil || s[0] != TsPsiIdPas {
+		return
+	}
+	for i := 8; i+4 <= len(s)-4; i += 4 {
+		programNumber := bele.BeUint16(s[i:])
+		if programNumber == 0 {
+			// network_PID
+			continue
+		}
+		d.pmtPid = bele.BeUint16(s[i+2:]) & 0x1fff
+		d.hasPmtPid = true
+		return
+	}
+}
+
+func (d *Demuxer) parsePmt(payload []byte) {
+	s := parsePsiSection(payload)
+	if s == nil || s[0] != TsPsiIdPms || len(s) < 12+4 {
+		return
+	}
+	end := len(s) - 4
+	d.pcrPid = bele.BeUint16(s[8:]) & 0x1fff
+	d.hasPcrPid = true
+	programInfoLength := int(bele.BeUint16(s[10:]) & 0x0fff)
+	streams := make(map[uint16]*demuxStream)
+	for i := 12 + programInfoLength; i+5 <= end; {
+		streamType := s[i]
+		pid := bele.BeUint16(s[i+1:]) & 0x1fff
+		esInfoLength := int(bele.BeUint16(s[i+3:]) & 0x0fff)
+		i += 5 + esInfoLength
+
+		switch streamType {
+		case StreamTypeAvc, StreamTypeHevc, StreamTypeAac:
+		default:
+			continue
+		}
+		// PMT会周期性重复，流没有变化时继续使用正在组装中的PES
+		if stream, ok := d.streams[pid]; ok && stream.streamType == streamType {
+			streams[pid] = stream
+		} else {
+			streams[pid] = &demuxStream{streamType: streamType}
+		}
+	}
+	d.streams = streams
+}
+
+func readPcr(b []byte) uint64 {
+	return uint64(b[0])<<25 | uint64(b[1])<<17 | uint64(b[2])<<9 | uint64(b[3])<<1 | uint64(b[4])>>7
+}
@@ -0,0 +1,62 @@
+package mpegts_test
+
+import (
+	"bytes"
+	"testing"
+
+	"live-server/library/LAL/pkg/mpegts"
+	"live-server/library/naza/pkg/assert"
+)
+
+func TestDemuxer(t *testing.T) {
+	var frames []mpegts.Frame
+	d := mpegts.NewDemuxer(func(streamType uint8, frame *mpegts.Frame) {
+		assert.Equal(t, mpegts.StreamTypeAvc, streamType)
+		f := *frame
+		f.Raw = append([]byte(nil), frame.Raw...)
+		frames = append(frames, f)
+	})
+
+	var cc uint8
+	pack := func(dts uint64, key bool, fill byte) []byte {
+		frame := mpegts.Frame{
+			Pts: dts + 3600,
+			Dts: dts,
+			Cc:  cc,
+			Pid: mpegts.PidVideo,
+			Sid: mpegts.StreamIdVideo,
+			Key: key,
+			Raw: append([]byte{0, 0, 0, 1, 0x65}, bytes.Repeat([]byte{fill}, 400)...),
+		}
+		b := frame.Pack()
+		cc = frame.Cc
+		return b
+	}
+
+	d.Feed(mpegts.FixedFragmentHeader)
+	d.Feed(pack(90000, true, 1))
+	d.Feed(pack(93600, false, 2))
+
+	// 丢失一个packet
+	lost := pack(97200, false, 3)
+	d.Feed(lost[:188])
+	d.Feed(lost[188*2:])
+	d.Feed(pack(100800, false, 4))
+	d.Flush()
+
+	assert.Equal(t, 3, len(frames))
+	assert.Equal(t, true, frames[0].Key)
+	assert.Equal(t, false, frames[1].Key)
+	assert.Equal(t, byte(4), frames[2].Raw[len(frames[2].Raw)-1])
+	for i, dts := range []uint64{90000, 93600, 100800} {
+		// 打包时加了固定的延迟
+		assert.Equal(t, dts+63000, frames[i].Dts)
+		assert.Equal(t, dts+63000+3600, frames[i].Pts)
+	}
+
+	stat := d.Stat()
+	assert.Equal(t, uint64(1), stat.CcErrorNum)
+	assert.Equal(t, uint64(1), stat.DropPesNum)
+	assert.Equal(t, true, stat.HasPcr)
+	assert.Equal(t, uint64(90000-63000), stat.Pcr)
+}
@@ -0,0 +1,135 @@
+package remux
+
+import (
+	"live-server/library/LAL/pkg/aac"
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/mpegts"
+	"live-server/library/LAL/pkg/rtmp"
+)
+
+// Mpegts2RtmpRemuxer 输入mpegts流，输出rtmp流
+//
+// 内部使用 mpegts.Demuxer 解析出音视频帧，再通过 AvPacket2RtmpRemuxer 转换为rtmp消息。
+// 输出的rtmp消息的时间戳为mpegts的dts换算成毫秒，处理了33位时间戳的回绕
+type Mpegts2RtmpRemuxer struct {
+	demuxer *mpegts.Demuxer
+	remuxer *AvPacket2RtmpRemuxer
+
+	hasAsc        bool
+	audioFrameMs  float64 // 一个aac帧的时长，单位毫秒
+	hasLastDts    bool
+	lastDts       uint64 // 上一帧原始的dts
+	lastUnwrapDts int64  // 上一帧处理回绕后的dts
+}
+
+func NewMpegts2RtmpRemuxer(onRtmpMsg rtmp.OnReadRtmpAvMsg) *Mpegts2RtmpRemuxer {
+	r := &Mpegts2RtmpRemuxer{}
+	r.remuxer = NewAvPacket2RtmpRemuxer().WithOnRtmpMsg(onRtmpMsg)
+	r.remuxer.WithOption(func(option *base.AvPacketStreamOption) {
+		option.VideoFormat = base.AvPacketStreamVideoFormatAnnexb
+		option.AudioFormat = base.AvPacketStreamAudioFormatRawAac
+	})
+	r.demuxer = mpegts.NewDemuxer(r.onFrame)
+	return r
+}
+
+// Feed
+//
+// @param b: 任意长度的mpegts数据，函数调用结束后，内部不持有该内存块
+func (r *Mpegts2RtmpRemuxer) Feed(b []byte) {
+	r.demuxer.Feed(b)
+}
+
+// Flush 输入结束时调用，将还在组装中的帧输出
+func (r *Mpegts2RtmpRemuxer) Flush() {
+	r.demuxer.Flush()
+}
+
+// DemuxerStat 见 mpegts.DemuxerStat ，比如continuity_counter错误的次数
+func (r *Mpegts2RtmpRemuxer) DemuxerStat() mpegts.DemuxerStat {
+	return r.demuxer.Stat()
+}
+
+func (r *Mpegts2RtmpRemuxer) onFrame(streamType uint8, frame *mpegts.Frame) {
+	dts := r.unwrapDts(frame.Dts)
+	pts := dts + int64(frame.Pts) - int64(frame.Dts)
+
+	switch streamType {
+	case mpegts.StreamTypeAvc, mpegts.StreamTypeHevc:
+		pt := base.AvPacketPtAvc
+		if streamType == mpegts.StreamTypeHevc {
+			pt = base.AvPacketPtHevc
+		}
+		r.remuxer.FeedAvPacket(base.AvPacket{
+			PayloadType: pt,
+			Timestamp:   dts / 90,
+			Pts:         pts / 90,
+			Payload:     frame.Raw,
+		})
+	case mpegts.StreamTypeAac:
+		r.feedAdts(frame.Raw, dts/90)
+	}
+}
+
+// feedAdts 一个PES中可能有多个ADTS帧，逐个拆分，后面的帧根据采样率推算时间戳
+func (r *Mpegts2RtmpRemuxer) feedAdts(b []byte, timestamp int64) {
+	var ctx aac.AdtsHeaderContext
+	for i := 0; len(b) >= aac.AdtsHeaderLength; i++ {
+		if err := ctx.Unpack(b); err != nil {
+			return
+		}
+		frameLength := int(ctx.AdtsLength)
+		headerLength := aac.AdtsHeaderLength
+		if b[1]&0x01 == 0 {
+			// protection_absent为0时，还有2字节的crc
+			headerLength += 2
+		}
+		if frameLength <= headerLength || frameLength > len(b) {
+			Log.Warnf("invalid adts frame. length=%d, remain=%d", frameLength, len(b))
+			return
+		}
+
+		if !r.hasAsc {
+			sampleRate, err := ctx.AscCtx.GetSamplingFrequency()
+			if err != nil {
+				return
+			}
+			r.audioFrameMs = 1024 * 1000 / float64(sampleRate)
+			r.remuxer.InitWithAvConfig(ctx.AscCtx.Pack(), nil, nil, nil)
+			r.hasAsc = true
+		}
+
+		r.remuxer.FeedAvPacket(base.AvPacket{
+			PayloadType: base.AvPacketPtAac,
+			Timestamp:   timestamp + int64(float64(i)*r.audioFrameMs),
+			Payload:     b[headerLength:frameLength],
+		})
+		b = b[frameLength:]
+	}
+}
+
+// unwrapDts mpegts的时间戳是33位的，回绕后继续递增。音视频的时间戳交错可能小幅回退，所以按有符号的差值计算
+func (r *Mpegts2RtmpRemuxer) unwrapDts(dts uint64) int64 {
+	const (
+		wrap = int64(1) << 33
+		half = int64(1) << 32
+	)
+	if !r.hasLastDts {
+		r.hasLastDts = true
+		r.lastDts = dts
+		r.lastUnwrapDts = int64(dts)
+		return r.lastUnwrapDts
+	}
+	diff := (int64(dts) - int64(r.lastDts)) % wrap
+	if diff >= half {
+		diff -= wrap
+	} else if diff < -half {
+		diff += wrap
+	}
+	r.lastDts = dts
+	r.lastUnwrapDts += diff
+	if r.lastUnwrapDts < 0 {
+		return 0
+	}
+	return r.lastUnwrapDts
+}
@@ -0,0 +1,110 @@
+package remux_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"live-server/library/LAL/pkg/avc"
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/mpegts"
+	"live-server/library/LAL/pkg/remux"
+	"live-server/library/naza/pkg/assert"
+)
+
+type tsCollector struct {
+	out []byte
+}
+
+func (c *tsCollector) OnPatPmt(b []byte) {
+	c.out = append(c.out, b...)
+}
+
+func (c *tsCollector) OnTsPackets(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
+	c.out = append(c.out, tsPackets...)
+}
+
+// TestMpegts2RtmpRemuxer rtmp -> mpegts -> rtmp
+func TestMpegts2RtmpRemuxer(t *testing.T) {
+	sps, _ := hex.DecodeString("67640032ad84010c20086100430802184010c200843b5014005ad370101014000003000400000300ca100002")
+	pps, _ := hex.DecodeString("68ee3cb0")
+	sh, err := avc.BuildSeqHeaderFromSpsPps(sps, pps)
+	assert.Equal(t, nil, err)
+	asc := []byte{0x12, 0x10} // AAC-LC 44100 双声道
+
+	makeMsg := func(typeId uint8, ts uint32, payload []byte) base.RtmpMsg {
+		return base.RtmpMsg{
+			Header:  base.RtmpHeader{MsgTypeId: typeId, TimestampAbs: ts, MsgLen: uint32(len(payload))},
+			Payload: payload,
+		}
+	}
+	// 帧的大小超过一个ts packet
+	makeVideo := func(ts uint32, key bool, i int) base.RtmpMsg {
+		nal := bytes.Repeat([]byte{0xaa}, 300)
+		nal[0], nal[1], nal[len(nal)-1] = 0x41, 0x9a, byte(i)
+		payload := []byte{0x27, 0x01, 0, 0, 0, 0, 0, 0x01, 0x2c}
+		if key {
+			nal[0], nal[1] = 0x65, 0x88
+			payload[0] = 0x17
+		}
+		return makeMsg(base.RtmpTypeIdVideo, ts, append(payload, nal...))
+	}
+
+	var in []base.RtmpMsg
+	in = append(in, makeMsg(base.RtmpTypeIdVideo, 0, sh))
+	in = append(in, makeMsg(base.RtmpTypeIdAudio, 0, append([]byte{0xaf, 0x00}, asc...)))
+	for i := 0; i < 10; i++ {
+		ts := uint32(i * 40)
+		in = append(in, makeVideo(ts, i%5 == 0, i))
+		in = append(in, makeMsg(base.RtmpTypeIdAudio, ts, []byte{0xaf, 0x01, 0x21, 0x00, byte(i)}))
+	}
+
+	var collector tsCollector
+	tsRemuxer := remux.NewRtmp2MpegtsRemuxer(&collector)
+	for _, msg := range in {
+		tsRemuxer.FeedRtmpMessage(msg)
+	}
+	tsRemuxer.FlushAudio()
+
+	var out []base.RtmpMsg
+	r := remux.NewMpegts2RtmpRemuxer(func(msg base.RtmpMsg) {
+		out = append(out, msg.Clone())
+	})
+	// 不按188字节对齐输入
+	for b := collector.out; len(b) > 0; {
+		n := 100
+		if n > len(b) {
+			n = len(b)
+		}
+		r.Feed(b[:n])
+		b = b[n:]
+	}
+	r.Flush()
+
+	var hasVideoSeqHeader, hasAudioSeqHeader bool
+	var videoNum, audioNum, keyNum int
+	for _, msg := range out {
+		switch {
+		case msg.IsAvcKeySeqHeader():
+			hasVideoSeqHeader = true
+		case msg.IsAacSeqHeader():
+			hasAudioSeqHeader = true
+			assert.Equal(t, asc, msg.Payload[2:])
+		case msg.Header.MsgTypeId == base.RtmpTypeIdVideo:
+			if msg.IsVideoKeyNalu() {
+				keyNum++
+			}
+			assert.Equal(t, uint32(videoNum*40), msg.Header.TimestampAbs-out[1].Header.TimestampAbs)
+			assert.Equal(t, byte(videoNum), msg.Payload[len(msg.Payload)-1])
+			videoNum++
+		case msg.Header.MsgTypeId == base.RtmpTypeIdAudio:
+			assert.Equal(t, byte(audioNum), msg.Payload[len(msg.Payload)-1])
+			audioNum++
+		}
+	}
+	assert.Equal(t, true, hasVideoSeqHeader)
+	assert.Equal(t, true, hasAudioSeqHeader)
+	assert.Equal(t, 10, videoNum)
+	assert.Equal(t, 2, keyNum)
+	assert.Equal(t, 10, audioNum)
+}
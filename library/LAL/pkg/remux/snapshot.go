@@ -0,0 +1,202 @@
+package remux
+
+import (
+	"live-server/library/LAL/pkg/avc"
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/fmp4"
+	"live-server/library/LAL/pkg/hevc"
+	"live-server/library/LAL/pkg/mpegts"
+	"live-server/library/naza/pkg/bele"
+)
+
+// snapshotFrameDurationMs 单帧mp4中视频采样的时长
+const snapshotFrameDurationMs = 40
+
+// MakeSnapshot 将rtmp的视频关键帧转换为可以独立解码的一帧数据，格式见 base.SnapshotFormatAnnexb 等
+//
+// 关键帧中自带的vps sps pps优先于seq header中的使用，见 https://github.com/q191201771/lal/issues/143
+//
+// @param videoSeqHeader: 视频的seq header，为nil时只使用关键帧中自带的vps sps pps
+//
+// @param keyFrame: 视频关键帧，支持H264和H265（包括enhanced rtmp）
+//
+// @return 返回值中的 StreamName 和 CaptureTime 由调用方填写
+func MakeSnapshot(videoSeqHeader *base.RtmpMsg, keyFrame base.RtmpMsg, format string) (ret base.Snapshot, err error) {
+	switch format {
+	case base.SnapshotFormatAnnexb, base.SnapshotFormatAvcc, base.SnapshotFormatMp4, base.SnapshotFormatTs:
+	default:
+		return ret, base.ErrRemuxSnapshotUnsupportedFormat
+	}
+	if len(keyFrame.Payload) <= 5 {
+		return ret, base.ErrShortBuffer
+	}
+
+	isHevc := keyFrame.VideoCodecId() == base.RtmpCodecIdHevc
+	var nals [][]byte
+	if isHevc && keyFrame.IsEnchanedHevcNalu() {
+		nals, err = avc.SplitNaluAvcc(keyFrame.Payload[keyFrame.GetEnchanedHevcNaluIndex():])
+	} else {
+		nals, err = avc.SplitNaluAvcc(keyFrame.Payload[5:])
+	}
+	if err != nil {
+		return ret, err
+	}
+
+	// 拆分出参数集和帧数据，aud过滤掉
+	var vps, sps, pps []byte
+	var frameNals [][]byte
+	for _, nal := range nals {
+		if len(nal) == 0 {
+			continue
+		}
+		if isHevc {
+			switch hevc.ParseNaluType(nal[0]) {
+			case hevc.NaluTypeAud:
+			case hevc.NaluTypeVps:
+				vps = nal
+			case hevc.NaluTypeSps:
+				sps = nal
+			case hevc.NaluTypePps:
+				pps = nal
+			default:
+				frameNals = append(frameNals, nal)
+			}
+		} else {
+			switch avc.ParseNaluType(nal[0]) {
+			case avc.NaluTypeAud:
+			case avc.NaluTypeSps:
+				sps = nal
+			case avc.NaluTypePps:
+				pps = nal
+			default:
+				frameNals = append(frameNals, nal)
+			}
+		}
+	}
+
+	inBand := sps != nil && pps != nil && (!isHevc || vps != nil)
+	if !inBand && videoSeqHeader != nil {
+		if isHevc {
+			if videoSeqHeader.IsEnhanced() {
+				vps, sps, pps, err = hevc.ParseVpsSpsPpsFromEnhancedSeqHeader(videoSeqHeader.Payload)
+			} else {
+				vps, sps, pps, err = hevc.ParseVpsSpsPpsFromSeqHeader(videoSeqHeader.Payload)
+			}
+		} else {
+			sps, pps, err = avc.ParseSpsPpsFromSeqHeader(videoSeqHeader.Payload)
+		}
+		if err != nil {
+			return ret, err
+		}
+	}
+	if sps == nil || pps == nil || (isHevc && vps == nil) || len(frameNals) == 0 {
+		return ret, base.ErrRemuxSnapshotNoParamSet
+	}
+
+	// 宽高从sps中解析，mp4格式也需要使用
+	var track *fmp4.VideoTrack
+	if isHevc {
+		track, err = fmp4.NewHevcVideoTrack(vps, sps, pps)
+	} else {
+		track, err = fmp4.NewAvcVideoTrack(sps, pps)
+	}
+	if err != nil {
+		return ret, err
+	}
+
+	ret.Format = format
+	ret.VideoWidth = track.Width
+	ret.VideoHeight = track.Height
+	ret.Timestamp = keyFrame.Dts()
+	if isHevc {
+		ret.VideoCodec = base.VideoCodecHevc
+	} else {
+		ret.VideoCodec = base.VideoCodecAvc
+	}
+
+	var paramSets [][]byte
+	if isHevc {
+		paramSets = [][]byte{vps, sps, pps}
+	} else {
+		paramSets = [][]byte{sps, pps}
+	}
+
+	switch format {
+	case base.SnapshotFormatAnnexb:
+		if isHevc {
+			ret.MimeType = "video/H265"
+		} else {
+			ret.MimeType = "video/H264"
+		}
+		ret.Data = snapshotAnnexb(nil, paramSets, frameNals)
+	case base.SnapshotFormatAvcc:
+		ret.MimeType = "application/octet-stream"
+		ret.Data = snapshotAvcc(nil, paramSets, frameNals)
+	case base.SnapshotFormatMp4:
+		ret.MimeType = "video/mp4"
+		ret.Data, err = snapshotMp4(track, frameNals)
+	case base.SnapshotFormatTs:
+		ret.MimeType = "video/mp2t"
+		ret.Data = snapshotTs(isHevc, paramSets, frameNals)
+	}
+	return ret, err
+}
+
+func snapshotAnnexb(out []byte, paramSets [][]byte, frameNals [][]byte) []byte {
+	for _, list := range [][][]byte{paramSets, frameNals} {
+		for _, nal := range list {
+			out = append(out, avc.NaluStartCode4...)
+			out = append(out, nal...)
+		}
+	}
+	return out
+}
+
+func snapshotAvcc(out []byte, paramSets [][]byte, frameNals [][]byte) []byte {
+	for _, list := range [][][]byte{paramSets, frameNals} {
+		for _, nal := range list {
+			var length [4]byte
+			bele.BePutUint32(length[:], uint32(len(nal)))
+			out = append(out, length[:]...)
+			out = append(out, nal...)
+		}
+	}
+	return out
+}
+
+// snapshotMp4 init segment加上只有一个采样的fragment，参数集在init segment中
+func snapshotMp4(track *fmp4.VideoTrack, frameNals [][]byte) ([]byte, error) {
+	m := fmp4.NewMuxer(track, nil)
+	out, err := m.InitSegment()
+	if err != nil {
+		return nil, err
+	}
+	m.FeedAvPacket(base.AvPacket{
+		PayloadType: track.PayloadType,
+		Payload:     snapshotAvcc(nil, nil, frameNals),
+	})
+	m.SetLastVideoDuration(snapshotFrameDurationMs)
+	return append(out, m.Flush()...), nil
+}
+
+// snapshotTs PAT PMT加上一个视频帧，时间戳从0开始
+func snapshotTs(isHevc bool, paramSets [][]byte, frameNals [][]byte) []byte {
+	var out []byte
+	var raw []byte
+	if isHevc {
+		out = append(out, mpegts.FixedFragmentHeaderHevc...)
+		raw = append(raw, hevc.AudNalu...)
+	} else {
+		out = append(out, mpegts.FixedFragmentHeader...)
+		raw = append(raw, avc.AudNalu...)
+	}
+	raw = snapshotAnnexb(raw, paramSets, frameNals)
+
+	frame := mpegts.Frame{
+		Pid: mpegts.PidVideo,
+		Sid: mpegts.StreamIdVideo,
+		Key: true,
+		Raw: raw,
+	}
+	return append(out, frame.Pack()...)
+}
@@ -0,0 +1,81 @@
+package remux_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"live-server/library/LAL/pkg/avc"
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/mpegts"
+	"live-server/library/LAL/pkg/remux"
+	"live-server/library/naza/pkg/assert"
+)
+
+func TestMakeSnapshot(t *testing.T) {
+	sps, _ := hex.DecodeString("67640032ad84010c20086100430802184010c200843b5014005ad370101014000003000400000300ca100002")
+	pps, _ := hex.DecodeString("68ee3cb0")
+	idr := []byte{0x65, 0x88, 0x80, 0x40}
+
+	sh, err := avc.BuildSeqHeaderFromSpsPps(sps, pps)
+	assert.Equal(t, nil, err)
+	seqHeader := base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo, MsgLen: uint32(len(sh))},
+		Payload: sh,
+	}
+	// 关键帧前带一个aud，会被过滤掉
+	payload := []byte{0x17, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x09, 0xf0, 0, 0, 0, byte(len(idr))}
+	payload = append(payload, idr...)
+	keyFrame := base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo, TimestampAbs: 1000, MsgLen: uint32(len(payload))},
+		Payload: payload,
+	}
+
+	s, err := remux.MakeSnapshot(&seqHeader, keyFrame, base.SnapshotFormatAnnexb)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, base.VideoCodecAvc, s.VideoCodec)
+	assert.Equal(t, 2560, s.VideoWidth)
+	assert.Equal(t, 1440, s.VideoHeight)
+	assert.Equal(t, uint32(1000), s.Timestamp)
+	var expected []byte
+	for _, nal := range [][]byte{sps, pps, idr} {
+		expected = append(expected, avc.NaluStartCode4...)
+		expected = append(expected, nal...)
+	}
+	assert.Equal(t, expected, s.Data)
+
+	s, err = remux.MakeSnapshot(&seqHeader, keyFrame, base.SnapshotFormatAvcc)
+	assert.Equal(t, nil, err)
+	expected, _ = avc.Annexb2Avcc(expected)
+	assert.Equal(t, expected, s.Data)
+
+	s, err = remux.MakeSnapshot(&seqHeader, keyFrame, base.SnapshotFormatTs)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "video/mp2t", s.MimeType)
+	assert.Equal(t, 0, len(s.Data)%188)
+	assert.Equal(t, true, bytes.HasPrefix(s.Data, mpegts.FixedFragmentHeader))
+
+	s, err = remux.MakeSnapshot(&seqHeader, keyFrame, base.SnapshotFormatMp4)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ftyp", string(s.Data[4:8]))
+	assert.Equal(t, true, bytes.Contains(s.Data, []byte("moof")))
+	assert.Equal(t, true, bytes.HasSuffix(s.Data, idr))
+
+	// 关键帧中带有sps pps时，不需要seq header
+	inBand := []byte{0x17, 0x01, 0, 0, 0}
+	for _, nal := range [][]byte{sps, pps, idr} {
+		inBand = append(inBand, 0, 0, 0, byte(len(nal)))
+		inBand = append(inBand, nal...)
+	}
+	keyFrame.Payload = inBand
+	s, err = remux.MakeSnapshot(nil, keyFrame, base.SnapshotFormatAnnexb)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2560, s.VideoWidth)
+
+	// 错误
+	keyFrame.Payload = payload
+	_, err = remux.MakeSnapshot(nil, keyFrame, base.SnapshotFormatAnnexb)
+	assert.Equal(t, base.ErrRemuxSnapshotNoParamSet, err)
+	_, err = remux.MakeSnapshot(&seqHeader, keyFrame, "jpeg")
+	assert.Equal(t, base.ErrRemuxSnapshotUnsupportedFormat, err)
+}
@@ -28,8 +28,14 @@ func MswLsw2Ntp(msw, lsw uint64) uint64 {
 	return (msw << 32) | lsw
 }
 
-//func UnixNano2Ntp(v uint64) uint64 {
-//	msw := v / 1e9 + offset
-//	lsw := ((v % 1e9) << 32) / 1e9
-//	return (msw << 32) | lsw
-//}
+// UnixNano2Ntp 将Unix时间戳（单位纳秒）转换为ntp时间戳
+func UnixNano2Ntp(v uint64) uint64 {
+	msw := v/1e9 + ntpOffset
+	lsw := ((v % 1e9) << 32) / 1e9
+	return (msw << 32) | lsw
+}
+
+// Ntp2Middle 取ntp时间戳的中间32位，用于rtcp rr中的LSR、DLSR等字段，单位是1/65536秒
+func Ntp2Middle(v uint64) uint32 {
+	return uint32(v >> 16)
+}
@@ -9,6 +9,8 @@
 package rtprtcp
 
 import (
+	"live-server/library/LAL/pkg/base"
+
 	"live-server/library/naza/pkg/bele"
 )
 
@@ -88,6 +90,10 @@ const (
 	RtcpPacketTypeApp = 204
 
 	RtcpHeaderLength = 4
+	RtcpSrLength     = 28 // 不包含report block
+	RtcpRrLength     = 8  // 不包含report block
+
+	RtcpReportBlockLength = 24
 
 	RtcpVersion = 2
 )
@@ -109,6 +115,17 @@ type Sr struct {
 	OctetCnt   uint32
 }
 
+// ReportBlock rfc3550 6.4.1，SR和RR中的report block
+type ReportBlock struct {
+	MediaSsrc      uint32 // SSRC_n (source identifier)
+	FractionLost   uint8  // 上一个SR或RR之后的丢包率，值为 丢包数*256/期望收包数
+	CumulativeLost uint32 // 24b, 累计丢包数
+	ExtendedSeq    uint32 // extended highest sequence number received
+	Jitter         uint32 // interarrival jitter, 单位为rtp时间戳
+	Lsr            uint32 // last SR timestamp, 也即SR中ntp时间戳的中间32位
+	Dlsr           uint32 // delay since last SR, 单位1/65536秒
+}
+
 func ParseRtcpHeader(b []byte) RtcpHeader {
 	var h RtcpHeader
 	h.Version = b[0] >> 6
@@ -133,6 +150,63 @@ func ParseSr(b []byte) Sr {
 	return s
 }
 
+// ParseRr rfc3550 6.4.2
+//
+// @param b rtcp包，包含包头
+func ParseRr(b []byte) (senderSsrc uint32, blocks []ReportBlock, err error) {
+	if len(b) < RtcpRrLength {
+		return 0, nil, base.ErrRtpRtcpShortBuffer
+	}
+	h := ParseRtcpHeader(b)
+	senderSsrc = bele.BeUint32(b[4:])
+	blocks, err = parseReportBlocks(b[RtcpRrLength:], int(h.CountOrFormat))
+	return
+}
+
+// ParseSrReportBlocks 解析SR中携带的report block，对端既发送又接收时会在SR中携带
+//
+// @param b rtcp包，包含包头
+func ParseSrReportBlocks(b []byte) ([]ReportBlock, error) {
+	if len(b) < RtcpSrLength {
+		return nil, base.ErrRtpRtcpShortBuffer
+	}
+	h := ParseRtcpHeader(b)
+	return parseReportBlocks(b[RtcpSrLength:], int(h.CountOrFormat))
+}
+
+// CalcRttMs rfc3550 6.4.1，根据对端回复的LSR和DLSR计算往返时延
+//
+// @param nowNtp: 收到report block时本地的ntp时间戳
+// @return 如果无法计算，比如对端还没收到过SR，返回-1
+func (r *ReportBlock) CalcRttMs(nowNtp uint64) int {
+	if r.Lsr == 0 {
+		return -1
+	}
+	rtt := Ntp2Middle(nowNtp) - r.Lsr - r.Dlsr
+	if int32(rtt) < 0 {
+		return -1
+	}
+	return int(uint64(rtt) * 1000 >> 16)
+}
+
+func parseReportBlocks(b []byte, count int) ([]ReportBlock, error) {
+	if len(b) < count*RtcpReportBlockLength {
+		return nil, base.ErrRtpRtcpShortBuffer
+	}
+	blocks := make([]ReportBlock, count)
+	for i := range blocks {
+		p := b[i*RtcpReportBlockLength:]
+		blocks[i].MediaSsrc = bele.BeUint32(p)
+		blocks[i].FractionLost = p[4]
+		blocks[i].CumulativeLost = bele.BeUint24(p[5:])
+		blocks[i].ExtendedSeq = bele.BeUint32(p[8:])
+		blocks[i].Jitter = bele.BeUint32(p[12:])
+		blocks[i].Lsr = bele.BeUint32(p[16:])
+		blocks[i].Dlsr = bele.BeUint32(p[20:])
+	}
+	return blocks, nil
+}
+
 // PackTo @param out 传出参数，注意，调用方保证长度>=4
 func (r *RtcpHeader) PackTo(out []byte) {
 	out[0] = r.Version<<6 | r.Padding<<5 | r.CountOrFormat
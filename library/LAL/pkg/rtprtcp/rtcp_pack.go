@@ -15,7 +15,6 @@ type Rr struct {
 	mediaSsrc   uint32
 	fraction    uint8
 	lost        uint32
-	cycles      uint16
 	extendedSeq uint32
 	jitter      uint32
 	lsr         uint32
@@ -38,13 +37,35 @@ func (r *Rr) Pack() []byte {
 	bele.BePutUint32(b[4:], r.senderSsrc)
 	bele.BePutUint32(b[8:], r.mediaSsrc)
 	b[12] = r.fraction
-	// TODO chef: lost的表示是否正确
-	bele.BePutUint24(b[13:], r.lost>>8)
-	bele.BePutUint16(b[16:], r.cycles)
-	bele.BePutUint32(b[18:], r.extendedSeq)
+	bele.BePutUint24(b[13:], r.lost)
+	bele.BePutUint32(b[16:], r.extendedSeq) // 高16位为cycles
 	bele.BePutUint32(b[20:], r.jitter)
 	bele.BePutUint32(b[24:], r.lsr)
 	bele.BePutUint32(b[28:], 0)
 
 	return b
 }
+
+// Pack 不携带report block
+func (s *Sr) Pack() []byte {
+	const lenInWords = RtcpSrLength / 4
+
+	b := make([]byte, RtcpSrLength)
+
+	var h RtcpHeader
+	h.Version = RtcpVersion
+	h.Padding = 0
+	h.CountOrFormat = 0
+	h.PacketType = RtcpPacketTypeSr
+	h.Length = lenInWords - 1
+	h.PackTo(b)
+
+	bele.BePutUint32(b[4:], s.SenderSsrc)
+	bele.BePutUint32(b[8:], s.Msw)
+	bele.BePutUint32(b[12:], s.Lsw)
+	bele.BePutUint32(b[16:], s.Timestamp)
+	bele.BePutUint32(b[20:], s.PktCnt)
+	bele.BePutUint32(b[24:], s.OctetCnt)
+
+	return b
+}
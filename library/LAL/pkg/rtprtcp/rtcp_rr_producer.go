@@ -96,7 +96,6 @@ func (r *RrProducer) Produce(lsr uint32) []byte {
 	rr.mediaSsrc = r.mediaSsrc
 	rr.fraction = fraction
 	rr.lost = lost
-	rr.cycles = uint16(r.cycles)
 	rr.extendedSeq = r.extendedSeq
 	rr.jitter = r.getJitter()
 	rr.lsr = lsr
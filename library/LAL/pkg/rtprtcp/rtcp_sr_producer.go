@@ -0,0 +1,89 @@
+package rtprtcp
+
+import "time"
+
+// 通过发送的rtp包，产生rtcp sr包
+//
+// 一个SrProducer对应一个SSRC，也即一路音频或视频流
+
+type SrProducer struct {
+	clockRate int
+
+	ssrc     uint32
+	pktCnt   uint32
+	octetCnt uint32
+
+	hasRtp           bool
+	lastRtpTimestamp uint32
+	lastRtpUnixNano  int64
+
+	srIntervalMs   int64
+	lastSrUnixNano int64 // 为0表示还没有产生过sr包
+}
+
+// NewSrProducer
+//
+// @param clockRate:    rtp时间戳的时钟频率，比如视频90000，音频和采样率相同
+// @param srIntervalMs: 两个sr包之间的最小间隔，单位毫秒
+func NewSrProducer(clockRate int, srIntervalMs int) *SrProducer {
+	return &SrProducer{
+		clockRate:    clockRate,
+		srIntervalMs: int64(srIntervalMs),
+	}
+}
+
+// FeedRtpPacket 每次发送rtp包，都将rtp包传入这个函数
+//
+// 注意，SSRC发生变化时（比如输入流重新推流），重新开始统计
+func (s *SrProducer) FeedRtpPacket(pkt RtpPacket) {
+	if s.hasRtp && pkt.Header.Ssrc != s.ssrc {
+		s.pktCnt = 0
+		s.octetCnt = 0
+	}
+	s.hasRtp = true
+	s.ssrc = pkt.Header.Ssrc
+	s.pktCnt++
+	s.octetCnt += uint32(len(pkt.Body()))
+	s.lastRtpTimestamp = pkt.Header.Timestamp
+	s.lastRtpUnixNano = time.Now().UnixNano()
+}
+
+// Produce 距离上一个sr包超过间隔时，产生sr包
+//
+// @return: sr包的二进制数据，不需要发送时返回nil
+func (s *SrProducer) Produce() []byte {
+	if !s.hasRtp {
+		return nil
+	}
+
+	now := time.Now().UnixNano()
+	if s.lastSrUnixNano != 0 && now-s.lastSrUnixNano < s.srIntervalMs*1e6 {
+		return nil
+	}
+	s.lastSrUnixNano = now
+
+	sr := s.makeSr(now)
+	return sr.Pack()
+}
+
+// Ssrc 最近一次发送的rtp包的SSRC
+func (s *SrProducer) Ssrc() uint32 {
+	return s.ssrc
+}
+
+func (s *SrProducer) makeSr(nowUnixNano int64) Sr {
+	// rfc3550 6.4.1
+	// sr中的rtp时间戳和ntp时间戳对应同一时刻，但是不一定等于任何一个rtp包的时间戳，
+	// 所以使用最后一个rtp包的时间戳，加上从发送该包到现在经过的时间
+	elapsed := nowUnixNano - s.lastRtpUnixNano
+	ntp := UnixNano2Ntp(uint64(nowUnixNano))
+
+	return Sr{
+		SenderSsrc: s.ssrc,
+		Msw:        uint32(ntp >> 32),
+		Lsw:        uint32(ntp),
+		Timestamp:  s.lastRtpTimestamp + uint32(elapsed*int64(s.clockRate)/1e9),
+		PktCnt:     s.pktCnt,
+		OctetCnt:   s.octetCnt,
+	}
+}
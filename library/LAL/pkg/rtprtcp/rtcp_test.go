@@ -0,0 +1,120 @@
+package rtprtcp_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/rtprtcp"
+
+	"live-server/library/naza/pkg/assert"
+)
+
+func TestUnixNano2Ntp(t *testing.T) {
+	now := uint64(time.Now().UnixNano())
+	ntp := rtprtcp.UnixNano2Ntp(now)
+	// lsw的精度为1/2^32秒，转换回来误差不超过1纳秒
+	u := rtprtcp.Ntp2UnixNano(ntp)
+	assert.Equal(t, true, now-u <= 1)
+	assert.Equal(t, uint32(ntp>>16), rtprtcp.Ntp2Middle(ntp))
+}
+
+func TestSr(t *testing.T) {
+	golden := rtprtcp.Sr{
+		SenderSsrc: 0x11223344,
+		Msw:        3805600902,
+		Lsw:        2181843386,
+		Timestamp:  90000,
+		PktCnt:     100,
+		OctetCnt:   102400,
+	}
+	b := golden.Pack()
+	assert.Equal(t, rtprtcp.RtcpSrLength, len(b))
+
+	h := rtprtcp.ParseRtcpHeader(b)
+	assert.Equal(t, uint8(rtprtcp.RtcpVersion), h.Version)
+	assert.Equal(t, uint8(rtprtcp.RtcpPacketTypeSr), h.PacketType)
+	assert.Equal(t, uint16(6), h.Length)
+	assert.Equal(t, golden, rtprtcp.ParseSr(b))
+
+	blocks, err := rtprtcp.ParseSrReportBlocks(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(blocks))
+}
+
+func TestRr(t *testing.T) {
+	rp := rtprtcp.NewRrProducer(90000)
+	for i := 0; i < 100; i++ {
+		// 丢掉第10到第19个包
+		if i >= 10 && i < 20 {
+			continue
+		}
+		rp.FeedRtpPacket(uint16(65500 + i))
+	}
+	b := rp.Produce(0x12345678)
+
+	_, blocks, err := rtprtcp.ParseRr(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(blocks))
+	assert.Equal(t, uint32(10), blocks[0].CumulativeLost)
+	assert.Equal(t, uint8(10*256/100), blocks[0].FractionLost)
+	assert.Equal(t, uint32(1<<16|(65500+99-65536)), blocks[0].ExtendedSeq)
+	assert.Equal(t, uint32(0x12345678), blocks[0].Lsr)
+	assert.Equal(t, uint32(0), blocks[0].Dlsr)
+
+	_, _, err = rtprtcp.ParseRr(b[:20])
+	assert.Equal(t, true, errors.Is(err, base.ErrRtpRtcpShortBuffer))
+}
+
+func TestReportBlock_CalcRttMs(t *testing.T) {
+	now := uint64(time.Now().UnixNano())
+	sendSr := rtprtcp.UnixNano2Ntp(now)
+	recvRr := rtprtcp.UnixNano2Ntp(now + uint64(150*time.Millisecond))
+
+	// 对端收到sr后，过了100毫秒回复rr，那么往返时延为50毫秒
+	block := rtprtcp.ReportBlock{
+		Lsr:  rtprtcp.Ntp2Middle(sendSr),
+		Dlsr: 65536 / 10,
+	}
+	rtt := block.CalcRttMs(recvRr)
+	assert.Equal(t, true, rtt >= 49 && rtt <= 50, fmt.Sprintf("%d", rtt))
+
+	block.Lsr = 0
+	assert.Equal(t, -1, block.CalcRttMs(recvRr))
+}
+
+func TestSrProducer(t *testing.T) {
+	sp := rtprtcp.NewSrProducer(90000, 100)
+	assert.Equal(t, nil, sp.Produce())
+
+	h := rtprtcp.MakeDefaultRtpHeader()
+	h.Ssrc = 1
+	h.Timestamp = 3600
+	sp.FeedRtpPacket(rtprtcp.MakeRtpPacket(h, make([]byte, 100)))
+	sp.FeedRtpPacket(rtprtcp.MakeRtpPacket(h, make([]byte, 200)))
+
+	b := sp.Produce()
+	sr := rtprtcp.ParseSr(b)
+	assert.Equal(t, uint32(1), sr.SenderSsrc)
+	assert.Equal(t, uint32(2), sr.PktCnt)
+	assert.Equal(t, uint32(300), sr.OctetCnt)
+	assert.Equal(t, true, sr.Timestamp-3600 < 90, fmt.Sprintf("%d", sr.Timestamp))
+	u := rtprtcp.MswLsw2UnixNano(uint64(sr.Msw), uint64(sr.Lsw))
+	assert.Equal(t, true, uint64(time.Now().UnixNano())-u < uint64(time.Second))
+
+	// 间隔内不产生sr
+	assert.Equal(t, nil, sp.Produce())
+
+	// SSRC变化后重新统计
+	h.Ssrc = 2
+	sp.FeedRtpPacket(rtprtcp.MakeRtpPacket(h, make([]byte, 50)))
+	time.Sleep(110 * time.Millisecond)
+	sr = rtprtcp.ParseSr(sp.Produce())
+	assert.Equal(t, uint32(2), sr.SenderSsrc)
+	assert.Equal(t, uint32(1), sr.PktCnt)
+	assert.Equal(t, uint32(50), sr.OctetCnt)
+	// 时间戳按照经过的时间推算
+	assert.Equal(t, true, sr.Timestamp-3600 >= 9900, fmt.Sprintf("%d", sr.Timestamp))
+}
@@ -12,6 +12,7 @@ import (
 	"encoding/hex"
 	"net"
 	"sync"
+	"time"
 
 	"live-server/library/naza/pkg/nazaatomic"
 
@@ -41,8 +42,15 @@ type BaseOutSession struct {
 	videoRtpChannel  int
 	videoRtcpChannel int
 
+	audioSrProducer *rtprtcp.SrProducer
+	videoSrProducer *rtprtcp.SrProducer
+
 	sessionStat base.BasicSessionStat
 
+	rtcpMutex     sync.Mutex // 音频和视频的rtcp可能在不同的协程中读取，并且和 GetStat 的调用协程不同
+	audioRtcpStat rtcpReceiverStat
+	videoRtcpStat rtcpReceiverStat
+
 	// only for debug log
 	debugLogMaxCount         int
 	loggedWriteAudioRtpCount int
@@ -59,7 +67,9 @@ func NewBaseOutSession(sessionType base.SessionType, cmdSession IInterleavedPack
 		cmdSession:       cmdSession,
 		sessionStat:      base.NewBasicSessionStat(sessionType, ""),
 		audioRtpChannel:  -1,
+		audioRtcpChannel: -1,
 		videoRtpChannel:  -1,
+		videoRtcpChannel: -1,
 		debugLogMaxCount: 3,
 		waitChan:         make(chan error, 1),
 	}
@@ -69,6 +79,8 @@ func NewBaseOutSession(sessionType base.SessionType, cmdSession IInterleavedPack
 
 func (session *BaseOutSession) InitWithSdp(sdpCtx sdp.LogicContext) {
 	session.sdpCtx = sdpCtx
+	session.audioSrProducer = rtprtcp.NewSrProducer(sdpCtx.AudioClockRate, rtcpSrIntervalMs)
+	session.videoSrProducer = rtprtcp.NewSrProducer(sdpCtx.VideoClockRate, rtcpSrIntervalMs)
 }
 
 func (session *BaseOutSession) SetupWithConn(uri string, rtpConn, rtcpConn *nazanet.UdpConnection) error {
@@ -82,8 +94,11 @@ func (session *BaseOutSession) SetupWithConn(uri string, rtpConn, rtcpConn *naza
 		return nazaerrors.Wrap(base.ErrRtsp)
 	}
 
+	isAudio := session.sdpCtx.IsAudioUri(uri)
 	go rtpConn.RunLoop(session.onReadRtpPacket)
-	go rtcpConn.RunLoop(session.onReadRtcpPacket)
+	go rtcpConn.RunLoop(func(b []byte, rAddr *net.UDPAddr, err error) bool {
+		return session.onReadRtcpPacket(b, rAddr, err, isAudio)
+	})
 
 	return nil
 }
@@ -127,9 +142,9 @@ func (session *BaseOutSession) HandleInterleavedPacket(b []byte, channel int) {
 	case session.videoRtpChannel:
 		Log.Warnf("[%s] not supposed to read packet in rtp channel of BaseOutSession. channel=%d, len=%d", session.UniqueKey(), channel, len(b))
 	case session.audioRtcpChannel:
-		fallthrough
+		session.handleRtcpPacket(b, true)
 	case session.videoRtcpChannel:
-		Log.Debugf("[%s] read interleaved rtcp packet. b=%s", session.UniqueKey(), hex.Dump(nazabytes.Prefix(b, 32)))
+		session.handleRtcpPacket(b, false)
 	default:
 		Log.Errorf("[%s] read interleaved packet but channel invalid. channel=%d", session.UniqueKey(), channel)
 	}
@@ -152,6 +167,10 @@ func (session *BaseOutSession) WriteRtpPacket(packet rtprtcp.RtpPacket) error {
 		if session.audioRtpChannel != -1 {
 			err = session.cmdSession.WriteInterleavedPacket(packet.Raw, session.audioRtpChannel)
 		}
+		if err == nil && session.audioSrProducer != nil {
+			session.audioSrProducer.FeedRtpPacket(packet)
+			session.writeRtcpPacket(session.audioSrProducer.Produce(), session.audioRtcpConn, session.audioRtcpChannel)
+		}
 	} else if session.sdpCtx.IsVideoPayloadTypeOrigin(t) {
 		if session.loggedWriteVideoRtpCount < session.debugLogMaxCount {
 			Log.Debugf("[%s] LOGPACKET. write video rtp=%+v", session.UniqueKey(), packet.Header)
@@ -164,6 +183,10 @@ func (session *BaseOutSession) WriteRtpPacket(packet rtprtcp.RtpPacket) error {
 		if session.videoRtpChannel != -1 {
 			err = session.cmdSession.WriteInterleavedPacket(packet.Raw, session.videoRtpChannel)
 		}
+		if err == nil && session.videoSrProducer != nil {
+			session.videoSrProducer.FeedRtpPacket(packet)
+			session.writeRtcpPacket(session.videoSrProducer.Produce(), session.videoRtcpConn, session.videoRtcpChannel)
+		}
 	} else {
 		Log.Errorf("[%s] write rtp packet but type invalid. type=%d", session.UniqueKey(), t)
 		err = nazaerrors.Wrap(base.ErrRtsp)
@@ -178,7 +201,22 @@ func (session *BaseOutSession) WriteRtpPacket(packet rtprtcp.RtpPacket) error {
 // ----- ISessionStat --------------------------------------------------------------------------------------------------
 
 func (session *BaseOutSession) GetStat() base.StatSession {
-	return session.sessionStat.GetStat()
+	stat := session.sessionStat.GetStat()
+
+	session.rtcpMutex.Lock()
+	defer session.rtcpMutex.Unlock()
+	for _, s := range []rtcpReceiverStat{session.audioRtcpStat, session.videoRtcpStat} {
+		if s.fractionLost > stat.RtcpFractionLost {
+			stat.RtcpFractionLost = s.fractionLost
+		}
+		if s.jitterMs > stat.RtcpJitterMs {
+			stat.RtcpJitterMs = s.jitterMs
+		}
+		if s.rttMs > stat.RtcpRttMs {
+			stat.RtcpRttMs = s.rttMs
+		}
+	}
+	return stat
 }
 
 func (session *BaseOutSession) UpdateStat(intervalSec uint32) {
@@ -205,14 +243,82 @@ func (session *BaseOutSession) onReadRtpPacket(b []byte, rAddr *net.UDPAddr, err
 	return true
 }
 
-func (session *BaseOutSession) onReadRtcpPacket(b []byte, rAddr *net.UDPAddr, err error) bool {
-	// TODO chef: impl me
+func (session *BaseOutSession) onReadRtcpPacket(b []byte, rAddr *net.UDPAddr, err error, isAudio bool) bool {
+	session.handleRtcpPacket(b, isAudio)
+	return true
+}
 
+// handleRtcpPacket 处理对端发送的rtcp包，可能是多个rtcp包组成的复合包，目前只处理其中rr和sr的report block
+func (session *BaseOutSession) handleRtcpPacket(b []byte, isAudio bool) {
 	if session.loggedReadRtcpCount.Load() < int32(session.debugLogMaxCount) {
 		Log.Debugf("[%s] LOGPACKET. read rtcp=%s", session.UniqueKey(), hex.Dump(nazabytes.Prefix(b, 32)))
 		session.loggedReadRtcpCount.Increment()
 	}
-	return true
+
+	for len(b) >= rtprtcp.RtcpHeaderLength {
+		h := rtprtcp.ParseRtcpHeader(b)
+		length := (int(h.Length) + 1) * 4
+		if length > len(b) {
+			Log.Warnf("[%s] handleRtcpPacket but length invalid. len=%d, need=%d", session.UniqueKey(), len(b), length)
+			return
+		}
+
+		var blocks []rtprtcp.ReportBlock
+		var err error
+		switch h.PacketType {
+		case rtprtcp.RtcpPacketTypeRr:
+			_, blocks, err = rtprtcp.ParseRr(b[:length])
+		case rtprtcp.RtcpPacketTypeSr:
+			blocks, err = rtprtcp.ParseSrReportBlocks(b[:length])
+		}
+		if err != nil {
+			Log.Warnf("[%s] parse rtcp failed. type=%d, err=%+v", session.UniqueKey(), h.PacketType, err)
+		}
+		// 对端只接收一路流，所以只取第一个report block
+		if len(blocks) > 0 {
+			session.updateRtcpStat(blocks[0], isAudio)
+		}
+
+		b = b[length:]
+	}
+}
+
+func (session *BaseOutSession) updateRtcpStat(block rtprtcp.ReportBlock, isAudio bool) {
+	clockRate := session.sdpCtx.VideoClockRate
+	if isAudio {
+		clockRate = session.sdpCtx.AudioClockRate
+	}
+
+	var s rtcpReceiverStat
+	s.fractionLost = float64(block.FractionLost) / 256
+	if clockRate > 0 {
+		s.jitterMs = int(uint64(block.Jitter) * 1000 / uint64(clockRate))
+	}
+	s.rttMs = block.CalcRttMs(rtprtcp.UnixNano2Ntp(uint64(time.Now().UnixNano())))
+
+	session.rtcpMutex.Lock()
+	defer session.rtcpMutex.Unlock()
+	stat := &session.videoRtcpStat
+	if isAudio {
+		stat = &session.audioRtcpStat
+	}
+	// 无法计算往返时延时，保留上一次的值
+	if s.rttMs == -1 {
+		s.rttMs = stat.rttMs
+	}
+	*stat = s
+}
+
+func (session *BaseOutSession) writeRtcpPacket(b []byte, rtcpConn *nazanet.UdpConnection, rtcpChannel int) {
+	if b == nil {
+		return
+	}
+	if rtcpConn != nil {
+		_ = rtcpConn.Write(b)
+	}
+	if rtcpChannel != -1 {
+		_ = session.cmdSession.WriteInterleavedPacket(b, rtcpChannel)
+	}
 }
 
 func (session *BaseOutSession) dispose(err error) error {
@@ -239,3 +345,9 @@ func (session *BaseOutSession) dispose(err error) error {
 	})
 	return retErr
 }
+
+type rtcpReceiverStat struct {
+	fractionLost float64
+	jitterMs     int
+	rttMs        int
+}
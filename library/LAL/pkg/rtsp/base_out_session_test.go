@@ -0,0 +1,80 @@
+package rtsp
+
+import (
+	"testing"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/rtprtcp"
+	"live-server/library/LAL/pkg/sdp"
+
+	"live-server/library/naza/pkg/assert"
+	"live-server/library/naza/pkg/bele"
+)
+
+type testInterleavedPacket struct {
+	packet  []byte
+	channel int
+}
+
+type testInterleavedPacketWriter struct {
+	packets []testInterleavedPacket
+}
+
+func (w *testInterleavedPacketWriter) WriteInterleavedPacket(packet []byte, channel int) error {
+	w.packets = append(w.packets, testInterleavedPacket{packet: packet, channel: channel})
+	return nil
+}
+
+func TestBaseOutSession_Rtcp(t *testing.T) {
+	rawSdp := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=No Name\r\n" +
+		"t=0 0\r\n" +
+		"m=video 0 RTP/AVP 96\r\n" +
+		"a=rtpmap:96 H264/90000\r\n" +
+		"a=fmtp:96 packetization-mode=1; sprop-parameter-sets=Z2QAIKzZQMApsBEAAAMAAQAAAwAyDxgxlg==,aOvssiw=; profile-level-id=640020\r\n" +
+		"a=control:streamid=0\r\n"
+	sdpCtx, err := sdp.ParseSdp2LogicContext([]byte(rawSdp))
+	assert.Equal(t, nil, err)
+
+	writer := &testInterleavedPacketWriter{}
+	session := NewBaseOutSession(base.SessionTypeRtspSub, writer)
+	session.InitWithSdp(sdpCtx)
+	err = session.SetupWithChannel("rtsp://127.0.0.1/live/test110/streamid=0", 0, 1)
+	assert.Equal(t, nil, err)
+
+	h := rtprtcp.MakeDefaultRtpHeader()
+	h.PacketType = 96
+	h.Ssrc = 0x11223344
+	h.Timestamp = 3600
+	_ = session.WriteRtpPacket(rtprtcp.MakeRtpPacket(h, make([]byte, 100)))
+	h.Seq++
+	_ = session.WriteRtpPacket(rtprtcp.MakeRtpPacket(h, make([]byte, 100)))
+
+	// 第一个rtp包之后立即发送sr，之后间隔内不再发送
+	assert.Equal(t, 3, len(writer.packets))
+	assert.Equal(t, 0, writer.packets[0].channel)
+	assert.Equal(t, 1, writer.packets[1].channel)
+	assert.Equal(t, 0, writer.packets[2].channel)
+	sr := rtprtcp.ParseSr(writer.packets[1].packet)
+	assert.Equal(t, uint32(0x11223344), sr.SenderSsrc)
+	assert.Equal(t, uint32(1), sr.PktCnt)
+	assert.Equal(t, uint32(100), sr.OctetCnt)
+
+	// 对端回复rr + sdes的复合包
+	rr := make([]byte, rtprtcp.RtcpRrLength+rtprtcp.RtcpReportBlockLength)
+	hr := rtprtcp.RtcpHeader{Version: rtprtcp.RtcpVersion, CountOrFormat: 1, PacketType: rtprtcp.RtcpPacketTypeRr, Length: uint16(len(rr)/4 - 1)}
+	hr.PackTo(rr)
+	p := rr[rtprtcp.RtcpRrLength:]
+	bele.BePutUint32(p, sr.SenderSsrc)
+	p[4] = 64                     // 25%
+	bele.BePutUint32(p[12:], 900) // 10ms
+	bele.BePutUint32(p[16:], sr.GetMiddleNtp())
+	sdes := []byte{0x81, 0xca, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}
+	session.HandleInterleavedPacket(append(rr, sdes...), 1)
+
+	stat := session.GetStat()
+	assert.Equal(t, 0.25, stat.RtcpFractionLost)
+	assert.Equal(t, 10, stat.RtcpJitterMs)
+	assert.Equal(t, true, stat.RtcpRttMs >= 0 && stat.RtcpRttMs < 100)
+}
@@ -43,6 +43,10 @@ const (
 type ClientCommandSessionOption struct {
 	DoTimeoutMs int
 	OverTcp     bool
+
+	// TunnelUrl 不为空时，通过rtsp over http隧道或rtsp over websocket与服务端建立连接，此时强制使用interleaved模式
+	// 比如 http://127.0.0.1:8080/rtsp/live/test110 或 ws://127.0.0.1:8080/rtsp/live/test110
+	TunnelUrl string
 }
 
 var defaultClientCommandSessionOption = ClientCommandSessionOption{
@@ -94,6 +98,9 @@ func NewClientCommandSession(t ClientCommandSessionType, uniqueKey string, obser
 	for _, fn := range modOptions {
 		fn(&option)
 	}
+	if option.TunnelUrl != "" {
+		option.OverTcp = true
+	}
 	s := &ClientCommandSession{
 		t:         t,
 		uniqueKey: uniqueKey,
@@ -331,10 +338,15 @@ func (session *ClientCommandSession) connect(rawUrl string) (err error) {
 		return err
 	}
 
-	Log.Debugf("[%s] > tcp connect.", session.uniqueKey)
-
 	// # 建立连接
-	conn, err := net.Dial("tcp", session.urlCtx.HostWithPort)
+	var conn net.Conn
+	if session.option.TunnelUrl != "" {
+		Log.Debugf("[%s] > tunnel connect. url=%s", session.uniqueKey, session.option.TunnelUrl)
+		conn, err = dialTunnel(session.option.TunnelUrl, time.Duration(session.option.DoTimeoutMs)*time.Millisecond)
+	} else {
+		Log.Debugf("[%s] > tcp connect.", session.uniqueKey)
+		conn, err = net.Dial("tcp", session.urlCtx.HostWithPort)
+	}
 	if err != nil {
 		return err
 	}
@@ -393,8 +405,8 @@ func (session *ClientCommandSession) writeSetup() error {
 	setup := func(setupUri string) error {
 		if session.option.OverTcp {
 			if err := session.writeOneSetupTcp(setupUri); err != nil {
-				// 461情况下尝试切换UDP重试
-				if err == base.ErrRtspUnsupportedTransport {
+				// 461情况下尝试切换UDP重试，隧道不能传输UDP数据
+				if err == base.ErrRtspUnsupportedTransport && session.option.TunnelUrl == "" {
 					if err := session.writeOneSetup(setupUri); err != nil {
 						return err
 					}
@@ -28,6 +28,8 @@ type PullSessionOption struct {
 	PullTimeoutMs int
 
 	OverTcp bool // 是否使用interleaved模式，也即是否通过rtsp command tcp连接传输rtp/rtcp数据
+
+	TunnelUrl string // 不为空时，通过rtsp over http隧道或rtsp over websocket拉流，见 ClientCommandSessionOption.TunnelUrl
 }
 
 var defaultPullSessionOption = PullSessionOption{
@@ -63,6 +65,7 @@ func NewPullSession(observer IPullSessionObserver, modOptions ...ModPullSessionO
 	cmdSession := NewClientCommandSession(CcstPullSession, baseInSession.UniqueKey(), s, func(opt *ClientCommandSessionOption) {
 		opt.DoTimeoutMs = option.PullTimeoutMs
 		opt.OverTcp = option.OverTcp
+		opt.TunnelUrl = option.TunnelUrl
 	})
 	s.baseInSession = baseInSession
 	s.cmdSession = cmdSession
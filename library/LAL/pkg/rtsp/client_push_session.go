@@ -22,6 +22,7 @@ import (
 type PushSessionOption struct {
 	PushTimeoutMs int
 	OverTcp       bool
+	TunnelUrl     string // 见 ClientCommandSessionOption.TunnelUrl
 }
 
 var defaultPushSessionOption = PushSessionOption{
@@ -52,6 +53,7 @@ func NewPushSession(modOptions ...ModPushSessionOption) *PushSession {
 	cmdSession := NewClientCommandSession(CcstPushSession, baseOutSession.UniqueKey(), s, func(opt *ClientCommandSessionOption) {
 		opt.DoTimeoutMs = option.PushTimeoutMs
 		opt.OverTcp = option.OverTcp
+		opt.TunnelUrl = option.TunnelUrl
 	})
 	s.cmdSession = cmdSession
 	s.baseOutSession = baseOutSession
@@ -0,0 +1,404 @@
+package rtsp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/rtprtcp"
+	"live-server/library/LAL/pkg/sdp"
+
+	"live-server/library/naza/pkg/bele"
+	"live-server/library/naza/pkg/nazaerrors"
+)
+
+// multicast.go
+//
+// rtsp拉流的UDP组播输出
+//
+// - 客户端在SETUP信令中使用 `Transport: RTP/AVP;multicast` 选择组播
+// - 一个流（group）对应一个 MulticastSender ，所有选择组播的拉流客户端共享它发送的同一份rtp数据，而不是每个客户端单独发送一份
+// - 每个track（音频或视频）从 MulticastAddrPool 中分配一个组播地址和一对rtp/rtcp端口，最后一个客户端离开时归还
+// - 会定时发送rtcp sr，但是不接收客户端的rtcp rr
+// - 客户端SETUP后占用组播地址，PLAY后才开始发送数据。组播数据是共享的，所有客户端都PAUSE时才停止发送
+// - 输入流的sdp发生变化时（比如重新推流），由上层调用 OnSdp 更新
+//
+// 注意，后加入的客户端从当前位置开始接收数据，不会单独等待视频关键帧
+//
+
+// MulticastAddrPool 组播地址池，多个 MulticastSender 共享，保证不同的流使用不同的组播地址和端口
+type MulticastAddrPool struct {
+	ipStart uint32
+	ipNum   uint64
+	portMin int
+	portNum uint64 // rtp/rtcp端口对的数量
+
+	mutex  sync.Mutex
+	used   map[uint64]struct{}
+	cursor uint64
+}
+
+// NewMulticastAddrPool
+//
+// @param addrStart, addrEnd: 组播地址的范围（包含两端），比如 "239.0.0.1" 和 "239.0.0.255"
+//
+// @param portMin, portMax:   端口的范围（包含两端），rtp使用偶数端口，rtcp使用rtp端口+1
+func NewMulticastAddrPool(addrStart, addrEnd string, portMin, portMax int) (*MulticastAddrPool, error) {
+	start := net.ParseIP(addrStart).To4()
+	end := net.ParseIP(addrEnd).To4()
+	if start == nil || end == nil || !start.IsMulticast() || !end.IsMulticast() {
+		return nil, nazaerrors.Wrap(base.ErrRtspInvalidMulticastConfig, fmt.Sprintf("addr=%s-%s", addrStart, addrEnd))
+	}
+	ipStart := bele.BeUint32(start)
+	ipEnd := bele.BeUint32(end)
+	if ipStart > ipEnd {
+		return nil, nazaerrors.Wrap(base.ErrRtspInvalidMulticastConfig, fmt.Sprintf("addr=%s-%s", addrStart, addrEnd))
+	}
+
+	if portMin%2 == 1 {
+		portMin++
+	}
+	if portMin <= 0 || portMax > 65535 || portMin+1 > portMax {
+		return nil, nazaerrors.Wrap(base.ErrRtspInvalidMulticastConfig, fmt.Sprintf("port=%d-%d", portMin, portMax))
+	}
+
+	return &MulticastAddrPool{
+		ipStart: ipStart,
+		ipNum:   uint64(ipEnd-ipStart) + 1,
+		portMin: portMin,
+		portNum: uint64(portMax-portMin+1) / 2,
+		used:    make(map[uint64]struct{}),
+	}, nil
+}
+
+// Acquire 分配一个组播地址和rtp端口，rtcp端口为rtp端口+1
+//
+// 优先使用不同的组播地址，使得接收端可以只加入自己需要的组
+func (p *MulticastAddrPool) Acquire() (addr string, rtpPort int, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	total := p.ipNum * p.portNum
+	for i := uint64(0); i < total; i++ {
+		idx := (p.cursor + i) % total
+		if _, ok := p.used[idx]; ok {
+			continue
+		}
+		p.used[idx] = struct{}{}
+		p.cursor = idx + 1
+		addr, rtpPort = p.index2Addr(idx)
+		return addr, rtpPort, nil
+	}
+	return "", 0, base.ErrRtspMulticastAddrExhausted
+}
+
+func (p *MulticastAddrPool) Release(addr string, rtpPort int) {
+	ip := net.ParseIP(addr).To4()
+	if ip == nil {
+		return
+	}
+	ipIdx := uint64(bele.BeUint32(ip) - p.ipStart)
+	portIdx := uint64(rtpPort-p.portMin) / 2
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.used, portIdx*p.ipNum+ipIdx)
+}
+
+func (p *MulticastAddrPool) index2Addr(idx uint64) (addr string, rtpPort int) {
+	var ip [4]byte
+	bele.BePutUint32(ip[:], p.ipStart+uint32(idx%p.ipNum))
+	return net.IP(ip[:]).String(), p.portMin + int(idx/p.ipNum)*2
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type MulticastSenderOption struct {
+	// Interface 发送组播时使用的网卡名，为空时由系统根据路由选择
+	Interface string
+
+	// Ttl 组播的TTL，小于等于0时使用系统默认值
+	Ttl int
+
+	// WaitKeyFrame 开始发送时是否等待视频关键帧，含义和lalserver的rtsp.out_wait_key_frame_flag相同
+	WaitKeyFrame bool
+}
+
+var defaultMulticastSenderOption = MulticastSenderOption{
+	WaitKeyFrame: true,
+}
+
+type ModMulticastSenderOption func(option *MulticastSenderOption)
+
+type MulticastSender struct {
+	uniqueKey string
+	pool      *MulticastAddrPool
+	option    MulticastSenderOption
+
+	mutex                   sync.Mutex
+	sdpCtx                  sdp.LogicContext
+	audioTrack              *multicastTrack
+	videoTrack              *multicastTrack
+	members                 map[*SubSession]struct{} // SETUP选择了组播的客户端
+	playing                 bool                     // 上一个rtp包到来时是否有客户端在播放
+	shouldWaitVideoKeyFrame bool
+	disposed                bool
+}
+
+type multicastTrack struct {
+	addr       string
+	rtpPort    int
+	rtpConn    *net.UDPConn
+	rtcpConn   *net.UDPConn
+	srProducer *rtprtcp.SrProducer
+}
+
+// NewMulticastSender
+//
+// @param uniqueKey: 用于日志
+func NewMulticastSender(uniqueKey string, pool *MulticastAddrPool, modOptions ...ModMulticastSenderOption) *MulticastSender {
+	option := defaultMulticastSenderOption
+	for _, fn := range modOptions {
+		fn(&option)
+	}
+	return &MulticastSender{
+		uniqueKey: uniqueKey,
+		pool:      pool,
+		option:    option,
+		members:   make(map[*SubSession]struct{}),
+	}
+}
+
+// WriteRtpPacket 上层每收到一个rtp包调用一次，不管有多少个组播客户端
+func (s *MulticastSender) WriteRtpPacket(pkt rtprtcp.RtpPacket) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.hasPlayingMember() {
+		s.playing = false
+		return
+	}
+	if !s.playing {
+		// 没有客户端播放之后重新开始发送，和单播恢复播放一样重新等待视频关键帧
+		s.playing = true
+		s.resetWaitVideoKeyFrame()
+	}
+
+	t := int(pkt.Header.PacketType)
+	var track *multicastTrack
+	isVideo := s.sdpCtx.IsVideoPayloadTypeOrigin(t)
+	if isVideo {
+		track = s.videoTrack
+	} else if s.sdpCtx.IsAudioPayloadTypeOrigin(t) {
+		track = s.audioTrack
+	}
+	if track == nil {
+		return
+	}
+
+	if s.shouldWaitVideoKeyFrame {
+		if !isVideo || !rtprtcp.IsAvcHevcBoundary(pkt, s.sdpCtx.GetVideoPayloadTypeBase()) {
+			return
+		}
+		s.shouldWaitVideoKeyFrame = false
+	}
+
+	if _, err := track.rtpConn.Write(pkt.Raw); err != nil {
+		Log.Warnf("[%s] write multicast rtp failed. addr=%s:%d, err=%+v", s.uniqueKey, track.addr, track.rtpPort, err)
+		return
+	}
+	track.srProducer.FeedRtpPacket(pkt)
+	if b := track.srProducer.Produce(); b != nil {
+		_, _ = track.rtcpConn.Write(b)
+	}
+}
+
+// MemberNum 当前正在播放组播的拉流客户端数量，只SETUP了还没有PLAY的，以及PAUSE了的客户端不计算在内
+func (s *MulticastSender) MemberNum() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := 0
+	for session := range s.members {
+		if session.Stage.Load() == SubSessionStageReadPlay {
+			n++
+		}
+	}
+	return n
+}
+
+// OnSdp 输入流的sdp发生变化时调用，已经加入的客户端继续使用原来的组播地址
+func (s *MulticastSender) OnSdp(sdpCtx sdp.LogicContext) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.audioTrack == nil && s.videoTrack == nil {
+		// 还没有客户端加入，第一个客户端加入时使用它的sdp
+		return
+	}
+	if string(s.sdpCtx.RawSdp) == string(sdpCtx.RawSdp) {
+		return
+	}
+	Log.Infof("[%s] rtsp multicast sender sdp changed.", s.uniqueKey)
+	s.sdpCtx = sdpCtx
+	if s.audioTrack != nil {
+		s.audioTrack.srProducer = rtprtcp.NewSrProducer(sdpCtx.AudioClockRate, rtcpSrIntervalMs)
+	}
+	if s.videoTrack != nil {
+		s.videoTrack.srProducer = rtprtcp.NewSrProducer(sdpCtx.VideoClockRate, rtcpSrIntervalMs)
+	}
+	s.resetWaitVideoKeyFrame()
+}
+
+func (s *MulticastSender) Dispose() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	Log.Infof("[%s] dispose rtsp multicast sender.", s.uniqueKey)
+	s.disposed = true
+	s.members = make(map[*SubSession]struct{})
+	s.closeTracks()
+}
+
+// join 拉流客户端通过SETUP信令选择组播，track还没有创建时创建
+//
+// @param sdpCtx: 拉流客户端的sdp，第一个客户端加入时使用
+//
+// @return ttl: 用于回复SETUP信令
+func (s *MulticastSender) join(session *SubSession, sdpCtx sdp.LogicContext, uri string) (addr string, rtpPort int, ttl int, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.disposed {
+		return "", 0, 0, nazaerrors.Wrap(base.ErrRtsp)
+	}
+	if s.audioTrack == nil && s.videoTrack == nil {
+		s.sdpCtx = sdpCtx
+	}
+
+	var (
+		trackPtr  **multicastTrack
+		clockRate int
+	)
+	if s.sdpCtx.IsVideoUri(uri) {
+		trackPtr = &s.videoTrack
+		clockRate = s.sdpCtx.VideoClockRate
+	} else if s.sdpCtx.IsAudioUri(uri) {
+		trackPtr = &s.audioTrack
+		clockRate = s.sdpCtx.AudioClockRate
+	} else {
+		return "", 0, 0, nazaerrors.Wrap(base.ErrRtsp, uri)
+	}
+
+	if *trackPtr == nil {
+		track, err := s.newTrack(clockRate)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		*trackPtr = track
+		if trackPtr == &s.videoTrack {
+			s.resetWaitVideoKeyFrame()
+		}
+		Log.Infof("[%s] new rtsp multicast track. uri=%s, addr=%s:%d", s.uniqueKey, uri, track.addr, track.rtpPort)
+	}
+	s.members[session] = struct{}{}
+
+	ttl = s.option.Ttl
+	if ttl <= 0 {
+		ttl = 1
+	}
+	return (*trackPtr).addr, (*trackPtr).rtpPort, ttl, nil
+}
+
+// leave 最后一个客户端离开时，停止发送并归还组播地址
+func (s *MulticastSender) leave(session *SubSession) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.members[session]; !ok {
+		return
+	}
+	delete(s.members, session)
+	if len(s.members) == 0 {
+		s.closeTracks()
+		s.playing = false
+	}
+}
+
+func (s *MulticastSender) hasPlayingMember() bool {
+	for session := range s.members {
+		if session.Stage.Load() == SubSessionStageReadPlay {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MulticastSender) resetWaitVideoKeyFrame() {
+	pt := s.sdpCtx.GetVideoPayloadTypeBase()
+	s.shouldWaitVideoKeyFrame = s.videoTrack != nil && s.option.WaitKeyFrame && (pt == base.AvPacketPtAvc || pt == base.AvPacketPtHevc)
+}
+
+func (s *MulticastSender) newTrack(clockRate int) (*multicastTrack, error) {
+	addr, rtpPort, err := s.pool.Acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	track := &multicastTrack{
+		addr:       addr,
+		rtpPort:    rtpPort,
+		srProducer: rtprtcp.NewSrProducer(clockRate, rtcpSrIntervalMs),
+	}
+	if track.rtpConn, err = s.dial(addr, rtpPort); err == nil {
+		track.rtcpConn, err = s.dial(addr, rtpPort+1)
+	}
+	if err != nil {
+		s.closeTrack(track)
+		return nil, err
+	}
+	return track, nil
+}
+
+func (s *MulticastSender) dial(addr string, port int) (*net.UDPConn, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(addr, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp4", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	ifi, err := base.GetMulticastInterface(s.option.Interface)
+	if err == nil {
+		err = base.SetMulticastSendOption(conn, ifi, s.option.Ttl)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (s *MulticastSender) closeTracks() {
+	if s.audioTrack != nil {
+		s.closeTrack(s.audioTrack)
+		s.audioTrack = nil
+	}
+	if s.videoTrack != nil {
+		s.closeTrack(s.videoTrack)
+		s.videoTrack = nil
+	}
+	s.shouldWaitVideoKeyFrame = false
+}
+
+func (s *MulticastSender) closeTrack(track *multicastTrack) {
+	Log.Infof("[%s] close rtsp multicast track. addr=%s:%d", s.uniqueKey, track.addr, track.rtpPort)
+	if track.rtpConn != nil {
+		_ = track.rtpConn.Close()
+	}
+	if track.rtcpConn != nil {
+		_ = track.rtcpConn.Close()
+	}
+	s.pool.Release(track.addr, track.rtpPort)
+}
@@ -0,0 +1,121 @@
+package rtsp_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/rtsp"
+
+	"live-server/library/naza/pkg/assert"
+	"live-server/library/naza/pkg/nazahttp"
+)
+
+func TestMulticastAddrPool(t *testing.T) {
+	// 2个地址，端口范围奇数起始，实际可用20002和20004两对
+	pool, err := rtsp.NewMulticastAddrPool("239.0.0.1", "239.0.0.2", 20001, 20005)
+	assert.Equal(t, nil, err)
+
+	golden := []string{"239.0.0.1:20002", "239.0.0.2:20002", "239.0.0.1:20004", "239.0.0.2:20004"}
+	for _, g := range golden {
+		addr, port, err := pool.Acquire()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, g, fmt.Sprintf("%s:%d", addr, port))
+	}
+	_, _, err = pool.Acquire()
+	assert.Equal(t, true, errors.Is(err, base.ErrRtspMulticastAddrExhausted))
+
+	pool.Release("239.0.0.2", 20002)
+	addr, port, err := pool.Acquire()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "239.0.0.2:20002", fmt.Sprintf("%s:%d", addr, port))
+
+	for _, c := range [][]interface{}{
+		{"192.168.0.1", "239.0.0.1", 20000, 20001},
+		{"239.0.0.2", "239.0.0.1", 20000, 20001},
+		{"239.0.0.1", "239.0.0.1", 20000, 20000},
+		{"239.0.0.1", "239.0.0.1", 65534, 65536},
+	} {
+		_, err = rtsp.NewMulticastAddrPool(c[0].(string), c[1].(string), c[2].(int), c[3].(int))
+		assert.Equal(t, true, errors.Is(err, base.ErrRtspInvalidMulticastConfig), fmt.Sprintf("%+v", c))
+	}
+}
+
+func TestServerCommandSession_SetupMulticast(t *testing.T) {
+	// 地址池中只有一个地址，用于验证多个客户端共享，以及归还
+	pool, err := rtsp.NewMulticastAddrPool("239.0.0.1", "239.0.0.1", 20000, 20001)
+	assert.Equal(t, nil, err)
+	sender := rtsp.NewMulticastSender("test", pool)
+	defer sender.Dispose()
+
+	uri := "rtsp://127.0.0.1:5544/live/test110"
+	setup := func(withSender bool) (nazahttp.HttpRespMsgCtx, *rtsp.SubSession, chan struct{}, net.Conn) {
+		cliConn, srvConn := net.Pipe()
+		observer := &testServerCommandSessionObserver{}
+		session := rtsp.NewServerCommandSession(observer, srvConn, rtsp.ServerAuthConfig{})
+		done := make(chan struct{})
+		go func() {
+			_ = session.RunLoop()
+			_ = observer.subSession.Dispose()
+			close(done)
+		}()
+
+		r := bufio.NewReader(cliConn)
+		request := func(method string, cseq int, uri string, headers map[string]string) nazahttp.HttpRespMsgCtx {
+			headers[rtsp.HeaderCSeq] = fmt.Sprintf("%d", cseq)
+			_, err := cliConn.Write([]byte(rtsp.PackRequest(method, uri, headers, "")))
+			assert.Equal(t, nil, err)
+			ctx, err := nazahttp.ReadHttpResponseMessage(r)
+			assert.Equal(t, nil, err)
+			return ctx
+		}
+		request(rtsp.MethodDescribe, 1, uri, map[string]string{})
+		if withSender {
+			observer.subSession.WithMulticastSender(sender)
+		}
+		ctx := request(rtsp.MethodSetup, 2, uri+"/streamid=0", map[string]string{rtsp.HeaderTransport: "RTP/AVP;multicast"})
+		return ctx, observer.subSession, done, cliConn
+	}
+
+	// 没有设置 MulticastSender 时回复461
+	ctx, _, done, cliConn := setup(false)
+	assert.Equal(t, "461", ctx.StatusCode)
+	_ = cliConn.Close()
+	<-done
+
+	// 两个客户端共享同一个组播地址
+	ctx1, subSession1, done1, cliConn1 := setup(true)
+	assert.Equal(t, "200", ctx1.StatusCode)
+	assert.Equal(t, "RTP/AVP;multicast;destination=239.0.0.1;port=20000-20001;ttl=1", ctx1.Headers.Get(rtsp.HeaderTransport))
+	ctx2, subSession2, done2, cliConn2 := setup(true)
+	assert.Equal(t, ctx1.Headers.Get(rtsp.HeaderTransport), ctx2.Headers.Get(rtsp.HeaderTransport))
+
+	// PLAY之后才计入组播的客户端，PAUSE之后不再计入
+	assert.Equal(t, 0, sender.MemberNum())
+	subSession1.Stage.Store(rtsp.SubSessionStageReadPlay)
+	subSession2.Stage.Store(rtsp.SubSessionStageReadPlay)
+	assert.Equal(t, 2, sender.MemberNum())
+	subSession2.Stage.Store(rtsp.SubSessionStagePause)
+	assert.Equal(t, 1, sender.MemberNum())
+	subSession2.Stage.Store(rtsp.SubSessionStageReadPlay)
+
+	// 组播客户端不以发送的数据判断存活，而是以信令保活判断
+	_, writeAlive := subSession1.IsAlive()
+	assert.Equal(t, true, writeAlive)
+
+	_ = cliConn1.Close()
+	<-done1
+	assert.Equal(t, 1, sender.MemberNum())
+	_ = cliConn2.Close()
+	<-done2
+	assert.Equal(t, 0, sender.MemberNum())
+
+	// 最后一个客户端离开后，地址归还给地址池
+	addr, port, err := pool.Acquire()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "239.0.0.1:20000", fmt.Sprintf("%s:%d", addr, port))
+	pool.Release(addr, port)
+}
@@ -21,7 +21,7 @@ import (
 var ResponseOptionsTmpl = "RTSP/1.0 200 OK\r\n" +
 	"Server: " + base.LalRtspOptionsResponseServer + "\r\n" +
 	"CSeq: %s\r\n" +
-	"Public: DESCRIBE, ANNOUNCE, SETUP, PLAY, PAUSE, RECORD, TEARDOWN\r\n" +
+	"Public: DESCRIBE, ANNOUNCE, SETUP, PLAY, PAUSE, RECORD, TEARDOWN, GET_PARAMETER, SET_PARAMETER\r\n" +
 	"\r\n"
 
 // rfc2326 10.3 ANNOUNCE
@@ -45,6 +45,8 @@ var ResponseDescribeTmpl = "RTSP/1.0 200 OK\r\n" +
 
 // ResponseSetupTmpl rfc2326 10.4 SETUP
 // CSeq, Date, Session, Transport
+//
+// 注意，Session中携带了timeout参数，见 ServerSessionTimeoutSec
 var ResponseSetupTmpl = "RTSP/1.0 200 OK\r\n" +
 	"CSeq: %s\r\n" +
 	"Date: %s\r\n" +
@@ -63,10 +65,48 @@ var ResponseRecordTmpl = "RTSP/1.0 200 OK\r\n" +
 
 // rfc2326 10.5 PLAY
 
-// ResponsePlayTmpl CSeq Date
+// ResponsePlayTmpl CSeq, Date, Session, Range
 var ResponsePlayTmpl = "RTSP/1.0 200 OK\r\n" +
 	"CSeq: %s\r\n" +
 	"Date: %s\r\n" +
+	"Session: %s\r\n" +
+	"Range: %s\r\n" +
+	"\r\n"
+
+// ResponsePlayWithScaleTmpl CSeq, Date, Session, Range, Scale
+var ResponsePlayWithScaleTmpl = "RTSP/1.0 200 OK\r\n" +
+	"CSeq: %s\r\n" +
+	"Date: %s\r\n" +
+	"Session: %s\r\n" +
+	"Range: %s\r\n" +
+	"Scale: %s\r\n" +
+	"\r\n"
+
+// rfc2326 10.6 PAUSE
+
+// ResponsePauseTmpl CSeq, Date, Session
+var ResponsePauseTmpl = "RTSP/1.0 200 OK\r\n" +
+	"CSeq: %s\r\n" +
+	"Date: %s\r\n" +
+	"Session: %s\r\n" +
+	"\r\n"
+
+// rfc2326 10.8 GET_PARAMETER
+// 目前只用于保活，不返回任何参数
+
+// ResponseGetParameterTmpl CSeq, Session
+var ResponseGetParameterTmpl = "RTSP/1.0 200 OK\r\n" +
+	"CSeq: %s\r\n" +
+	"Session: %s\r\n" +
+	"\r\n"
+
+// rfc2326 10.9 SET_PARAMETER
+// 目前只用于保活，不处理任何参数
+
+// ResponseSetParameterTmpl CSeq, Session
+var ResponseSetParameterTmpl = "RTSP/1.0 200 OK\r\n" +
+	"CSeq: %s\r\n" +
+	"Session: %s\r\n" +
 	"\r\n"
 
 // rfc2326 10.7 TEARDOWN
@@ -77,6 +117,16 @@ var ResponseTeardownTmpl = "RTSP/1.0 200 OK\r\n" +
 	"CSeq: %s\r\n" +
 	"\r\n"
 
+// ResponseNotImplementedTmpl CSeq
+var ResponseNotImplementedTmpl = "RTSP/1.0 501 Not Implemented\r\n" +
+	"CSeq: %s\r\n" +
+	"\r\n"
+
+// ResponseUnsupportedTransportTmpl CSeq
+var ResponseUnsupportedTransportTmpl = "RTSP/1.0 461 Unsupported Transport\r\n" +
+	"CSeq: %s\r\n" +
+	"\r\n"
+
 var ResponseAuthorizedTmpl = "RTSP/1.0 401 Unauthorized\r\n" +
 	"CSeq: %s\r\n" +
 	"Date: %s\r\n" +
@@ -99,16 +149,43 @@ func PackResponseDescribe(cseq, sdp string) string {
 func PackResponseSetup(cseq string, htv string) string {
 	date := time.Now().Format(time.RFC1123)
 
-	return fmt.Sprintf(ResponseSetupTmpl, cseq, date, sessionId, htv)
+	return fmt.Sprintf(ResponseSetupTmpl, cseq, date, sessionHeaderWithTimeout(), htv)
 }
 
 func PackResponseRecord(cseq string) string {
 	return fmt.Sprintf(ResponseRecordTmpl, cseq, sessionId)
 }
 
-func PackResponsePlay(cseq string) string {
+// PackResponsePlay
+//
+// @param scale: 为空字符串时，不携带Scale header
+func PackResponsePlay(cseq string, rangeValue string, scale string) string {
 	date := time.Now().Format(time.RFC1123)
-	return fmt.Sprintf(ResponsePlayTmpl, cseq, date)
+	if scale == "" {
+		return fmt.Sprintf(ResponsePlayTmpl, cseq, date, sessionId, rangeValue)
+	}
+	return fmt.Sprintf(ResponsePlayWithScaleTmpl, cseq, date, sessionId, rangeValue, scale)
+}
+
+func PackResponsePause(cseq string) string {
+	date := time.Now().Format(time.RFC1123)
+	return fmt.Sprintf(ResponsePauseTmpl, cseq, date, sessionId)
+}
+
+func PackResponseGetParameter(cseq string) string {
+	return fmt.Sprintf(ResponseGetParameterTmpl, cseq, sessionId)
+}
+
+func PackResponseSetParameter(cseq string) string {
+	return fmt.Sprintf(ResponseSetParameterTmpl, cseq, sessionId)
+}
+
+func PackResponseNotImplemented(cseq string) string {
+	return fmt.Sprintf(ResponseNotImplementedTmpl, cseq)
+}
+
+func PackResponseUnsupportedTransport(cseq string) string {
+	return fmt.Sprintf(ResponseUnsupportedTransportTmpl, cseq)
 }
 
 func PackResponseTeardown(cseq string) string {
@@ -24,7 +24,6 @@ import (
 )
 
 // TODO chef
-// - out缺少主动发送sr
 // - pull session回调有observer interface和on func回调两种方式，是否需要统一
 // - [refactor] BaseInSession和BaseOutSession有不少重复内容
 // - [refactor] PullSession和PushSession有不少重复内容
@@ -38,6 +37,8 @@ const (
 	MethodPlay         = "PLAY"
 	MethodTeardown     = "TEARDOWN"
 	MethodGetParameter = "GET_PARAMETER"
+	MethodSetParameter = "SET_PARAMETER"
+	MethodPause        = "PAUSE"
 )
 
 const (
@@ -49,6 +50,7 @@ const (
 	HeaderTransport       = "Transport"
 	HeaderSession         = "Session"
 	HeaderRange           = "Range"
+	HeaderScale           = "Scale"
 	HeaderWwwAuthenticate = "WWW-Authenticate"
 	HeaderAuthorization   = "Authorization"
 	HeaderPublic          = "Public"
@@ -67,12 +69,15 @@ const (
 	HeaderTransportServerRecordTmpl = "RTP/AVP/UDP;unicast;client_port=%d-%d;server_port=%d-%d;mode=record"
 
 	//HeaderTransportServerRecordTCPTmpl = "RTP/AVP/TCP;unicast;interleaved=%d-%d;mode=record"
+
+	HeaderTransportServerPlayMulticastTmpl = "RTP/AVP;multicast;destination=%s;port=%d-%d;ttl=%d" // destination, rtpPort, rtcpPort, ttl
 )
 
 const (
 	TransportFieldClientPort  = "client_port"
 	TransportFieldServerPort  = "server_port"
 	TransportFieldInterleaved = "interleaved"
+	TransportFieldMulticast   = "multicast"
 )
 
 const (
@@ -91,6 +96,8 @@ var (
 	serverCommandSessionReadBufSize   = 256
 	serverCommandSessionWriteChanSize = 1024
 
+	rtcpSrIntervalMs = 5000
+
 	dummyRtpPacket = []byte{
 		0x80, 0x00, 0x00, 0x00,
 		0x00, 0x00, 0x00, 0x00,
@@ -182,6 +189,98 @@ func parseTransport(setupTransport string, key string) (first, second uint16, er
 	return uint16(iFirst), uint16(iSecond), err
 }
 
+// RangeNpt rfc2326 3.6 Normal Play Time
+//
+// 比如 `npt=now-`，`npt=0.000-`，`npt=10-20.5`，`npt=0:01:02.5-`
+type RangeNpt struct {
+	Now    bool    // 起始位置为`now`，也即直播
+	Start  float64 // 起始位置，单位秒
+	End    float64 // 结束位置，单位秒，HasEnd为false时无意义
+	HasEnd bool
+}
+
+// ParseRange 解析Range header的值，目前只支持npt格式
+func ParseRange(v string) (ret RangeNpt, err error) {
+	// 忽略`;time=`等参数
+	v = strings.TrimSpace(strings.Split(v, ";")[0])
+	if !strings.HasPrefix(v, "npt=") {
+		return ret, nazaerrors.Wrap(base.ErrRtspInvalidRange, v)
+	}
+	items := strings.SplitN(strings.TrimPrefix(v, "npt="), "-", 2)
+	if len(items) != 2 {
+		return ret, nazaerrors.Wrap(base.ErrRtspInvalidRange, v)
+	}
+
+	start := strings.TrimSpace(items[0])
+	if start == "now" {
+		ret.Now = true
+	} else if start != "" {
+		if ret.Start, err = parseNptTime(start); err != nil {
+			return ret, nazaerrors.Wrap(base.ErrRtspInvalidRange, v)
+		}
+	}
+
+	end := strings.TrimSpace(items[1])
+	if end != "" {
+		if ret.End, err = parseNptTime(end); err != nil {
+			return ret, nazaerrors.Wrap(base.ErrRtspInvalidRange, v)
+		}
+		ret.HasEnd = true
+	}
+	return ret, nil
+}
+
+// String 用于回复PLAY信令时填入Range header
+func (r RangeNpt) String() string {
+	var start string
+	if r.Now {
+		start = "now"
+	} else {
+		start = fmt.Sprintf("%.3f", r.Start)
+	}
+	if r.HasEnd {
+		return fmt.Sprintf("npt=%s-%.3f", start, r.End)
+	}
+	return fmt.Sprintf("npt=%s-", start)
+}
+
+// ParseScale 解析Scale header的值，比如`2.0`表示2倍速，负数表示倒放
+func ParseScale(v string) (float64, error) {
+	scale, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || scale == 0 {
+		return 0, nazaerrors.Wrap(base.ErrRtspInvalidScale, v)
+	}
+	return scale, nil
+}
+
+// parseNptTime 支持`123.45`秒数格式，以及`hh:mm:ss.fraction`格式
+func parseNptTime(s string) (float64, error) {
+	items := strings.Split(s, ":")
+	switch len(items) {
+	case 1:
+		return strconv.ParseFloat(s, 64)
+	case 3:
+		h, err := strconv.Atoi(items[0])
+		if err != nil {
+			return 0, err
+		}
+		m, err := strconv.Atoi(items[1])
+		if err != nil {
+			return 0, err
+		}
+		sec, err := strconv.ParseFloat(items[2], 64)
+		if err != nil {
+			return 0, err
+		}
+		return float64(h*3600+m*60) + sec, nil
+	}
+	return 0, nazaerrors.Wrap(base.ErrRtspInvalidRange)
+}
+
+func sessionHeaderWithTimeout() string {
+	return fmt.Sprintf("%s;timeout=%d", sessionId, ServerSessionTimeoutSec)
+}
+
 func makeSetupUri(urlCtx base.UrlContext, aControl string) string {
 	if strings.HasPrefix(aControl, "rtsp://") {
 		return aControl
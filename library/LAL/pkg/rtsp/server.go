@@ -9,8 +9,15 @@
 package rtsp
 
 import (
+	"bufio"
 	"crypto/tls"
 	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
 )
 
 type IServerObserver interface {
@@ -63,13 +70,17 @@ type Server struct {
 
 	ln   net.Listener
 	auth ServerAuthConfig
+
+	tunnelMutex    sync.Mutex
+	tunnelGetConns map[string]net.Conn // key为x-sessioncookie，value为等待对应POST请求的http隧道GET连接
 }
 
 func NewServer(addr string, observer IServerObserver, auth ServerAuthConfig) *Server {
 	return &Server{
-		addr:     addr,
-		observer: observer,
-		auth:     auth,
+		addr:           addr,
+		observer:       observer,
+		auth:           auth,
+		tunnelGetConns: make(map[string]net.Conn),
 	}
 }
 
@@ -106,6 +117,60 @@ func (s *Server) RunLoop() error {
 	}
 }
 
+// ServeHttp 处理rtsp over http隧道以及rtsp over websocket的http请求
+//
+// 注册到http server上使用，建立连接后和rtsp tcp连接走相同的处理逻辑
+func (s *Server) ServeHttp(writer http.ResponseWriter, req *http.Request) {
+	// 火狐浏览器 Connection = [keep-alive, Upgrade]
+	isWebSocket := strings.Contains(req.Header.Get("Connection"), "Upgrade") && strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+	cookie := req.Header.Get(HeaderSessionCookie)
+	if !isWebSocket && (cookie == "" || (req.Method != http.MethodGet && req.Method != http.MethodPost)) {
+		Log.Warnf("invalid rtsp over http request. method=%s, uri=%s, cookie=%s", req.Method, req.RequestURI, cookie)
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 客户端携带了Sec-WebSocket-Protocol时，必须包含rtsp，并且回复rtsp，否则浏览器会断开连接
+	var protocol string
+	if isWebSocket {
+		if v := req.Header.Get("Sec-WebSocket-Protocol"); v != "" {
+			for _, item := range strings.Split(v, ",") {
+				if strings.TrimSpace(item) == WebSocketProtocolRtsp {
+					protocol = WebSocketProtocolRtsp
+					break
+				}
+			}
+			if protocol == "" {
+				Log.Warnf("invalid rtsp over websocket protocol. uri=%s, protocol=%s", req.RequestURI, v)
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	conn, bio, err := writer.(http.Hijacker).Hijack()
+	if err != nil {
+		Log.Errorf("hijack failed. err=%+v", err)
+		return
+	}
+
+	if isWebSocket {
+		if _, err = conn.Write(base.UpdateWebSocketHeaderWithProtocol(req.Header.Get("Sec-WebSocket-Key"), protocol)); err != nil {
+			_ = conn.Close()
+			return
+		}
+		Log.Infof("accept rtsp over websocket. raddr=%s, uri=%s", conn.RemoteAddr().String(), req.RequestURI)
+		s.handleTcpConnect(newWsConn(conn, bio.Reader, false))
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		s.handleTunnelGet(conn, cookie)
+		return
+	}
+	s.handleTunnelPost(conn, bio.Reader, cookie)
+}
+
 func (s *Server) Dispose() {
 	if s.ln == nil {
 		return
@@ -139,6 +204,56 @@ func (s *Server) OnDelRtspSubSession(session *SubSession) {
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+func (s *Server) handleTunnelGet(conn net.Conn, cookie string) {
+	resp := "HTTP/1.0 200 OK\r\n" +
+		"Server: " + base.LalRtspOptionsResponseServer + "\r\n" +
+		"Content-Type: " + HeaderContentTypeRtspTunnelled + "\r\n" +
+		"Cache-Control: no-cache\r\n" +
+		"Pragma: no-cache\r\n" +
+		"Connection: close\r\n" +
+		"\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	s.tunnelMutex.Lock()
+	if old, ok := s.tunnelGetConns[cookie]; ok {
+		_ = old.Close()
+	}
+	s.tunnelGetConns[cookie] = conn
+	s.tunnelMutex.Unlock()
+	Log.Infof("accept rtsp over http tunnel get. raddr=%s, cookie=%s", conn.RemoteAddr().String(), cookie)
+
+	// 超时没有收到对应的POST请求，关闭GET连接
+	time.AfterFunc(time.Duration(HttpTunnelPostTimeoutMs)*time.Millisecond, func() {
+		s.tunnelMutex.Lock()
+		defer s.tunnelMutex.Unlock()
+		if c, ok := s.tunnelGetConns[cookie]; ok && c == conn {
+			Log.Warnf("rtsp over http tunnel wait post timeout. raddr=%s, cookie=%s", conn.RemoteAddr().String(), cookie)
+			delete(s.tunnelGetConns, cookie)
+			_ = conn.Close()
+		}
+	})
+}
+
+func (s *Server) handleTunnelPost(conn net.Conn, r *bufio.Reader, cookie string) {
+	s.tunnelMutex.Lock()
+	getConn, ok := s.tunnelGetConns[cookie]
+	delete(s.tunnelGetConns, cookie)
+	s.tunnelMutex.Unlock()
+
+	if !ok {
+		Log.Warnf("rtsp over http tunnel get not found. raddr=%s, cookie=%s", conn.RemoteAddr().String(), cookie)
+		_ = conn.Close()
+		return
+	}
+	Log.Infof("accept rtsp over http tunnel post. raddr=%s, cookie=%s", conn.RemoteAddr().String(), cookie)
+
+	// POST请求不需要回复，后续body中的数据全部是base64编码后的rtsp数据
+	s.handleTcpConnect(newHttpTunnelServerConn(getConn, conn, r))
+}
+
 func (s *Server) handleTcpConnect(conn net.Conn) {
 	session := NewServerCommandSession(s, conn, s.auth)
 	s.observer.OnNewRtspSessionConnect(session)
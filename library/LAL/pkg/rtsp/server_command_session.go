@@ -13,7 +13,9 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
+	"live-server/library/naza/pkg/nazaatomic"
 	"live-server/library/naza/pkg/nazaerrors"
 
 	"live-server/library/naza/pkg/connection"
@@ -68,6 +70,8 @@ type ServerCommandSession struct {
 	subSession *SubSession
 
 	describeSeq string // only for sub session
+
+	lastReadUnixMs nazaatomic.Int64 // 最后一次读到客户端信令或interleaved数据的时间，用于保活
 }
 
 func NewServerCommandSession(observer IServerCommandSessionObserver, conn net.Conn, authConf ServerAuthConfig) *ServerCommandSession {
@@ -82,6 +86,8 @@ func NewServerCommandSession(observer IServerCommandSessionObserver, conn net.Co
 		}),
 	}
 
+	s.lastReadUnixMs.Store(time.Now().UnixMilli())
+
 	Log.Infof("[%s] lifecycle new rtsp ServerSession. session=%p, laddr=%s, raddr=%s", uk, s, conn.LocalAddr().String(), conn.RemoteAddr().String())
 	return s
 }
@@ -163,6 +169,7 @@ Loop:
 			Log.Errorf("[%s] read interleaved error. err=%+v", session.uniqueKey, err)
 			break Loop
 		}
+		session.lastReadUnixMs.Store(time.Now().UnixMilli())
 		if isInterleaved {
 			if session.pubSession != nil {
 				session.pubSession.HandleInterleavedPacket(packet, int(channel))
@@ -205,16 +212,27 @@ Loop:
 		case MethodPlay:
 			// sub
 			handleMsgErr = session.handlePlay(requestCtx)
+		case MethodPause:
+			// sub
+			handleMsgErr = session.handlePause(requestCtx)
+		case MethodGetParameter:
+			// pub, sub
+			handleMsgErr = session.handleGetParameter(requestCtx)
+		case MethodSetParameter:
+			// pub, sub
+			handleMsgErr = session.handleSetParameter(requestCtx)
 		case MethodTeardown:
 			// pub
 			handleMsgErr = session.handleTeardown(requestCtx)
 			break Loop
 		default:
 			Log.Errorf("[%s] unknown rtsp message. method=%s", session.uniqueKey, requestCtx.Method)
+			resp := PackResponseNotImplemented(requestCtx.Headers.Get(HeaderCSeq))
+			_, handleMsgErr = session.conn.Write([]byte(resp))
 		}
 		if handleMsgErr != nil {
 			Log.Errorf("[%s] handle rtsp message error. err=%+v, ctx=%+v", session.uniqueKey, handleMsgErr, requestCtx)
-			break
+			break Loop
 		}
 	}
 
@@ -224,6 +242,11 @@ Loop:
 	return nil
 }
 
+// isKeepaliveTimeout 超过 ServerSessionTimeoutSec 没有读到客户端的任何数据
+func (session *ServerCommandSession) isKeepaliveTimeout() bool {
+	return time.Now().UnixMilli()-session.lastReadUnixMs.Load() > int64(ServerSessionTimeoutSec)*1000
+}
+
 func (session *ServerCommandSession) handleOptions(requestCtx nazahttp.HttpReqMsgCtx) error {
 	Log.Infof("[%s] < R OPTIONS", session.uniqueKey)
 	resp := PackResponseOptions(requestCtx.Headers.Get(HeaderCSeq))
@@ -376,6 +399,29 @@ func (session *ServerCommandSession) handleSetup(requestCtx nazahttp.HttpReqMsgC
 		return err
 	}
 
+	// 是否为组播模式，只支持拉流
+	if strings.Contains(htv, TransportFieldMulticast) {
+		cseq := requestCtx.Headers.Get(HeaderCSeq)
+		var (
+			addr         string
+			rtpPort, ttl int
+			err          = base.ErrRtspUnsupportedTransport
+		)
+		if session.subSession != nil {
+			addr, rtpPort, ttl, err = session.subSession.SetupWithMulticast(requestCtx.Uri)
+		}
+		if err != nil {
+			// 回复461，客户端可以切换其他transport重试
+			Log.Warnf("[%s] setup multicast failed. err=%+v", session.uniqueKey, err)
+			_, err = session.conn.Write([]byte(PackResponseUnsupportedTransport(cseq)))
+			return err
+		}
+
+		htv = fmt.Sprintf(HeaderTransportServerPlayMulticastTmpl, addr, rtpPort, rtpPort+1, ttl)
+		_, err = session.conn.Write([]byte(PackResponseSetup(cseq, htv)))
+		return err
+	}
+
 	rRtpPort, rRtcpPort, err := parseClientPort(requestCtx.Headers.Get(HeaderTransport))
 	if err != nil {
 		Log.Errorf("[%s] parseClientPort failed. err=%+v", session.uniqueKey, err)
@@ -427,13 +473,85 @@ func (session *ServerCommandSession) handlePlay(requestCtx nazahttp.HttpReqMsgCt
 		return base.ErrRtsp
 	}
 
+	// 直播时忽略Range和Scale，设置了 SubSession.WithOnSeek 时交给上层处理
+	rangeValue := HeaderRangeDefault
+	var scaleValue string
+	hr := requestCtx.Headers.Get(HeaderRange)
+	hs := requestCtx.Headers.Get(HeaderScale)
+	if hs != "" {
+		scaleValue = "1.000"
+	}
+	if session.subSession.onSeek != nil && (hr != "" || hs != "") {
+		var rangeNpt *RangeNpt
+		if hr != "" {
+			r, err := ParseRange(hr)
+			if err != nil {
+				return err
+			}
+			rangeNpt = &r
+			rangeValue = r.String()
+		}
+		scale := 1.0
+		if hs != "" {
+			var err error
+			if scale, err = ParseScale(hs); err != nil {
+				return err
+			}
+			scaleValue = fmt.Sprintf("%.3f", scale)
+		}
+		if err := session.subSession.onSeek(session.subSession, rangeNpt, scale); err != nil {
+			return err
+		}
+	}
+
+	resp := PackResponsePlay(requestCtx.Headers.Get(HeaderCSeq), rangeValue, scaleValue)
+
+	// 暂停后恢复播放，不需要再通知上层
+	if session.subSession.Stage.Load() == SubSessionStagePause {
+		Log.Infof("[%s] resume. [%s]", session.uniqueKey, session.subSession.UniqueKey())
+		session.subSession.resumeFlag.Store(true)
+		session.subSession.Stage.Store(SubSessionStageReadPlay)
+		_, err := session.conn.Write([]byte(resp))
+		return err
+	}
+
 	session.subSession.Stage.Store(SubSessionStageReadPlay)
 
 	// TODO(chef): [opt] 上层关闭，可以考虑回复非200状态码再关闭
 	if err := session.observer.OnNewRtspSubSessionPlay(session.subSession); err != nil {
 		return err
 	}
-	resp := PackResponsePlay(requestCtx.Headers.Get(HeaderCSeq))
+	_, err := session.conn.Write([]byte(resp))
+	return err
+}
+
+func (session *ServerCommandSession) handlePause(requestCtx nazahttp.HttpReqMsgCtx) error {
+	Log.Infof("[%s] < R PAUSE", session.uniqueKey)
+
+	if session.subSession == nil {
+		Log.Errorf("[%s] handlePause but subSession not exist.", session.uniqueKey)
+		return base.ErrRtsp
+	}
+
+	// 只有播放中才需要暂停，其他阶段直接回复
+	session.subSession.Stage.CompareAndSwap(SubSessionStageReadPlay, SubSessionStagePause)
+
+	resp := PackResponsePause(requestCtx.Headers.Get(HeaderCSeq))
+	_, err := session.conn.Write([]byte(resp))
+	return err
+}
+
+func (session *ServerCommandSession) handleGetParameter(requestCtx nazahttp.HttpReqMsgCtx) error {
+	// 保活信令，客户端会周期性发送，所以使用Debug级别日志
+	Log.Debugf("[%s] < R GET_PARAMETER", session.uniqueKey)
+	resp := PackResponseGetParameter(requestCtx.Headers.Get(HeaderCSeq))
+	_, err := session.conn.Write([]byte(resp))
+	return err
+}
+
+func (session *ServerCommandSession) handleSetParameter(requestCtx nazahttp.HttpReqMsgCtx) error {
+	Log.Debugf("[%s] < R SET_PARAMETER", session.uniqueKey)
+	resp := PackResponseSetParameter(requestCtx.Headers.Get(HeaderCSeq))
 	_, err := session.conn.Write([]byte(resp))
 	return err
 }
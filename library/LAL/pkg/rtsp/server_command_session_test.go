@@ -0,0 +1,204 @@
+package rtsp_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/rtsp"
+
+	"live-server/library/naza/pkg/assert"
+	"live-server/library/naza/pkg/nazahttp"
+)
+
+var testSdp = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=No Name\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 packetization-mode=1; sprop-parameter-sets=Z2QAIKzZQMApsBEAAAMAAQAAAwAyDxgxlg==,aOvssiw=; profile-level-id=640020\r\n" +
+	"a=control:streamid=0\r\n"
+
+type testServerCommandSessionObserver struct {
+	subSession *rtsp.SubSession
+	playCount  int
+}
+
+func (o *testServerCommandSessionObserver) OnNewRtspPubSession(session *rtsp.PubSession) error {
+	return nil
+}
+
+func (o *testServerCommandSessionObserver) OnNewRtspSubSessionDescribe(session *rtsp.SubSession) (ok bool, sdp []byte) {
+	o.subSession = session
+	return true, []byte(testSdp)
+}
+
+func (o *testServerCommandSessionObserver) OnNewRtspSubSessionPlay(session *rtsp.SubSession) error {
+	o.playCount++
+	return nil
+}
+
+func TestParseRange(t *testing.T) {
+	golden := map[string]rtsp.RangeNpt{
+		"npt=0.000-":              {},
+		"npt=now-":                {Now: true},
+		"npt=10-20.5":             {Start: 10, End: 20.5, HasEnd: true},
+		"npt=0:01:02.5-":          {Start: 62.5},
+		"npt=-30":                 {End: 30, HasEnd: true},
+		"npt=5-;time=19970123T15": {Start: 5},
+	}
+	for v, expected := range golden {
+		r, err := rtsp.ParseRange(v)
+		assert.Equal(t, nil, err, v)
+		assert.Equal(t, expected, r, v)
+	}
+	assert.Equal(t, "npt=0.000-", rtsp.RangeNpt{}.String())
+	assert.Equal(t, "npt=now-", rtsp.RangeNpt{Now: true}.String())
+	assert.Equal(t, "npt=10.000-20.500", rtsp.RangeNpt{Start: 10, End: 20.5, HasEnd: true}.String())
+
+	for _, v := range []string{"", "clock=19961108T142300Z-", "npt=abc-", "npt=10", "npt=1:2-"} {
+		_, err := rtsp.ParseRange(v)
+		assert.Equal(t, true, errors.Is(err, base.ErrRtspInvalidRange), v)
+	}
+
+	scale, err := rtsp.ParseScale(" 2.0")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2.0, scale)
+	scale, err = rtsp.ParseScale("-1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, -1.0, scale)
+	for _, v := range []string{"", "0", "abc"} {
+		_, err = rtsp.ParseScale(v)
+		assert.Equal(t, true, errors.Is(err, base.ErrRtspInvalidScale), v)
+	}
+}
+
+func TestServerCommandSession_PauseAndKeepalive(t *testing.T) {
+	cliConn, srvConn := net.Pipe()
+	observer := &testServerCommandSessionObserver{}
+	session := rtsp.NewServerCommandSession(observer, srvConn, rtsp.ServerAuthConfig{})
+	done := make(chan struct{})
+	go func() {
+		_ = session.RunLoop()
+		close(done)
+	}()
+
+	r := bufio.NewReader(cliConn)
+	uri := "rtsp://127.0.0.1:5544/live/test110"
+	cseq := 0
+	request := func(method string, uri string, headers map[string]string) nazahttp.HttpRespMsgCtx {
+		cseq++
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[rtsp.HeaderCSeq] = fmt.Sprintf("%d", cseq)
+		_, err := cliConn.Write([]byte(rtsp.PackRequest(method, uri, headers, "")))
+		assert.Equal(t, nil, err)
+		ctx, err := nazahttp.ReadHttpResponseMessage(r)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, fmt.Sprintf("%d", cseq), ctx.Headers.Get(rtsp.HeaderCSeq))
+		return ctx
+	}
+
+	ctx := request(rtsp.MethodOptions, uri, nil)
+	assert.Equal(t, "200", ctx.StatusCode)
+	assert.Equal(t, true, strings.Contains(ctx.Headers.Get(rtsp.HeaderPublic), rtsp.MethodGetParameter))
+
+	ctx = request(rtsp.MethodDescribe, uri, nil)
+	assert.Equal(t, "200", ctx.StatusCode)
+	assert.Equal(t, testSdp, string(ctx.Body))
+
+	ctx = request(rtsp.MethodSetup, uri+"/streamid=0", map[string]string{rtsp.HeaderTransport: fmt.Sprintf(rtsp.HeaderTransportClientPlayTcpTmpl, 0, 1)})
+	assert.Equal(t, "200", ctx.StatusCode)
+	assert.Equal(t, fmt.Sprintf("191201771;timeout=%d", rtsp.ServerSessionTimeoutSec), ctx.Headers.Get(rtsp.HeaderSession))
+
+	ctx = request(rtsp.MethodPlay, uri, map[string]string{rtsp.HeaderRange: "npt=10-"})
+	assert.Equal(t, "200", ctx.StatusCode)
+	// 直播，忽略Range
+	assert.Equal(t, rtsp.HeaderRangeDefault, ctx.Headers.Get(rtsp.HeaderRange))
+	assert.Equal(t, 1, observer.playCount)
+	assert.Equal(t, int32(rtsp.SubSessionStageReadPlay), observer.subSession.Stage.Load())
+
+	ctx = request(rtsp.MethodGetParameter, uri, nil)
+	assert.Equal(t, "200", ctx.StatusCode)
+	ctx = request(rtsp.MethodSetParameter, uri, nil)
+	assert.Equal(t, "200", ctx.StatusCode)
+
+	ctx = request(rtsp.MethodPause, uri, nil)
+	assert.Equal(t, "200", ctx.StatusCode)
+	assert.Equal(t, int32(rtsp.SubSessionStagePause), observer.subSession.Stage.Load())
+
+	// 暂停期间没有数据发送，由保活决定是否存活
+	observer.subSession.IsAlive()
+	_, writeAlive := observer.subSession.IsAlive()
+	assert.Equal(t, true, writeAlive)
+	timeoutSec := rtsp.ServerSessionTimeoutSec
+	rtsp.ServerSessionTimeoutSec = 0
+	time.Sleep(10 * time.Millisecond)
+	_, writeAlive = observer.subSession.IsAlive()
+	assert.Equal(t, false, writeAlive)
+	rtsp.ServerSessionTimeoutSec = timeoutSec
+
+	// 恢复播放，不再回调上层
+	ctx = request(rtsp.MethodPlay, uri, map[string]string{rtsp.HeaderScale: "2.0"})
+	assert.Equal(t, "200", ctx.StatusCode)
+	assert.Equal(t, "1.000", ctx.Headers.Get(rtsp.HeaderScale))
+	assert.Equal(t, 1, observer.playCount)
+	assert.Equal(t, int32(rtsp.SubSessionStageReadPlay), observer.subSession.Stage.Load())
+
+	ctx = request("FOO", uri, nil)
+	assert.Equal(t, "501", ctx.StatusCode)
+
+	_, err := cliConn.Write([]byte(rtsp.PackRequest(rtsp.MethodTeardown, uri, map[string]string{rtsp.HeaderCSeq: "100"}, "")))
+	assert.Equal(t, nil, err)
+	<-done
+}
+
+func TestServerCommandSession_Seek(t *testing.T) {
+	cliConn, srvConn := net.Pipe()
+	observer := &testServerCommandSessionObserver{}
+	session := rtsp.NewServerCommandSession(observer, srvConn, rtsp.ServerAuthConfig{})
+	go func() {
+		_ = session.RunLoop()
+	}()
+	defer cliConn.Close()
+
+	r := bufio.NewReader(cliConn)
+	uri := "rtsp://127.0.0.1:5544/vod/test110"
+	request := func(method string, cseq int, headers map[string]string) nazahttp.HttpRespMsgCtx {
+		headers[rtsp.HeaderCSeq] = fmt.Sprintf("%d", cseq)
+		_, err := cliConn.Write([]byte(rtsp.PackRequest(method, uri, headers, "")))
+		assert.Equal(t, nil, err)
+		ctx, err := nazahttp.ReadHttpResponseMessage(r)
+		assert.Equal(t, nil, err)
+		return ctx
+	}
+
+	request(rtsp.MethodDescribe, 1, map[string]string{})
+	var seekRange *rtsp.RangeNpt
+	var seekScale float64
+	observer.subSession.WithOnSeek(func(session *rtsp.SubSession, rangeNpt *rtsp.RangeNpt, scale float64) error {
+		seekRange = rangeNpt
+		seekScale = scale
+		return nil
+	})
+
+	ctx := request(rtsp.MethodPlay, 2, map[string]string{rtsp.HeaderRange: "npt=10-20"})
+	assert.Equal(t, "200", ctx.StatusCode)
+	assert.Equal(t, "npt=10.000-20.000", ctx.Headers.Get(rtsp.HeaderRange))
+	assert.Equal(t, "", ctx.Headers.Get(rtsp.HeaderScale))
+	assert.Equal(t, rtsp.RangeNpt{Start: 10, End: 20, HasEnd: true}, *seekRange)
+	assert.Equal(t, 1.0, seekScale)
+
+	ctx = request(rtsp.MethodPlay, 3, map[string]string{rtsp.HeaderScale: "0.5"})
+	assert.Equal(t, "200", ctx.StatusCode)
+	assert.Equal(t, "0.500", ctx.Headers.Get(rtsp.HeaderScale))
+	assert.Equal(t, (*rtsp.RangeNpt)(nil), seekRange)
+	assert.Equal(t, 0.5, seekScale)
+}
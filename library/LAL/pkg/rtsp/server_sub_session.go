@@ -24,8 +24,18 @@ const (
 	SubSessionStageReadDescribe int32 = 0 // 初时阶段，已收到 describe
 	SubSessionStageWriteSdp           = 1 // 已发送 sdp
 	SubSessionStageReadPlay           = 2 // 已收到 play
+	SubSessionStagePause              = 3 // 已收到 pause，再次收到 play 后回到 SubSessionStageReadPlay
 )
 
+// OnSeek
+//
+// 收到携带Range或Scale的PLAY信令时回调，用于点播等非直播场景的seek和倍速
+//
+// @param rangeNpt: PLAY信令中没有Range时为nil
+// @param scale:    PLAY信令中没有Scale时为1
+// @return 如果返回非nil，则表示上层要强制关闭这个拉流请求
+type OnSeek func(session *SubSession, rangeNpt *RangeNpt, scale float64) error
+
 type SubSession struct {
 	urlCtx         base.UrlContext
 	cmdSession     *ServerCommandSession
@@ -34,6 +44,12 @@ type SubSession struct {
 	ShouldWaitVideoKeyFrame bool
 
 	Stage nazaatomic.Int32 // 见 SubSessionStageReadDescribe 等常量定义
+
+	onSeek     OnSeek
+	resumeFlag nazaatomic.Bool // 暂停后恢复播放，需要重新等待视频关键帧
+
+	multicastSender *MulticastSender
+	multicastFlag   nazaatomic.Bool // 是否有track选择了组播
 }
 
 func NewSubSession(urlCtx base.UrlContext, cmdSession *ServerCommandSession) *SubSession {
@@ -50,6 +66,26 @@ func NewSubSession(urlCtx base.UrlContext, cmdSession *ServerCommandSession) *Su
 	return s
 }
 
+// WithOnSeek
+//
+// 直播场景不需要设置，此时忽略PLAY信令中的Range和Scale
+//
+// 注意，需要在PLAY信令之前设置，比如在 IServerObserver.OnNewRtspSubSessionDescribe 回调中设置
+func (session *SubSession) WithOnSeek(onSeek OnSeek) *SubSession {
+	session.onSeek = onSeek
+	return session
+}
+
+// WithMulticastSender
+//
+// 设置后，客户端可以在SETUP信令中选择组播，同一个流的组播客户端共享 MulticastSender 发送的数据，见 multicast.go
+//
+// 注意，需要在SETUP信令之前设置，比如在 IServerObserver.OnNewRtspSubSessionDescribe 回调中设置
+func (session *SubSession) WithMulticastSender(sender *MulticastSender) *SubSession {
+	session.multicastSender = sender
+	return session
+}
+
 // FeedSdp 供上层调用
 func (session *SubSession) FeedSdp(sdpCtx sdp.LogicContext) {
 	session.Stage.Store(SubSessionStageWriteSdp)
@@ -70,17 +106,40 @@ func (session *SubSession) SetupWithChannel(uri string, rtpChannel, rtcpChannel
 	return session.baseOutSession.SetupWithChannel(uri, rtpChannel, rtcpChannel)
 }
 
+// SetupWithMulticast
+//
+// @return 用于回复SETUP信令的组播地址、rtp端口以及ttl，没有设置 MulticastSender 时返回 base.ErrRtspUnsupportedTransport
+func (session *SubSession) SetupWithMulticast(uri string) (addr string, rtpPort int, ttl int, err error) {
+	if session.multicastSender == nil {
+		return "", 0, 0, base.ErrRtspUnsupportedTransport
+	}
+	addr, rtpPort, ttl, err = session.multicastSender.join(session, session.baseOutSession.sdpCtx, uri)
+	if err == nil {
+		session.multicastFlag.Store(true)
+	}
+	return
+}
+
 func (session *SubSession) WriteRtpPacket(packet rtprtcp.RtpPacket) {
 	stage := session.Stage.Load()
 	if stage != SubSessionStageReadPlay {
 		//Log.Warnf("[%s] write rtp packet is not as expected, stage is not ready yet.. stage=%d", session.UniqueKey(), stage)
 		return
 	}
+	if session.resumeFlag.Load() {
+		// 注意，WriteRtpPacket 和 ShouldWaitVideoKeyFrame 都由上层在同一个协程（加锁）中访问，所以在这里而不是在处理PLAY信令的协程中修改
+		session.resumeFlag.Store(false)
+		session.ShouldWaitVideoKeyFrame = true
+		return
+	}
 	session.baseOutSession.WriteRtpPacket(packet)
 }
 
 func (session *SubSession) Dispose() error {
 	Log.Infof("[%s] lifecycle dispose rtsp SubSession. session=%p", session.UniqueKey(), session)
+	if session.multicastFlag.Load() {
+		session.multicastSender.leave(session)
+	}
 	e1 := session.baseOutSession.Dispose()
 	e2 := session.cmdSession.Dispose()
 	return nazaerrors.CombineErrors(e1, e2)
@@ -121,7 +180,13 @@ func (session *SubSession) UpdateStat(intervalSec uint32) {
 }
 
 func (session *SubSession) IsAlive() (readAlive, writeAlive bool) {
-	return session.baseOutSession.IsAlive()
+	readAlive, writeAlive = session.baseOutSession.IsAlive()
+	if session.Stage.Load() == SubSessionStagePause || session.multicastFlag.Load() {
+		// 暂停时没有数据发送，组播时发送的数据与该客户端无关，
+		// 这两种情况下，只要客户端在超时时间内发送过信令，就认为是存活的
+		writeAlive = !session.cmdSession.isKeepaliveTimeout()
+	}
+	return
 }
 
 // WriteInterleavedPacket IInterleavedPacketWriter, callback by BaseOutSession
@@ -0,0 +1,427 @@
+package rtsp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+
+	"live-server/library/naza/pkg/bele"
+	"live-server/library/naza/pkg/nazaerrors"
+	"live-server/library/naza/pkg/nazahttp"
+)
+
+// rtsp over http隧道（Apple QuickTime的方式）
+//
+// 客户端建立两条http连接，使用相同的x-sessioncookie进行关联：
+// - GET连接，服务端回复200后，后续所有rtsp response和interleaved数据都通过该连接原样发送给客户端
+// - POST连接，客户端后续所有rtsp request（以及interleaved数据）经过base64编码后作为body发送给服务端，服务端不回复
+//
+// rtsp over websocket
+//
+// 一条websocket连接，rtsp信令和interleaved数据都使用二进制帧承载
+//
+// 以上两种方式都只能使用interleaved模式传输音视频数据
+
+const (
+	HeaderSessionCookie            = "x-sessioncookie"
+	HeaderContentTypeRtspTunnelled = "application/x-rtsp-tunnelled"
+
+	// WebSocketProtocolRtsp 客户端握手时携带的Sec-WebSocket-Protocol
+	WebSocketProtocolRtsp = "rtsp"
+)
+
+// HttpTunnelPostTimeoutMs 服务端收到隧道的GET请求后，等待对应POST请求的超时时间，单位毫秒
+var HttpTunnelPostTimeoutMs = 10000
+
+// ----- http tunnel ---------------------------------------------------------------------------------------------------
+
+// httpTunnelConn 将http隧道的两条连接组合成一个 net.Conn ，读写分别对应不同的连接
+type httpTunnelConn struct {
+	readConn  net.Conn
+	writeConn net.Conn
+	r         io.Reader
+	w         io.Writer
+}
+
+// newHttpTunnelServerConn
+//
+// @param postReader: POST连接上的读取对象，可能已经缓存了部分body数据
+func newHttpTunnelServerConn(getConn net.Conn, postConn net.Conn, postReader io.Reader) *httpTunnelConn {
+	return &httpTunnelConn{
+		readConn:  postConn,
+		writeConn: getConn,
+		r:         newBase64Reader(postReader),
+		w:         getConn,
+	}
+}
+
+// newHttpTunnelClientConn
+//
+// @param getReader: GET连接上的读取对象，可能已经缓存了部分rtsp数据
+func newHttpTunnelClientConn(getConn net.Conn, getReader io.Reader, postConn net.Conn) *httpTunnelConn {
+	return &httpTunnelConn{
+		readConn:  getConn,
+		writeConn: postConn,
+		r:         getReader,
+		w:         &base64Writer{w: postConn},
+	}
+}
+
+func (c *httpTunnelConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *httpTunnelConn) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
+
+func (c *httpTunnelConn) Close() error {
+	err1 := c.readConn.Close()
+	err2 := c.writeConn.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+func (c *httpTunnelConn) LocalAddr() net.Addr {
+	return c.readConn.LocalAddr()
+}
+
+func (c *httpTunnelConn) RemoteAddr() net.Addr {
+	return c.readConn.RemoteAddr()
+}
+
+func (c *httpTunnelConn) SetDeadline(t time.Time) error {
+	if err := c.readConn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.writeConn.SetWriteDeadline(t)
+}
+
+func (c *httpTunnelConn) SetReadDeadline(t time.Time) error {
+	return c.readConn.SetReadDeadline(t)
+}
+
+func (c *httpTunnelConn) SetWriteDeadline(t time.Time) error {
+	return c.writeConn.SetWriteDeadline(t)
+}
+
+// base64Reader 解码POST body
+//
+// 注意，客户端通常对每个rtsp request单独编码，所以数据流中间也可能出现填充字符'='，
+// 标准库的 base64.NewDecoder 遇到这种情况会报错，所以这里按4字节一组独立解码
+type base64Reader struct {
+	r   io.Reader
+	buf []byte
+	in  []byte // 不足4字节一组的剩余数据
+	out []byte // 已解码但还没有被读取的数据
+}
+
+func newBase64Reader(r io.Reader) *base64Reader {
+	return &base64Reader{
+		r:   r,
+		buf: make([]byte, 4096),
+	}
+}
+
+func (br *base64Reader) Read(p []byte) (int, error) {
+	for len(br.out) == 0 {
+		n, err := br.r.Read(br.buf)
+		for _, c := range br.buf[:n] {
+			if c == '\r' || c == '\n' || c == ' ' || c == '\t' {
+				continue
+			}
+			br.in = append(br.in, c)
+		}
+
+		m := len(br.in) / 4 * 4
+		if m > 0 {
+			out := make([]byte, m/4*3)
+			k := 0
+			for i := 0; i < m; i += 4 {
+				nn, derr := base64.StdEncoding.Decode(out[k:], br.in[i:i+4])
+				if derr != nil {
+					return 0, derr
+				}
+				k += nn
+			}
+			br.out = out[:k]
+			br.in = append(br.in[:0], br.in[m:]...)
+		}
+
+		if err != nil && len(br.out) == 0 {
+			return 0, err
+		}
+	}
+
+	n := copy(p, br.out)
+	br.out = br.out[n:]
+	return n, nil
+}
+
+// base64Writer 每次写入的数据单独编码后发送
+type base64Writer struct {
+	w io.Writer
+}
+
+func (bw *base64Writer) Write(p []byte) (int, error) {
+	if _, err := bw.w.Write([]byte(base64.StdEncoding.EncodeToString(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ----- websocket -----------------------------------------------------------------------------------------------------
+
+// wsConn 将websocket连接封装成 net.Conn ，读写的是帧中的payload
+type wsConn struct {
+	net.Conn
+
+	r        io.Reader
+	isClient bool // 客户端发送的帧需要带掩码
+
+	payload []byte // 当前帧还没有被读取的数据
+
+	writeMutex sync.Mutex
+}
+
+// newWsConn
+//
+// @param r: conn上的读取对象，可能已经缓存了部分握手之后的数据
+func newWsConn(conn net.Conn, r io.Reader, isClient bool) *wsConn {
+	return &wsConn{
+		Conn:     conn,
+		r:        r,
+		isClient: isClient,
+	}
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for len(c.payload) == 0 {
+		h, payload, err := base.ReadWsFrame(c.r)
+		if err != nil {
+			return 0, err
+		}
+		switch h.Opcode {
+		case base.Wso_Continuous, base.Wso_Text, base.Wso_Binary:
+			c.payload = payload
+		case base.Wso_Ping:
+			if err = c.writeFrame(base.Wso_Pong, payload); err != nil {
+				return 0, err
+			}
+		case base.Wso_Close:
+			_ = c.writeFrame(base.Wso_Close, payload)
+			return 0, io.EOF
+		default:
+			// noop
+		}
+	}
+
+	n := copy(b, c.payload)
+	c.payload = c.payload[n:]
+	return n, nil
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	if err := c.writeFrame(base.Wso_Binary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsConn) writeFrame(opcode base.WsOpcode, payload []byte) error {
+	h := base.WsHeader{
+		Fin:           true,
+		Opcode:        opcode,
+		PayloadLength: uint64(len(payload)),
+	}
+	if c.isClient {
+		var key [4]byte
+		_, _ = rand.Read(key[:])
+		h.Masked = true
+		h.MaskKey = bele.LeUint32(key[:])
+		payload = append([]byte(nil), payload...)
+		base.MaskWsPayload(payload, h.MaskKey)
+	}
+
+	// 帧头和payload合并后一次写入，并且加锁，避免信令和数据的帧交错
+	buf := append(base.MakeWsFrameHeader(h), payload...)
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, err := c.Conn.Write(buf)
+	return err
+}
+
+// ----- client --------------------------------------------------------------------------------------------------------
+
+// dialTunnel 客户端通过http隧道或websocket与服务端建立连接
+//
+// @param tunnelUrl: 比如 http://127.0.0.1:8080/rtsp/live/test110 或 ws://127.0.0.1:8080/rtsp/live/test110
+// @param timeout:   建立连接以及http握手的超时时间，0表示不超时
+//
+// @return 返回的连接上直接收发rtsp信令和interleaved数据
+func dialTunnel(tunnelUrl string, timeout time.Duration) (net.Conn, error) {
+	defaultPort := base.DefaultHttpPort
+	switch strings.ToLower(strings.SplitN(tunnelUrl, ":", 2)[0]) {
+	case "https", "wss":
+		defaultPort = base.DefaultHttpsPort
+	}
+	urlCtx, err := base.ParseUrl(tunnelUrl, defaultPort)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(urlCtx.Scheme) {
+	case "http", "https":
+		return dialHttpTunnel(urlCtx, timeout)
+	case "ws", "wss":
+		return dialWebSocket(urlCtx, timeout)
+	}
+	return nil, nazaerrors.Wrap(base.ErrInvalidUrl, tunnelUrl)
+}
+
+func dialHttpTunnel(urlCtx base.UrlContext, timeout time.Duration) (net.Conn, error) {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	cookie := fmt.Sprintf("%x", b)
+
+	getConn, err := dialTunnelTcp(urlCtx, timeout)
+	if err != nil {
+		return nil, err
+	}
+	getReq := fmt.Sprintf("GET %s HTTP/1.0\r\n"+
+		"Host: %s\r\n"+
+		"User-Agent: %s\r\n"+
+		"%s: %s\r\n"+
+		"Accept: %s\r\n"+
+		"Pragma: no-cache\r\n"+
+		"Cache-Control: no-cache\r\n"+
+		"\r\n",
+		urlCtx.PathWithRawQuery, urlCtx.StdHost, base.LalRtspPullSessionUa, HeaderSessionCookie, cookie, HeaderContentTypeRtspTunnelled)
+	if _, err = getConn.Write([]byte(getReq)); err != nil {
+		_ = getConn.Close()
+		return nil, err
+	}
+	getReader := bufio.NewReader(getConn)
+	if err = readTunnelResponse(getReader, "200"); err != nil {
+		_ = getConn.Close()
+		return nil, err
+	}
+
+	postConn, err := dialTunnelTcp(urlCtx, timeout)
+	if err != nil {
+		_ = getConn.Close()
+		return nil, err
+	}
+	postReq := fmt.Sprintf("POST %s HTTP/1.0\r\n"+
+		"Host: %s\r\n"+
+		"User-Agent: %s\r\n"+
+		"%s: %s\r\n"+
+		"Content-Type: %s\r\n"+
+		"Pragma: no-cache\r\n"+
+		"Cache-Control: no-cache\r\n"+
+		"Content-Length: 32767\r\n"+
+		"Expires: Sun, 9 Jan 1972 00:00:00 GMT\r\n"+
+		"\r\n",
+		urlCtx.PathWithRawQuery, urlCtx.StdHost, base.LalRtspPullSessionUa, HeaderSessionCookie, cookie, HeaderContentTypeRtspTunnelled)
+	if _, err = postConn.Write([]byte(postReq)); err != nil {
+		_ = getConn.Close()
+		_ = postConn.Close()
+		return nil, err
+	}
+
+	// 握手完成，取消超时
+	_ = getConn.SetDeadline(time.Time{})
+	_ = postConn.SetDeadline(time.Time{})
+	return newHttpTunnelClientConn(getConn, getReader, postConn), nil
+}
+
+func dialWebSocket(urlCtx base.UrlContext, timeout time.Duration) (net.Conn, error) {
+	conn, err := dialTunnelTcp(urlCtx, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	key := base.MakeWebSocketKey()
+	req := fmt.Sprintf("GET %s HTTP/1.1\r\n"+
+		"Host: %s\r\n"+
+		"User-Agent: %s\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Protocol: %s\r\n"+
+		"\r\n",
+		urlCtx.PathWithRawQuery, urlCtx.StdHost, base.LalRtspPullSessionUa, key, WebSocketProtocolRtsp)
+	if _, err = conn.Write([]byte(req)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	firstLine, headers, err := nazahttp.ReadHttpHeader(r)
+	if err == nil {
+		var code string
+		if _, code, _, err = nazahttp.ParseHttpStatusLine(firstLine); err == nil && code != "101" {
+			err = nazaerrors.Wrap(base.ErrRtsp, firstLine)
+		}
+	}
+	if err == nil && strings.TrimSpace(headers.Get("Sec-WebSocket-Accept")) != base.CalcWebSocketAccept(key) {
+		err = nazaerrors.Wrap(base.ErrRtsp, "invalid Sec-WebSocket-Accept")
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// 握手完成，取消超时
+	_ = conn.SetDeadline(time.Time{})
+	return newWsConn(conn, r, true), nil
+}
+
+// dialTunnelTcp 建立tcp（或tls）连接，timeout不为0时同时设置连接的读写超时，握手完成后由调用方取消
+func dialTunnelTcp(urlCtx base.UrlContext, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	switch strings.ToLower(urlCtx.Scheme) {
+	case "https", "wss":
+		conn, err = tls.DialWithDialer(dialer, "tcp", urlCtx.HostWithPort, &tls.Config{ServerName: urlCtx.Host})
+	default:
+		conn, err = dialer.Dial("tcp", urlCtx.HostWithPort)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
+	return conn, nil
+}
+
+func readTunnelResponse(r *bufio.Reader, expectedCode string) error {
+	firstLine, _, err := nazahttp.ReadHttpHeader(r)
+	if err != nil {
+		return err
+	}
+	_, code, _, err := nazahttp.ParseHttpStatusLine(firstLine)
+	if err != nil {
+		return err
+	}
+	if code != expectedCode {
+		return nazaerrors.Wrap(base.ErrRtsp, firstLine)
+	}
+	return nil
+}
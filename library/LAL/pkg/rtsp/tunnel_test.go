@@ -0,0 +1,153 @@
+package rtsp_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/rtprtcp"
+	"live-server/library/LAL/pkg/rtsp"
+	"live-server/library/LAL/pkg/sdp"
+
+	"live-server/library/naza/pkg/assert"
+)
+
+type testServerObserver struct {
+	playChan chan *rtsp.SubSession
+}
+
+func (o *testServerObserver) OnNewRtspSessionConnect(session *rtsp.ServerCommandSession) {
+}
+
+func (o *testServerObserver) OnDelRtspSession(session *rtsp.ServerCommandSession) {
+}
+
+func (o *testServerObserver) OnNewRtspPubSession(session *rtsp.PubSession) error {
+	return nil
+}
+
+func (o *testServerObserver) OnDelRtspPubSession(session *rtsp.PubSession) {
+}
+
+func (o *testServerObserver) OnNewRtspSubSessionDescribe(session *rtsp.SubSession) (ok bool, sdp []byte) {
+	return true, []byte(testSdp)
+}
+
+func (o *testServerObserver) OnNewRtspSubSessionPlay(session *rtsp.SubSession) error {
+	o.playChan <- session
+	return nil
+}
+
+func (o *testServerObserver) OnDelRtspSubSession(session *rtsp.SubSession) {
+}
+
+type testPullSessionObserver struct {
+	rtpChan chan rtprtcp.RtpPacket
+}
+
+func (o *testPullSessionObserver) OnSdp(sdpCtx sdp.LogicContext) {
+}
+
+func (o *testPullSessionObserver) OnRtpPacket(pkt rtprtcp.RtpPacket) {
+	o.rtpChan <- pkt
+}
+
+func (o *testPullSessionObserver) OnAvPacket(pkt base.AvPacket) {
+}
+
+func TestServer_ServeHttp(t *testing.T) {
+	observer := &testServerObserver{playChan: make(chan *rtsp.SubSession, 1)}
+	server := rtsp.NewServer("", observer, rtsp.ServerAuthConfig{})
+	httpServer := httptest.NewServer(http.HandlerFunc(server.ServeHttp))
+	defer httpServer.Close()
+
+	// 依次测试http隧道和websocket
+	for _, scheme := range []string{"http", "ws"} {
+		tunnelUrl := strings.Replace(httpServer.URL, "http", scheme, 1) + "/rtsp/live/test110"
+		pullObserver := &testPullSessionObserver{rtpChan: make(chan rtprtcp.RtpPacket, 8)}
+		pullSession := rtsp.NewPullSession(pullObserver, func(option *rtsp.PullSessionOption) {
+			option.TunnelUrl = tunnelUrl
+		})
+		err := pullSession.Pull("rtsp://127.0.0.1/live/test110")
+		assert.Equal(t, nil, err, scheme)
+
+		subSession := <-observer.playChan
+		h := rtprtcp.MakeDefaultRtpHeader()
+		h.PacketType = 96
+		h.Seq = 1000
+		h.Ssrc = 0x11223344
+		subSession.WriteRtpPacket(rtprtcp.MakeRtpPacket(h, []byte{0x41, 0x9a, 0x00, 0x01}))
+
+		select {
+		case pkt := <-pullObserver.rtpChan:
+			assert.Equal(t, uint16(1000), pkt.Header.Seq, scheme)
+			assert.Equal(t, uint32(0x11223344), pkt.Header.Ssrc, scheme)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wait rtp packet timeout. scheme=%s", scheme)
+		}
+
+		_ = pullSession.Dispose()
+	}
+
+	// 没有对应GET请求的POST请求
+	req, err := http.NewRequest(http.MethodPost, httpServer.URL+"/rtsp/", strings.NewReader("T1BUSU9OUw=="))
+	assert.Equal(t, nil, err)
+	req.Header.Set(rtsp.HeaderSessionCookie, "notexist")
+	_, err = http.DefaultClient.Do(req)
+	assert.Equal(t, true, err != nil)
+
+	resp, err := http.Get(httpServer.URL + "/rtsp/")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_ = resp.Body.Close()
+
+	// websocket的子协议必须是rtsp
+	for protocol, code := range map[string]int{"chat": http.StatusBadRequest, "chat, rtsp": http.StatusSwitchingProtocols} {
+		req, err = http.NewRequest(http.MethodGet, httpServer.URL+"/rtsp/live/test110", nil)
+		assert.Equal(t, nil, err)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Protocol", protocol)
+		resp, err = http.DefaultClient.Do(req)
+		assert.Equal(t, nil, err, protocol)
+		assert.Equal(t, code, resp.StatusCode, protocol)
+		if code == http.StatusSwitchingProtocols {
+			assert.Equal(t, rtsp.WebSocketProtocolRtsp, resp.Header.Get("Sec-WebSocket-Protocol"))
+		}
+		_ = resp.Body.Close()
+	}
+}
+
+func TestPullSession_TunnelTimeout(t *testing.T) {
+	// 服务端接受连接后不回复
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer ln.Close()
+	connChan := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			connChan <- conn
+		}
+	}()
+
+	pullSession := rtsp.NewPullSession(&testPullSessionObserver{}, func(option *rtsp.PullSessionOption) {
+		option.PullTimeoutMs = 200
+		option.TunnelUrl = "ws://" + ln.Addr().String() + "/rtsp/live/test110"
+	})
+	assert.IsNotNil(t, pullSession.Pull("rtsp://127.0.0.1/live/test110"))
+
+	// 握手超时后客户端关闭连接，而不是一直阻塞
+	conn := <-connChan
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = io.ReadAll(conn)
+	assert.Equal(t, nil, err)
+}
@@ -15,3 +15,8 @@ var Log = nazalog.GetGlobalLogger()
 // BaseInSessionTimestampFilterFlag 控制输入 BaseInSession 的音视频数据是否开启时间戳过滤器，也即经过 AvPacketQueue 处理
 var BaseInSessionTimestampFilterFlag = true
 var TimestampFilterHandleRotateFlag = true
+
+// ServerSessionTimeoutSec 服务端在SETUP信令的回复中通告给客户端的session超时时间，单位秒
+//
+// 客户端需要在超时时间内发送GET_PARAMETER等保活信令，暂停状态下的拉流session超过这个时间没有收到信令会被关闭
+var ServerSessionTimeoutSec = 60
@@ -0,0 +1,217 @@
+package udpts
+
+import (
+	"net"
+	"sync"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/remux"
+	"live-server/library/LAL/pkg/rtmp"
+	"live-server/library/LAL/pkg/rtprtcp"
+	"live-server/library/naza/pkg/nazanet"
+)
+
+// PubSession 接收udp单播或组播的mpegts流，转换成rtmp消息
+//
+// 每个udp包中可以是若干个裸的ts packet，也可以是rtp封装的ts（RFC 2250，payload type通常为33），逐包自动识别
+type PubSession struct {
+	option     PubSessionOption
+	streamName string
+	onRtmpMsg  rtmp.OnReadRtmpAvMsg
+
+	remuxer *remux.Mpegts2RtmpRemuxer
+
+	disposeOnce sync.Once
+	conn        *net.UDPConn
+	udpConn     *nazanet.UdpConnection
+	sessionStat base.BasicSessionStat
+
+	rtpPacketNum uint64 // rtp封装的包的数量，用于日志
+}
+
+type PubSessionOption struct {
+	// Addr 监听地址，比如 ":1234" 接收单播，"239.1.1.1:1234" 加入组播组并接收组播
+	Addr string
+
+	// Interface 加入组播组时使用的网卡名，比如 "eth0"，为空时由系统选择。单播时无效
+	Interface string
+}
+
+type ModPubSessionOption func(option *PubSessionOption)
+
+func NewPubSession(modOptions ...ModPubSessionOption) *PubSession {
+	var option PubSessionOption
+	for _, fn := range modOptions {
+		fn(&option)
+	}
+	s := &PubSession{
+		option:      option,
+		sessionStat: base.NewBasicSessionStat(base.SessionTypeTsPub, ""),
+	}
+	s.remuxer = remux.NewMpegts2RtmpRemuxer(s.onRtmpMsgFromRemux)
+	return s
+}
+
+func (session *PubSession) WithStreamName(streamName string) *PubSession {
+	session.streamName = streamName
+	return session
+}
+
+// WithOnRtmpMsg 设置转换后的rtmp消息的回调，回调在 RunLoop 的协程中执行
+func (session *PubSession) WithOnRtmpMsg(onRtmpMsg rtmp.OnReadRtmpAvMsg) *PubSession {
+	session.onRtmpMsg = onRtmpMsg
+	return session
+}
+
+// Listen 非阻塞函数，打开udp端口，组播地址时加入组播组
+func (session *PubSession) Listen() error {
+	uaddr, err := net.ResolveUDPAddr("udp4", session.option.Addr)
+	if err != nil {
+		return err
+	}
+
+	var conn *net.UDPConn
+	if uaddr.IP != nil && uaddr.IP.IsMulticast() {
+		ifi, err := base.GetMulticastInterface(session.option.Interface)
+		if err != nil {
+			return err
+		}
+		conn, err = net.ListenMulticastUDP("udp4", ifi, uaddr)
+		if err != nil {
+			return err
+		}
+		Log.Infof("[%s] join multicast group. addr=%s, interface=%s", session.UniqueKey(), uaddr.String(), session.option.Interface)
+	} else {
+		if conn, err = net.ListenUDP("udp4", uaddr); err != nil {
+			return err
+		}
+	}
+	if err = conn.SetReadBuffer(readBufSize); err != nil {
+		Log.Warnf("[%s] set read buffer failed. err=%+v", session.UniqueKey(), err)
+	}
+	session.sessionStat.SetRemoteAddr(session.option.Addr)
+	session.conn = conn
+
+	session.udpConn, err = nazanet.NewUdpConnection(func(option *nazanet.UdpConnectionOption) {
+		option.Conn = conn
+		option.MaxReadPacketSize = readPacketSize
+	})
+	return err
+}
+
+// RunLoop 阻塞函数
+func (session *PubSession) RunLoop() error {
+	if session.udpConn == nil {
+		return base.ErrSessionNotStarted
+	}
+	return session.udpConn.RunLoop(func(b []byte, raddr *net.UDPAddr, err error) bool {
+		if len(b) == 0 && err != nil {
+			return false
+		}
+		session.feedPacket(b)
+		return true
+	})
+}
+
+// LocalAddr Listen 成功后有效，比如 Addr 端口为0时，可以通过它获取系统分配的端口
+func (session *PubSession) LocalAddr() string {
+	if session.conn == nil {
+		return ""
+	}
+	return session.conn.LocalAddr().String()
+}
+
+// ----- IServerSessionLifecycle ---------------------------------------------------------------------------------------
+
+func (session *PubSession) Dispose() error {
+	var retErr error
+	session.disposeOnce.Do(func() {
+		Log.Infof("[%s] lifecycle dispose udpts PubSession. addr=%s", session.UniqueKey(), session.option.Addr)
+		if session.udpConn == nil {
+			retErr = base.ErrSessionNotStarted
+			return
+		}
+		retErr = session.udpConn.Dispose()
+	})
+	return retErr
+}
+
+// ----- ISessionUrlContext --------------------------------------------------------------------------------------------
+
+func (session *PubSession) Url() string {
+	return "udp://" + session.option.Addr
+}
+
+func (session *PubSession) AppName() string {
+	Log.Warnf("[%s] PubSession.AppName() is not implemented", session.UniqueKey())
+	return "invalid"
+}
+
+func (session *PubSession) StreamName() string {
+	// 如果stream name没有设置，则使用session的unique key作为stream name
+	if session.streamName == "" {
+		return session.UniqueKey()
+	}
+	return session.streamName
+}
+
+func (session *PubSession) RawQuery() string {
+	Log.Warnf("[%s] PubSession.RawQuery() is not implemented", session.UniqueKey())
+	return "invalid"
+}
+
+// ----- IObject -------------------------------------------------------------------------------------------------------
+
+func (session *PubSession) UniqueKey() string {
+	return session.sessionStat.UniqueKey()
+}
+
+// ----- ISessionStat --------------------------------------------------------------------------------------------------
+
+func (session *PubSession) UpdateStat(intervalSec uint32) {
+	session.sessionStat.UpdateStat(intervalSec)
+}
+
+func (session *PubSession) GetStat() base.StatSession {
+	return session.sessionStat.GetStat()
+}
+
+func (session *PubSession) IsAlive() (readAlive, writeAlive bool) {
+	return session.sessionStat.IsAlive()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (session *PubSession) feedPacket(b []byte) {
+	session.sessionStat.AddReadBytes(len(b))
+
+	// 空包以及长度不足的包直接丢弃，避免后续越界访问
+	if len(b) < rtprtcp.RtpFixedHeaderLength {
+		Log.Warnf("[%s] invalid packet, too short. len=%d", session.UniqueKey(), len(b))
+		return
+	}
+	if b[0] == 0x47 && len(b) < 188 {
+		Log.Warnf("[%s] invalid ts packet, too short. len=%d", session.UniqueKey(), len(b))
+		return
+	}
+
+	if b[0] != 0x47 {
+		pkt, err := rtprtcp.ParseRtpPacket(b)
+		if err != nil || pkt.Header.Version != rtprtcp.DefaultRtpVersion {
+			Log.Warnf("[%s] invalid packet, neither ts nor rtp. len=%d", session.UniqueKey(), len(b))
+			return
+		}
+		if session.rtpPacketNum == 0 {
+			Log.Infof("[%s] recv rtp packet. payload type=%d", session.UniqueKey(), pkt.Header.PacketType)
+		}
+		session.rtpPacketNum++
+		b = pkt.Body()
+	}
+	session.remuxer.Feed(b)
+}
+
+func (session *PubSession) onRtmpMsgFromRemux(msg base.RtmpMsg) {
+	if session.onRtmpMsg != nil {
+		session.onRtmpMsg(msg)
+	}
+}
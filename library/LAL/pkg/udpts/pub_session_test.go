@@ -0,0 +1,125 @@
+package udpts_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/mpegts"
+	"live-server/library/LAL/pkg/udpts"
+	"live-server/library/naza/pkg/assert"
+)
+
+// makeTs 生成包含n个视频帧的mpegts流，帧间隔40毫秒，第一帧为关键帧
+func makeTs(n int) []byte {
+	sps, _ := hex.DecodeString("67640032ad84010c20086100430802184010c200843b5014005ad370101014000003000400000300ca100002")
+	pps, _ := hex.DecodeString("68ee3cb0")
+
+	var ts []byte
+	ts = append(ts, mpegts.FixedFragmentHeader...)
+	var cc uint8
+	for i := 0; i < n; i++ {
+		var raw []byte
+		if i == 0 {
+			raw = append(raw, 0, 0, 0, 1)
+			raw = append(raw, sps...)
+			raw = append(raw, 0, 0, 0, 1)
+			raw = append(raw, pps...)
+			raw = append(raw, 0, 0, 0, 1, 0x65)
+		} else {
+			raw = append(raw, 0, 0, 0, 1, 0x41)
+		}
+		raw = append(raw, bytes.Repeat([]byte{0xaa}, 300)...)
+		frame := mpegts.Frame{
+			Pts: uint64(i) * 3600,
+			Dts: uint64(i) * 3600,
+			Cc:  cc,
+			Pid: mpegts.PidVideo,
+			Sid: mpegts.StreamIdVideo,
+			Key: i == 0,
+			Raw: raw,
+		}
+		ts = append(ts, frame.Pack()...)
+		cc = frame.Cc
+	}
+	return ts
+}
+
+func TestPubSession(t *testing.T) {
+	ts := makeTs(4)
+
+	// 裸的ts，以及rtp封装的ts
+	for _, isRtp := range []bool{false, true} {
+		var mutex sync.Mutex
+		var videoTs []uint32
+		var hasSeqHeader bool
+		session := udpts.NewPubSession(func(option *udpts.PubSessionOption) {
+			option.Addr = "127.0.0.1:0"
+		}).WithStreamName("test110").WithOnRtmpMsg(func(msg base.RtmpMsg) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if msg.IsAvcKeySeqHeader() {
+				hasSeqHeader = true
+			} else if msg.Header.MsgTypeId == base.RtmpTypeIdVideo {
+				videoTs = append(videoTs, msg.Header.TimestampAbs)
+			}
+		})
+		err := session.Listen()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "test110", session.StreamName())
+		go session.RunLoop()
+
+		raddr, _ := net.ResolveUDPAddr("udp4", session.LocalAddr())
+		conn, err := net.DialUDP("udp4", nil, raddr)
+		assert.Equal(t, nil, err)
+
+		// 空包以及长度不足的包不影响后续的处理
+		for _, b := range [][]byte{{}, {0x47}, ts[:100]} {
+			_, err = conn.Write(b)
+			assert.Equal(t, nil, err)
+		}
+
+		var seq uint16
+		for b := ts; len(b) > 0; {
+			n := 7 * 188
+			if n > len(b) {
+				n = len(b)
+			}
+			datagram := b[:n]
+			if isRtp {
+				h := []byte{0x80, 33, byte(seq >> 8), byte(seq), 0, 0, 0, 0, 0, 0, 0, 1}
+				datagram = append(h, datagram...)
+				seq++
+			}
+			_, err = conn.Write(datagram)
+			assert.Equal(t, nil, err)
+			b = b[n:]
+		}
+		_ = conn.Close()
+
+		for i := 0; i < 100; i++ {
+			mutex.Lock()
+			num := len(videoTs)
+			mutex.Unlock()
+			if num >= 4 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		_ = session.Dispose()
+
+		mutex.Lock()
+		assert.Equal(t, true, hasSeqHeader)
+		assert.Equal(t, 4, len(videoTs))
+		for i := 1; i < len(videoTs); i++ {
+			assert.Equal(t, uint32(40), videoTs[i]-videoTs[i-1])
+		}
+		mutex.Unlock()
+		assert.Equal(t, base.SessionProtocolTsStr, session.GetStat().Protocol)
+		assert.Equal(t, base.SessionBaseTypePubStr, session.GetStat().BaseType)
+	}
+}
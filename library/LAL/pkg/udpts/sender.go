@@ -0,0 +1,293 @@
+package udpts
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"live-server/library/LAL/pkg/base"
+	"live-server/library/LAL/pkg/mpegts"
+	"live-server/library/LAL/pkg/rtprtcp"
+)
+
+// sender.go
+//
+// 将mpegts流通过udp发送给单播或组播地址，比如只支持udp ts输入的解码器、播出设备
+//
+// - 每个udp包包含 SenderOption.PacketNumPerDatagram 个ts packet（默认7个，即1316字节），可选rtp封装
+// - 按照帧的dts平滑发送，一帧的多个udp包均匀分布在上一帧与这一帧的发送时间之间，避免突发流量导致接收端丢包
+// - 从第一个关键帧开始发送，并且每个关键帧前插入PAT和PMT，使得接收端可以随时加入
+// - 上层调用 FeedTsPackets 不阻塞，发送队列满时丢弃数据，并等待下一个关键帧重新开始
+//
+
+const rtpPayloadTypeMp2t = 33
+
+type SenderOption struct {
+	// Addr 目标地址，比如 "192.168.1.100:1234" 或者组播地址 "239.1.1.1:1234"
+	Addr string
+
+	// Interface 发送组播时使用的网卡名，为空时由系统根据路由选择。单播时无效
+	Interface string
+
+	// Ttl 组播的TTL，小于等于0时使用系统默认值。单播时无效
+	Ttl int
+
+	// RtpEnable 是否使用rtp封装（RFC 2250）
+	RtpEnable bool
+
+	PacketNumPerDatagram int // 每个udp包中的ts packet数量
+
+	// DelayMs 发送时间相对于dts的延迟，用于吸收输入的抖动，dts交错时也能保证发送时间递增
+	DelayMs int
+
+	QueueSize int // 发送队列的长度，单位为帧
+}
+
+var defaultSenderOption = SenderOption{
+	PacketNumPerDatagram: 7,
+	DelayMs:              200,
+	QueueSize:            1024,
+}
+
+type ModSenderOption func(option *SenderOption)
+
+type SenderStat struct {
+	WroteBytes uint64 // 发送的字节数，包含rtp头
+	DropNum    uint64 // 因为队列满而丢弃的帧数
+}
+
+type Sender struct {
+	uniqueKey string
+	option    SenderOption
+
+	conn     *net.UDPConn
+	itemChan chan senderItem
+	exitChan chan struct{}
+	exitOnce sync.Once
+
+	// 以下字段只在上层调用的协程中访问（lalserver中是在group的锁内）
+	patpmt             []byte
+	shouldWaitBoundary bool
+
+	// 以下字段只在发送协程中访问
+	hasBase    bool
+	baseTime   time.Time // 基准时间戳对应的发送时间
+	baseTs     int64     // 基准时间戳，单位毫秒
+	prevTs     int64
+	prevSendAt time.Time
+	rtpSeq     uint16
+	rtpSsrc    uint32
+
+	statMutex sync.Mutex
+	stat      SenderStat
+}
+
+type senderItem struct {
+	tsPackets []byte
+	dts       uint64 // 单位1/90000秒
+}
+
+// NewSender
+//
+// @param uniqueKey: 用于日志
+func NewSender(uniqueKey string, modOptions ...ModSenderOption) *Sender {
+	option := defaultSenderOption
+	for _, fn := range modOptions {
+		fn(&option)
+	}
+	if option.PacketNumPerDatagram <= 0 {
+		option.PacketNumPerDatagram = defaultSenderOption.PacketNumPerDatagram
+	}
+	if option.QueueSize <= 0 {
+		option.QueueSize = defaultSenderOption.QueueSize
+	}
+	return &Sender{
+		uniqueKey:          uniqueKey,
+		option:             option,
+		itemChan:           make(chan senderItem, option.QueueSize),
+		exitChan:           make(chan struct{}),
+		shouldWaitBoundary: true,
+		rtpSsrc:            rand.Uint32(),
+	}
+}
+
+// Start 非阻塞函数，创建socket并开启发送协程
+func (s *Sender) Start() error {
+	raddr, err := net.ResolveUDPAddr("udp4", s.option.Addr)
+	if err != nil {
+		return err
+	}
+	s.conn, err = net.DialUDP("udp4", nil, raddr)
+	if err != nil {
+		return err
+	}
+	if raddr.IP.IsMulticast() {
+		ifi, err := base.GetMulticastInterface(s.option.Interface)
+		if err == nil {
+			err = base.SetMulticastSendOption(s.conn, ifi, s.option.Ttl)
+		}
+		if err != nil {
+			_ = s.conn.Close()
+			return err
+		}
+	}
+
+	Log.Infof("[%s] start udp ts sender. option=%+v", s.uniqueKey, s.option)
+	go s.runLoop()
+	return nil
+}
+
+// FeedPatPmt FeedTsPackets
+//
+// 输入来自 remux.Rtmp2MpegtsRemuxer 的回调，参数含义见 remux.IRtmp2MpegtsRemuxerObserver 。
+// 函数调用结束后，内部不持有参数的内存块
+func (s *Sender) FeedPatPmt(b []byte) {
+	s.patpmt = append(s.patpmt[:0], b...)
+}
+
+func (s *Sender) FeedTsPackets(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
+	if s.shouldWaitBoundary {
+		if !boundary || s.patpmt == nil {
+			return
+		}
+		s.shouldWaitBoundary = false
+	}
+
+	var b []byte
+	if boundary {
+		b = make([]byte, 0, len(s.patpmt)+len(tsPackets))
+		b = append(b, s.patpmt...)
+	} else {
+		b = make([]byte, 0, len(tsPackets))
+	}
+	b = append(b, tsPackets...)
+
+	select {
+	case s.itemChan <- senderItem{tsPackets: b, dts: frame.Dts}:
+	default:
+		s.statMutex.Lock()
+		s.stat.DropNum++
+		s.statMutex.Unlock()
+		s.shouldWaitBoundary = true
+		Log.Warnf("[%s] udp ts sender queue full, drop until next key frame.", s.uniqueKey)
+	}
+}
+
+func (s *Sender) Stat() SenderStat {
+	s.statMutex.Lock()
+	defer s.statMutex.Unlock()
+	return s.stat
+}
+
+func (s *Sender) Addr() string {
+	return s.option.Addr
+}
+
+func (s *Sender) Dispose() {
+	s.exitOnce.Do(func() {
+		Log.Infof("[%s] dispose udp ts sender. addr=%s", s.uniqueKey, s.option.Addr)
+		close(s.exitChan)
+		if s.conn != nil {
+			_ = s.conn.Close()
+		}
+	})
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (s *Sender) runLoop() {
+	for {
+		select {
+		case <-s.exitChan:
+			return
+		case item := <-s.itemChan:
+			if !s.send(item) {
+				return
+			}
+		}
+	}
+}
+
+// send 发送一帧的数据
+//
+// @return 是否继续发送
+func (s *Sender) send(item senderItem) bool {
+	sendAt := s.calcSendTime(int64(item.dts / 90))
+	prevSendAt := s.prevSendAt
+	if prevSendAt.IsZero() || prevSendAt.After(sendAt) {
+		prevSendAt = sendAt
+	}
+	s.prevSendAt = sendAt
+
+	size := s.option.PacketNumPerDatagram * 188
+	num := (len(item.tsPackets) + size - 1) / size
+	for i := 0; i < num; i++ {
+		// 第i个udp包在上一帧与这一帧的发送时间之间均匀分布
+		at := prevSendAt.Add(sendAt.Sub(prevSendAt) * time.Duration(i+1) / time.Duration(num))
+		if wait := time.Until(at); wait > time.Millisecond {
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-s.exitChan:
+				t.Stop()
+				return false
+			}
+		}
+
+		end := (i + 1) * size
+		if end > len(item.tsPackets) {
+			end = len(item.tsPackets)
+		}
+		b := s.pack(item.tsPackets[i*size:end], uint32(item.dts))
+		if _, err := s.conn.Write(b); err != nil {
+			// udp发送失败通常是暂时的，比如目标不可达，不关闭sender
+			Log.Debugf("[%s] udp ts sender write failed. err=%+v", s.uniqueKey, err)
+			continue
+		}
+		s.statMutex.Lock()
+		s.stat.WroteBytes += uint64(len(b))
+		s.statMutex.Unlock()
+	}
+	return true
+}
+
+// calcSendTime 将时间戳映射为发送时间
+//
+// 时间戳回退时使用上一帧的时间戳，跳跃超过1秒或者发送落后超过1秒时重新设置基准
+func (s *Sender) calcSendTime(ts int64) time.Time {
+	now := time.Now()
+	if s.hasBase {
+		if ts < s.prevTs {
+			ts = s.prevTs
+		}
+		if ts-s.prevTs > 1000 {
+			Log.Warnf("[%s] udp ts sender timestamp jump. prev=%d, curr=%d", s.uniqueKey, s.prevTs, ts)
+			s.hasBase = false
+		} else if now.Sub(s.baseTime.Add(time.Duration(ts-s.baseTs)*time.Millisecond)) > time.Second {
+			Log.Warnf("[%s] udp ts sender too slow, reset. ts=%d", s.uniqueKey, ts)
+			s.hasBase = false
+		}
+	}
+	if !s.hasBase {
+		s.hasBase = true
+		s.baseTime = now.Add(time.Duration(s.option.DelayMs) * time.Millisecond)
+		s.baseTs = ts
+		s.prevSendAt = time.Time{}
+	}
+	s.prevTs = ts
+	return s.baseTime.Add(time.Duration(ts-s.baseTs) * time.Millisecond)
+}
+
+func (s *Sender) pack(tsPackets []byte, timestamp uint32) []byte {
+	if !s.option.RtpEnable {
+		return tsPackets
+	}
+	h := rtprtcp.MakeDefaultRtpHeader()
+	h.PacketType = rtpPayloadTypeMp2t
+	h.Seq = s.rtpSeq
+	h.Timestamp = timestamp
+	h.Ssrc = s.rtpSsrc
+	s.rtpSeq++
+	return rtprtcp.MakeRtpPacket(h, tsPackets).Raw
+}
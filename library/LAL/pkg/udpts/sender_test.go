@@ -0,0 +1,77 @@
+package udpts_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"live-server/library/LAL/pkg/mpegts"
+	"live-server/library/LAL/pkg/udpts"
+	"live-server/library/naza/pkg/assert"
+	"live-server/library/naza/pkg/bele"
+)
+
+func makeTsPackets(n int, flag byte) []byte {
+	b := make([]byte, n*188)
+	for i := 0; i < n; i++ {
+		b[i*188] = 0x47
+		b[i*188+1] = flag
+	}
+	return b
+}
+
+func TestSender(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+
+	sender := udpts.NewSender("test", func(option *udpts.SenderOption) {
+		option.Addr = conn.LocalAddr().String()
+		option.RtpEnable = true
+		option.DelayMs = 0
+	})
+	err = sender.Start()
+	assert.Equal(t, nil, err)
+	defer sender.Dispose()
+
+	sender.FeedPatPmt(makeTsPackets(2, 0))
+	// 第一个关键帧之前的数据被丢弃
+	sender.FeedTsPackets(makeTsPackets(14, 1), &mpegts.Frame{Dts: 0}, false)
+	// 关键帧前插入PAT和PMT，共16个ts packet，分成3个udp包
+	sender.FeedTsPackets(makeTsPackets(14, 2), &mpegts.Frame{Dts: 90000}, true)
+	// 间隔100毫秒的两帧，各2个udp包
+	sender.FeedTsPackets(makeTsPackets(14, 3), &mpegts.Frame{Dts: 99000}, false)
+	sender.FeedTsPackets(makeTsPackets(14, 4), &mpegts.Frame{Dts: 108000}, false)
+
+	var sizes []int
+	var flags []byte
+	var times []time.Time
+	var firstSeq uint16
+	buf := make([]byte, 2048)
+	for i := 0; i < 7; i++ {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFromUDP(buf)
+		assert.Equal(t, nil, err)
+		// rtp头
+		assert.Equal(t, byte(0x80), buf[0])
+		assert.Equal(t, byte(33), buf[1])
+		if i == 0 {
+			firstSeq = bele.BeUint16(buf[2:])
+		}
+		assert.Equal(t, firstSeq+uint16(i), bele.BeUint16(buf[2:]))
+		sizes = append(sizes, (n-12)/188)
+		flags = append(flags, buf[12+1])
+		times = append(times, time.Now())
+	}
+	assert.Equal(t, []int{7, 7, 2, 7, 7, 7, 7}, sizes)
+	assert.Equal(t, []byte{0, 2, 2, 3, 3, 4, 4}, flags)
+
+	// 按时间戳平滑发送
+	elapsed := times[6].Sub(times[0])
+	assert.Equal(t, true, elapsed >= 180*time.Millisecond, elapsed.String())
+	assert.Equal(t, true, elapsed < time.Second, elapsed.String())
+	assert.Equal(t, true, times[4].Sub(times[2]) >= 40*time.Millisecond)
+
+	assert.Equal(t, uint64(7*12+44*188), sender.Stat().WroteBytes)
+	assert.Equal(t, uint64(0), sender.Stat().DropNum)
+}
@@ -0,0 +1,10 @@
+package udpts
+
+import "live-server/library/naza/pkg/nazalog"
+
+var Log = nazalog.GetGlobalLogger()
+
+var (
+	readPacketSize = 65507   // PubSession单个udp包的最大长度
+	readBufSize    = 4 << 20 // PubSession的socket接收缓冲区大小，mpegts码率较高时避免丢包
+)
@@ -11,6 +11,7 @@ package filesystemlayer
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 type FslDisk struct {
@@ -47,3 +48,23 @@ func (f *FslDisk) ReadFile(filename string) ([]byte, error) {
 func (f *FslDisk) WriteFile(filename string, data []byte, perm uint32) error {
 	return ioutil.WriteFile(filename, data, os.FileMode(perm))
 }
+
+func (f *FslDisk) Walk(root string, fn WalkFunc) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// 遍历过程中文件被删除等情况，忽略
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return fn(FileInfo{
+			Name:    path,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	})
+}
@@ -11,6 +11,7 @@ package filesystemlayer_test
 import (
 	"bytes"
 	"fmt"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"testing"
@@ -145,3 +146,56 @@ func TestFslMemory(t *testing.T) {
 	runtime.ReadMemStats(&m)
 	nazalog.Debugf("%+v", m)
 }
+
+func TestWalk(t *testing.T) {
+	dir := t.TempDir()
+	for _, typ := range []filesystemlayer.FslType{filesystemlayer.FslTypeDisk, filesystemlayer.FslTypeMemory} {
+		fslCtx := filesystemlayer.FslFactory(typ)
+		root := filepath.Join(dir, fmt.Sprintf("walk%d", typ))
+		assert.Equal(t, nil, fslCtx.MkdirAll(filepath.Join(root, "sub"), 0777))
+		assert.Equal(t, nil, fslCtx.WriteFile(filepath.Join(root, "1.ts"), []byte("hello"), 0666))
+		assert.Equal(t, nil, fslCtx.WriteFile(filepath.Join(root, "sub", "2.ts"), []byte("helloworld"), 0666))
+		assert.Equal(t, nil, fslCtx.WriteFile(root+"_other.ts", []byte("x"), 0666))
+
+		sizes := make(map[string]int64)
+		err := fslCtx.Walk(root, func(info filesystemlayer.FileInfo) error {
+			assert.Equal(t, false, info.ModTime.IsZero())
+			sizes[info.Name] = info.Size
+			return nil
+		})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, map[string]int64{
+			filepath.Join(root, "1.ts"):        5,
+			filepath.Join(root, "sub", "2.ts"): 10,
+		}, sizes)
+	}
+}
+
+// TestWalkWhileWrite 写文件的同时Walk，需要使用-race运行
+func TestWalkWhileWrite(t *testing.T) {
+	fslCtx := filesystemlayer.FslFactory(filesystemlayer.FslTypeMemory)
+	fp, err := fslCtx.Create("/tmp/lal/walk/1.ts")
+	assert.Equal(t, nil, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			_, _ = fp.Write([]byte("hello"))
+			if i%100 == 0 {
+				_, _ = fslCtx.Create("/tmp/lal/walk/1.ts")
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		err = fslCtx.Walk("/tmp/lal/walk", func(info filesystemlayer.FileInfo) error {
+			return nil
+		})
+		assert.Equal(t, nil, err)
+	}
+}
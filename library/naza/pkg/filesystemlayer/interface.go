@@ -8,6 +8,8 @@
 
 package filesystemlayer
 
+import "time"
+
 // 注意，这个package并没有完整实现所有的文件操作，使用内存作为存储时，存在一些限制
 // 目前只是服务于我另一个项目中的特定场景 https://github.com/q191201771/lal
 
@@ -30,8 +32,21 @@ type IFileSystemLayer interface {
 
 	ReadFile(filename string) ([]byte, error)
 	WriteFile(filename string, data []byte, perm uint32) error
+
+	// Walk 遍历root目录（包含子目录）下的所有文件，不包含目录本身，遍历的顺序不保证
+	//
+	// fn返回错误时，停止遍历，并将该错误返回
+	Walk(root string, fn WalkFunc) error
 }
 
+type FileInfo struct {
+	Name    string // 文件名，包含root路径
+	Size    int64
+	ModTime time.Time
+}
+
+type WalkFunc func(info FileInfo) error
+
 type IFile interface {
 	Write(b []byte) (n int, err error)
 	Close() error
@@ -12,8 +12,10 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 var ErrNotFound = errors.New("naza filesystemlayer: not found")
@@ -24,7 +26,9 @@ type FslMemory struct {
 }
 
 type file struct {
-	buf []byte
+	mu      sync.Mutex // 文件的写入和 FslMemory.Walk 可能在不同的协程中
+	buf     []byte
+	modTime time.Time
 }
 
 func NewFslMemory() *FslMemory {
@@ -107,6 +111,31 @@ func (f *FslMemory) WriteFile(filename string, data []byte, perm uint32) error {
 	return err
 }
 
+func (f *FslMemory) Walk(root string, fn WalkFunc) error {
+	// 注意，root和文件名都清理成统一的格式后再比较，比如"./a/"和"a/b.ts"
+	prefix := filepath.Clean(root)
+	if !os.IsPathSeparator(prefix[len(prefix)-1]) {
+		prefix = fmt.Sprintf("%s%c", prefix, os.PathSeparator)
+	}
+
+	// 先拷贝一份，避免fn中对文件进行操作时死锁
+	f.mu.Lock()
+	infos := make([]FileInfo, 0, len(f.files))
+	for k, v := range f.files {
+		if strings.HasPrefix(filepath.Clean(k), prefix) {
+			infos = append(infos, v.info(k))
+		}
+	}
+	f.mu.Unlock()
+
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (f *FslMemory) openFile(name string, flag int, perm uint32) (IFile, error) {
@@ -114,7 +143,7 @@ func (f *FslMemory) openFile(name string, flag int, perm uint32) (IFile, error)
 	defer f.mu.Unlock()
 	fi, ok := f.files[name]
 	if !ok {
-		fi = &file{}
+		fi = &file{modTime: time.Now()}
 		f.files[name] = fi
 		return fi, nil
 	}
@@ -126,7 +155,10 @@ func (f *FslMemory) openFile(name string, flag int, perm uint32) (IFile, error)
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (f *file) Write(b []byte) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.buf = append(f.buf, b...)
+	f.modTime = time.Now()
 	return len(b), nil
 }
 
@@ -135,10 +167,25 @@ func (f *file) Close() error {
 }
 
 func (f *file) truncate() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.buf = nil
+	f.modTime = time.Now()
+}
+
+func (f *file) info(name string) FileInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return FileInfo{
+		Name:    name,
+		Size:    int64(len(f.buf)),
+		ModTime: f.modTime,
+	}
 }
 
 func (f *file) clone() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if f.buf == nil {
 		return nil
 	}
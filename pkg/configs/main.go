@@ -17,6 +17,6 @@ var (
 func InitConfig(confPath string, conf *embed.FS) {
 	// 初始化配置目录
 	utils.MkDir(ModuleConfigPath)
-	// 将配置文件写入到本地
-	utils.DeepWalk(confPath, conf)
+	// 将配置文件写入到本地，已经存在的文件不覆盖，保留用户的修改
+	utils.DeepWalkKeepExist(confPath, conf)
 }
@@ -20,9 +20,18 @@ type FoldersData struct {
 	Folders []*FolderData
 }
 
-// deepWalk 深度遍历 将embed.FS中的文件夹和文件写入到本地
+// DeepWalk 深度遍历 将embed.FS中的文件夹和文件写入到本地
 // @param path string 遍历的路径:此路径一定要和`go:embed all:path` 对应
 func DeepWalk(path string, assets *embed.FS) (FoldersData, error) {
+	return deepWalk(path, assets, true)
+}
+
+// DeepWalkKeepExist 同 DeepWalk，但是本地已经存在的文件不覆盖，用于释放用户可编辑的默认配置
+func DeepWalkKeepExist(path string, assets *embed.FS) (FoldersData, error) {
+	return deepWalk(path, assets, false)
+}
+
+func deepWalk(path string, assets *embed.FS, overwrite bool) (FoldersData, error) {
 	var folderData FoldersData
 	folderData.Path = path
 	asset := *assets
@@ -39,6 +48,9 @@ func DeepWalk(path string, assets *embed.FS) (FoldersData, error) {
 				Path: fpath,
 			})
 		} else {
+			if _, err := os.Stat(GetCurrPath() + "\\" + fpath); err == nil && !overwrite {
+				return nil
+			}
 			fContent, err := asset.ReadFile(fpath)
 			if err != nil {
 				return err